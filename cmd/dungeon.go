@@ -7,11 +7,14 @@ import (
 	"dungeon/internal/transport"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,12 +22,143 @@ import (
 var addr = flag.String("addr", "127.0.0.1:9001", "http service address")
 var serveFiles = flag.Bool("serveFiles", true, "use this app to serve static files (js, css, images)")
 var appEnv = flag.String("env", "local", "application environment: local, production")
-var mapPath = flag.String("map", "./public/assets/dungeon1.tmj", "path to the .tmj map (or room-template map when --rooms > 0) to load")
-var numRooms = flag.Int("rooms", 10, "number of rooms to assemble from the template's predefined rooms; 0 loads the map as-is")
-var mapSeed = flag.Int64("seed", 0, "random seed for map generation; 0 derives one from the current time")
+var mapsDir = flag.String("maps", "./public/assets", "directory of the .tmj maps room owners can choose from, named by file name without .tmj")
+var mapsRescan = flag.Duration("mapsRescan", 2*time.Second, "how often to look for new, changed and removed maps in --maps")
+var mapName = flag.String("map", "dungeon1", "name of the map (or room-template map when --rooms > 0) rooms start with, or the path of its .tmj file in --maps")
+var numRooms = flag.Int("rooms", 10, "number of rooms rooms start with, to assemble from the template's predefined rooms; 0 loads the map as-is")
+var mapLayout = flag.String("layout", "ladder", "how the rooms of generated maps rooms start with are laid out: ladder (rows joined into loops) or tree (branches and dead ends off a critical path)")
+var mapBiomes = flag.String("biomes", "", "comma-separated names of other room-template maps whose rooms and corridors generated maps rooms start with mix in")
+var biomesPerFloor = flag.Bool("biomesPerFloor", false, "generate each floor from one of --map and --biomes in turn instead of mixing them")
+var monsterDifficulty = flag.String("difficulty", "normal", "monster difficulty rooms start with: easy, normal or hard; monsters are scaled to it and to the number of players")
+var numFloors = flag.Int("floors", 3, "number of floors of every match on a generated map, each generated with more rooms than the one above")
+var mapSeed = flag.Int64("seed", 0, "random seed for map generation rooms start with; 0 derives a new one for every match")
+var matchSeed = flag.Int64("matchSeed", 0, "random seed for every match (to reproduce a reported one); 0 derives a new one per match")
+var replaysDir = flag.String("replays", "./replays", "directory to record match replays to and serve them from; empty disables replays")
+var replayGzip = flag.Bool("replayGzip", true, "gzip recorded replays")
 
 var indexPageContent []byte
 
+// maxMapRooms caps the number of rooms a room owner can ask a map to have.
+const maxMapRooms = 30
+
+var mapCache = game.NewMapCache(32)
+
+// mapCatalog holds the maps room owners can choose from.
+var mapCatalog *game.MapCatalog
+
+// mapPaths returns the paths of the template of settings and of its biomes.
+func mapPaths(settings lobby.MapSettings) ([]string, error) {
+	template, ok := mapCatalog.Lookup(settings.Template)
+	if !ok {
+		return nil, fmt.Errorf("unknown map template %q", settings.Template)
+	}
+	paths := []string{template.Path()}
+	for _, name := range settings.Biomes {
+		biome, ok := mapCatalog.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown biome %q", name)
+		}
+		if biome.RoomTemplates == 0 {
+			return nil, fmt.Errorf("biome %q has no rooms to generate a map from", name)
+		}
+		paths = append(paths, biome.Path())
+	}
+
+	return paths, nil
+}
+
+// firstFloorPaths returns the paths of the templates the first floor is
+// generated from.
+func firstFloorPaths(settings lobby.MapSettings, paths []string) []string {
+	if settings.BiomesPerFloor {
+		return paths[:1]
+	}
+
+	return paths
+}
+
+// resolveMapName returns the name of the map --map names. --map used to take
+// the path of a .tmj file, so the path of one in --maps is accepted too.
+func resolveMapName(name string) (string, error) {
+	if _, ok := mapCatalog.Lookup(name); ok {
+		return name, nil
+	}
+	if strings.HasSuffix(name, ".tmj") {
+		dir, err := filepath.Abs(filepath.Dir(name))
+		mapsAbs, mapsErr := filepath.Abs(*mapsDir)
+		base := strings.TrimSuffix(filepath.Base(name), ".tmj")
+		if _, ok := mapCatalog.Lookup(base); ok && err == nil && mapsErr == nil && dir == mapsAbs {
+			return base, nil
+		}
+	}
+
+	names := make([]string, 0)
+	for _, template := range mapCatalog.Templates() {
+		if template.Error == "" {
+			names = append(names, template.Name)
+		}
+	}
+
+	return "", fmt.Errorf("unknown map %q, --map takes one of the maps in %s: %s", name, *mapsDir, strings.Join(names, ", "))
+}
+
+func checkMapSettings(settings lobby.MapSettings) error {
+	template, ok := mapCatalog.Lookup(settings.Template)
+	if !ok {
+		return fmt.Errorf("unknown map template %q", settings.Template)
+	}
+	if settings.Rooms > 0 && template.RoomTemplates == 0 {
+		return fmt.Errorf("map template %q has no rooms to generate a map from", settings.Template)
+	}
+	if settings.Rooms < 0 || settings.Rooms > maxMapRooms {
+		return fmt.Errorf("rooms must be between 0 and %d, got %d", maxMapRooms, settings.Rooms)
+	}
+	if _, err := game.ParseMapLayout(settings.Layout); err != nil {
+		return err
+	}
+	if _, err := mapPaths(settings); err != nil {
+		return err
+	}
+	if len(settings.Biomes) > 0 && settings.Rooms == 0 {
+		return fmt.Errorf("biomes are mixed into generated maps only, but rooms is 0")
+	}
+	return nil
+}
+
+// prepareMap does the slow checks of settings that passed checkMapSettings: it
+// parses the templates that are mixed together and, with a seed, generates the
+// dungeon, since not every seed makes one. The cache keeps it for the match.
+func prepareMap(settings lobby.MapSettings) error {
+	paths, err := mapPaths(settings)
+	if err != nil {
+		return err
+	}
+	if len(settings.Biomes) > 0 && !settings.BiomesPerFloor {
+		if err := game.CheckMapMix(paths); err != nil {
+			return err
+		}
+	}
+	if settings.Seed == 0 {
+		return nil
+	}
+	layout, err := game.ParseMapLayout(settings.Layout)
+	if err != nil {
+		return err
+	}
+	_, err = mapCache.Load(firstFloorPaths(settings, paths), layout, settings.Rooms, settings.Seed)
+
+	return err
+}
+
+func mapsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := json.NewEncoder(w).Encode(mapCatalog.Templates())
+	if err != nil {
+		log.Println("Write maps error: ", err)
+	}
+}
+
 type avatarCacheEntry struct {
 	data        []byte
 	contentType string
@@ -122,40 +256,91 @@ func main() {
 	indexPageContent = bytes.Replace(indexPageContentRaw, []byte("%APP_ENV%"), []byte(*appEnv), 1)
 	indexPageContent = bytes.Replace(indexPageContent, []byte("%APP_VERSION%"), bytes.TrimSpace([]byte(version)), 2)
 
-	var gameMap *game.Map
-	if *numRooms > 0 {
-		gameMap, err = game.LoadGeneratedMap(*mapPath, *numRooms, *mapSeed)
-	} else {
-		gameMap, err = game.LoadMap(*mapPath)
-	}
+	mapCatalog, err = game.NewMapCatalog(*mapsDir, mapCache)
 	if err != nil {
-		log.Fatal("Load map error: ", err)
+		log.Fatal("Scan maps error: ", err)
 	}
-
-	// write map to debug light rects
-	mapJson, err := json.Marshal(gameMap)
+	defaultMapName, err := resolveMapName(*mapName)
 	if err != nil {
-		log.Fatal("Marshal map error: ", err)
+		log.Fatal("Map error: ", err)
 	}
-	err = os.WriteFile("./public/assets/dungeon1_.tmj", mapJson, 0666)
-	if err != nil {
-		log.Fatal("Write map error: ", err)
+	defaultMapSettings := lobby.MapSettings{
+		Template:       defaultMapName,
+		Rooms:          *numRooms,
+		Layout:         *mapLayout,
+		BiomesPerFloor: *biomesPerFloor,
+		Seed:           *mapSeed,
+		Difficulty:     *monsterDifficulty,
+	}
+	if *mapBiomes != "" {
+		defaultMapSettings.Biomes = strings.Split(*mapBiomes, ",")
+	}
+	if err := checkMapSettings(defaultMapSettings); err != nil {
+		log.Fatal("Map error: ", err)
+	}
+	if err := prepareMap(defaultMapSettings); err != nil {
+		log.Fatal("Map error: ", err)
 	}
+	if !lobby.IsValidMonsterDifficulty(*monsterDifficulty) {
+		log.Fatalf("Unknown monster difficulty %q", *monsterDifficulty)
+	}
+	// A default map that cannot be generated fails now, not at the first match.
+	// prepareMap generated it already if it has a seed.
+	if defaultMapSettings.Seed == 0 {
+		defaultPaths, _ := mapPaths(defaultMapSettings)
+		defaultLayout, _ := game.ParseMapLayout(*mapLayout)
+		if _, err := mapCache.Load(firstFloorPaths(defaultMapSettings, defaultPaths), defaultLayout, *numRooms, *mapSeed); err != nil {
+			log.Fatal("Load map error: ", err)
+		}
+	}
+	go mapCatalog.Watch(*mapsRescan)
 
-	newGameFunc := func(playersClients []lobby.ClientPlayer, room *lobby.Room, broadcastEventFunc func(event interface{})) lobby.GameEventsDispatcher {
-		return game.NewGame(playersClients, room, broadcastEventFunc, gameMap, *appEnv == "local")
+	newGameFunc := func(playersClients []lobby.ClientPlayer, room *lobby.Room, mapSettings lobby.MapSettings, broadcastEventFunc func(event interface{})) lobby.GameEventsDispatcher {
+		// The map may have been removed or broken since the room chose it.
+		paths, err := mapPaths(mapSettings)
+		if err != nil {
+			log.Printf("Cannot load map %+v: %v\n", mapSettings, err)
+			return nil
+		}
+		layout, _ := game.ParseMapLayout(mapSettings.Layout) // checked when chosen
+		gameMap, err := mapCache.Load(firstFloorPaths(mapSettings, paths), layout, mapSettings.Rooms, mapSettings.Seed)
+		if err != nil {
+			log.Printf("Cannot load map %+v: %v\n", mapSettings, err)
+			return nil
+		}
+		g := game.NewGame(playersClients, room, broadcastEventFunc, gameMap, *appEnv == "local", *matchSeed)
+		g.UseMonsterDifficulty(mapSettings.Difficulty)
+		if mapSettings.Rooms > 0 {
+			g.UseFloors(*numFloors, game.GeneratedFloors(paths, layout, mapSettings.Rooms, mapSettings.BiomesPerFloor))
+		}
+		if *replaysDir != "" {
+			replayID, err := transport.NewReplayID()
+			var rec *transport.ReplayRecorder
+			if err == nil {
+				rec, err = transport.NewReplayRecorder(*replaysDir, replayID, *replayGzip)
+			}
+			if err != nil {
+				log.Println("Cannot record replay: ", err)
+			} else {
+				g.RecordReplay(rec)
+			}
+		}
+
+		return g
 	}
 
-	newBotFunc := func(botId uint64, room *lobby.Room, sendGameCommand func(client lobby.ClientPlayer, commandName string, commandData json.RawMessage)) lobby.ClientPlayer {
-		return game.NewBotClient(botId, room, sendGameCommand)
+	newBotFunc := func(botId uint64, room *lobby.Room, settings lobby.BotSettings, sendGameCommand func(client lobby.ClientPlayer, commandName string, commandData json.RawMessage)) lobby.ClientPlayer {
+		return game.NewBotClient(botId, room, settings, sendGameCommand)
 	}
 
 	matchMaker := game.NewMatchMaker()
 
 	lobbyInstance := lobby.NewLobby(newGameFunc, newBotFunc, matchMaker, 1, 20)
+	lobbyInstance.UseMaps(defaultMapSettings, checkMapSettings, prepareMap)
 	go lobbyInstance.Run()
 	http.HandleFunc("/", serveIndexPage)
 	http.HandleFunc("/avatar-proxy", avatarProxyHandler)
+	http.HandleFunc("/maps", mapsHandler)
 	if *serveFiles {
 		http.HandleFunc("/favicon.ico", faviconHandler)
 		http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(http.Dir("./public/js"))))
@@ -165,6 +350,20 @@ func main() {
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		transport.ServeWebSocketRequest(lobbyInstance, w, r)
 	})
+	// Fallback for clients that cannot use WebSockets, see transport/sse_client.go.
+	http.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		transport.ServeSSERequest(lobbyInstance, w, r)
+	})
+	http.HandleFunc("POST /sse/{id}", transport.ServeSSECommands)
+	// Client IDs and queue metrics are for debugging, not for the public.
+	if *appEnv == "local" {
+		http.HandleFunc("/debug/queues", transport.ServeQueueStats)
+	}
+	if *replaysDir != "" {
+		http.HandleFunc("/replays/{id}", func(w http.ResponseWriter, r *http.Request) {
+			transport.ServeReplayRequest(*replaysDir, w, r)
+		})
+	}
 	log.Printf("Listening http://%s", *addr)
 	err = http.ListenAndServe(*addr, nil)
 	if err != nil {
@@ -0,0 +1,143 @@
+// Command simulate plays bot-only matches faster than real time and prints
+// aggregate stats, for balancing damage, classes, monsters and loot without
+// playing by hand.
+package main
+
+import (
+	"dungeon/internal/game"
+	"dungeon/internal/lobby"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var mapPath = flag.String("map", "./public/assets/dungeon1.tmj", "path to the .tmj map (or room-template map when --rooms > 0) to load")
+var numRooms = flag.Int("rooms", 10, "number of rooms to assemble from the template's predefined rooms; 0 loads the map as-is")
+var mapLayout = flag.String("layout", "ladder", "how the rooms of a generated map are laid out: ladder or tree")
+var mapBiomes = flag.String("biomes", "", "comma-separated paths to other room-template maps whose rooms and corridors the generated map mixes in")
+var mapSeed = flag.Int64("seed", 0, "random seed for map generation; 0 derives one from the current time")
+var difficulty = flag.String("difficulty", "", "monster difficulty to scale monsters to the bots with: easy, normal or hard; empty keeps the map's monsters")
+var matches = flag.Int("matches", 20, "number of matches to simulate")
+var bots = flag.Int("bots", 4, "number of bot players per match")
+var matchSeed = flag.Int64("matchSeed", 0, "random seed of the first match, the following matches use the next seeds; 0 derives one from the current time")
+var maxDuration = flag.Duration("maxDuration", 30*time.Minute, "game time after which an undecided match is stopped")
+var parallel = flag.Int("parallel", runtime.NumCPU(), "number of matches to simulate at once")
+var verbose = flag.Bool("v", false, "print each match's result and the game log")
+
+func main() {
+	flag.Parse()
+	if *matches < 1 || *bots < 1 || *parallel < 1 {
+		log.Fatal("-matches, -bots and -parallel must be positive")
+	}
+	if *difficulty != "" && !lobby.IsValidMonsterDifficulty(*difficulty) {
+		log.Fatalf("unknown monster difficulty %q", *difficulty)
+	}
+
+	layout, err := game.ParseMapLayout(*mapLayout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var gameMap *game.Map
+	if *numRooms > 0 {
+		templates := []string{*mapPath}
+		if *mapBiomes != "" {
+			templates = append(templates, strings.Split(*mapBiomes, ",")...)
+		}
+		gameMap, err = game.LoadMixedMap(templates, layout, *numRooms, *mapSeed)
+	} else {
+		gameMap, err = game.LoadMap(*mapPath)
+	}
+	if err != nil {
+		log.Fatal("Load map error: ", err)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	firstSeed := *matchSeed
+	if firstSeed == 0 {
+		firstSeed = time.Now().UnixNano()
+	}
+
+	started := time.Now()
+	results := make([]game.MatchResult, *matches)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = game.SimulateMatch(gameMap, *bots, *difficulty, firstSeed+int64(i), *maxDuration)
+				if *verbose {
+					fmt.Printf("match %d: %+v\n", i+1, results[i])
+				}
+			}
+		}()
+	}
+	for i := range results {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	printSummary(os.Stdout, results, time.Since(started))
+}
+
+func printSummary(w io.Writer, results []game.MatchResult, elapsed time.Duration) {
+	n := len(results)
+	percent := func(count int) string {
+		return fmt.Sprintf("%d (%.0f%%)", count, float64(count)*100/float64(n))
+	}
+
+	wins := map[string]int{}
+	var bossRevealed, withCultists, cultists, playerDeaths int
+	var timeToBoss, played time.Duration
+	monsterDeaths := map[string]int{}
+	for _, r := range results {
+		wins[r.WinningSide]++
+		played += r.Duration
+		if r.BossRevealedAfter > 0 {
+			bossRevealed++
+			timeToBoss += r.BossRevealedAfter
+		}
+		if r.Cultists > 0 {
+			withCultists++
+		}
+		cultists += r.Cultists
+		playerDeaths += r.PlayerDeaths
+		for kind, count := range r.MonsterDeaths {
+			monsterDeaths[kind] += count
+		}
+	}
+
+	fmt.Fprintf(w, "%d matches, %d bots each, %s of game time simulated in %s\n",
+		n, *bots, played.Round(time.Second), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "wins: light %s, cultists %s, undecided %s\n",
+		percent(wins["light"]), percent(wins["cultists"]), percent(wins[""]))
+	if bossRevealed > 0 {
+		fmt.Fprintf(w, "boss revealed in %s matches, after %s on average\n",
+			percent(bossRevealed), (timeToBoss / time.Duration(bossRevealed)).Round(time.Second))
+	} else {
+		fmt.Fprintln(w, "boss never revealed")
+	}
+	fmt.Fprintf(w, "cultists created in %s matches, %.2f per match\n", percent(withCultists), float64(cultists)/float64(n))
+	fmt.Fprintf(w, "player deaths: %.2f per match\n", float64(playerDeaths)/float64(n))
+
+	kinds := make([]string, 0, len(monsterDeaths))
+	for kind := range monsterDeaths {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintln(w, "monster deaths per match:")
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %-12s %.2f\n", kind, float64(monsterDeaths[kind])/float64(n))
+	}
+}
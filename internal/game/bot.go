@@ -2,21 +2,727 @@ package game
 
 import (
 	"dungeon/internal/lobby"
+	"math"
+	"time"
 )
 
+// A bot plays through its BotClient like a web client would: it only knows
+// what the events it receives tell it, and acts by sending game commands. The
+// brain is driven from outside: handleEvent for every incoming event and think
+// every botThinkPeriod. run does that in real time for bots added to a room;
+// the simulator steps bots in lockstep with the game instead.
+//
+// Each think a living bot uses an item if one helps, then fights the monster
+// it is engaged with, or else heads for the stairs down once they are open, the
+// nearest chest it has not looted yet, the nearest monster, or its allies, in
+// that order. Paths come from
+// Map.findPath. A bot holds its attack while an ally stands in the line of
+// fire. Bots cursed into cultists play differently, see bot_cultist.go.
+
+// botThinkPeriod is how often a bot decides what to do.
+const botThinkPeriod = 100 * time.Millisecond
+
+// botRespawnPeriod is how often a dead bot asks to respawn.
+const botRespawnPeriod = time.Second
+
+// botRepathPeriod is how often a bot recomputes its path to a moving target.
+const botRepathPeriod = time.Second
+
+// botWaypointReach is how close a bot must get to a waypoint to head for the
+// next one.
+const botWaypointReach = 4
+
+// botEngageRange is how close a visible monster must be for a bot to stop what
+// it is doing and fight it; botDisengageRange is how far a monster may get
+// before the bot gives up on it.
+const botEngageRange = 8 * tileSize
+const botDisengageRange = 15 * tileSize
+
+// botChestReach is how close a bot walks up to a chest. Chests open on their
+// own within three tiles.
+const botChestReach = 2 * tileSize
+
+// botAllyDistance is how close a bot with nothing else to do stays to its
+// nearest ally.
+const botAllyDistance = 3 * tileSize
+
+// botFriendlyFireMargin is how close to the line of fire an ally may stand
+// before a bot holds its attack.
+const botFriendlyFireMargin = 24
+
+// Bots read a protection scroll below botProtectionHPPercent of max HP while
+// fighting, and put on the cloak to slip away below botCloakHPPercent. When
+// they drink potions depends on their difficulty.
+const botProtectionHPPercent = 70
+const botCloakHPPercent = 25
+
+// botItemPeriod is the minimum time between two items a bot uses.
+const botItemPeriod = time.Second
+
+// botAttackRanges is how close a bot of each class closes in before attacking.
+var botAttackRanges = map[string]int{
+	ClassKnight: 100,
+	ClassMage:   180,
+	ClassRogue:  250,
+}
+
 type Bot struct {
 	botClient          *BotClient
 	room               *lobby.Room
 	delayedCastCommand *CastCommand
+	sendCommand        func(commandName string, commandData interface{})
+	difficulty         *BotDifficultyDef
+
+	joined            bool
+	ended             bool
+	alive             bool
+	isSpectator       bool
+	class             string
+	x                 int
+	y                 int
+	hp                int
+	maxHP             int
+	hasShield         bool
+	speedBoostPercent int
+	direction         string
+	isMoving          bool
+	inventory         map[string]int
+	gameMap           *Map
+	monsters          map[int]*botMonster
+	allies            map[uint64]*botAlly
+	chests            map[int]*botChest
+	stairs            *Point
+	targetID          int
+	path              []Point
+	pathGoal          Point
+	isCultist         bool
+	cultists          map[uint64]bool
+	bossRevealed      bool
+	soulPower         int
+	traps             map[string]Point
+
+	lastThink          time.Time
+	lastAttack         time.Time
+	lastItemUse        time.Time
+	lastRespawnRequest time.Time
+	lastRepath         time.Time
+	lastSpikes         time.Time
+	// aimedSince is when the bot lined up with its current target, zero while it
+	// is not lined up with any.
+	aimedSince time.Time
+	aiming     bool
+}
+
+// botMonster is what a bot knows about a monster.
+type botMonster struct {
+	kind string
+	x    int
+	y    int
+	hp   int
 }
 
-func newBot(botClient *BotClient, room *lobby.Room) *Bot {
-	return &Bot{
-		botClient: botClient,
-		room:      room,
+// botAlly is what a bot knows about another player.
+type botAlly struct {
+	x  int
+	y  int
+	hp int
+}
+
+// botChest is a chest a bot knows of. A chest is done once it is open, or once
+// the bot got to it and it did not open (a monster was guarding it).
+type botChest struct {
+	x    int
+	y    int
+	done bool
+}
+
+func newBot(botClient *BotClient, room *lobby.Room, difficulty *BotDifficultyDef) *Bot {
+	b := &Bot{
+		botClient:   botClient,
+		room:        room,
+		sendCommand: botClient.sendCommandToGame,
+		difficulty:  difficulty,
 	}
+	b.reset()
+
+	return b
 }
 
+// reset forgets everything about the previous match.
+func (b *Bot) reset() {
+	*b = Bot{
+		botClient:   b.botClient,
+		room:        b.room,
+		sendCommand: b.sendCommand,
+		difficulty:  b.difficulty,
+		direction:   "right",
+		inventory:   make(map[string]int),
+		monsters:    make(map[int]*botMonster),
+		allies:      make(map[uint64]*botAlly),
+		chests:      make(map[int]*botChest),
+		cultists:    make(map[uint64]bool),
+		traps:       make(map[string]Point),
+	}
+}
+
+// run plays in real time until the bot's client is closed.
 func (b *Bot) run() {
+	ticker := time.NewTicker(botThinkPeriod)
+	defer ticker.Stop()
 
+	for {
+		select {
+		case event := <-b.botClient.incomingEvents:
+			b.handleEvent(event)
+		case now := <-ticker.C:
+			if b.botClient.stopped {
+				return
+			}
+			b.think(now)
+		}
+	}
+}
+
+// handlePendingEvents feeds the events waiting in the bot's client to the brain.
+func (b *Bot) handlePendingEvents() {
+	for {
+		select {
+		case event := <-b.botClient.incomingEvents:
+			b.handleEvent(event)
+		default:
+			return
+		}
+	}
+}
+
+// handleEvent updates the bot's picture of the match.
+func (b *Bot) handleEvent(event interface{}) {
+	id := b.botClient.ID()
+
+	switch e := event.(type) {
+	case JoinToStartedGameEvent:
+		ps, ok := e.GameData["playerData"].(PlayerStats)
+		if !ok {
+			return
+		}
+		b.reset()
+		b.joined = true
+		b.class = ps.Class
+		b.x, b.y = ps.X, ps.Y
+		b.hp, b.maxHP = ps.HP, ps.MaxHP
+		b.alive = ps.HP > 0
+		b.isSpectator, _ = e.GameData["isSpectator"].(bool)
+		b.isCultist, _ = e.GameData["isCultist"].(bool)
+		b.bossRevealed, _ = e.GameData["bossRevealed"].(bool)
+		b.soulPower, _ = e.GameData["soulPower"].(int)
+		if cultistIDs, ok := e.GameData["cultistIds"].([]uint64); ok {
+			for _, cultistID := range cultistIDs {
+				b.cultists[cultistID] = true
+			}
+		}
+		if traps, ok := e.GameData["traps"].([]map[string]interface{}); ok {
+			for _, t := range traps {
+				id, _ := t["trapId"].(string)
+				x, _ := t["x"].(int)
+				y, _ := t["y"].(int)
+				b.traps[id] = Point{X: x, Y: y}
+			}
+		}
+		b.gameMap, _ = e.GameData["mapData"].(*Map)
+		if items, ok := e.GameData["inventory"].([]InventoryItem); ok {
+			b.setInventory(items)
+		}
+		if objects, ok := e.GameData["gameObjects"].([]Object); ok {
+			for _, obj := range objects {
+				switch obj.Kind {
+				case objectKindChest:
+					b.chests[obj.ID] = &botChest{x: obj.X, y: obj.Y}
+				case objectKindStairs:
+					b.stairs = &Point{X: obj.X, Y: obj.Y}
+				}
+			}
+		}
+	case CreaturesStatsUpdateEvent:
+		for _, p := range e.Players {
+			if p.ClientID == id {
+				b.x, b.y = p.X, p.Y
+				b.hp, b.maxHP = p.HP, p.MaxHP
+				b.alive = p.HP > 0
+				b.hasShield = p.HasShield
+				b.speedBoostPercent = p.SpeedBoostPercent
+				continue
+			}
+			if p.Hidden {
+				delete(b.allies, p.ClientID)
+				continue
+			}
+			b.allies[p.ClientID] = &botAlly{x: p.X, y: p.Y, hp: p.HP}
+		}
+		// The stats list only the monsters the bot can see.
+		clear(b.monsters)
+		for _, m := range e.Monsters {
+			b.monsters[m.ID] = &botMonster{kind: m.Kind, x: m.X, y: m.Y, hp: m.HP}
+		}
+	case CreaturesPosUpdateEvent:
+		for _, p := range e.Players {
+			if ally, ok := b.allies[p.ClientID]; ok {
+				ally.x, ally.y = p.X, p.Y
+			}
+		}
+		for _, m := range e.Monsters {
+			if known, ok := b.monsters[m.ID]; ok {
+				known.x, known.y = m.X, m.Y
+			}
+		}
+	case DamageEvent:
+		if known, ok := b.monsters[e.TargetMonsterID]; ok {
+			known.hp = max(known.hp-e.Damage, 0)
+		}
+		if e.TargetPlayerId == id {
+			b.hp = max(b.hp-e.Damage, 0)
+		}
+	case HealEvent:
+		if e.ClientID == id {
+			b.hp, b.maxHP = e.HP, e.MaxHP
+		}
+	case InventoryUpdateEvent:
+		b.setInventory(e.Inventory)
+	case ChestOpenEvent:
+		if chest, ok := b.chests[e.ObjectID]; ok {
+			chest.done = true
+		}
+	case PlayerPositionCorrectionEvent:
+		b.x, b.y = e.X, e.Y
+		b.path = nil
+	case PlayerTeleportEvent:
+		if e.ClientID == id {
+			b.x, b.y = e.X, e.Y
+			b.path = nil
+		} else if ally, ok := b.allies[e.ClientID]; ok {
+			ally.x, ally.y = e.X, e.Y
+		}
+	case PlayerRespawnEvent:
+		if e.ClientID == id {
+			b.x, b.y = e.X, e.Y
+			b.hp = b.maxHP
+			b.alive = true
+			b.path = nil
+		} else if ally, ok := b.allies[e.ClientID]; ok {
+			ally.x, ally.y = e.X, e.Y
+			ally.hp = 1 // alive; the next stats update has the real value
+		}
+	case PlayerDeathEvent:
+		if e.ClientID == id {
+			b.alive = false
+			b.hp = 0
+		} else if ally, ok := b.allies[e.ClientID]; ok {
+			ally.hp = 0
+		}
+	case RespawnDeniedEvent:
+		b.isSpectator = true
+	case TrapStateChangedEvent:
+		b.traps[e.TrapID] = Point{X: e.X, Y: e.Y}
+	case BecameCultistEvent:
+		b.isCultist = true
+	case CultistsRosterEvent:
+		clear(b.cultists)
+		for _, cultistID := range e.ClientIDs {
+			b.cultists[cultistID] = true
+		}
+	case StairsOpenedEvent:
+		b.stairs = &Point{X: e.X, Y: e.Y}
+	case BossRevealedEvent:
+		b.bossRevealed = true
+	case SoulPowerEvent:
+		b.soulPower = e.Value
+	case EndGameEvent:
+		b.ended = true
+	}
+}
+
+func (b *Bot) setInventory(items []InventoryItem) {
+	clear(b.inventory)
+	for _, item := range items {
+		b.inventory[item.Kind] += item.Count
+	}
+}
+
+// think decides what to do at now.
+func (b *Bot) think(now time.Time) {
+	elapsed := botThinkPeriod
+	if !b.lastThink.IsZero() {
+		elapsed = min(now.Sub(b.lastThink), moveWindowMax)
+	}
+	b.lastThink = now
+
+	b.aiming = false
+	defer func() {
+		if !b.aiming {
+			b.aimedSince = time.Time{}
+		}
+	}()
+
+	if !b.joined || b.ended || b.isSpectator {
+		return
+	}
+	if !b.alive {
+		b.requestRespawn(now)
+		return
+	}
+	if b.huntsPlayers() {
+		if victim := b.pickVictim(); victim != nil {
+			b.useItems(now, true)
+			b.fight(now, victim.x, victim.y, elapsed)
+			return
+		}
+	}
+
+	target := b.pickTarget(botEngageRange)
+	b.useItems(now, target != nil)
+
+	if target != nil {
+		b.fight(now, target.x, target.y, elapsed)
+		return
+	}
+	if b.stairs != nil && b.walkTo(now, b.stairs.X, b.stairs.Y, elapsed) {
+		return
+	}
+	if chest := b.nearestChest(); chest != nil {
+		if getDistance(b.x, b.y, chest.x, chest.y) > botChestReach {
+			if b.walkTo(now, chest.x, chest.y, elapsed) {
+				return
+			}
+		} else if guard := b.chestGuard(chest); guard != nil {
+			b.fight(now, guard.x, guard.y, elapsed)
+			return
+		}
+		// Reached, or out of reach.
+		chest.done = true
+	}
+	if target := b.pickTarget(math.MaxInt); target != nil {
+		b.fight(now, target.x, target.y, elapsed)
+		return
+	}
+	if b.isCultist && b.lure(now, elapsed) {
+		return
+	}
+	if ally := b.nearestAlly(); ally != nil && getDistance(b.x, b.y, ally.x, ally.y) > botAllyDistance {
+		b.walkTo(now, ally.x, ally.y, elapsed)
+		return
+	}
+	b.stop()
+}
+
+// requestRespawn asks to respawn, unless the bot knows it is out of the match:
+// good players are eliminated once the boss is revealed, and cultists then need
+// Soul Power.
+func (b *Bot) requestRespawn(now time.Time) {
+	if b.bossRevealed && (!b.isCultist || b.soulPower <= 0) {
+		b.isSpectator = true
+		return
+	}
+	if now.Sub(b.lastRespawnRequest) >= botRespawnPeriod {
+		b.lastRespawnRequest = now
+		b.sendCommand("RespawnCommand", struct{}{})
+	}
+}
+
+// pickTarget returns the monster the bot fights: the one it was already
+// fighting while it lives and stays close, otherwise the nearest living one
+// within reach that the bot can see (any living one when reach is unlimited).
+func (b *Bot) pickTarget(reach int) *botMonster {
+	if m, ok := b.monsters[b.targetID]; ok && m.hp > 0 && getDistance(b.x, b.y, m.x, m.y) <= max(reach, botDisengageRange) {
+		return m
+	}
+
+	b.targetID = 0
+	best := math.MaxInt
+	for id, m := range b.monsters {
+		if m.hp <= 0 {
+			continue
+		}
+		d := getDistance(b.x, b.y, m.x, m.y)
+		if d > reach || (reach != math.MaxInt && !b.canSee(m.x, m.y)) {
+			continue
+		}
+		if d < best || (d == best && id < b.targetID) {
+			b.targetID, best = id, d
+		}
+	}
+
+	return b.monsters[b.targetID]
+}
+
+func (b *Bot) nearestChest() *botChest {
+	var nearest *botChest
+	best := math.MaxInt
+	for _, c := range b.chests {
+		if c.done {
+			continue
+		}
+		if d := getDistance(b.x, b.y, c.x, c.y); d < best {
+			nearest, best = c, d
+		}
+	}
+
+	return nearest
+}
+
+// chestGuard returns a living monster that keeps chest from opening, if the
+// bot knows of one.
+func (b *Bot) chestGuard(chest *botChest) *botMonster {
+	guardID := 0
+	for id, m := range b.monsters {
+		if m.hp > 0 && getDistance(chest.x, chest.y, m.x, m.y) <= chestMonsterRange && (guardID == 0 || id < guardID) {
+			guardID = id
+		}
+	}
+	if guardID == 0 {
+		return nil
+	}
+	b.targetID = guardID
+
+	return b.monsters[guardID]
+}
+
+func (b *Bot) nearestAlly() *botAlly {
+	var nearest *botAlly
+	best := math.MaxInt
+	for _, a := range b.allies {
+		if a.hp <= 0 {
+			continue
+		}
+		if d := getDistance(b.x, b.y, a.x, a.y); d < best {
+			nearest, best = a, d
+		}
+	}
+
+	return nearest
+}
+
+// useItems uses at most one item that helps right now.
+func (b *Bot) useItems(now time.Time, fighting bool) {
+	if now.Sub(b.lastItemUse) < botItemPeriod {
+		return
+	}
+
+	hpPercent := 100
+	if b.maxHP > 0 {
+		hpPercent = b.hp * 100 / b.maxHP
+	}
+
+	var kind string
+	switch {
+	case b.shouldDropSpikes(now):
+		kind = itemSpikes
+		b.lastSpikes = now
+	case hpPercent < b.difficulty.PotionHPPercent && b.inventory[itemHealingPotion] > 0:
+		kind = itemHealingPotion
+	case !b.difficulty.UsesItems:
+		return
+	case hpPercent < botCloakHPPercent && fighting && b.inventory[itemCloakOfInvisibility] > 0:
+		kind = itemCloakOfInvisibility
+	case hpPercent < botProtectionHPPercent && fighting && !b.hasShield && b.inventory[itemScrollOfProtection] > 0:
+		kind = itemScrollOfProtection
+	case b.inventory[itemScrollOfXP] > 0:
+		kind = itemScrollOfXP
+	case b.inventory[itemBootsOfHaste] > 0 && b.speedBoostPercent == 0:
+		kind = itemBootsOfHaste
+	default:
+		return
+	}
+
+	b.lastItemUse = now
+	b.useItem(kind)
+}
+
+func (b *Bot) useItem(kind string) {
+	// Until the inventory update arrives, assume consumables are gone. The cloak
+	// is kept and has a cooldown instead.
+	if itemDefs[kind].ConsumesOne {
+		b.inventory[kind]--
+	}
+	b.sendCommand("UseItemCommand", UseItemCommand{Kind: kind})
+}
+
+// fight closes in on the target at (x, y), lines up with it and attacks.
+func (b *Bot) fight(now time.Time, x, y int, elapsed time.Duration) {
+	dx, dy := x-b.x, y-b.y
+	direction := getDirection(b.x, b.y, x, y)
+	vertical := direction == "up" || direction == "down"
+	along, across := abs(dx), abs(dy)
+	if vertical {
+		along, across = abs(dy), abs(dx)
+	}
+	inRange := along <= botAttackRanges[b.class]
+
+	if inRange && across <= b.difficulty.AimError {
+		if !b.allyInLineOfFire(direction, along) {
+			b.attack(now, direction)
+			return
+		}
+		// Step aside to get a clear shot.
+		if vertical {
+			b.moveToward(b.x+tileSize, b.y, elapsed)
+		} else {
+			b.moveToward(b.x, b.y+tileSize, elapsed)
+		}
+		return
+	}
+
+	// Line up with the target once in range, otherwise walk up to it.
+	if inRange && b.canSee(x, y) {
+		if vertical {
+			dy = 0
+		} else {
+			dx = 0
+		}
+		b.path = nil
+		b.moveToward(b.x+dx, b.y+dy, elapsed)
+		return
+	}
+	b.walkTo(now, x, y, elapsed)
+}
+
+// allyInLineOfFire reports whether a living player the bot spares stands
+// within length px of the bot in direction, close enough to the line to be hit.
+func (b *Bot) allyInLineOfFire(direction string, length int) bool {
+	vecX, vecY := getVectorFromDirection(direction)
+	for id, a := range b.allies {
+		if a.hp <= 0 || !b.spares(id) {
+			continue
+		}
+		dx, dy := a.x-b.x, a.y-b.y
+		along := dx*int(vecX) + dy*int(vecY)
+		across := abs(dx*int(vecY) - dy*int(vecX))
+		if along >= 0 && along <= length+botFriendlyFireMargin && across <= botFriendlyFireMargin {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Bot) canSee(x, y int) bool {
+	if b.gameMap == nil {
+		return true
+	}
+	for _, col := range b.gameMap.getVisibilityColliders() {
+		if lineIntersectsRect(b.x, b.y, x, y, col.X, col.Y, col.Width, col.Height) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// walkTo follows a path to (x, y), recomputing it when the goal moves to
+// another tile. It returns false if the bot is stuck.
+func (b *Bot) walkTo(now time.Time, x, y int, elapsed time.Duration) bool {
+	if b.gameMap == nil || len(b.gameMap.blockedGrid) == 0 {
+		return b.moveToward(x, y, elapsed)
+	}
+
+	goal := Point{X: x / tileSize, Y: y / tileSize}
+	if (goal != b.pathGoal || len(b.path) == 0) && now.Sub(b.lastRepath) >= botRepathPeriod {
+		b.lastRepath = now
+		b.pathGoal = goal
+		b.path = b.gameMap.findPath(b.x/tileSize, b.y/tileSize, goal.X, goal.Y)
+	}
+	for len(b.path) > 0 && getDistance(b.x, b.y, b.path[0].X, b.path[0].Y) <= botWaypointReach {
+		b.path = b.path[1:]
+	}
+	if len(b.path) == 0 {
+		return b.moveToward(x, y, elapsed)
+	}
+
+	return b.moveToward(b.path[0].X, b.path[0].Y, elapsed)
+}
+
+// moveToward walks toward (x, y) as far as the bot's speed allows in elapsed,
+// sliding along one axis if the straight step is blocked. It returns false if
+// the bot is stuck.
+func (b *Bot) moveToward(x, y int, elapsed time.Duration) bool {
+	speed := classMoveSpeed(b.class) * (100 + b.speedBoostPercent) / 100
+	step := float64(speed) * elapsed.Seconds()
+	dx, dy := float64(x-b.x), float64(y-b.y)
+	length := math.Hypot(dx, dy)
+	if length < 1 {
+		b.stop()
+		return true
+	}
+	if length > step {
+		dx, dy = dx/length*step, dy/length*step
+	}
+
+	candidates := [][2]int{
+		{b.x + int(dx), b.y + int(dy)},
+		{b.x + int(dx), b.y},
+		{b.x, b.y + int(dy)},
+	}
+	for _, c := range candidates {
+		if c[0] == b.x && c[1] == b.y {
+			continue
+		}
+		if b.gameMap != nil && b.gameMap.isSegmentBlocked(b.x, b.y, c[0], c[1]) {
+			continue
+		}
+		b.move(c[0], c[1], getDirection(b.x, b.y, c[0], c[1]), true)
+		return true
+	}
+	b.stop()
+
+	return false
+}
+
+func (b *Bot) stop() {
+	if b.isMoving {
+		b.move(b.x, b.y, b.direction, false)
+	}
+}
+
+func (b *Bot) move(x, y int, direction string, isMoving bool) {
+	b.x, b.y, b.direction, b.isMoving = x, y, direction, isMoving
+	b.sendCommand("PlayerMoveCommand", MoveCommand{X: x, Y: y, Direction: direction, IsMoving: isMoving})
+}
+
+// attack faces direction and uses the class attack, once the bot has kept its
+// aim for its reaction delay and the attack is off cooldown.
+func (b *Bot) attack(now time.Time, direction string) {
+	b.aiming = true
+	if b.aimedSince.IsZero() {
+		b.aimedSince = now
+	}
+	if now.Sub(b.aimedSince) < b.difficulty.ReactionDelay {
+		if b.direction != direction || b.isMoving {
+			b.move(b.x, b.y, direction, false)
+		}
+		return
+	}
+
+	var cooldown time.Duration
+	switch b.class {
+	case ClassMage:
+		cooldown = attackFireballCooldown
+	case ClassRogue:
+		cooldown = attackShotArrowCooldown
+	default:
+		cooldown = attackSwordCooldown + attackSwordDelay
+	}
+	if now.Sub(b.lastAttack) < cooldown {
+		return
+	}
+	b.lastAttack = now
+
+	if b.direction != direction || b.isMoving {
+		b.move(b.x, b.y, direction, false)
+	}
+	switch b.class {
+	case ClassMage:
+		b.sendCommand("CastFireballCommand", CastFireballCommand{X: b.x, Y: b.y, Direction: direction})
+	case ClassRogue:
+		b.sendCommand("ShootArrowCommand", ShootArrowCommand{X: b.x, Y: b.y, Direction: direction})
+	default:
+		b.sendCommand("SwordAttackCommand", SwordAttackCommand{})
+	}
 }
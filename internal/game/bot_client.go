@@ -29,16 +29,31 @@ type BotClientCommandDecodeWrapper struct {
 	Data json.RawMessage `json:"data"`
 }
 
-func NewBotClient(botId uint64, room *lobby.Room, sendGameCommand func(client lobby.ClientPlayer, commandName string, commandData json.RawMessage)) lobby.ClientPlayer {
+// NewBotClient creates a bot playing with settings, or returns nil if their
+// difficulty or class is unknown.
+func NewBotClient(botId uint64, room *lobby.Room, settings lobby.BotSettings, sendGameCommand func(client lobby.ClientPlayer, commandName string, commandData json.RawMessage)) lobby.ClientPlayer {
+	difficulty, ok := botDifficultyDefs[settings.Difficulty]
+	if !ok {
+		return nil
+	}
+	properties := map[string]interface{}{}
+	if settings.Class != "" {
+		if _, ok := classDefs[settings.Class]; !ok {
+			return nil
+		}
+		properties["class"] = settings.Class
+	}
+
 	botClient := &BotClient{
-		id:               botId,
-		incomingEvents:   make(chan interface{}, 256),
-		outgoingCommands: make(chan *GameBotCommandWithName),
-		sendGameCommand:  sendGameCommand,
+		id:                   botId,
+		incomingEvents:       make(chan interface{}, 256),
+		outgoingCommands:     make(chan *GameBotCommandWithName),
+		sendGameCommand:      sendGameCommand,
+		additionalProperties: properties,
 	}
 	botClient.SetNickname("BotClient")
 
-	bot := newBot(botClient, room)
+	bot := newBot(botClient, room, difficulty)
 	go botClient.sendingCommandsToGame()
 	go bot.run()
 
@@ -65,21 +80,30 @@ func (bc *BotClient) sendCommandToGame(commandType string, commandData interface
 	if bc.stopped {
 		return
 	}
-	// Game accepts type json.RawMessage for data, because it comes from web clients.
-	// To satisfy interface bot client should get json.RawMessage for commandData.
-	// To achieve this we encode to json and decode data back.
+	data, ok := encodeBotCommand(commandType, commandData)
+	if !ok {
+		return
+	}
+	bc.outgoingCommands <- &GameBotCommandWithName{commandType, data}
+}
+
+// encodeBotCommand turns a bot's command into the json.RawMessage data the game
+// accepts, because it comes from web clients. To achieve this we encode to json
+// and decode data back.
+func encodeBotCommand(commandType string, commandData interface{}) (json.RawMessage, bool) {
 	commandDataEncoded, err := json.Marshal(&BotClientCommandEncodeWrapper{commandData})
 	if err != nil {
 		log.Println("cannot encode bot command with type = "+commandType, err)
-		return
+		return nil, false
 	}
 	var commandDataDecoded BotClientCommandDecodeWrapper
 	err = json.Unmarshal(commandDataEncoded, &commandDataDecoded)
 	if err != nil {
 		log.Println("cannot decode back bot command with type = "+commandType, err)
-		return
+		return nil, false
 	}
-	bc.outgoingCommands <- &GameBotCommandWithName{commandType, commandDataDecoded.Data}
+
+	return commandDataDecoded.Data, true
 }
 
 func (bc *BotClient) ID() uint64 {
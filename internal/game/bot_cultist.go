@@ -0,0 +1,133 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+// A bot cursed into a cultist keeps playing along with the good players until
+// the boss is revealed: it fights monsters and loots chests like everyone else
+// and never hits another player. Meanwhile it sabotages quietly. It drops
+// spikes where good players are about to walk, though never right next to one,
+// and when idle it waits beyond a known trap so that players who come to it
+// walk across the trap. Once the boss is revealed it turns on the good players
+// and spends Soul Power to respawn for as long as there is some.
+
+// botSpikesPeriod is the minimum time between two spikes a cultist drops.
+const botSpikesPeriod = 20 * time.Second
+
+// A cultist drops spikes when a good player is within botSpikesRange of it, but
+// not closer than botSpikesMinDistance: dropping them at someone's feet would
+// give it away.
+const botSpikesRange = 4 * tileSize
+const botSpikesMinDistance = tileSize + tileSize/2
+
+// botLureRange is how close to a good player a trap must be for an idle cultist
+// to wait beyond it; botLureOffset is how far beyond.
+const botLureRange = 10 * tileSize
+const botLureOffset = 2 * tileSize
+
+// huntsPlayers reports whether the bot fights good players rather than
+// monsters.
+func (b *Bot) huntsPlayers() bool {
+	return b.isCultist && b.bossRevealed
+}
+
+// spares reports whether the bot avoids hitting the player clientID: everyone
+// but good players once it hunts them.
+func (b *Bot) spares(clientID uint64) bool {
+	return !b.huntsPlayers() || b.cultists[clientID]
+}
+
+// pickVictim returns the nearest good player still in the fight.
+func (b *Bot) pickVictim() *botAlly {
+	var victim *botAlly
+	best := math.MaxInt
+	for id, a := range b.allies {
+		if a.hp <= 0 || b.spares(id) {
+			continue
+		}
+		if d := getDistance(b.x, b.y, a.x, a.y); d < best {
+			victim, best = a, d
+		}
+	}
+
+	return victim
+}
+
+// nearestGoodPlayer returns the nearest living player the bot does not know to
+// be a cultist, and how far it is.
+func (b *Bot) nearestGoodPlayer() (*botAlly, int) {
+	var nearest *botAlly
+	best := math.MaxInt
+	for id, a := range b.allies {
+		if a.hp <= 0 || b.cultists[id] {
+			continue
+		}
+		if d := getDistance(b.x, b.y, a.x, a.y); d < best {
+			nearest, best = a, d
+		}
+	}
+
+	return nearest, best
+}
+
+// shouldDropSpikes reports whether a cultist should drop spikes now.
+func (b *Bot) shouldDropSpikes(now time.Time) bool {
+	if !b.isCultist || b.bossRevealed || b.inventory[itemSpikes] <= 0 {
+		return false
+	}
+	if !b.lastSpikes.IsZero() && now.Sub(b.lastSpikes) < botSpikesPeriod {
+		return false
+	}
+	if _, d := b.nearestGoodPlayer(); d < botSpikesMinDistance || d > botSpikesRange {
+		return false
+	}
+	// Spikes are dropped on the bot's tile; one trap per tile is enough.
+	tile := Point{X: b.x / tileSize * tileSize, Y: b.y / tileSize * tileSize}
+	for _, trap := range b.traps {
+		if trap == tile {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lure walks to the far side of the known trap nearest to a good player, as
+// seen from that player, and waits there. It returns false if there is no such
+// trap.
+func (b *Bot) lure(now time.Time, elapsed time.Duration) bool {
+	player, _ := b.nearestGoodPlayer()
+	if player == nil {
+		return false
+	}
+
+	var bait Point
+	best := math.MaxInt
+	for _, trap := range b.traps {
+		cx, cy := trap.X+tileSize/2, trap.Y+tileSize/2
+		d := getDistance(player.x, player.y, cx, cy)
+		if d > botLureRange || d == 0 {
+			continue
+		}
+		x := cx + (cx-player.x)*botLureOffset/d
+		y := cy + (cy-player.y)*botLureOffset/d
+		if b.gameMap != nil && b.gameMap.isSegmentBlocked(cx, cy, x, y) {
+			continue
+		}
+		if d < best {
+			bait, best = Point{X: x, Y: y}, d
+		}
+	}
+	if best == math.MaxInt {
+		return false
+	}
+
+	if getDistance(b.x, b.y, bait.X, bait.Y) <= botWaypointReach {
+		b.stop()
+		return true
+	}
+
+	return b.walkTo(now, bait.X, bait.Y, elapsed)
+}
@@ -0,0 +1,143 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func seePlayer(b *Bot, clientID uint64, x, y int) {
+	b.handleEvent(CreaturesStatsUpdateEvent{Players: []PlayerStats{{
+		PlayerPosition: PlayerPosition{ClientID: clientID, X: x, Y: y},
+		HP:             100,
+		MaxHP:          100,
+	}}})
+}
+
+// newTestCultistBot returns a cultist bot at (x, y) holding spikes, with
+// clientID 3 as its fellow cultist.
+func newTestCultistBot(class string, x, y int) (*Bot, *[]sentBotCommand) {
+	b, sent := newTestBot(class, x, y)
+	b.handleEvent(BecameCultistEvent{})
+	b.handleEvent(CultistsRosterEvent{ClientIDs: []uint64{1, 3}})
+	b.handleEvent(InventoryUpdateEvent{ClientID: 1, Inventory: []InventoryItem{{Kind: itemSpikes, Count: 3}}})
+
+	return b, sent
+}
+
+func TestCultistBotDropsSpikesNearGoodPlayers(t *testing.T) {
+	b, sent := newTestCultistBot(ClassKnight, 100, 100)
+	seePlayer(b, 2, 100+3*tileSize, 100)
+
+	b.think(testEpoch)
+
+	want := sentBotCommand{"UseItemCommand", UseItemCommand{Kind: itemSpikes}}
+	if len(*sent) == 0 || !reflect.DeepEqual((*sent)[0], want) {
+		t.Fatalf("commands = %v, want to start with %v", *sent, want)
+	}
+
+	// Not again right away, and not while a good player stands next to the bot.
+	*sent = nil
+	b.think(testEpoch.Add(botSpikesPeriod / 2))
+	b.lastSpikes = testEpoch.Add(-botSpikesPeriod)
+	seePlayer(b, 2, 100+tileSize, 100)
+	b.think(testEpoch.Add(botSpikesPeriod))
+	for _, c := range *sent {
+		if c.name == "UseItemCommand" {
+			t.Errorf("commands = %v, want no more spikes", *sent)
+		}
+	}
+}
+
+func TestGoodBotKeepsItsSpikes(t *testing.T) {
+	b, sent := newTestBot(ClassKnight, 100, 100)
+	b.handleEvent(InventoryUpdateEvent{ClientID: 1, Inventory: []InventoryItem{{Kind: itemSpikes, Count: 3}}})
+	seePlayer(b, 2, 100+3*tileSize, 100)
+
+	b.think(testEpoch)
+
+	for _, c := range *sent {
+		if c.name == "UseItemCommand" {
+			t.Errorf("commands = %v, want no items used", *sent)
+		}
+	}
+}
+
+func TestCultistBotLuresOverTrap(t *testing.T) {
+	b, sent := newTestCultistBot(ClassKnight, 100, 100)
+	b.inventory[itemSpikes] = 0
+	seePlayer(b, 2, 100, 300)
+	b.handleEvent(TrapStateChangedEvent{TrapID: "t", X: 96, Y: 192})
+
+	b.think(testEpoch)
+
+	// The trap's centre is at (104, 200); the bot waits beyond it, away from the
+	// player, at (104, 168).
+	if len(*sent) != 1 || (*sent)[0].data.(MoveCommand).Direction != "down" {
+		t.Fatalf("commands = %v, want a move down toward the bait spot", *sent)
+	}
+	b.x, b.y = 104, 168
+	*sent = nil
+	b.think(testEpoch.Add(botThinkPeriod))
+	if len(*sent) != 1 || (*sent)[0].data.(MoveCommand).IsMoving {
+		t.Errorf("commands = %v, want the bot to wait at the bait spot", *sent)
+	}
+}
+
+func TestCultistBotHuntsGoodPlayersAfterBossReveal(t *testing.T) {
+	b, sent := newTestCultistBot(ClassMage, 100, 100)
+	seePlayer(b, 2, 250, 100)
+
+	// Before the reveal the good player is left alone.
+	b.think(testEpoch)
+	for _, c := range *sent {
+		if c.name == "CastFireballCommand" {
+			t.Fatalf("commands = %v, want no attack before the boss is revealed", *sent)
+		}
+	}
+
+	b.handleEvent(BossRevealedEvent{})
+	b.think(testEpoch.Add(botThinkPeriod))
+	*sent = nil
+	b.think(testEpoch.Add(botThinkPeriod + b.difficulty.ReactionDelay))
+	want := sentBotCommand{"CastFireballCommand", CastFireballCommand{X: b.x, Y: b.y, Direction: "right"}}
+	if n := len(*sent); n == 0 || !reflect.DeepEqual((*sent)[n-1], want) {
+		t.Errorf("commands = %v, want to end with %v", *sent, want)
+	}
+
+	// A fellow cultist in the way is spared.
+	seePlayer(b, 3, 180, 100)
+	b.lastAttack = testEpoch
+	*sent = nil
+	b.think(testEpoch.Add(2 * attackFireballCooldown))
+	for _, c := range *sent {
+		if c.name == "CastFireballCommand" {
+			t.Errorf("commands = %v, want no fireball through a fellow cultist", *sent)
+		}
+	}
+}
+
+func TestBotRespawnAfterBossReveal(t *testing.T) {
+	good, goodSent := newTestBot(ClassKnight, 100, 100)
+	cultist, cultistSent := newTestCultistBot(ClassKnight, 100, 100)
+	for _, b := range []*Bot{good, cultist} {
+		b.handleEvent(BossRevealedEvent{})
+		b.handleEvent(SoulPowerEvent{Value: 1, Visible: true})
+		b.handleEvent(PlayerDeathEvent{ClientID: 1})
+		b.think(testEpoch)
+	}
+
+	if len(*goodSent) != 0 || !good.isSpectator {
+		t.Errorf("good bot: commands = %v, spectator = %v; want none and eliminated", *goodSent, good.isSpectator)
+	}
+	if len(*cultistSent) != 1 || (*cultistSent)[0].name != "RespawnCommand" {
+		t.Errorf("cultist bot: commands = %v, want a RespawnCommand", *cultistSent)
+	}
+
+	// Out of Soul Power the cultist is out too.
+	cultist.handleEvent(SoulPowerEvent{Value: 0, Visible: true})
+	*cultistSent = nil
+	cultist.think(testEpoch.Add(botRespawnPeriod))
+	if len(*cultistSent) != 0 || !cultist.isSpectator {
+		t.Errorf("cultist bot: commands = %v, spectator = %v; want none and out", *cultistSent, cultist.isSpectator)
+	}
+}
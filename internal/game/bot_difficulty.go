@@ -0,0 +1,43 @@
+package game
+
+import (
+	"dungeon/internal/lobby"
+	"time"
+)
+
+// BotDifficultyDef tunes how well a bot plays. Adding a difficulty is an entry
+// in botDifficultyDefs plus its lobby.BotDifficulty* constant.
+type BotDifficultyDef struct {
+	// ReactionDelay is how long a bot keeps its aim on a target before its
+	// first attack.
+	ReactionDelay time.Duration
+	// AimError is how far off the target's attack axis, in px, a bot may be and
+	// still attack. Projectiles pass within 12-14 px of a target to hit it.
+	AimError int
+	// PotionHPPercent is the share of max HP below which a bot drinks a healing
+	// potion.
+	PotionHPPercent int
+	// UsesItems is whether a bot uses items other than healing potions.
+	UsesItems bool
+}
+
+var botDifficultyDefs = map[string]*BotDifficultyDef{
+	lobby.BotDifficultyEasy: {
+		ReactionDelay:   700 * time.Millisecond,
+		AimError:        32,
+		PotionHPPercent: 20,
+		UsesItems:       false,
+	},
+	lobby.BotDifficultyNormal: {
+		ReactionDelay:   250 * time.Millisecond,
+		AimError:        12,
+		PotionHPPercent: 40,
+		UsesItems:       true,
+	},
+	lobby.BotDifficultyHard: {
+		ReactionDelay:   0,
+		AimError:        6,
+		PotionHPPercent: 50,
+		UsesItems:       true,
+	},
+}
@@ -0,0 +1,238 @@
+package game
+
+import (
+	"dungeon/internal/lobby"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type sentBotCommand struct {
+	name string
+	data interface{}
+}
+
+// newTestBot returns a bot of the given class that has joined a match at
+// (x, y), and the list its commands are recorded into.
+func newTestBot(class string, x, y int) (*Bot, *[]sentBotCommand) {
+	sent := &[]sentBotCommand{}
+	b := newBot(&BotClient{id: 1, incomingEvents: make(chan interface{}, 256)}, nil, botDifficultyDefs[lobby.BotDifficultyNormal])
+	b.sendCommand = func(name string, data interface{}) {
+		*sent = append(*sent, sentBotCommand{name, data})
+	}
+	b.handleEvent(JoinToStartedGameEvent{GameData: map[string]interface{}{
+		"playerData": PlayerStats{
+			PlayerPosition: PlayerPosition{ClientID: 1, X: x, Y: y},
+			Class:          class,
+			HP:             classMaxHP(class),
+		},
+	}})
+
+	return b, sent
+}
+
+func seeMonster(b *Bot, id, x, y int) {
+	b.handleEvent(CreaturesStatsUpdateEvent{Monsters: []MonsterStats{{
+		MonsterPosition: MonsterPosition{ID: id, X: x, Y: y},
+		Kind:            monsterKindSkeleton,
+		HP:              100,
+	}}})
+}
+
+func TestBotAttacksMonsterInRange(t *testing.T) {
+	b, sent := newTestBot(ClassMage, 100, 100)
+	seeMonster(b, 7, 250, 100)
+
+	// The bot takes aim first.
+	b.think(testEpoch)
+	if len(*sent) != 0 {
+		t.Fatalf("commands = %v, want none before the reaction delay", *sent)
+	}
+
+	attackAt := testEpoch.Add(b.difficulty.ReactionDelay)
+	b.think(attackAt)
+	want := sentBotCommand{"CastFireballCommand", CastFireballCommand{X: 100, Y: 100, Direction: "right"}}
+	if n := len(*sent); n == 0 || !reflect.DeepEqual((*sent)[n-1], want) {
+		t.Fatalf("commands = %v, want to end with %v", *sent, want)
+	}
+
+	// The fireball is on cooldown for a second.
+	*sent = nil
+	b.think(attackAt.Add(botThinkPeriod))
+	if len(*sent) != 0 {
+		t.Errorf("attacked again on cooldown: %v", *sent)
+	}
+}
+
+func TestBotWalksTowardFarMonster(t *testing.T) {
+	b, sent := newTestBot(ClassKnight, 100, 100)
+	seeMonster(b, 7, 600, 100)
+
+	b.think(testEpoch)
+
+	step := int(float64(classMoveSpeed(ClassKnight)) * botThinkPeriod.Seconds())
+	want := sentBotCommand{"PlayerMoveCommand", MoveCommand{X: 100 + step, Y: 100, Direction: "right", IsMoving: true}}
+	if len(*sent) != 1 || !reflect.DeepEqual((*sent)[0], want) {
+		t.Errorf("commands = %v, want %v", *sent, want)
+	}
+}
+
+func TestBotRespawnsWhenDead(t *testing.T) {
+	b, sent := newTestBot(ClassRogue, 100, 100)
+	b.handleEvent(PlayerDeathEvent{ClientID: 1})
+
+	b.think(testEpoch)
+	b.think(testEpoch.Add(botThinkPeriod))
+	if len(*sent) != 1 || (*sent)[0].name != "RespawnCommand" {
+		t.Errorf("commands = %v, want one RespawnCommand", *sent)
+	}
+
+	b.handleEvent(PlayerRespawnEvent{ClientID: 1, X: 50, Y: 60})
+	if !b.alive || b.x != 50 || b.y != 60 {
+		t.Errorf("bot after respawn: alive=%v at (%d, %d)", b.alive, b.x, b.y)
+	}
+}
+
+func TestSimulateMatchBotsFight(t *testing.T) {
+	gameMap := newTestMap(40, 20)
+	gameMap.spawnX, gameMap.spawnY = 100, 100
+	gameMap.Layers = []MapLayer{{Name: "spawns", Objects: []MapObject{
+		{Name: "skeleton", X: 600, Y: 100},
+	}}}
+
+	result := SimulateMatch(gameMap, 2, "", 42, time.Minute)
+
+	if result.Seed != 42 || result.WinningSide != "" || result.Duration < time.Minute {
+		t.Errorf("result = %+v, want the seed and a match that ran to the time limit", result)
+	}
+	if result.MonsterDeaths[monsterKindSkeleton] != 1 {
+		t.Errorf("monster deaths = %v, want the bots to kill the skeleton", result.MonsterDeaths)
+	}
+}
+
+func TestBotWalksToChest(t *testing.T) {
+	b, sent := newTestBot(ClassKnight, 100, 100)
+	b.handleEvent(JoinToStartedGameEvent{GameData: map[string]interface{}{
+		"playerData": PlayerStats{
+			PlayerPosition: PlayerPosition{ClientID: 1, X: 100, Y: 100},
+			Class:          ClassKnight,
+			HP:             100,
+			MaxHP:          100,
+		},
+		"gameObjects": []Object{{ID: 5, Kind: objectKindChest, X: 100, Y: 500}},
+	}})
+
+	b.think(testEpoch)
+	if n := len(*sent); n != 1 || (*sent)[0].data.(MoveCommand).Direction != "down" {
+		t.Fatalf("commands = %v, want a move down toward the chest", *sent)
+	}
+
+	b.handleEvent(ChestOpenEvent{ObjectID: 5})
+	*sent = nil
+	b.think(testEpoch.Add(botThinkPeriod))
+	if n := len(*sent); n != 1 || (*sent)[0].data.(MoveCommand).IsMoving {
+		t.Errorf("commands = %v, want the bot to stop once the chest is open", *sent)
+	}
+}
+
+func TestBotTakesStairsBeforeChests(t *testing.T) {
+	b, sent := newTestBot(ClassKnight, 100, 100)
+	b.handleEvent(JoinToStartedGameEvent{GameData: map[string]interface{}{
+		"playerData": PlayerStats{
+			PlayerPosition: PlayerPosition{ClientID: 1, X: 100, Y: 100},
+			Class:          ClassKnight,
+			HP:             100,
+			MaxHP:          100,
+		},
+		"gameObjects": []Object{{ID: 5, Kind: objectKindChest, X: 100, Y: 500}},
+		"cultistIds":  []uint64{1, 3},
+	}})
+	b.handleEvent(StairsOpenedEvent{ObjectID: 6, X: 500, Y: 100, Floor: 1})
+
+	b.think(testEpoch)
+	if n := len(*sent); n != 1 || (*sent)[0].data.(MoveCommand).Direction != "right" {
+		t.Fatalf("commands = %v, want a move right toward the stairs", *sent)
+	}
+	if !b.cultists[3] {
+		t.Errorf("cultists = %v, want the roster from the game data", b.cultists)
+	}
+}
+
+func TestBotDrinksPotionWhenHurt(t *testing.T) {
+	b, sent := newTestBot(ClassKnight, 100, 100)
+	b.handleEvent(InventoryUpdateEvent{ClientID: 1, Inventory: []InventoryItem{{Kind: itemHealingPotion, Count: 1}}})
+	b.handleEvent(CreaturesStatsUpdateEvent{Players: []PlayerStats{{
+		PlayerPosition: PlayerPosition{ClientID: 1, X: 100, Y: 100},
+		HP:             30,
+		MaxHP:          100,
+	}}})
+
+	b.think(testEpoch)
+	b.think(testEpoch.Add(botItemPeriod))
+
+	want := sentBotCommand{"UseItemCommand", UseItemCommand{Kind: itemHealingPotion}}
+	if len(*sent) != 1 || !reflect.DeepEqual((*sent)[0], want) {
+		t.Errorf("commands = %v, want a single %v", *sent, want)
+	}
+}
+
+func TestBotHoldsFireWhenAllyInTheWay(t *testing.T) {
+	b, sent := newTestBot(ClassRogue, 100, 100)
+	seeMonster(b, 7, 300, 100)
+	b.handleEvent(CreaturesStatsUpdateEvent{Players: []PlayerStats{{
+		PlayerPosition: PlayerPosition{ClientID: 2, X: 200, Y: 110},
+		HP:             100,
+	}}})
+
+	b.think(testEpoch)
+
+	for _, c := range *sent {
+		if c.name == "ShootArrowCommand" {
+			t.Fatalf("commands = %v, want no arrow through the ally", *sent)
+		}
+	}
+	if len(*sent) != 1 || (*sent)[0].data.(MoveCommand).Y <= 100 {
+		t.Errorf("commands = %v, want a sidestep", *sent)
+	}
+}
+
+func TestNewBotClientSettings(t *testing.T) {
+	for _, settings := range []lobby.BotSettings{{Difficulty: "impossible"}, {Difficulty: lobby.BotDifficultyEasy, Class: "dragon"}} {
+		if c := NewBotClient(1, nil, settings, nil); c != nil {
+			t.Errorf("NewBotClient(%+v) = %v, want nil", settings, c)
+		}
+	}
+
+	c := NewBotClient(1, nil, lobby.BotSettings{Difficulty: lobby.BotDifficultyHard, Class: ClassRogue}, nil)
+	defer c.CloseConnection()
+	if class := c.GetAdditionalProperties()["class"]; class != ClassRogue {
+		t.Errorf("class property = %v, want %s", class, ClassRogue)
+	}
+}
+
+func TestBotDifficulty(t *testing.T) {
+	hard, hardSent := newTestBot(ClassMage, 100, 100)
+	hard.difficulty = botDifficultyDefs[lobby.BotDifficultyHard]
+	seeMonster(hard, 7, 250, 100)
+	hard.think(testEpoch)
+	if n := len(*hardSent); n == 0 || (*hardSent)[n-1].name != "CastFireballCommand" {
+		t.Errorf("hard bot commands = %v, want an immediate fireball", *hardSent)
+	}
+
+	// Easy bots keep scrolls and boots, and drink potions only when nearly dead.
+	easy, easySent := newTestBot(ClassKnight, 100, 100)
+	easy.difficulty = botDifficultyDefs[lobby.BotDifficultyEasy]
+	easy.handleEvent(InventoryUpdateEvent{ClientID: 1, Inventory: []InventoryItem{
+		{Kind: itemScrollOfXP, Count: 1},
+		{Kind: itemHealingPotion, Count: 1},
+	}})
+	easy.handleEvent(CreaturesStatsUpdateEvent{Players: []PlayerStats{{
+		PlayerPosition: PlayerPosition{ClientID: 1, X: 100, Y: 100},
+		HP:             30,
+		MaxHP:          100,
+	}}})
+	easy.think(testEpoch)
+	if len(*easySent) != 0 {
+		t.Errorf("easy bot commands = %v, want none at 30%% HP", *easySent)
+	}
+}
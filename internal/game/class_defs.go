@@ -6,6 +6,7 @@ package game
 type ClassDef struct {
 	Name        string
 	MaxHP       int
+	MoveSpeed   int                // top walking speed in px per second, including attack-move bonuses
 	Resistances map[string]float64 // damage kind -> damage multiplier (e.g. 0.5 = half)
 }
 
@@ -15,8 +16,9 @@ var classList = []string{ClassMage, ClassKnight, ClassRogue}
 
 var classDefs = map[string]*ClassDef{
 	ClassMage: {
-		Name:  ClassMage,
-		MaxHP: 150,
+		Name:      ClassMage,
+		MaxHP:     150,
+		MoveSpeed: 180,
 		Resistances: map[string]float64{
 			damageKindFireball:  0.5,
 			damageKindExplosion: 0.5,
@@ -24,16 +26,18 @@ var classDefs = map[string]*ClassDef{
 		},
 	},
 	ClassKnight: {
-		Name:  ClassKnight,
-		MaxHP: 250,
+		Name:      ClassKnight,
+		MaxHP:     250,
+		MoveSpeed: 180,
 		Resistances: map[string]float64{
 			damageKindSpike: 0.5,
 			damageKindArrow: 0.5,
 		},
 	},
 	ClassRogue: {
-		Name:  ClassRogue,
-		MaxHP: 200,
+		Name:      ClassRogue,
+		MaxHP:     200,
+		MoveSpeed: 220, // rogues move faster while attacking
 		Resistances: map[string]float64{
 			damageKindBullet: 0.5,
 		},
@@ -41,6 +45,7 @@ var classDefs = map[string]*ClassDef{
 }
 
 const defaultClassMaxHP = 100
+const defaultClassMoveSpeed = 180
 
 // classMaxHP returns the max HP for a class, or defaultClassMaxHP if unknown.
 func classMaxHP(class string) int {
@@ -51,6 +56,16 @@ func classMaxHP(class string) int {
 	return defaultClassMaxHP
 }
 
+// classMoveSpeed returns the top walking speed for a class in px per second, or
+// defaultClassMoveSpeed if unknown.
+func classMoveSpeed(class string) int {
+	if def, ok := classDefs[class]; ok && def.MoveSpeed > 0 {
+		return def.MoveSpeed
+	}
+
+	return defaultClassMoveSpeed
+}
+
 // classResistance returns the damage multiplier a class has against a damage
 // kind, or 1.0 (no resistance) if none is defined.
 func classResistance(class, kind string) float64 {
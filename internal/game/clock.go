@@ -0,0 +1,187 @@
+package game
+
+import (
+	"dungeon/internal/lobby"
+	"slices"
+	"sort"
+	"time"
+)
+
+// The whole game runs on one simulation timeline that advances in fixed steps.
+// Each step moves monsters and projectiles and runs the timers that fell due;
+// slower systems (monster AI, objects and traps, stats broadcasts) run every
+// few steps. Game logic reads the time with g.now(), which is the time of the
+// current step, and defers work with scheduleUnsafe instead of sleeping or
+// starting timers, so everything happens under g.mutex in a single goroutine
+// and tests can step a game deterministically.
+
+// simulationStep is the length of one simulation step.
+const simulationStep = positionsUpdateTickPeriod
+
+// Slower systems run every N steps.
+const intellectEverySteps = int(period / simulationStep)
+const objectsEverySteps = int(objectsPeriod / simulationStep)
+const statsEverySteps = int(commonUpdateTickPeriod / simulationStep)
+
+// maxCatchUpSteps bounds how many steps the loop runs at once after falling
+// behind the clock (e.g. after a GC pause). Any lag beyond that is dropped.
+const maxCatchUpSteps = 10
+
+// Clock paces the simulation loop against real time. Tests pass a manual clock
+// to UseClock, or step the game directly.
+type Clock interface {
+	Now() time.Time
+}
+
+// UseClock paces the match with clock instead of the system clock, starting the
+// simulation timeline at its current time. It must be called before
+// StartMainLoop.
+func (g *Game) UseClock(clock Clock) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.clock = clock
+	g.simNow = clock.Now()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// scheduledTask is a piece of work that runs at the first step at or after at.
+type scheduledTask struct {
+	at  time.Time
+	run func()
+}
+
+// now returns the current simulation time. Caller must hold g.mutex.
+func (g *Game) now() time.Time {
+	return g.simNow
+}
+
+// scheduleUnsafe runs fn, with g.mutex held, once the simulation reaches
+// delay from now. Tasks due at the same time run in the order they were
+// scheduled. Caller must hold g.mutex.
+func (g *Game) scheduleUnsafe(delay time.Duration, fn func()) {
+	at := g.simNow.Add(delay)
+	i := sort.Search(len(g.tasks), func(i int) bool {
+		return g.tasks[i].at.After(at)
+	})
+	g.tasks = slices.Insert(g.tasks, i, scheduledTask{at: at, run: fn})
+}
+
+// runDueTasksUnsafe runs every task due by the current simulation time,
+// including tasks scheduled by those tasks for the same time. Caller must hold
+// g.mutex.
+func (g *Game) runDueTasksUnsafe() {
+	for len(g.tasks) > 0 && !g.tasks[0].at.After(g.simNow) {
+		task := g.tasks[0]
+		g.tasks = g.tasks[1:]
+		task.run()
+	}
+}
+
+// runLoop drives the simulation from the clock until the game ends, running as
+// many steps as real time calls for.
+func (g *Game) runLoop() {
+	ticker := time.NewTicker(simulationStep)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !g.catchUp() {
+			return
+		}
+	}
+}
+
+// catchUp runs the steps the clock has reached, at most maxCatchUpSteps of
+// them, and reports whether the game goes on.
+func (g *Game) catchUp() bool {
+	for i := 0; i < maxCatchUpSteps && g.stepDue(); i++ {
+		if g.isGameEnded() {
+			return false
+		}
+		g.step()
+	}
+	if g.isGameEnded() {
+		return false
+	}
+	g.dropLag()
+
+	return true
+}
+
+// stepDue reports whether the clock has reached the end of the next step.
+func (g *Game) stepDue() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return !g.simNow.Add(simulationStep).After(g.clock.Now())
+}
+
+// dropLag moves the simulation timeline forward if it fell more than
+// maxCatchUpSteps behind the clock, so the loop doesn't spiral trying to catch
+// up.
+func (g *Game) dropLag() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if behind := g.clock.Now().Sub(g.simNow); behind > maxCatchUpSteps*simulationStep {
+		g.simNow = g.simNow.Add(behind - simulationStep)
+	}
+}
+
+// step advances the simulation by one fixed step and sends the resulting
+// position (and, every few steps, stats) updates, see visibility.go.
+func (g *Game) step() {
+	g.mutex.Lock()
+
+	g.stepCount++
+	g.simNow = g.simNow.Add(simulationStep)
+
+	g.runDueTasksUnsafe()
+	g.moveMonstersUnsafe()
+	g.tickProjectilesUnsafe(simulationStep)
+	if g.stepCount%intellectEverySteps == 0 {
+		g.tickIntellectUnsafe()
+	}
+	if g.stepCount%objectsEverySteps == 0 {
+		g.tickObjectsUnsafe(objectsPeriod.Seconds())
+	}
+
+	positions := g.collectPositionsUnsafe()
+	var stats *CreaturesStatsUpdateEvent
+	var footprints FootprintsEvent
+	var footprintClients []lobby.ClientPlayer
+	if g.stepCount%statsEverySteps == 0 {
+		stats = g.collectStatsUnsafe()
+		footprints, footprintClients = g.recordFootprintsUnsafe()
+	}
+	views := g.creatureViewsUnsafe(positions, stats)
+
+	g.mutex.Unlock()
+
+	// Send outside the lock (network sends shouldn't block command handling),
+	// and skip positions entirely when nothing is moving or attacking. Each
+	// player gets only what they can see; a replay records it all.
+	if g.replay != nil {
+		if positions != nil {
+			g.replay.RecordEvent(*positions)
+		}
+		if stats != nil {
+			g.replay.RecordEvent(*stats)
+		}
+	}
+	for _, view := range views {
+		if view.positions != nil {
+			view.client.SendEvent(*view.positions)
+		}
+		if view.stats != nil {
+			view.client.SendEvent(*view.stats)
+		}
+	}
+	for _, client := range footprintClients {
+		client.SendEvent(footprints)
+	}
+}
@@ -0,0 +1,140 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledTasksRunInOrderWhenDue(t *testing.T) {
+	g, _ := newTestGame()
+	var ran []string
+
+	g.scheduleUnsafe(100*time.Millisecond, func() { ran = append(ran, "b") })
+	g.scheduleUnsafe(50*time.Millisecond, func() {
+		ran = append(ran, "a")
+		g.scheduleUnsafe(0, func() { ran = append(ran, "a2") })
+	})
+	g.scheduleUnsafe(100*time.Millisecond, func() { ran = append(ran, "c") })
+
+	stepFor(g, 40*time.Millisecond)
+	if len(ran) != 0 {
+		t.Fatalf("tasks ran early: %v", ran)
+	}
+
+	stepFor(g, 100*time.Millisecond)
+	want := []string{"a", "a2", "b", "c"}
+	if len(ran) != len(want) {
+		t.Fatalf("ran = %v, want %v", ran, want)
+	}
+	for i := range want {
+		if ran[i] != want[i] {
+			t.Fatalf("ran = %v, want %v", ran, want)
+		}
+	}
+}
+
+func TestSwordAttackLandsAfterDelay(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(40, 40)
+	attacker, _ := addTestPlayer(g, 1, ClassKnight)
+	attacker.x, attacker.y = 100, 100
+	mon := &Monster{id: 7, kind: monsterKindSkeleton, hp: 500, maxHP: 500, x: 140, y: 100}
+	g.monsters = append(g.monsters, mon)
+
+	g.attackWithSword(1)
+
+	stepFor(g, attackSwordDelay-simulationStep)
+	if mon.hp != 500 {
+		t.Fatalf("sword landed before its delay: hp = %d", mon.hp)
+	}
+	stepFor(g, simulationStep)
+	if mon.hp != 450 {
+		t.Errorf("monster hp = %d, want 450", mon.hp)
+	}
+}
+
+func TestArrowVolleyFiresOneArrowPerLevel(t *testing.T) {
+	g, broadcast := newTestGame()
+	p, _ := addTestPlayer(g, 1, ClassRogue)
+	p.level = 3
+
+	g.shootArrow(1, "right")
+	stepFor(g, 500*time.Millisecond)
+
+	arrows := 0
+	for _, ev := range *broadcast {
+		if _, ok := ev.(ShootArrowEvent); ok {
+			arrows++
+		}
+	}
+	if arrows != 3 {
+		t.Errorf("arrows shot = %d, want 3", arrows)
+	}
+}
+
+func TestCloakExpiresOnSimulationTimeline(t *testing.T) {
+	g, _ := newTestGame()
+	p, client := addTestPlayer(g, 1, ClassRogue)
+	p.inventory = []InventoryItem{{Kind: itemCloakOfInvisibility, Count: 1}}
+
+	g.useItem(1, itemCloakOfInvisibility)
+	if !p.isInvisible(g.now()) {
+		t.Fatal("cloak should make the player invisible")
+	}
+
+	stepFor(g, 30*time.Second)
+	if p.isInvisible(g.now()) {
+		t.Error("player still invisible after the cloak duration")
+	}
+	expired := false
+	for _, ev := range client.sentEvents {
+		if _, ok := ev.(CloakExpiredEvent); ok {
+			expired = true
+		}
+	}
+	if !expired {
+		t.Error("CloakExpiredEvent not sent")
+	}
+}
+
+func TestStepRunsSlowerSystemsOnSchedule(t *testing.T) {
+	g, _ := newTestGame()
+	_, client := addTestPlayer(g, 1, ClassMage)
+
+	stepFor(g, time.Second)
+
+	stats := 0
+	for _, ev := range client.sentEvents {
+		if _, ok := ev.(CreaturesStatsUpdateEvent); ok {
+			stats++
+		}
+	}
+	if stats != 3 {
+		t.Errorf("stats updates in 1s = %d, want 3", stats)
+	}
+}
+
+func TestLoopCatchesUpWithTheClockAndDropsLag(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(20, 20)
+	clock := g.clock.(*manualClock)
+
+	clock.t = clock.t.Add(3 * simulationStep)
+	if !g.catchUp() || g.stepCount != 3 {
+		t.Fatalf("ran %d steps, want the 3 the clock reached", g.stepCount)
+	}
+
+	// After a long pause the loop runs at most maxCatchUpSteps steps and lets
+	// the rest of the lag go.
+	clock.t = clock.t.Add(time.Second)
+	g.catchUp()
+	if g.stepCount != 3+maxCatchUpSteps {
+		t.Errorf("ran %d steps after a pause, want %d", g.stepCount-3, maxCatchUpSteps)
+	}
+	if behind := clock.t.Sub(g.simNow); behind != simulationStep {
+		t.Errorf("simulation %s behind the clock, want one step", behind)
+	}
+	if g.catchUp(); g.stepCount != 4+maxCatchUpSteps {
+		t.Errorf("ran %d steps in total, want the last step too", g.stepCount)
+	}
+}
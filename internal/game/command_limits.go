@@ -0,0 +1,164 @@
+package game
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"dungeon/internal/lobby"
+)
+
+// Every command a client sends is checked against a token bucket of its kind,
+// then decoded and validated, before the game acts on it. A command that fails
+// either is dropped and counts as a strike against its client, and so does an
+// implausible hit report (see hit_validation.go). Strikes are
+// forgiven commandStrikeWindow after the first one; until then the client is
+// warned with a CommandRejectedEvent at commandStrikesToWarn strikes and
+// disconnected at commandStrikesToDisconnect, for good: its session cannot be
+// resumed.
+const (
+	commandStrikeWindow        = 10 * time.Second
+	commandStrikesToWarn       = 20
+	commandStrikesToDisconnect = 100
+)
+
+// commandLimit lets a client send burst commands of a kind at once, and rate
+// of them per second on average.
+type commandLimit struct {
+	rate  float64
+	burst float64
+}
+
+var commandLimits = map[string]commandLimit{
+	// The web client reports its position 45 times a second while moving; the
+	// burst absorbs a second of them arriving at once after a network hiccup.
+	"PlayerMoveCommand": {rate: 60, burst: 60},
+	"DodgeCommand":      {rate: 4, burst: 4},
+	// The web client attacks at most twice a second, see scene.game.js.
+	"CastFireballCommand": {rate: 4, burst: 4},
+	"SwordAttackCommand":  {rate: 4, burst: 4},
+	"ShootArrowCommand":   {rate: 4, burst: 4},
+	// Hits are reported per monster projectile and trap, several at a time.
+	"HitPlayerCommand": {rate: 20, burst: 20},
+	"RespawnCommand":   {rate: 1, burst: 3},
+	"UseItemCommand":   {rate: 4, burst: 8},
+}
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take takes a token from the bucket at now, and reports whether there was one.
+func (b *tokenBucket) take(limit commandLimit, now time.Time) bool {
+	if b.at.IsZero() {
+		b.tokens = limit.burst
+	} else {
+		b.tokens = min(limit.burst, b.tokens+now.Sub(b.at).Seconds()*limit.rate)
+	}
+	b.at = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// commandGuard is the rate limiting state of a player's client.
+type commandGuard struct {
+	buckets      map[string]*tokenBucket
+	strikes      int
+	strikesSince time.Time
+}
+
+// take takes a token from the bucket of commandName, and reports whether there
+// was one. Commands without a limit always pass.
+func (cg *commandGuard) take(commandName string, now time.Time) bool {
+	limit, ok := commandLimits[commandName]
+	if !ok {
+		return true
+	}
+	if cg.buckets == nil {
+		cg.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := cg.buckets[commandName]
+	if !ok {
+		b = &tokenBucket{}
+		cg.buckets[commandName] = b
+	}
+
+	return b.take(limit, now)
+}
+
+// strike counts a rejected command at now and returns the strikes in the
+// current window. Reaching commandStrikesToDisconnect starts the count over.
+func (cg *commandGuard) strike(now time.Time) int {
+	if cg.strikes == 0 || now.Sub(cg.strikesSince) >= commandStrikeWindow {
+		cg.strikes = 0
+		cg.strikesSince = now
+	}
+	cg.strikes++
+	strikes := cg.strikes
+	if strikes >= commandStrikesToDisconnect {
+		cg.strikes = 0
+	}
+
+	return strikes
+}
+
+// admitCommand rate-limits, decodes and validates a command of client. It
+// returns the command if the game should act on it, or nil after dealing with
+// the client as its strikes warrant.
+func (g *Game) admitCommand(client lobby.ClientPlayer, commandName string, data json.RawMessage) gameCommand {
+	g.mutex.Lock()
+	p, ok := g.players[client.ID()]
+	if !ok {
+		g.mutex.Unlock()
+		return nil
+	}
+	now := g.now()
+	if !p.commands.take(commandName, now) {
+		strikes := p.commands.strike(now)
+		g.mutex.Unlock()
+		g.rejectCommand(client, commandName, commandRejectedRateLimited, "too many commands", strikes)
+
+		return nil
+	}
+	command, err := decodeGameCommand(commandName, data)
+	if err != nil {
+		strikes := p.commands.strike(now)
+		g.mutex.Unlock()
+		g.rejectCommand(client, commandName, commandRejectedInvalid, err.Error(), strikes)
+
+		return nil
+	}
+	g.mutex.Unlock()
+
+	return command
+}
+
+// rejectCommand logs the client's rejected command, then warns or disconnects
+// the client once it has had strikes of them.
+func (g *Game) rejectCommand(client lobby.ClientPlayer, commandName, reason, detail string, strikes int) {
+	switch strikes {
+	case 1:
+		log.Printf("client %d: %s rejected: %s\n", client.ID(), commandName, detail)
+	case commandStrikesToWarn:
+		log.Printf("client %d: warned after %d rejected commands, last %s: %s\n", client.ID(), strikes, commandName, detail)
+		client.SendEvent(CommandRejectedEvent{Command: commandName, Reason: reason})
+	case commandStrikesToDisconnect:
+		log.Printf("client %d: disconnected after %d rejected commands, last %s: %s\n", client.ID(), strikes, commandName, detail)
+		if k, ok := client.(kickableClient); ok {
+			k.Kick()
+		} else {
+			client.CloseConnection()
+		}
+	}
+}
+
+// kickableClient is a client whose session can be ended for abuse, like
+// lobby.Client.
+type kickableClient interface {
+	Kick()
+}
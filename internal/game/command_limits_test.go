@@ -0,0 +1,96 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCommandsBeyondTheirRateAreDropped(t *testing.T) {
+	g, _ := newTestGame()
+	_, client := addTestPlayer(g, 1, ClassMage)
+	data := json.RawMessage(`{"kind":"healing_potion"}`)
+	admitted := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if g.admitCommand(client, "UseItemCommand", data) != nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	limit := commandLimits["UseItemCommand"]
+	if got := admitted(20); got != int(limit.burst) {
+		t.Errorf("%d commands of a burst admitted, want %v", got, limit.burst)
+	}
+	g.simNow = g.simNow.Add(time.Second)
+	if got := admitted(20); got != int(limit.rate) {
+		t.Errorf("%d commands admitted a second later, want %v", got, limit.rate)
+	}
+	if got := g.admitCommand(client, "PlayerMoveCommand", json.RawMessage(`{"x":1,"y":1,"direction":"up"}`)); got == nil {
+		t.Error("a command of another kind was dropped")
+	}
+}
+
+func TestInvalidCommandsAreRejected(t *testing.T) {
+	g, _ := newTestGame()
+	_, client := addTestPlayer(g, 1, ClassMage)
+
+	for _, tc := range []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"PlayerMoveCommand", `{"x":10,"y":20,"direction":"left","isMoving":true}`, true},
+		{"PlayerMoveCommand", `{"x":10,"y":20,"direction":"sideways"}`, false},
+		{"PlayerMoveCommand", `{"x":-1,"y":20,"direction":"left"}`, false},
+		{"DodgeCommand", `{"x":10,"y":20,"direction":""}`, false},
+		{"CastFireballCommand", `{"x":10,"y":20,"direction":"up"}`, true},
+		{"ShootArrowCommand", `{"x":10,"y":99999999,"direction":"up"}`, false},
+		{"SwordAttackCommand", `{"x":10,"y":20,"direction":"up"}`, true},
+		{"HitPlayerCommand", `{"monsterId":-1,"targetClientId":1,"kind":"spike"}`, true},
+		{"HitPlayerCommand", `{"monsterId":-1,"targetClientId":1,"kind":"nuke"}`, false},
+		{"HitPlayerCommand", `{"monsterId":-7,"targetClientId":1,"kind":"spike"}`, false},
+		{"UseItemCommand", `{"kind":"spikes"}`, true},
+		{"UseItemCommand", `{"kind":"infinite_gold"}`, false},
+		{"UseItemCommand", `{"kind":`, false},
+		{"RespawnCommand", ``, true},
+		{"TeleportCommand", `{}`, false},
+	} {
+		if got := g.admitCommand(client, tc.name, json.RawMessage(tc.data)) != nil; got != tc.valid {
+			t.Errorf("%s %s admitted = %v, want %v", tc.name, tc.data, got, tc.valid)
+		}
+	}
+}
+
+func TestRepeatedOffendersAreWarnedThenDisconnected(t *testing.T) {
+	g, _ := newTestGame()
+	_, client := addTestPlayer(g, 1, ClassMage)
+	offend := func(n int) {
+		for i := 0; i < n; i++ {
+			g.admitCommand(client, "TeleportCommand", json.RawMessage(`{}`))
+		}
+	}
+
+	offend(commandStrikesToWarn - 1)
+	g.simNow = g.simNow.Add(commandStrikeWindow)
+	offend(commandStrikesToWarn - 1)
+	if len(client.sentEvents) != 0 {
+		t.Fatalf("warned of strikes spread over two windows: %v", client.sentEvents)
+	}
+
+	offend(1)
+	want := CommandRejectedEvent{Command: "TeleportCommand", Reason: commandRejectedInvalid}
+	if len(client.sentEvents) != 1 || client.sentEvents[0] != want {
+		t.Errorf("sent %v, want a single %v", client.sentEvents, want)
+	}
+	if client.closed {
+		t.Fatal("disconnected at the warning")
+	}
+
+	offend(commandStrikesToDisconnect - commandStrikesToWarn)
+	if !client.kicked {
+		t.Errorf("not disconnected after %d rejected commands", commandStrikesToDisconnect)
+	}
+}
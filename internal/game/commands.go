@@ -1,5 +1,20 @@
 package game
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// maxCommandCoordinate bounds the positions a command may report, well beyond
+// the largest map.
+const maxCommandCoordinate = 1 << 16
+
+// gameCommand is a command a client sends to the game. validate checks the
+// decoded command is well formed; whether it is allowed is up to the game.
+type gameCommand interface {
+	validate() error
+}
+
 type DemoCommand struct {
 	DemoMessage string `json:"demoMessage"`
 }
@@ -15,27 +30,47 @@ type MoveCommand struct {
 	IsMoving  bool   `json:"isMoving"`
 }
 
+func (c MoveCommand) validate() error {
+	return validatePosition(c.X, c.Y, c.Direction)
+}
+
 type CastFireballCommand struct {
 	X         int    `json:"x"`
 	Y         int    `json:"y"`
 	Direction string `json:"direction"`
 }
 
+func (c CastFireballCommand) validate() error {
+	return validatePosition(c.X, c.Y, c.Direction)
+}
+
 type SwordAttackCommand struct {
 }
 
+func (c SwordAttackCommand) validate() error {
+	return nil
+}
+
 type ShootArrowCommand struct {
 	X         int    `json:"x"`
 	Y         int    `json:"y"`
 	Direction string `json:"direction"`
 }
 
+func (c ShootArrowCommand) validate() error {
+	return validatePosition(c.X, c.Y, c.Direction)
+}
+
 type DodgeCommand struct {
 	X         int    `json:"x"`
 	Y         int    `json:"y"`
 	Direction string `json:"direction"`
 }
 
+func (c DodgeCommand) validate() error {
+	return validatePosition(c.X, c.Y, c.Direction)
+}
+
 type HitPlayerCommand struct {
 	OriginClientID uint64 `json:"originClientId"`
 	MonsterID      int    `json:"monsterId"`
@@ -43,12 +78,78 @@ type HitPlayerCommand struct {
 	Kind           string `json:"kind"`
 }
 
-type HitMonsterCommand struct {
-	OriginClientID uint64 `json:"originClientId"`
-	MonsterID      int    `json:"monsterId"`
-	Kind           string `json:"kind"`
+func (c HitPlayerCommand) validate() error {
+	if _, ok := damageDefs[c.Kind]; !ok {
+		return fmt.Errorf("unknown damage kind %q", c.Kind)
+	}
+	if c.MonsterID < trapOriginID {
+		return fmt.Errorf("invalid monster id %d", c.MonsterID)
+	}
+
+	return nil
+}
+
+type RespawnCommand struct {
+}
+
+func (c RespawnCommand) validate() error {
+	return nil
 }
 
 type UseItemCommand struct {
 	Kind string `json:"kind"`
 }
+
+func (c UseItemCommand) validate() error {
+	if itemDefs[c.Kind] == nil {
+		return fmt.Errorf("unknown item %q", c.Kind)
+	}
+
+	return nil
+}
+
+// validatePosition checks the position and facing a command reports.
+func validatePosition(x, y int, direction string) error {
+	if x < 0 || y < 0 || x > maxCommandCoordinate || y > maxCommandCoordinate {
+		return fmt.Errorf("position %d,%d out of bounds", x, y)
+	}
+	if vecX, vecY := getVectorFromDirection(direction); vecX == 0 && vecY == 0 {
+		return fmt.Errorf("invalid direction %q", direction)
+	}
+
+	return nil
+}
+
+// decodeGameCommand decodes and validates the data of the named command.
+func decodeGameCommand(commandName string, data json.RawMessage) (gameCommand, error) {
+	var c gameCommand
+	switch commandName {
+	case "PlayerMoveCommand":
+		c = &MoveCommand{}
+	case "CastFireballCommand":
+		c = &CastFireballCommand{}
+	case "SwordAttackCommand":
+		c = &SwordAttackCommand{}
+	case "ShootArrowCommand":
+		c = &ShootArrowCommand{}
+	case "DodgeCommand":
+		c = &DodgeCommand{}
+	case "HitPlayerCommand":
+		c = &HitPlayerCommand{}
+	case "RespawnCommand":
+		// The web client sends no data with it.
+		return &RespawnCommand{}, nil
+	case "UseItemCommand":
+		c = &UseItemCommand{}
+	default:
+		return nil, fmt.Errorf("unknown command %s", commandName)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", commandName, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", commandName, err)
+	}
+
+	return c, nil
+}
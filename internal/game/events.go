@@ -17,20 +17,30 @@ type XPEvent struct {
 }
 
 type FireballEvent struct {
-	ClientID  uint64 `json:"clientId"`
-	X         int    `json:"x"`
-	Y         int    `json:"y"`
-	Direction string `json:"direction"`
-	Distance  int    `json:"distance"`
+	ProjectileID int    `json:"projectileId"`
+	ClientID     uint64 `json:"clientId"`
+	X            int    `json:"x"`
+	Y            int    `json:"y"`
+	Direction    string `json:"direction"`
+	Distance     int    `json:"distance"`
 }
 
 type ShootArrowEvent struct {
-	ClientID uint64 `json:"clientId"`
-	X1       int    `json:"x1"`
-	Y1       int    `json:"y1"`
-	X2       int    `json:"x2"`
-	Y2       int    `json:"y2"`
-	Velocity int    `json:"velocity"`
+	ProjectileID int    `json:"projectileId"`
+	ClientID     uint64 `json:"clientId"`
+	X1           int    `json:"x1"`
+	Y1           int    `json:"y1"`
+	X2           int    `json:"x2"`
+	Y2           int    `json:"y2"`
+	Velocity     int    `json:"velocity"`
+}
+
+// ProjectileDestroyedEvent tells clients that a server-simulated projectile
+// stopped at (X, Y), by hitting a wall or a creature or by reaching its range.
+type ProjectileDestroyedEvent struct {
+	ProjectileID int `json:"projectileId"`
+	X            int `json:"x"`
+	Y            int `json:"y"`
 }
 
 type SwordAttackPrepareEvent struct {
@@ -51,12 +61,13 @@ type SwordAttackEvent struct {
 }
 
 type ArrowEvent struct {
-	ClientID  uint64 `json:"clientId"`
-	MonsterID int    `json:"monsterId"`
-	X1        int    `json:"x1"`
-	Y1        int    `json:"y1"`
-	X2        int    `json:"x2"`
-	Y2        int    `json:"y2"`
+	ProjectileID int    `json:"projectileId"`
+	ClientID     uint64 `json:"clientId"`
+	MonsterID    int    `json:"monsterId"`
+	X1           int    `json:"x1"`
+	Y1           int    `json:"y1"`
+	X2           int    `json:"x2"`
+	Y2           int    `json:"y2"`
 }
 
 type SpawnSpikeEvent struct {
@@ -74,12 +85,13 @@ type TrapStateChangedEvent struct {
 }
 
 type DemonFireballEvent struct {
-	ClientID  uint64 `json:"clientId"`
-	MonsterID int    `json:"monsterId"`
-	X1        int    `json:"x1"`
-	Y1        int    `json:"y1"`
-	X2        int    `json:"x2"`
-	Y2        int    `json:"y2"`
+	ProjectileID int    `json:"projectileId"`
+	ClientID     uint64 `json:"clientId"`
+	MonsterID    int    `json:"monsterId"`
+	X1           int    `json:"x1"`
+	Y1           int    `json:"y1"`
+	X2           int    `json:"x2"`
+	Y2           int    `json:"y2"`
 }
 
 type FireCircleEvent struct {
@@ -140,6 +152,12 @@ type EndGameEvent struct {
 	WinningSide string `json:"winningSide"`
 	// Roles reveals every player's true allegiance on the final screen.
 	Roles []PlayerRole `json:"roles"`
+	// Seed is the match seed, so a reported match can be reproduced. It is sent
+	// as a string because it doesn't fit in a JavaScript number.
+	Seed int64 `json:"seed,string"`
+	// ReplayID names the match's replay, watchable at /replays/{id}. Empty when
+	// the match was not recorded.
+	ReplayID string `json:"replayId,omitempty"`
 }
 
 // PlayerRole is one entry in the end-game role reveal.
@@ -155,6 +173,16 @@ type PlayerRole struct {
 // whether the viewer is a cultist.
 type BossRevealedEvent struct{}
 
+// StairsOpenedEvent is broadcast when the keys of a floor above the last one are
+// all collected. The stairs down appear at the floor's player spawn as the object
+// ObjectID.
+type StairsOpenedEvent struct {
+	ObjectID int `json:"objectId"`
+	X        int `json:"x"`
+	Y        int `json:"y"`
+	Floor    int `json:"floor"`
+}
+
 type PlayerStats struct {
 	PlayerPosition
 	Class             string `json:"class"`
@@ -169,6 +197,11 @@ type PlayerStats struct {
 	SpeedBoostPercent int    `json:"speedBoostPercent"`
 	HasShield         bool   `json:"hasShield"`
 	IsInvisible       bool   `json:"isInvisible"`
+	// Disconnected is set while the player's client has lost its connection.
+	Disconnected bool `json:"disconnected,omitempty"`
+	// Hidden is set when the recipient cannot see the player. The player is
+	// listed for the player list only, without a position.
+	Hidden bool `json:"hidden,omitempty"`
 }
 
 type MonsterStats struct {
@@ -234,6 +267,15 @@ type PlayerTeleportEvent struct {
 	Y        int    `json:"y"`
 }
 
+// PlayerPositionCorrectionEvent is sent only to a player whose reported move was
+// rejected (too fast, or through a wall). The client snaps back to the
+// server-side position.
+type PlayerPositionCorrectionEvent struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Direction string `json:"direction"`
+}
+
 // Reasons a respawn or rejoin can be refused.
 const (
 	respawnDeniedEliminated  = "eliminated"
@@ -247,6 +289,19 @@ type RespawnDeniedEvent struct {
 	Reason string `json:"reason"`
 }
 
+// Reasons a command can be rejected.
+const (
+	commandRejectedRateLimited = "rateLimited"
+	commandRejectedInvalid     = "invalid"
+)
+
+// CommandRejectedEvent warns a client that the game keeps dropping its commands,
+// sent too fast or malformed. A client that goes on is disconnected.
+type CommandRejectedEvent struct {
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+}
+
 type InventoryItem struct {
 	Kind       string `json:"kind"`
 	Count      int    `json:"count"`
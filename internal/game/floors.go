@@ -0,0 +1,173 @@
+package game
+
+import (
+	"log"
+)
+
+// A dungeon can be several floors deep. Collecting the three keys of a floor
+// opens stairs down at its player spawn instead of unsealing the demon; the
+// first living player to reach them takes everyone to the next floor, which is
+// generated fresh with its own monsters, chests, keys and traps. The demon waits
+// on the last floor. Players keep their level, items and team from floor to
+// floor.
+//
+// Generating a floor takes hundreds of milliseconds, so it starts in the
+// background as soon as the stairs open, and only the finished map is swapped
+// in under g.mutex. Players who reach the stairs earlier wait on them.
+
+// FloorGenerator returns the map of a floor below the first one. floor is
+// 1-based and seed is drawn from the match RNG.
+type FloorGenerator func(floor int, seed int64) (*Map, error)
+
+// floorExtraRooms is how many more rooms each floor has than the one above.
+const floorExtraRooms = 2
+
+// stairsReach is how close a living player must get to the stairs to go down.
+const stairsReach = tileSize
+
+// nextFloor is a floor being generated in the background.
+type nextFloor struct {
+	number  int
+	ready   chan struct{} // closed once gameMap or err is set
+	gameMap *Map
+	err     error
+}
+
+// GeneratedFloors generates every floor from the room-template maps at
+// templates, laid out as layout: rooms rooms on the first floor and
+// floorExtraRooms more on each next one. The templates are mixed on every floor
+// like LoadMixedMap does, or with perFloor each floor is generated from one of
+// them in turn, so the theme changes from floor to floor.
+func GeneratedFloors(templates []string, layout MapLayout, rooms int, perFloor bool) FloorGenerator {
+	return func(floor int, seed int64) (*Map, error) {
+		filenames := templates
+		if perFloor {
+			filenames = []string{templates[(floor-1)%len(templates)]}
+		}
+		return LoadMixedMap(filenames, layout, rooms+(floor-1)*floorExtraRooms, seed)
+	}
+}
+
+// UseFloors makes the match floors deep, with generate making the floors below
+// the first one. It must be called before StartMainLoop.
+func (g *Game) UseFloors(floors int, generate FloorGenerator) {
+	g.floors = max(floors, 1)
+	g.generateFloor = generate
+}
+
+// isLastFloorUnsafe reports whether the players are on the floor of the demon.
+// Caller must hold g.mutex.
+func (g *Game) isLastFloorUnsafe() bool {
+	return g.floor >= g.floors
+}
+
+// openStairsUnsafe places the stairs down at the floor's player spawn. Caller
+// must hold g.mutex.
+func (g *Game) openStairsUnsafe() {
+	x, y := g.gameMap.PlayerSpawn()
+	id := len(g.objects) + 1
+	g.objects[uint64(id)] = &Object{
+		ID:     id,
+		Kind:   objectKindStairs,
+		X:      x,
+		Y:      y,
+		Width:  tileSize,
+		Height: tileSize,
+		State:  "open",
+	}
+	g.broadcastEventFunc(StairsOpenedEvent{ObjectID: id, X: x, Y: y, Floor: g.floor})
+
+	g.prepareNextFloorUnsafe()
+}
+
+// prepareNextFloorUnsafe starts generating the floor below. The seed is drawn
+// here, under the lock, to keep floors reproducible from the match seed.
+// Caller must hold g.mutex.
+func (g *Game) prepareNextFloorUnsafe() {
+	next := &nextFloor{number: g.floor + 1, ready: make(chan struct{})}
+	generate, seed := g.generateFloor, g.rng.Int63()
+	go func() {
+		defer close(next.ready)
+		next.gameMap, next.err = generate(next.number, seed)
+	}()
+	g.nextFloor = next
+}
+
+// tickStairs reports whether a living player stands on the stairs.
+func (g *Game) tickStairs(obj *Object) bool {
+	for _, player := range g.players {
+		if player.hp > 0 && !player.isSpectator && getDistance(obj.X, obj.Y, player.x, player.y) <= stairsReach {
+			return true
+		}
+	}
+
+	return false
+}
+
+// descendUnsafe takes every player to the freshly generated next floor, once
+// it is ready. If the floor cannot be generated, the demon is unsealed on this
+// one instead. Caller must hold g.mutex.
+func (g *Game) descendUnsafe() {
+	next := g.nextFloor
+	if next == nil {
+		return
+	}
+	select {
+	case <-next.ready:
+	default:
+		return
+	}
+	g.nextFloor = nil
+
+	gameMap, err := next.gameMap, next.err
+	if err != nil {
+		log.Printf("cannot generate floor %d: %v\n", next.number, err)
+		g.floors = g.floor
+		g.spawnDemonUnsafe()
+		return
+	}
+
+	g.floor++
+	g.gameMap = gameMap
+	g.resetFloorUnsafe()
+	g.spawnInitialMonsters()
+	g.spawnInitialObjects()
+	log.Printf("players went down to floor %d of %d\n", g.floor, g.floors)
+
+	// Everyone comes along. The dead are revived: respawning is free before the
+	// demon is unsealed anyway.
+	x, y := g.gameMap.PlayerSpawn()
+	for _, p := range g.players {
+		p.x, p.y = x, y
+		p.isMoving = false
+		p.isDodging = false
+		if p.hp <= 0 {
+			p.hp = p.maxHp
+		}
+	}
+
+	// The clients load the new floor as if they had just joined.
+	g.recordReplayViewUnsafe()
+	for _, p := range g.players {
+		p.client.SendEvent(JoinToStartedGameEvent{GameData: g.getPlayerInitialGameData(p)})
+	}
+}
+
+// resetFloorUnsafe forgets the monsters, objects, traps, keys and everything
+// else that belongs to the floor the players are leaving. Caller must hold
+// g.mutex.
+func (g *Game) resetFloorUnsafe() {
+	g.monsters = []*Monster{}
+	g.objects = make(map[uint64]*Object)
+	g.traps = make(map[string]*Trap)
+	g.keysCollected = map[string]bool{
+		"1": false,
+		"2": false,
+		"3": false,
+	}
+	g.spikeEvents = make([]SpawnSpikeEvent, 0)
+	g.updateTilesEvents = make([]UpdateTilesEvent, 0)
+	g.projectiles = nil
+	g.recentAttacks = nil
+	g.positionSnapshots = nil
+}
@@ -0,0 +1,186 @@
+package game
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestFloor builds a map whose player spawn is at (x, y) with a skeleton, the
+// demon's spawn point and a chest.
+func newTestFloor(x, y int) *Map {
+	m := newTestMap(40, 40)
+	m.spawnX, m.spawnY = x, y
+	m.Layers = []MapLayer{
+		{Name: "spawns", Objects: []MapObject{
+			{Id: 1, Name: "skeleton", X: 400, Y: 400},
+			{Id: 2, Name: "demon", X: 500, Y: 500},
+		}},
+		{Name: "objects", Objects: []MapObject{
+			{Id: 3, Type: "chest", X: 300, Y: 300},
+		}},
+	}
+
+	return m
+}
+
+// newTestFloorsGame returns a game two floors deep whose next floor spawns the
+// players at (200, 200).
+func newTestFloorsGame() (*Game, *[]interface{}) {
+	g, broadcast := newTestGame()
+	g.gameMap = newTestFloor(100, 100)
+	g.UseFloors(2, func(floor int, seed int64) (*Map, error) {
+		return newTestFloor(200, 200), nil
+	})
+
+	return g, broadcast
+}
+
+// collectKeysUnsafe opens a key chest at p's position with the other two keys
+// already collected, and waits for the next floor, if any, to be generated.
+func collectKeysUnsafe(g *Game, p *Player) {
+	g.keysCollected["1"], g.keysCollected["2"], g.keysCollected["3"] = true, true, false
+	g.objects[99] = &Object{ID: 99, Kind: objectKindChest, X: p.x, Y: p.y, State: "closed", HasKey: true}
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+	if g.nextFloor != nil {
+		<-g.nextFloor.ready
+	}
+}
+
+func TestKeysOpenStairsAboveLastFloor(t *testing.T) {
+	g, broadcast := newTestFloorsGame()
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 500, 100
+
+	collectKeysUnsafe(g, p)
+
+	if g.demonWasSpawned {
+		t.Fatal("demon spawned on floor 1 of 2")
+	}
+	var opened *StairsOpenedEvent
+	for _, e := range *broadcast {
+		if e, ok := e.(StairsOpenedEvent); ok {
+			opened = &e
+		}
+	}
+	if opened == nil || opened.X != 100 || opened.Y != 100 || opened.Floor != 1 {
+		t.Fatalf("stairs event = %+v, want stairs at the player spawn (100, 100) of floor 1", opened)
+	}
+	if stairs := g.objects[uint64(opened.ObjectID)]; stairs == nil || stairs.Kind != objectKindStairs {
+		t.Errorf("object %d = %+v, want the stairs", opened.ObjectID, stairs)
+	}
+}
+
+func TestDescendResetsFloor(t *testing.T) {
+	g, _ := newTestFloorsGame()
+	g.spawnInitialMonsters()
+	g.spawnInitialObjects()
+	p, client := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 500, 100
+	dead, _ := addTestPlayer(g, 2, ClassMage)
+	dead.hp = 0
+	collectKeysUnsafe(g, p)
+	g.traps["t"] = &Trap{ID: "t"}
+	killed := g.monsters[0]
+	g.hitMonsterUnsafe(1, killed.id, killed.hp)
+
+	// Going down the stairs.
+	p.x, p.y = 100, 100
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+
+	if g.floor != 2 {
+		t.Fatalf("floor = %d, want 2", g.floor)
+	}
+	if len(g.monsters) != 1 || g.monsters[0].hp <= 0 || g.monsters[0].x != 400 {
+		t.Errorf("monsters = %+v, want the new floor's skeleton", g.monsters)
+	}
+	if len(g.objects) != 1 || g.objects[1].Kind != objectKindChest || g.objects[1].State != "closed" {
+		t.Errorf("objects = %+v, want the new floor's chest", g.objects)
+	}
+	if len(g.traps) != 0 {
+		t.Errorf("traps = %v, want none", g.traps)
+	}
+	if g.monsterDeaths[killed.kind] != 1 {
+		t.Errorf("monster deaths = %v, want the kill on floor 1 counted", g.monsterDeaths)
+	}
+	for number, collected := range g.keysCollected {
+		if collected {
+			t.Errorf("key %s collected on a new floor", number)
+		}
+	}
+	for _, pl := range []*Player{p, dead} {
+		if pl.x != 200 || pl.y != 200 || pl.hp != pl.maxHp {
+			t.Errorf("player %d at (%d, %d) with %d HP, want alive at the new spawn (200, 200)", pl.client.ID(), pl.x, pl.y, pl.hp)
+		}
+	}
+	join, ok := client.sentEvents[len(client.sentEvents)-1].(JoinToStartedGameEvent)
+	if !ok || join.GameData["floor"] != 2 || join.GameData["mapData"] != g.gameMap {
+		t.Errorf("last event = %+v, want the new floor's game data", client.sentEvents[len(client.sentEvents)-1])
+	}
+
+	// The demon waits on the last floor.
+	collectKeysUnsafe(g, p)
+	if !g.demonWasSpawned {
+		t.Error("demon not spawned on the last floor")
+	}
+}
+
+func TestDescendFailureUnsealsDemon(t *testing.T) {
+	g, _ := newTestFloorsGame()
+	g.generateFloor = func(int, int64) (*Map, error) { return nil, errors.New("no rooms") }
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 500, 100
+	collectKeysUnsafe(g, p)
+
+	p.x, p.y = 100, 100
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+
+	if g.floor != 1 || !g.demonWasSpawned {
+		t.Errorf("floor = %d, demon = %v; want the demon on floor 1", g.floor, g.demonWasSpawned)
+	}
+}
+
+func TestPlayersWaitOnStairsForNextFloor(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestFloor(100, 100)
+	release := make(chan struct{})
+	g.UseFloors(2, func(floor int, seed int64) (*Map, error) {
+		<-release
+		return newTestFloor(200, 200), nil
+	})
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 500, 100
+	g.keysCollected["1"], g.keysCollected["2"] = true, true
+	g.objects[99] = &Object{ID: 99, Kind: objectKindChest, X: p.x, Y: p.y, State: "closed", HasKey: true}
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+
+	// The floor is still being generated: the tick must not wait for it.
+	p.x, p.y = 100, 100
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+	if g.floor != 1 {
+		t.Fatalf("floor = %d before the next floor was generated, want 1", g.floor)
+	}
+
+	close(release)
+	<-g.nextFloor.ready
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+	if g.floor != 2 || p.x != 200 || p.y != 200 {
+		t.Errorf("floor = %d, player at (%d, %d); want floor 2 at (200, 200)", g.floor, p.x, p.y)
+	}
+}
+
+func TestSplitJellyDoesNotFollowPlayersDown(t *testing.T) {
+	g, _ := newTestFloorsGame()
+	addTestPlayer(g, 1, ClassKnight)
+	jelly := &Monster{id: 1, kind: monsterKindJelly, hp: 100, damage: 10}
+	g.monsters = append(g.monsters, jelly)
+
+	g.splitJellyUnsafe(jelly, 1)
+	g.floor++
+	g.monsters = []*Monster{}
+	stepFor(g, 2*time.Second)
+
+	if len(g.monsters) != 0 {
+		t.Errorf("monsters = %+v, want no jellies from the floor above", g.monsters)
+	}
+}
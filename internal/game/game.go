@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"slices"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -75,6 +77,7 @@ const objectKindChest = "chest"
 const objectKindTrigger = "trigger"
 const objectKindTrapArrow = "trap_arrow"
 const objectKindTrapSpikes = "trap_spikes"
+const objectKindStairs = "stairs"
 
 const damageKindFireball = "fireball"
 const damageKindArrow = "arrow"
@@ -110,6 +113,9 @@ type Player struct {
 	direction             string
 	isMoving              bool
 	isDodging             bool
+	lastMoveAt            time.Time // when the last client-reported position was accepted
+	dodgeStartedAt        time.Time // when the last dodge was granted
+	commands              commandGuard
 	inventory             []InventoryItem
 	footprintsActiveUntil time.Time
 	protectionActiveUntil time.Time
@@ -124,14 +130,17 @@ type Player struct {
 	// so they can watch the battlemap, but their gameplay commands are ignored and
 	// they are not broadcast to others as active players.
 	isSpectator bool
+	// disconnected is set while the player's client has lost its connection and
+	// may still resume its session.
+	disconnected bool
 	// goodDeathsBeforeBoss counts this player's deaths that fed Soul Power while
 	// good and before the boss phase. They are uncounted if the player is cursed
 	// into a cultist.
 	goodDeathsBeforeBoss int
 }
 
-func (p *Player) isInvisible() bool {
-	return !p.invisibleUntil.IsZero() && time.Now().Before(p.invisibleUntil)
+func (p *Player) isInvisible(now time.Time) bool {
+	return !p.invisibleUntil.IsZero() && now.Before(p.invisibleUntil)
 }
 
 type Monster struct {
@@ -186,10 +195,10 @@ type LootItem struct {
 	Count int
 }
 
-func newPlayer(client lobby.ClientPlayer) *Player {
-	colorHex := playerColors[rand.Intn(len(playerColors))]
+func newPlayer(client lobby.ClientPlayer, rng *rand.Rand) *Player {
+	colorHex := playerColors[rng.Intn(len(playerColors))]
 
-	class := classList[rand.Intn(len(classList))]
+	class := classList[rng.Intn(len(classList))]
 
 	props := client.GetAdditionalProperties()
 	if cls, ok := props["class"].(string); ok {
@@ -250,12 +259,30 @@ type Game struct {
 	monsters           []*Monster
 	objects            map[uint64]*Object
 	gameMap            *Map
+	floor              int // 1-based floor the players are on, out of floors
+	floors             int
+	generateFloor      FloorGenerator // makes the floors below the first, see floors.go
+	nextFloor          *nextFloor     // the floor below, once the stairs are open
 	demonWasSpawned    bool
 	keysCollected      map[string]bool
 	spikeEvents        []SpawnSpikeEvent
 	updateTilesEvents  []UpdateTilesEvent
 	traps              map[string]*Trap
 	positionSnapshots  []positionSnapshot
+	projectiles        []*Projectile
+	lastProjectileID   int
+	recentAttacks      []*recentAttack
+	clock              Clock
+	simNow             time.Time // time of the current simulation step
+	stepCount          int
+	tasks              []scheduledTask // pending scheduled work, ordered by due time
+	// seed is the match seed. Every random decision of the match (classes,
+	// colors, loot placement, curse rolls, arrow dispersion) is drawn from rng,
+	// so replaying a match with the same seed reproduces them.
+	seed int64
+	rng  *rand.Rand
+	// replay records the match for later review; nil when not recording.
+	replay ReplayRecorder
 	// soulPower is a running tally: +1 for every good player that dies before the
 	// boss phase, -1 for every cultist that dies before the boss phase. Once the
 	// boss is revealed it is no longer fed by deaths and is instead spent by
@@ -264,18 +291,30 @@ type Game struct {
 	// debug lets good players see the Soul Power value (used for local/dev
 	// environments). Cultists always see it.
 	debug bool
+	// monsterDifficulty scales the monsters to the party; nil keeps the map's.
+	monsterDifficulty *MonsterDifficultyDef
+	// monsterDeaths counts the monsters killed on every floor, by kind.
+	monsterDeaths map[string]int
 }
 
-func NewGame(playersClients []lobby.ClientPlayer, room *lobby.Room, broadcastEventFunc func(event interface{}), gameMap *Map, debug bool) *Game {
+// NewGame creates a match. seed drives all of the match's randomness; pass 0 to
+// derive one from the current time.
+func NewGame(playersClients []lobby.ClientPlayer, room *lobby.Room, broadcastEventFunc func(event interface{}), gameMap *Map, debug bool, seed int64) *Game {
+	clock := systemClock{}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
 	spawnX, spawnY := gameMap.PlayerSpawn()
 	players := make(map[uint64]*Player, len(playersClients))
 	for _, client := range playersClients {
-		p := newPlayer(client)
+		p := newPlayer(client, rng)
 		p.x, p.y = spawnX, spawnY
 		players[client.ID()] = p
 	}
 
-	log.Printf("new game created by %s\n", playersClients[0].Nickname())
+	log.Printf("new game created by %s (seed %d)\n", playersClients[0].Nickname(), seed)
 
 	return &Game{
 		status:             StatusStarted,
@@ -285,8 +324,11 @@ func NewGame(playersClients []lobby.ClientPlayer, room *lobby.Room, broadcastEve
 		room:               room,
 		monsters:           []*Monster{},
 		gameMap:            gameMap,
+		floor:              1,
+		floors:             1,
 		debug:              debug,
 		objects:            make(map[uint64]*Object),
+		monsterDeaths:      make(map[string]int),
 		keysCollected: map[string]bool{
 			"1": false,
 			"2": false,
@@ -295,6 +337,10 @@ func NewGame(playersClients []lobby.ClientPlayer, room *lobby.Room, broadcastEve
 		spikeEvents:       make([]SpawnSpikeEvent, 0),
 		updateTilesEvents: make([]UpdateTilesEvent, 0),
 		traps:             make(map[string]*Trap),
+		clock:             clock,
+		simNow:            clock.Now(),
+		seed:              seed,
+		rng:               rng,
 	}
 }
 
@@ -308,86 +354,44 @@ func (g *Game) DispatchGameCommand(client lobby.ClientPlayer, commandName string
 		log.Printf("cannot decode event data for event name = %s\n", commandName)
 		return
 	}
+	command := g.admitCommand(client, commandName, eventDataJson)
+	if command == nil {
+		return
+	}
+	if g.replay != nil {
+		g.replay.RecordCommand(client.ID(), commandName, eventDataJson)
+	}
 
-	switch commandName {
-	case "PlayerMoveCommand":
-		var c MoveCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode PlayerMoveCommand: %v\n", err)
-
-			return
-		}
+	switch c := command.(type) {
+	case *MoveCommand:
 		g.movePlayerTo(client.ID(), c.X, c.Y, c.Direction, c.IsMoving)
-		break
-	case "CastFireballCommand":
-		var c CastFireballCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode CastFireballCommand: %v\n", err)
-
-			return
-		}
-		g.castFireball(client.ID(), c.X, c.Y, c.Direction)
-		break
-	case "SwordAttackCommand":
-		var c SwordAttackCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode SwordAttackCommand: %v\n", err)
-
-			return
-		}
+	case *CastFireballCommand:
+		g.castFireball(client.ID(), c.Direction)
+	case *SwordAttackCommand:
 		g.attackWithSword(client.ID())
-		break
-	case "ShootArrowCommand":
-		var c ShootArrowCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode ShootArrowCommand: %v\n", err)
-
-			return
-		}
-		g.shootArrow(client.ID(), c.X, c.Y, c.Direction)
-		break
-	case "DodgeCommand":
-		var c DodgeCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode DodgeCommand: %v\n", err)
-
-			return
-		}
+	case *ShootArrowCommand:
+		g.shootArrow(client.ID(), c.Direction)
+	case *DodgeCommand:
 		g.dodge(client.ID(), c.X, c.Y, c.Direction, true)
-		break
-	case "HitPlayerCommand":
-		var c HitPlayerCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode HitPlayerCommand: %v\n", err)
-
+	case *HitPlayerCommand:
+		// Projectile hits are resolved by the server simulation.
+		if projectileDamageKinds[c.Kind] {
 			return
 		}
 
 		g.mutex.Lock()
-		g.hitPlayerWithKindUnsafe(c.TargetClientID, c.Kind)
+		valid, strikes := g.validateHitUnsafe(client.ID(), *c)
+		if valid {
+			g.hitPlayerWithKindUnsafe(c.TargetClientID, c.Kind)
+		}
 		g.mutex.Unlock()
-		break
-	case "HitMonsterCommand":
-		var c HitMonsterCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode HitMonsterCommand: %v\n", err)
-
-			return
+		if strikes > 0 {
+			g.rejectCommand(client, "HitPlayerCommand", commandRejectedInvalid, "implausible hit", strikes)
 		}
-		damage := damageForKind(c.Kind)
-		g.hitMonster(c.OriginClientID, c.MonsterID, damage)
-		break
-	case "RespawnCommand":
+	case *RespawnCommand:
 		g.respawnPlayer(client.ID())
-		break
-	case "UseItemCommand":
-		var c UseItemCommand
-		if err := json.Unmarshal(eventDataJson, &c); err != nil {
-			log.Printf("cannot decode UseItemCommand: %v\n", err)
-			return
-		}
+	case *UseItemCommand:
 		g.useItem(client.ID(), c.Kind)
-		break
 	}
 }
 
@@ -404,7 +408,7 @@ func (g *Game) OnClientRemoved(client lobby.ClientPlayer) {
 func (g *Game) OnClientJoined(client lobby.ClientPlayer) {
 	log.Printf("client '%s' joined game\n", client.Nickname())
 	g.mutex.Lock()
-	p := newPlayer(client)
+	p := newPlayer(client, g.rng)
 	p.x, p.y = g.playerSpawn()
 	if g.demonWasSpawned {
 		// Anyone joining or rejoining after the boss is revealed cannot play; they
@@ -417,14 +421,50 @@ func (g *Game) OnClientJoined(client lobby.ClientPlayer) {
 	if g.demonWasSpawned {
 		g.checkCultistsWinUnsafe()
 	}
+	gameData := g.getPlayerInitialGameData(p)
 	g.mutex.Unlock()
-	client.SendEvent(JoinToStartedGameEvent{GameData: g.getPlayerInitialGameData(p)})
+
+	client.SendEvent(JoinToStartedGameEvent{GameData: gameData})
+}
+
+// OnClientDisconnected keeps the player of a client that lost its connection,
+// standing still, until it reconnects or is removed.
+func (g *Game) OnClientDisconnected(client lobby.ClientPlayer) {
+	log.Printf("client '%s' disconnected from game\n", client.Nickname())
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if p, ok := g.players[client.ID()]; ok {
+		p.disconnected = true
+		p.isMoving = false
+		p.isDodging = false
+	}
+}
+
+// OnClientReconnected sends a client that resumed its session the full state of
+// the match, as if it had just joined, and gives it its player back.
+func (g *Game) OnClientReconnected(client lobby.ClientPlayer) {
+	g.mutex.Lock()
+	p, ok := g.players[client.ID()]
+	if !ok {
+		g.mutex.Unlock()
+		g.OnClientJoined(client)
+		return
+	}
+	log.Printf("client '%s' reconnected to game\n", client.Nickname())
+	p.disconnected = false
+	p.client = client
+	gameData := g.getPlayerInitialGameData(p)
+	g.mutex.Unlock()
+
+	client.SendEvent(JoinToStartedGameEvent{GameData: gameData})
 }
 
 func (g *Game) GetCommonInitialGameData() map[string]interface{} {
 	return map[string]interface{}{}
 }
 
+// getPlayerInitialGameData returns everything a client needs to start playing
+// as pl. Caller must hold g.mutex.
 func (g *Game) getPlayerInitialGameData(pl *Player) map[string]interface{} {
 	// Convert traps to initial state data
 	trapsData := make([]map[string]interface{}, 0, len(g.traps))
@@ -438,9 +478,16 @@ func (g *Game) getPlayerInitialGameData(pl *Player) map[string]interface{} {
 		})
 	}
 
+	// Cultists know each other. The roster is sent again here because a client
+	// starts over on every floor.
+	var cultistIDs []uint64
+	if pl.isCultist {
+		cultistIDs = g.cultistIDsUnsafe()
+	}
+
 	return map[string]interface{}{
 		"mapData":     g.gameMap,
-		"gameObjects": g.objects,
+		"gameObjects": g.objectSnapshotsUnsafe(),
 		"playerData": PlayerStats{
 			PlayerPosition: PlayerPosition{
 				ClientID:  pl.client.ID(),
@@ -464,13 +511,16 @@ func (g *Game) getPlayerInitialGameData(pl *Player) map[string]interface{} {
 		"spikeEvents":       g.spikeEvents,
 		"updateTilesEvents": g.updateTilesEvents,
 		"traps":             trapsData,
-		"inventory":         pl.inventory,
+		"inventory":         slices.Clone(pl.inventory),
 		"speedBoostPercent": pl.speedBoostPercent,
 		"soulPower":         g.soulPower,
 		"soulPowerVisible":  pl.isCultist || g.debug,
 		"isCultist":         pl.isCultist,
 		"isSpectator":       pl.isSpectator,
 		"bossRevealed":      g.demonWasSpawned,
+		"floor":             g.floor,
+		"floors":            g.floors,
+		"cultistIds":        cultistIDs,
 	}
 }
 
@@ -483,159 +533,146 @@ func (g *Game) sendPlayerInitialGameData() {
 func (g *Game) StartMainLoop() {
 	g.spawnInitialMonsters()
 	g.spawnInitialObjects()
+	g.recordReplayStart()
 	g.sendPlayerInitialGameData()
-	go g.startIntellect()
-	go g.startObjectsLoop()
-	tickerPositions := time.NewTicker(positionsUpdateTickPeriod)
-	tickerCommon := time.NewTicker(commonUpdateTickPeriod)
-	defer tickerPositions.Stop()
-	defer tickerCommon.Stop()
-	for {
-		select {
-		case <-tickerPositions.C:
-			if g.isGameEnded() {
-				return
-			}
-
-			g.mutex.Lock()
-
-			g.moveMonstersUnsafe()
-
-			p := make([]PlayerPosition, 0, len(g.players))
-			for _, pl := range g.players {
-				if pl.isSpectator || !pl.isMoving {
-					continue
-				}
-				p = append(p, PlayerPosition{
-					ClientID:  pl.client.ID(),
-					X:         pl.x,
-					Y:         pl.y,
-					Direction: pl.direction,
-					IsMoving:  pl.isMoving,
-					IsDodging: pl.isDodging,
-				})
-			}
-			m := make([]MonsterPosition, 0, len(g.monsters))
-			for _, mon := range g.monsters {
-				if !mon.isMoving && !mon.isAttacking {
-					continue
-				}
-				m = append(m, MonsterPosition{
-					ID:          mon.id,
-					X:           mon.x,
-					Y:           mon.y,
-					Direction:   mon.direction,
-					IsMoving:    mon.isMoving,
-					IsAttacking: mon.isAttacking,
-				})
-			}
+	g.runLoop()
+}
 
-			g.mutex.Unlock()
+// collectPositionsUnsafe builds the positions update for players and monsters
+// that are moving or attacking, or returns nil if nothing is. Caller must hold
+// g.mutex.
+func (g *Game) collectPositionsUnsafe() *CreaturesPosUpdateEvent {
+	p := make([]PlayerPosition, 0, len(g.players))
+	for _, pl := range g.players {
+		if pl.isSpectator || !pl.isMoving {
+			continue
+		}
+		p = append(p, PlayerPosition{
+			ClientID:  pl.client.ID(),
+			X:         pl.x,
+			Y:         pl.y,
+			Direction: pl.direction,
+			IsMoving:  pl.isMoving,
+			IsDodging: pl.isDodging,
+		})
+	}
+	m := make([]MonsterPosition, 0, len(g.monsters))
+	for _, mon := range g.monsters {
+		if !mon.isMoving && !mon.isAttacking {
+			continue
+		}
+		m = append(m, MonsterPosition{
+			ID:          mon.id,
+			X:           mon.x,
+			Y:           mon.y,
+			Direction:   mon.direction,
+			IsMoving:    mon.isMoving,
+			IsAttacking: mon.isAttacking,
+		})
+	}
 
-			// Broadcast outside the lock (network sends shouldn't block command
-			// handling), and skip entirely when nothing is moving or attacking.
-			if len(p) > 0 || len(m) > 0 {
-				g.broadcastEventFunc(CreaturesPosUpdateEvent{
-					Players:  p,
-					Monsters: m,
-				})
-			}
-		case <-tickerCommon.C:
-			if g.isGameEnded() {
-				return
-			}
-			g.mutex.Lock()
+	if len(p) == 0 && len(m) == 0 {
+		return nil
+	}
 
-			p := make([]PlayerStats, 0, len(g.players))
-			for _, pl := range g.players {
-				if pl.isSpectator {
-					continue
-				}
-				p = append(p, PlayerStats{
-					PlayerPosition: PlayerPosition{
-						ClientID:  pl.client.ID(),
-						X:         pl.x,
-						Y:         pl.y,
-						Direction: pl.direction,
-						IsMoving:  pl.isMoving,
-					},
-					Class:             pl.class,
-					Nickname:          pl.client.Nickname(),
-					AvatarUrl:         pl.avatarUrl,
-					Color:             pl.color,
-					Level:             pl.level,
-					MaxHP:             pl.maxHp,
-					HP:                pl.hp,
-					SpeedBoostPercent: pl.speedBoostPercent,
-					HasShield:         !pl.protectionActiveUntil.IsZero() && time.Now().Before(pl.protectionActiveUntil),
-					IsInvisible:       pl.isInvisible(),
-				})
-			}
-			m := make([]MonsterStats, 0, len(g.monsters))
-			for _, mon := range g.monsters {
-				m = append(m, MonsterStats{
-					MonsterPosition: MonsterPosition{
-						ID:          mon.id,
-						X:           mon.x,
-						Y:           mon.y,
-						Direction:   mon.direction,
-						IsMoving:    mon.isMoving,
-						IsAttacking: mon.isAttacking,
-					},
-					Kind:  mon.kind,
-					HP:    mon.hp,
-					MaxHP: mon.maxHP,
-				})
-			}
+	return &CreaturesPosUpdateEvent{
+		Players:  p,
+		Monsters: m,
+	}
+}
 
-			now := time.Now()
+// collectStatsUnsafe builds the full stats update for players and monsters.
+// Caller must hold g.mutex.
+func (g *Game) collectStatsUnsafe() *CreaturesStatsUpdateEvent {
+	now := g.now()
 
-			// Record position snapshot (all alive players including self)
-			snap := positionSnapshot{
-				t:      now,
-				points: make([]FootprintPoint, 0, len(g.players)),
-			}
-			for _, pl := range g.players {
-				if pl.hp > 0 {
-					snap.points = append(snap.points, FootprintPoint{
-						ClientID: pl.client.ID(),
-						X:        pl.x,
-						Y:        pl.y,
-						Color:    pl.color,
-					})
-				}
-			}
-			cutoff := now.Add(-60 * time.Second)
-			for len(g.positionSnapshots) > 0 && g.positionSnapshots[0].t.Before(cutoff) {
-				g.positionSnapshots = g.positionSnapshots[1:]
-			}
-			g.positionSnapshots = append(g.positionSnapshots, snap)
-
-			// Collect footprint recipients (players with an active scroll) while
-			// holding the lock; the actual sends happen after unlocking.
-			var footprintClients []lobby.ClientPlayer
-			if len(snap.points) > 0 {
-				for _, pl := range g.players {
-					if pl.hp <= 0 || now.After(pl.footprintsActiveUntil) {
-						continue
-					}
-					footprintClients = append(footprintClients, pl.client)
-				}
-			}
+	p := make([]PlayerStats, 0, len(g.players))
+	for _, pl := range g.players {
+		if pl.isSpectator {
+			continue
+		}
+		p = append(p, PlayerStats{
+			PlayerPosition: PlayerPosition{
+				ClientID:  pl.client.ID(),
+				X:         pl.x,
+				Y:         pl.y,
+				Direction: pl.direction,
+				IsMoving:  pl.isMoving,
+			},
+			Class:             pl.class,
+			Nickname:          pl.client.Nickname(),
+			AvatarUrl:         pl.avatarUrl,
+			Color:             pl.color,
+			Level:             pl.level,
+			MaxHP:             pl.maxHp,
+			HP:                pl.hp,
+			SpeedBoostPercent: pl.speedBoostPercent,
+			HasShield:         !pl.protectionActiveUntil.IsZero() && now.Before(pl.protectionActiveUntil),
+			IsInvisible:       pl.isInvisible(now),
+			Disconnected:      pl.disconnected,
+		})
+	}
+	m := make([]MonsterStats, 0, len(g.monsters))
+	for _, mon := range g.monsters {
+		m = append(m, MonsterStats{
+			MonsterPosition: MonsterPosition{
+				ID:          mon.id,
+				X:           mon.x,
+				Y:           mon.y,
+				Direction:   mon.direction,
+				IsMoving:    mon.isMoving,
+				IsAttacking: mon.isAttacking,
+			},
+			Kind:  mon.kind,
+			HP:    mon.hp,
+			MaxHP: mon.maxHP,
+		})
+	}
 
-			g.mutex.Unlock()
+	return &CreaturesStatsUpdateEvent{
+		Players:  p,
+		Monsters: m,
+	}
+}
 
-			// Broadcast/send outside the lock so network sends don't block
-			// command handling.
-			g.broadcastEventFunc(CreaturesStatsUpdateEvent{
-				Players:  p,
-				Monsters: m,
+// recordFootprintsUnsafe records a position snapshot of all alive players and
+// returns the footprints event along with the players (with an active scroll)
+// who should receive it. Caller must hold g.mutex.
+func (g *Game) recordFootprintsUnsafe() (FootprintsEvent, []lobby.ClientPlayer) {
+	now := g.now()
+
+	// Record position snapshot (all alive players including self)
+	snap := positionSnapshot{
+		t:      now,
+		points: make([]FootprintPoint, 0, len(g.players)),
+	}
+	for _, pl := range g.players {
+		if pl.hp > 0 {
+			snap.points = append(snap.points, FootprintPoint{
+				ClientID: pl.client.ID(),
+				X:        pl.x,
+				Y:        pl.y,
+				Color:    pl.color,
 			})
-			for _, client := range footprintClients {
-				client.SendEvent(FootprintsEvent{Points: snap.points})
+		}
+	}
+	cutoff := now.Add(-60 * time.Second)
+	for len(g.positionSnapshots) > 0 && g.positionSnapshots[0].t.Before(cutoff) {
+		g.positionSnapshots = g.positionSnapshots[1:]
+	}
+	g.positionSnapshots = append(g.positionSnapshots, snap)
+
+	var footprintClients []lobby.ClientPlayer
+	if len(snap.points) > 0 {
+		for _, pl := range g.players {
+			if pl.hp <= 0 || now.After(pl.footprintsActiveUntil) {
+				continue
 			}
+			footprintClients = append(footprintClients, pl.client)
 		}
 	}
+
+	return FootprintsEvent{Points: snap.points}, footprintClients
 }
 
 func (g *Game) Status() string {
@@ -652,10 +689,7 @@ func (g *Game) movePlayerTo(clientID uint64, x int, y int, direction string, isM
 		if p.isSpectator {
 			return
 		}
-		p.x = x
-		p.y = y
-		p.direction = direction
-		p.isMoving = isMoving
+		g.applyMoveUnsafe(p, x, y, direction, isMoving)
 	}
 }
 
@@ -666,90 +700,124 @@ func (g *Game) dodge(clientID uint64, x int, y int, direction string, isMoving b
 		if p.isSpectator {
 			return
 		}
-		p.x = x
-		p.y = y
-		p.direction = direction
-		p.isMoving = isMoving
+		// A dodge reports the position it starts from, so validate it as a plain
+		// move; only then grant the dash speed, at most once per cooldown.
+		if !g.applyMoveUnsafe(p, x, y, direction, isMoving) {
+			return
+		}
+		now := g.now()
+		if p.dodgeStartedAt.IsZero() || now.Sub(p.dodgeStartedAt) >= dodgeCooldown-dodgeCooldownSlack {
+			p.dodgeStartedAt = now
+		}
 		p.isDodging = true
 	}
 }
 
-// beginAttack runs the shared preamble for a player attack: it reveals the
-// attacker (clearing invisibility), aborts if the player is missing or dead,
-// enforces the shared attack cooldown, and claims it. It returns the player to
-// attack with, or nil if the attack should not proceed.
-func (g *Game) beginAttack(clientID uint64, cooldown time.Duration) *Player {
-	g.mutex.Lock()
+// beginAttackUnsafe runs the shared preamble for a player attack: it reveals
+// the attacker (clearing invisibility), aborts if the player is missing or
+// dead, enforces the shared attack cooldown, and claims it. It returns the
+// player to attack with, or nil if the attack should not proceed. Caller must
+// hold g.mutex.
+func (g *Game) beginAttackUnsafe(clientID uint64, cooldown time.Duration) *Player {
 	p, ok := g.players[clientID]
-	dead := false
-	if ok {
-		dead = p.hp <= 0
-		g.revealPlayerUnsafe(p)
+	if !ok {
+		return nil
 	}
-	g.mutex.Unlock()
-
-	if !ok || dead {
+	g.revealPlayerUnsafe(p)
+	if p.hp <= 0 {
 		return nil
 	}
 
-	if time.Since(p.lastAttackTime) < cooldown {
+	if g.now().Sub(p.lastAttackTime) < cooldown {
 		return nil
 	}
-	p.lastAttackTime = time.Now()
+	p.lastAttackTime = g.now()
 
 	return p
 }
 
-func (g *Game) castFireball(clientID uint64, x int, y int, direction string) {
-	player := g.beginAttack(clientID, attackFireballCooldown)
+func (g *Game) castFireball(clientID uint64, direction string) {
+	vecX, vecY := getVectorFromDirection(direction)
+	if vecX == 0 && vecY == 0 {
+		return
+	}
+
+	g.mutex.Lock()
+	player := g.beginAttackUnsafe(clientID, attackFireballCooldown)
 	if player == nil {
+		g.mutex.Unlock()
 		return
 	}
 
 	distance := 200 * player.level
+	x, y := player.x, player.y
+	pr := g.spawnProjectileUnsafe(damageKindFireball, clientID, x, y, x+int(vecX), y+int(vecY), fireballVelocity, distance)
+	g.mutex.Unlock()
 
 	g.broadcastEventFunc(FireballEvent{
-		ClientID:  clientID,
-		X:         x,
-		Y:         y,
-		Direction: direction,
-		Distance:  distance,
+		ProjectileID: pr.id,
+		ClientID:     clientID,
+		X:            x,
+		Y:            y,
+		Direction:    direction,
+		Distance:     distance,
 	})
 }
 
-func (g *Game) shootArrow(clientID uint64, x int, y int, direction string) {
-	go func() {
-		time.Sleep(time.Millisecond * 200)
+// shootArrow looses a volley of one arrow per player level, 50ms apart, after
+// the 200ms draw.
+func (g *Game) shootArrow(clientID uint64, direction string) {
+	vecX, vecY := getVectorFromDirection(direction)
+	if vecX == 0 && vecY == 0 {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
-		player := g.beginAttack(clientID, attackShotArrowCooldown)
+	g.scheduleUnsafe(200*time.Millisecond, func() {
+		player := g.beginAttackUnsafe(clientID, attackShotArrowCooldown)
 		if player == nil {
 			return
 		}
 
-		const dispersion = 100.0
-
 		for i := 0; i < player.level; i++ {
-			vecX, vecY := getVectorFromDirection(direction)
-			vecXDisp := vecX*1000 + (rand.Float64()*2-1)*dispersion
-			vecYDisp := vecY*1000 + (rand.Float64()*2-1)*dispersion
-			attackX, attackY := float64(x)+vecXDisp, float64(y)+vecYDisp
-
-			g.broadcastEventFunc(ShootArrowEvent{
-				ClientID: clientID,
-				X1:       x + 20*int(vecX), // fix offset from player center
-				Y1:       y + 20*int(vecY),
-				X2:       int(attackX),
-				Y2:       int(attackY),
-				Velocity: 700,
+			g.scheduleUnsafe(time.Duration(i)*50*time.Millisecond, func() {
+				g.shootOneArrowUnsafe(player, vecX, vecY)
 			})
-
-			time.Sleep(time.Millisecond * 50)
 		}
-	}()
+	})
+}
+
+// shootOneArrowUnsafe fires a single, slightly dispersed arrow from the player
+// in the given direction. Caller must hold g.mutex.
+func (g *Game) shootOneArrowUnsafe(player *Player, vecX, vecY float64) {
+	const dispersion = 100.0
+
+	vecXDisp := vecX*1000 + (g.rng.Float64()*2-1)*dispersion
+	vecYDisp := vecY*1000 + (g.rng.Float64()*2-1)*dispersion
+
+	x, y := player.x, player.y
+	x1, y1 := x+20*int(vecX), y+20*int(vecY) // fix offset from player center
+	x2, y2 := int(float64(x)+vecXDisp), int(float64(y)+vecYDisp)
+	pr := g.spawnProjectileUnsafe(damageKindArrow, player.client.ID(), x1, y1, x2, y2, playerArrowVelocity, 0)
+
+	g.broadcastEventFunc(ShootArrowEvent{
+		ProjectileID: pr.id,
+		ClientID:     player.client.ID(),
+		X1:           x1,
+		Y1:           y1,
+		X2:           x2,
+		Y2:           y2,
+		Velocity:     playerArrowVelocity,
+	})
 }
 
 func (g *Game) attackWithSword(clientID uint64) {
-	player := g.beginAttack(clientID, attackSwordCooldown)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player := g.beginAttackUnsafe(clientID, attackSwordCooldown)
 	if player == nil {
 		return
 	}
@@ -761,61 +829,51 @@ func (g *Game) attackWithSword(clientID uint64) {
 		Direction: player.direction,
 	})
 
-	go func() {
-		time.Sleep(attackSwordDelay)
-
-		isDead := false
-		g.mutex.Lock()
-		if p, ok := g.players[clientID]; ok {
-			player = p
-			if p.hp <= 0 {
-				isDead = true
-			}
-		}
-		g.mutex.Unlock()
+	g.scheduleUnsafe(attackSwordDelay, func() {
+		g.swingSwordUnsafe(clientID)
+	})
+}
 
-		if player == nil {
-			return
-		}
-		if isDead {
-			return
-		}
+// swingSwordUnsafe lands a prepared sword attack, hitting every player and
+// monster along the blade. Caller must hold g.mutex.
+func (g *Game) swingSwordUnsafe(clientID uint64) {
+	player, ok := g.players[clientID]
+	if !ok || player.hp <= 0 {
+		return
+	}
 
-		length := 50 + 60*player.level
-		radius := 20 + 12*player.level
-		damage := 50 + 5*(player.level-1)
+	length := 50 + 60*player.level
+	radius := 20 + 12*player.level
+	damage := 50 + 5*(player.level-1)
 
-		vecX, vecY := getVectorFromDirection(player.direction)
-		attackX, attackY := player.x+int(vecX)*length, player.y+int(vecY)*length
+	vecX, vecY := getVectorFromDirection(player.direction)
+	attackX, attackY := player.x+int(vecX)*length, player.y+int(vecY)*length
 
-		g.mutex.Lock()
-		for _, p := range g.players {
-			if p.client.ID() == clientID {
-				continue
-			}
-			if (g.isSwordAttackHit(player.x, player.y, attackX, attackY, p.x, p.y, radius)) == false {
-				continue
-			}
-			g.hitPlayerUnsafe(p.client.ID(), damage)
+	for _, p := range g.players {
+		if p.client.ID() == clientID {
+			continue
 		}
-		for _, m := range g.monsters {
-			if (g.isSwordAttackHit(player.x, player.y, attackX, attackY, m.x, m.y, radius)) == false {
-				continue
-			}
-			g.hitMonsterUnsafe(clientID, m.id, damage)
+		if (g.isSwordAttackHit(player.x, player.y, attackX, attackY, p.x, p.y, radius)) == false {
+			continue
 		}
-		g.mutex.Unlock()
+		g.hitPlayerUnsafe(p.client.ID(), damage)
+	}
+	for _, m := range g.monsters {
+		if (g.isSwordAttackHit(player.x, player.y, attackX, attackY, m.x, m.y, radius)) == false {
+			continue
+		}
+		g.hitMonsterUnsafe(clientID, m.id, damage)
+	}
 
-		g.broadcastEventFunc(SwordAttackEvent{
-			ClientID:    clientID,
-			X:           player.x,
-			Y:           player.y,
-			Direction:   player.direction,
-			AttackLineX: attackX,
-			AttackLineY: attackY,
-			Radius:      radius,
-		})
-	}()
+	g.broadcastEventFunc(SwordAttackEvent{
+		ClientID:    clientID,
+		X:           player.x,
+		Y:           player.y,
+		Direction:   player.direction,
+		AttackLineX: attackX,
+		AttackLineY: attackY,
+		Radius:      radius,
+	})
 }
 
 func (g *Game) hitPlayerUnsafe(targetClientID uint64, damage int) {
@@ -850,7 +908,7 @@ func (g *Game) hitPlayerWithKindUnsafe(targetClientID uint64, kind string) {
 
 		damage := damageForKind(kind)
 		damage = int(float64(damage) * classResistance(p.class, kind))
-		if !p.protectionActiveUntil.IsZero() && time.Now().Before(p.protectionActiveUntil) {
+		if !p.protectionActiveUntil.IsZero() && g.now().Before(p.protectionActiveUntil) {
 			damage = damage / 2
 		}
 
@@ -908,16 +966,10 @@ func (g *Game) killPlayer(clientID uint64) {
 	}
 }
 
-func (g *Game) hitMonster(originClientID uint64, monsterID int, damage int) {
-	g.mutex.Lock()
-	defer g.mutex.Unlock()
-	g.hitMonsterUnsafe(originClientID, monsterID, damage)
-}
-
 func (g *Game) hitMonsterUnsafe(originClientID uint64, monsterID int, damage int) {
 	for _, m := range g.monsters {
 		if m.id == monsterID && m.hp > 0 {
-			if !m.shieldUntil.IsZero() && time.Now().Before(m.shieldUntil) {
+			if !m.shieldUntil.IsZero() && g.now().Before(m.shieldUntil) {
 				damage = damage / 10 // 90% reduction
 				if damage < 1 {
 					damage = 1
@@ -942,6 +994,10 @@ func (g *Game) hitMonsterUnsafe(originClientID uint64, monsterID int, damage int
 				g.defaultOnHit(m, originClientID)
 			}
 
+			if m.hp == 0 {
+				g.monsterDeaths[m.kind]++
+			}
+
 			// Destroying the demon cleanses the dungeon: the Light wins.
 			if m.kind == monsterKindDemon && m.hp == 0 {
 				g.endGame(originClientID, winningSideLight)
@@ -979,12 +1035,11 @@ func (g *Game) splitJellyUnsafe(mon *Monster, originClientID uint64) {
 	// Delay spawning until split animation completes on client (13 frames @ 8fps ≈ 1625ms)
 	spawnX := mon.x
 	spawnY := mon.y
-	time.AfterFunc(1700*time.Millisecond, func() {
-		if g.isGameEnded() {
+	floor := g.floor
+	g.scheduleUnsafe(1700*time.Millisecond, func() {
+		if g.floor != floor {
 			return
 		}
-		g.mutex.Lock()
-		defer g.mutex.Unlock()
 		offsets := []int{-tileSize, tileSize}
 		for _, offsetX := range offsets {
 			g.monsters = append(g.monsters, &Monster{
@@ -1048,16 +1103,38 @@ func (g *Game) endGame(winnerPlayerId uint64, winningSide string) {
 		})
 	}
 
+	var replayID string
+	if g.replay != nil {
+		replayID = g.replay.ID()
+	}
+
 	g.broadcastEventFunc(EndGameEvent{
 		WinnerPlayerId: winnerPlayerId,
 		WinningSide:    winningSide,
 		Roles:          roles,
+		Seed:           g.seed,
+		ReplayID:       replayID,
 	})
+	g.closeReplay()
 	if g.room != nil {
 		g.room.OnGameEnded()
 	}
 }
 
+// Stop ends a match deleted before it was over, without a winner: the main loop
+// returns and the replay recorded so far is finished.
+func (g *Game) Stop() {
+	g.statusMx.Lock()
+	if g.status == StatusEnded {
+		g.statusMx.Unlock()
+		return
+	}
+	g.status = StatusEnded
+	g.statusMx.Unlock()
+
+	g.closeReplay()
+}
+
 const (
 	winningSideLight    = "light"
 	winningSideCultists = "cultists"
@@ -1095,7 +1172,7 @@ func (g *Game) moveMonstersUnsafe() {
 		if def := monsterDefs[mon.kind]; def != nil {
 			moveSpeedPerTick = def.MoveSpeed
 		}
-		if !mon.speedBoostUntil.IsZero() && time.Now().Before(mon.speedBoostUntil) {
+		if !mon.speedBoostUntil.IsZero() && g.now().Before(mon.speedBoostUntil) {
 			moveSpeedPerTick = (moveSpeedPerTick*3 + 1) / 2 // 50% boost
 		}
 
@@ -1150,25 +1227,25 @@ func (g *Game) moveMonstersUnsafe() {
 }
 
 func (g *Game) spawnInitialMonsters() {
-	spawnLayer := g.gameMap.getLayerByName("spawns")
-	if spawnLayer == nil {
+	if g.gameMap.getLayerByName("spawns") == nil {
 		log.Println("no spawn layer found in map")
 		return
 	}
 
-	for _, obj := range spawnLayer.Objects {
-		def := monsterDefBySpawnName(obj.Name)
-		if def == nil || !def.SpawnOnStart {
-			continue
-		}
+	spawns := g.mapMonsterSpawns()
+	if g.monsterDifficulty != nil {
+		spawns = g.scaleMonsterSpawns(spawns)
+	}
+	for _, s := range spawns {
+		hp := g.monsterHP(s.def)
 		g.monsters = append(g.monsters, &Monster{
 			id:        len(g.monsters) + 1,
-			kind:      def.Kind,
-			hp:        def.BaseHP,
-			maxHP:     def.BaseHP,
-			damage:    def.Damage,
-			x:         int(obj.X),
-			y:         int(obj.Y),
+			kind:      s.def.Kind,
+			hp:        hp,
+			maxHP:     hp,
+			damage:    s.def.Damage,
+			x:         s.x,
+			y:         s.y,
 			direction: "left",
 			isMoving:  false,
 		})
@@ -1337,7 +1414,12 @@ func (g *Game) distributeChestLootUnsafe() {
 		return
 	}
 
-	rand.Shuffle(len(chests), func(i, j int) {
+	// Map iteration order is random, so sort before shuffling with the match
+	// RNG to keep the placement reproducible from the seed.
+	sort.Slice(chests, func(i, j int) bool {
+		return chests[i].ID < chests[j].ID
+	})
+	g.rng.Shuffle(len(chests), func(i, j int) {
 		chests[i], chests[j] = chests[j], chests[i]
 	})
 
@@ -1358,7 +1440,7 @@ func (g *Game) distributeChestLootUnsafe() {
 	for _, spec := range chestLootSpecs {
 		count := spec.minCount
 		if spec.maxCount > spec.minCount {
-			count += rand.Intn(spec.maxCount - spec.minCount + 1)
+			count += g.rng.Intn(spec.maxCount - spec.minCount + 1)
 		}
 		assign(func(c *Object) {
 			c.Loot = append(c.Loot, LootItem{Kind: spec.kind, Count: count})
@@ -1379,7 +1461,7 @@ func (g *Game) spawnDemonUnsafe() {
 			g.monsters = append(g.monsters, &Monster{
 				id:        len(g.monsters) + 1,
 				kind:      def.Kind,
-				hp:        def.BaseHP,
+				hp:        g.monsterHP(def),
 				x:         int(obj.X),
 				y:         int(obj.Y),
 				direction: "left",
@@ -1514,7 +1596,7 @@ func (g *Game) sendInventoryUpdateUnsafe(p *Player) {
 	copy(items, p.inventory)
 	for i, item := range items {
 		if item.Kind == itemCloakOfInvisibility && !p.cloakLastUsed.IsZero() {
-			remaining := time.Until(p.cloakLastUsed.Add(cloakCooldown))
+			remaining := p.cloakLastUsed.Add(cloakCooldown).Sub(g.now())
 			if remaining > 0 {
 				items[i].CooldownMs = int(remaining.Milliseconds())
 			}
@@ -1527,7 +1609,7 @@ func (g *Game) sendInventoryUpdateUnsafe(p *Player) {
 }
 
 func (g *Game) revealPlayerUnsafe(p *Player) {
-	if !p.isInvisible() {
+	if !p.isInvisible(g.now()) {
 		return
 	}
 	p.invisibleUntil = time.Time{}
@@ -1567,17 +1649,24 @@ func (g *Game) maxCultistsAllowedUnsafe() int {
 // broadcastCultistsRosterUnsafe sends the list of cultist client IDs to every
 // cultist so they can recognise one another. Good players are never told.
 func (g *Game) broadcastCultistsRosterUnsafe() {
-	ids := make([]uint64, 0)
+	ids := g.cultistIDsUnsafe()
 	for _, p := range g.players {
 		if p.isCultist {
-			ids = append(ids, p.client.ID())
+			p.client.SendEvent(CultistsRosterEvent{ClientIDs: ids})
 		}
 	}
+}
+
+// cultistIDsUnsafe returns the client IDs of the cultists.
+func (g *Game) cultistIDsUnsafe() []uint64 {
+	ids := make([]uint64, 0)
 	for _, p := range g.players {
 		if p.isCultist {
-			p.client.SendEvent(CultistsRosterEvent{ClientIDs: ids})
+			ids = append(ids, p.client.ID())
 		}
 	}
+
+	return ids
 }
 
 // makePlayerCultistUnsafe curses a player into a cultist. Soul Power is
@@ -1654,12 +1743,13 @@ func (g *Game) useCloakOfInvisibilityUnsafe(p *Player, clientID uint64) {
 		return
 	}
 
-	if !p.cloakLastUsed.IsZero() && time.Now().Before(p.cloakLastUsed.Add(cloakCooldown)) {
+	now := g.now()
+	if !p.cloakLastUsed.IsZero() && now.Before(p.cloakLastUsed.Add(cloakCooldown)) {
 		return
 	}
 
-	p.invisibleUntil = time.Now().Add(cloakDuration)
-	p.cloakLastUsed = time.Now()
+	p.invisibleUntil = now.Add(cloakDuration)
+	p.cloakLastUsed = now
 
 	p.client.SendEvent(CloakActiveEvent{
 		Duration:   int(cloakDuration.Milliseconds()),
@@ -1667,11 +1757,9 @@ func (g *Game) useCloakOfInvisibilityUnsafe(p *Player, clientID uint64) {
 	})
 	g.sendInventoryUpdateUnsafe(p)
 
-	time.AfterFunc(cloakDuration, func() {
-		g.mutex.Lock()
-		defer g.mutex.Unlock()
+	g.scheduleUnsafe(cloakDuration, func() {
 		pl, ok := g.players[clientID]
-		if !ok || time.Now().Before(pl.invisibleUntil) {
+		if !ok || g.now().Before(pl.invisibleUntil) {
 			return
 		}
 		pl.invisibleUntil = time.Time{}
@@ -1679,9 +1767,7 @@ func (g *Game) useCloakOfInvisibilityUnsafe(p *Player, clientID uint64) {
 		g.sendInventoryUpdateUnsafe(pl)
 	})
 
-	time.AfterFunc(cloakCooldown, func() {
-		g.mutex.Lock()
-		defer g.mutex.Unlock()
+	g.scheduleUnsafe(cloakCooldown, func() {
 		pl, ok := g.players[clientID]
 		if !ok {
 			return
@@ -1,6 +1,7 @@
 package game
 
 import (
+	"math"
 	"time"
 )
 
@@ -63,8 +64,8 @@ func (g *Game) moveTowardPlayer(mon *Monster, player *Player) {
 }
 
 // tickAttack advances the attack FSM: fires once at delay, holds animation until duration, resets at cooldown.
-func tickAttack(mon *Monster, delay, duration, cooldown time.Duration, onFire func()) {
-	elapsed := time.Since(mon.attackStartedAt)
+func tickAttack(mon *Monster, now time.Time, delay, duration, cooldown time.Duration, onFire func()) {
+	elapsed := now.Sub(mon.attackStartedAt)
 	if elapsed >= cooldown {
 		mon.attackStartedAt = time.Time{}
 		mon.attacked = false
@@ -81,29 +82,16 @@ func tickAttack(mon *Monster, delay, duration, cooldown time.Duration, onFire fu
 	}
 }
 
-func (g *Game) startIntellect() {
-	ticker := time.NewTicker(period)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			if g.isGameEnded() {
-				return
-			}
-
-			g.mutex.Lock()
-
-			for _, mon := range g.monsters {
-				if mon.hp <= 0 {
-					continue
-				}
-
-				if def := monsterDefs[mon.kind]; def != nil && def.Intellect != nil {
-					def.Intellect(g, mon)
-				}
-			}
+// tickIntellectUnsafe runs one AI tick for every living monster. Caller must
+// hold g.mutex.
+func (g *Game) tickIntellectUnsafe() {
+	for _, mon := range g.monsters {
+		if mon.hp <= 0 {
+			continue
+		}
 
-			g.mutex.Unlock()
+		if def := monsterDefs[mon.kind]; def != nil && def.Intellect != nil {
+			def.Intellect(g, mon)
 		}
 	}
 }
@@ -113,7 +101,7 @@ func (g *Game) intellectArcher(mon *Monster) {
 	minDistance := 1000000
 
 	for _, player := range g.players {
-		if player.hp <= 0 || player.isInvisible() {
+		if player.hp <= 0 || player.isInvisible(g.now()) {
 			continue
 		}
 		distance := getDistance(mon.x, mon.y, player.x, player.y)
@@ -130,19 +118,24 @@ func (g *Game) intellectArcher(mon *Monster) {
 	}
 
 	if mon.attackStartedAt.IsZero() {
-		mon.attackStartedAt = time.Now()
+		mon.attackStartedAt = g.now()
 		mon.isAttacking = true
 		mon.attacked = false
 		mon.direction = getDirection(mon.x, mon.y, closestPlayer.x, closestPlayer.y)
 	} else {
-		tickAttack(mon, archerAttackDelay, archerAttackDuration, archerAttackCooldown, func() {
+		tickAttack(mon, g.now(), archerAttackDelay, archerAttackDuration, archerAttackCooldown, func() {
+			pr := g.spawnProjectileUnsafe(damageKindArrow, 0, mon.x, mon.y, closestPlayer.x, closestPlayer.y, monsterArrowVelocity, 0)
+			if pr == nil {
+				return
+			}
 			g.broadcastEventFunc(ArrowEvent{
-				ClientID:  0,
-				MonsterID: mon.id,
-				X1:        mon.x,
-				Y1:        mon.y,
-				X2:        closestPlayer.x,
-				Y2:        closestPlayer.y,
+				ProjectileID: pr.id,
+				ClientID:     0,
+				MonsterID:    mon.id,
+				X1:           mon.x,
+				Y1:           mon.y,
+				X2:           closestPlayer.x,
+				Y2:           closestPlayer.y,
 			})
 		})
 	}
@@ -153,7 +146,7 @@ func (g *Game) intellectDemon(mon *Monster) {
 	hasOneOnDirectLines := false
 
 	for _, player := range g.players {
-		if player.hp <= 0 || player.isInvisible() {
+		if player.hp <= 0 || player.isInvisible(g.now()) {
 			continue
 		}
 
@@ -176,8 +169,10 @@ func (g *Game) intellectDemon(mon *Monster) {
 			lightningTarget = p
 		}
 	}
-	if lightningTarget != nil && time.Since(mon.lightningStartedAt) >= demonAttackLightningCooldown {
-		mon.lightningStartedAt = time.Now()
+	if lightningTarget != nil && g.now().Sub(mon.lightningStartedAt) >= demonAttackLightningCooldown {
+		mon.lightningStartedAt = g.now()
+		reach := int(math.Hypot(float64(lightningTarget.x-mon.x), float64(lightningTarget.y-mon.y))) + lightningHitRadius
+		g.recordAttackUnsafe(attackOrigin{monsterID: mon.id}, damageKindLightning, mon.x, mon.y, reach, lightningDuration, 1)
 		g.broadcastEventFunc(DemonLightningEvent{
 			MonsterID: mon.id,
 			X:         mon.x,
@@ -187,8 +182,9 @@ func (g *Game) intellectDemon(mon *Monster) {
 		})
 	}
 
-	if hasOneOnDirectLines && time.Since(mon.firecircleStartedAt) >= demonAttackFirecircleCooldown {
-		mon.firecircleStartedAt = time.Now()
+	if hasOneOnDirectLines && g.now().Sub(mon.firecircleStartedAt) >= demonAttackFirecircleCooldown {
+		mon.firecircleStartedAt = g.now()
+		g.recordAttackUnsafe(attackOrigin{monsterID: mon.id}, damageKindFirespot, mon.x, mon.y, firespotReach, firespotLifetime, firespotHitsPerTarget)
 		g.broadcastEventFunc(FireCircleEvent{
 			ClientID:  0,
 			MonsterID: mon.id,
@@ -201,18 +197,23 @@ func (g *Game) intellectDemon(mon *Monster) {
 		if len(closestPlayers) == 0 {
 			return
 		}
-		mon.attackStartedAt = time.Now()
+		mon.attackStartedAt = g.now()
 		mon.isAttacking = true
 	} else {
-		tickAttack(mon, demonAttackDelay, demonAttackDuration, demonAttackCooldown, func() {
+		tickAttack(mon, g.now(), demonAttackDelay, demonAttackDuration, demonAttackCooldown, func() {
 			for _, p := range closestPlayers {
+				pr := g.spawnProjectileUnsafe(damageKindBullet, 0, mon.x, mon.y, p.x, p.y, demonBoltVelocity, 0)
+				if pr == nil {
+					continue
+				}
 				g.broadcastEventFunc(DemonFireballEvent{
-					ClientID:  0,
-					MonsterID: mon.id,
-					X1:        mon.x,
-					Y1:        mon.y,
-					X2:        p.x,
-					Y2:        p.y,
+					ProjectileID: pr.id,
+					ClientID:     0,
+					MonsterID:    mon.id,
+					X1:           mon.x,
+					Y1:           mon.y,
+					X2:           p.x,
+					Y2:           p.y,
 				})
 			}
 		})
@@ -223,7 +224,7 @@ func (g *Game) intellectSkeleton(mon *Monster) {
 	var closestPlayer *Player
 	minDistance := 1000000
 	for _, player := range g.players {
-		if player.hp <= 0 || player.isInvisible() {
+		if player.hp <= 0 || player.isInvisible(g.now()) {
 			continue
 		}
 
@@ -249,8 +250,8 @@ func (g *Game) intellectSkeleton(mon *Monster) {
 		mon.path = nil
 		mon.isAttacking = true
 		if mon.attackStartedAt.IsZero() {
-			mon.attackStartedAt = time.Now()
-		} else if time.Since(mon.attackStartedAt) >= skeletonAttackDuration {
+			mon.attackStartedAt = g.now()
+		} else if g.now().Sub(mon.attackStartedAt) >= skeletonAttackDuration {
 			mon.attackStartedAt = time.Time{}
 			g.hitPlayerUnsafe(closestPlayer.client.ID(), 30)
 		}
@@ -267,7 +268,7 @@ func (g *Game) intellectGolem(mon *Monster) {
 	var closestPlayer *Player
 	minDistance := 1000000
 	for _, player := range g.players {
-		if player.hp <= 0 || player.isInvisible() {
+		if player.hp <= 0 || player.isInvisible(g.now()) {
 			continue
 		}
 		distance := getDistance(mon.x, mon.y, player.x, player.y)
@@ -287,14 +288,14 @@ func (g *Game) intellectGolem(mon *Monster) {
 
 	if mon.attackStartedAt.IsZero() {
 		if minDistance <= golemAttackRadius {
-			mon.attackStartedAt = time.Now()
+			mon.attackStartedAt = g.now()
 			mon.isAttacking = true
 			mon.attacked = false
 			mon.isMoving = false
 			mon.path = nil
 		}
 	} else {
-		tickAttack(mon, golemAttackDelay, golemAttackDuration, golemAttackCooldown, func() {
+		tickAttack(mon, g.now(), golemAttackDelay, golemAttackDuration, golemAttackCooldown, func() {
 			for _, player := range g.players {
 				if player.hp <= 0 {
 					continue
@@ -321,7 +322,7 @@ func (g *Game) intellectSpider(mon *Monster) {
 	var closestPlayer *Player
 	minDistance := 1000000
 	for _, player := range g.players {
-		if player.hp <= 0 || player.isInvisible() {
+		if player.hp <= 0 || player.isInvisible(g.now()) {
 			continue
 		}
 		distance := getDistance(mon.x, mon.y, player.x, player.y)
@@ -341,12 +342,12 @@ func (g *Game) intellectSpider(mon *Monster) {
 
 	// Initialize web cooldown on first contact so spider waits before first throw
 	if mon.webStartedAt.IsZero() {
-		mon.webStartedAt = time.Now()
+		mon.webStartedAt = g.now()
 	}
 
 	// Web throw with 10s cooldown
-	if minDistance <= spiderWebRange && time.Since(mon.webStartedAt) >= spiderWebCooldown {
-		mon.webStartedAt = time.Now()
+	if minDistance <= spiderWebRange && g.now().Sub(mon.webStartedAt) >= spiderWebCooldown {
+		mon.webStartedAt = g.now()
 		g.broadcastEventFunc(SpiderWebEvent{
 			MonsterID: mon.id,
 			X:         closestPlayer.x,
@@ -355,7 +356,7 @@ func (g *Game) intellectSpider(mon *Monster) {
 	}
 
 	// Play attack animation briefly after web throw
-	if !mon.webStartedAt.IsZero() && time.Since(mon.webStartedAt) < spiderWebAttackDuration {
+	if !mon.webStartedAt.IsZero() && g.now().Sub(mon.webStartedAt) < spiderWebAttackDuration {
 		mon.isAttacking = true
 	}
 
@@ -365,8 +366,8 @@ func (g *Game) intellectSpider(mon *Monster) {
 		mon.path = nil
 		mon.isAttacking = true
 		if mon.attackStartedAt.IsZero() {
-			mon.attackStartedAt = time.Now()
-		} else if time.Since(mon.attackStartedAt) >= spiderMeleeAttackDuration {
+			mon.attackStartedAt = g.now()
+		} else if g.now().Sub(mon.attackStartedAt) >= spiderMeleeAttackDuration {
 			mon.attackStartedAt = time.Time{}
 			g.hitPlayerUnsafe(closestPlayer.client.ID(), spiderMeleeAttackDamage)
 		}
@@ -381,7 +382,7 @@ func (g *Game) intellectJelly(mon *Monster) {
 	var closestPlayer *Player
 	minDistance := 1000000
 	for _, player := range g.players {
-		if player.hp <= 0 || player.isInvisible() {
+		if player.hp <= 0 || player.isInvisible(g.now()) {
 			continue
 		}
 		distance := getDistance(mon.x, mon.y, player.x, player.y)
@@ -404,10 +405,10 @@ func (g *Game) intellectJelly(mon *Monster) {
 		mon.path = nil
 		mon.isAttacking = true
 		if mon.attackStartedAt.IsZero() {
-			mon.attackStartedAt = time.Now()
+			mon.attackStartedAt = g.now()
 			mon.attacked = false
 		} else {
-			tickAttack(mon, jellyAttackDelay, jellyAttackDuration, jellyAttackDuration, func() {
+			tickAttack(mon, g.now(), jellyAttackDelay, jellyAttackDuration, jellyAttackDuration, func() {
 				g.hitPlayerUnsafe(closestPlayer.client.ID(), mon.damage)
 				closestPlayer.client.SendEvent(JellyHitSlowEvent{
 					Duration:    jellyHitSlowDuration,
@@ -426,8 +427,8 @@ func (g *Game) intellectJelly(mon *Monster) {
 func (g *Game) intellectDemonMage(mon *Monster) {
 	// If mid-cast, continue ticking the animation; reset attackStartedAt just after animation ends
 	if !mon.attackStartedAt.IsZero() {
-		tickAttack(mon, demonMageSpellDelay, demonMageSpellDuration, demonMageSpellDuration+time.Millisecond, func() {
-			now := time.Now()
+		tickAttack(mon, g.now(), demonMageSpellDelay, demonMageSpellDuration, demonMageSpellDuration+time.Millisecond, func() {
+			now := g.now()
 			for _, other := range g.monsters {
 				if other.id == mon.spellTargetID && other.hp > 0 {
 					if mon.spellIsShield {
@@ -454,7 +455,7 @@ func (g *Game) intellectDemonMage(mon *Monster) {
 		return
 	}
 
-	now := time.Now()
+	now := g.now()
 
 	// Enforce 30s cross-cooldown: minimum gap between any two casts
 	lastAny := mon.shieldLastCastAt
@@ -501,7 +502,7 @@ func (g *Game) intellectDemonMage(mon *Monster) {
 		return
 	}
 
-	mon.attackStartedAt = time.Now()
+	mon.attackStartedAt = g.now()
 	mon.isAttacking = true
 	mon.attacked = false
 	mon.direction = getDirection(mon.x, mon.y, target.x, target.y)
@@ -1,7 +1,7 @@
 package game
 
 import (
-	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -12,36 +12,71 @@ const trapSize = 64
 // chest) to block the chest from being opened.
 const chestMonsterRange = tileSize * 3
 
-func (g *Game) startObjectsLoop() {
-	ticker := time.NewTicker(objectsPeriod)
-	defer ticker.Stop()
-	deltaTime := objectsPeriod.Seconds()
+// tickObjectsUnsafe updates chests, triggers and traps by deltaTime seconds.
+// Caller must hold g.mutex.
+func (g *Game) tickObjectsUnsafe(deltaTime float64) {
+	descend := false
+	for _, obj := range g.sortedObjectsUnsafe() {
+		switch obj.Kind {
+		case objectKindChest:
+			g.tickChest(obj)
+		case objectKindTrigger:
+			g.tickTrigger(obj)
+		case objectKindStairs:
+			descend = descend || g.tickStairs(obj)
+		}
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			if g.isGameEnded() {
-				return
-			}
+	g.tickTraps(deltaTime)
 
-			g.mutex.Lock()
+	// The floor is swapped only after the loop over its objects.
+	if descend {
+		g.descendUnsafe()
+	}
+}
 
-			// Update objects
-			for _, obj := range g.objects {
-				switch obj.Kind {
-				case objectKindChest:
-					g.tickChest(obj)
-				case objectKindTrigger:
-					g.tickTrigger(obj)
-				}
-			}
+// sortedObjectsUnsafe returns the objects in ID order. Map iteration order is
+// random, so walking them in this order keeps the match RNG draws (curse rolls)
+// and who gets to a chest first reproducible from the seed.
+func (g *Game) sortedObjectsUnsafe() []*Object {
+	objects := make([]*Object, 0, len(g.objects))
+	for _, obj := range g.objects {
+		objects = append(objects, obj)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ID < objects[j].ID
+	})
 
-			// Update traps
-			g.tickTraps(deltaTime)
+	return objects
+}
 
-			g.mutex.Unlock()
-		}
+// objectSnapshotsUnsafe copies the objects, in ID order, for events: the
+// events are encoded and read (by bots) outside g.mutex, while the game keeps
+// changing its objects.
+func (g *Game) objectSnapshotsUnsafe() []Object {
+	snapshots := make([]Object, 0, len(g.objects))
+	for _, obj := range g.sortedObjectsUnsafe() {
+		snapshots = append(snapshots, *obj)
 	}
+
+	return snapshots
+}
+
+// sortedPlayersUnsafe returns the players in client ID order, for the same
+// reason as sortedObjectsUnsafe.
+func (g *Game) sortedPlayersUnsafe() []*Player {
+	ids := make([]uint64, 0, len(g.players))
+	for id := range g.players {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	players := make([]*Player, len(ids))
+	for i, id := range ids {
+		players[i] = g.players[id]
+	}
+
+	return players
 }
 
 func (g *Game) tickChest(obj *Object) {
@@ -49,7 +84,7 @@ func (g *Game) tickChest(obj *Object) {
 		return
 	}
 
-	for _, player := range g.players {
+	for _, player := range g.sortedPlayersUnsafe() {
 		if player.hp <= 0 {
 			continue
 		}
@@ -80,7 +115,7 @@ func (g *Game) tickChest(obj *Object) {
 				if alwaysCurse {
 					g.makePlayerCultistUnsafe(player)
 				} else if g.cultistCountUnsafe() < g.maxCultistsAllowedUnsafe() &&
-					rand.Float64() < cultistCurseChance {
+					g.rng.Float64() < cultistCurseChance {
 					g.makePlayerCultistUnsafe(player)
 				}
 			}
@@ -110,7 +145,13 @@ func (g *Game) tickChest(obj *Object) {
 			allKeysCollected := g.keysCollected["1"] && g.keysCollected["2"] && g.keysCollected["3"]
 
 			if allKeysCollected && g.demonWasSpawned == false {
-				g.spawnDemonUnsafe()
+				// The demon waits on the last floor; the keys of any other floor
+				// open the stairs down.
+				if g.isLastFloorUnsafe() {
+					g.spawnDemonUnsafe()
+				} else {
+					g.openStairsUnsafe()
+				}
 			}
 
 			// Only the opener interacts with the chest this tick.
@@ -179,13 +220,16 @@ func (g *Game) tickTrigger(obj *Object) {
 						if targetObj.PropertiesMap["direction"] == "left" {
 							x2 = targetObj.X - tileSize
 						}
-						g.broadcastEventFunc(ArrowEvent{
-							MonsterID: -1,
-							X1:        targetObj.X,
-							Y1:        targetObj.Y,
-							X2:        x2,
-							Y2:        y2,
-						})
+						if pr := g.spawnProjectileUnsafe(damageKindArrow, 0, targetObj.X, targetObj.Y, x2, y2, monsterArrowVelocity, 0); pr != nil {
+							g.broadcastEventFunc(ArrowEvent{
+								ProjectileID: pr.id,
+								MonsterID:    -1,
+								X1:           targetObj.X,
+								Y1:           targetObj.Y,
+								X2:           x2,
+								Y2:           y2,
+							})
+						}
 					}
 					if targetObj.Kind == objectKindTrapSpikes {
 						// Activate trap using new trap system
@@ -193,6 +237,7 @@ func (g *Game) tickTrigger(obj *Object) {
 						if trapIDStr, ok := trapID.(string); ok {
 							if trap, exists := g.traps[trapIDStr]; exists {
 								trap.Activate()
+								g.recordTrapAttackUnsafe(trap)
 								g.broadcastEventFunc(TrapStateChangedEvent{
 									TrapID: trap.ID,
 									State:  trap.State,
@@ -214,6 +259,9 @@ func (g *Game) tickTraps(deltaTime float64) {
 		stateChanged, newState := trap.Tick(deltaTime)
 
 		if stateChanged {
+			if newState == TrapStateActive {
+				g.recordTrapAttackUnsafe(trap)
+			}
 			g.broadcastEventFunc(TrapStateChangedEvent{
 				TrapID: trap.ID,
 				State:  newState,
@@ -1,6 +1,10 @@
 package game
 
 import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -209,3 +213,141 @@ func TestHitMonsterUnsafeIgnoresDeadOrMissing(t *testing.T) {
 
 	g.hitMonsterUnsafe(1, 999, 50) // missing monster -> no panic, no effect
 }
+
+// seededChestGame builds a test game whose RNG is seeded with seed and that has
+// a handful of chests, then distributes loot into them.
+func seededChestGame(seed int64) *Game {
+	g, _ := newTestGame()
+	g.seed = seed
+	g.rng = rand.New(rand.NewSource(seed))
+	for id := 1; id <= 8; id++ {
+		g.objects[uint64(id)] = &Object{ID: id, Kind: objectKindChest}
+	}
+	g.distributeChestLootUnsafe()
+
+	return g
+}
+
+func TestMatchSeedReproducesRandomDecisions(t *testing.T) {
+	a, b := seededChestGame(42), seededChestGame(42)
+
+	for id, objA := range a.objects {
+		objB := b.objects[id]
+		if objA.HasKey != objB.HasKey || len(objA.Loot) != len(objB.Loot) {
+			t.Fatalf("chest %d differs between runs with the same seed", id)
+		}
+		for i := range objA.Loot {
+			if objA.Loot[i] != objB.Loot[i] {
+				t.Fatalf("chest %d loot differs between runs with the same seed", id)
+			}
+		}
+	}
+
+	pa := newPlayer(newFakeClient(1), a.rng)
+	pb := newPlayer(newFakeClient(1), b.rng)
+	if pa.class != pb.class || pa.color != pb.color {
+		t.Errorf("players differ: %s/%s vs %s/%s", pa.class, pa.color, pb.class, pb.color)
+	}
+}
+
+func TestEndGameEventExposesSeed(t *testing.T) {
+	g, broadcast := newTestGame()
+	g.seed = 1234567890123456789
+	addTestPlayer(g, 1, ClassKnight)
+
+	g.endGame(1, winningSideLight)
+
+	ev, ok := (*broadcast)[len(*broadcast)-1].(EndGameEvent)
+	if !ok {
+		t.Fatalf("last event = %T, want EndGameEvent", (*broadcast)[len(*broadcast)-1])
+	}
+	if ev.Seed != g.seed {
+		t.Errorf("seed = %d, want %d", ev.Seed, g.seed)
+	}
+	data, _ := json.Marshal(ev)
+	if !strings.Contains(string(data), `"seed":"1234567890123456789"`) {
+		t.Errorf("seed not encoded as a string: %s", data)
+	}
+}
+
+func TestReconnectedPlayerKeepsItsCharacter(t *testing.T) {
+	g, _ := newTestGame()
+	p, client := addTestPlayer(g, 1, ClassMage)
+	p.x, p.y, p.level, p.isCultist = 300, 200, 3, true
+	p.isMoving = true
+
+	g.OnClientDisconnected(client)
+	if !p.disconnected || p.isMoving {
+		t.Error("a disconnected player is not marked disconnected and standing still")
+	}
+	if stats := g.collectStatsUnsafe(); !stats.Players[0].Disconnected {
+		t.Error("the stats do not show the player disconnected")
+	}
+
+	reconnected := newFakeClient(1)
+	g.OnClientReconnected(reconnected)
+
+	if g.players[1] != p || p.disconnected || p.client != reconnected {
+		t.Fatal("the reconnected client did not get its player back")
+	}
+	if len(reconnected.sentEvents) != 1 {
+		t.Fatalf("reconnected client sent %d events, want the game data", len(reconnected.sentEvents))
+	}
+	data := reconnected.sentEvents[0].(JoinToStartedGameEvent).GameData
+	stats := data["playerData"].(PlayerStats)
+	if stats.X != 300 || stats.Level != 3 || data["isCultist"] != true {
+		t.Errorf("game data %+v, cultist %v, want the player's position, level and team", stats, data["isCultist"])
+	}
+}
+
+// cursedByChests opens two chests at once in a seeded game with six players
+// standing next to both, and returns the IDs of the players who got cursed.
+func cursedByChests(seed int64) []uint64 {
+	g, _ := newTestGame()
+	g.rng = rand.New(rand.NewSource(seed))
+	g.gameMap = newTestMap(40, 20)
+	for id := uint64(1); id <= 6; id++ {
+		p, _ := addTestPlayer(g, id, ClassKnight)
+		p.x, p.y = 200, 200
+	}
+	g.objects[1] = &Object{ID: 1, Kind: objectKindChest, X: 180, Y: 200}
+	g.objects[2] = &Object{ID: 2, Kind: objectKindChest, X: 220, Y: 200}
+
+	g.tickObjectsUnsafe(objectsPeriod.Seconds())
+
+	var cursed []uint64
+	for id := uint64(1); id <= 6; id++ {
+		if g.players[id].isCultist {
+			cursed = append(cursed, id)
+		}
+	}
+
+	return cursed
+}
+
+func TestMatchSeedReproducesCurseRolls(t *testing.T) {
+	for seed := int64(1); seed <= 50; seed++ {
+		want := cursedByChests(seed)
+		for run := 0; run < 5; run++ {
+			if got := cursedByChests(seed); !reflect.DeepEqual(got, want) {
+				t.Fatalf("seed %d: cursed players = %v, want %v as in the first run", seed, got, want)
+			}
+		}
+	}
+}
+
+func TestInitialGameDataCopiesObjects(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(10, 10)
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	g.objects[2] = &Object{ID: 2, Kind: objectKindChest}
+	g.objects[1] = &Object{ID: 1, Kind: objectKindChest}
+
+	objects, _ := g.getPlayerInitialGameData(p)["gameObjects"].([]Object)
+	g.objects[1].State = "open"
+	g.objects[3] = &Object{ID: 3, Kind: objectKindStairs}
+
+	if len(objects) != 2 || objects[0].ID != 1 || objects[1].ID != 2 || objects[0].State != "" {
+		t.Errorf("gameObjects = %+v, want a copy of chests 1 and 2", objects)
+	}
+}
@@ -0,0 +1,152 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+// Some hits are still detected by clients and reported with HitPlayerCommand:
+// spikes, demon firespots and demon lightning. The server keeps a short log of
+// the attacks it started and only applies a reported hit that one of them can
+// explain: the origin must match the attack, the target must be within the
+// attack's reach and in its line of sight, and the attack must be recent.
+// Rejected reports are counted on the sending player.
+
+// trapOriginID is the monster id clients use for hits caused by traps.
+const trapOriginID = -1
+
+// hitReportSlack forgives the round trip between the server starting an attack
+// and the client's hit report arriving.
+const hitReportSlack = 500 * time.Millisecond
+
+// spikeReportPeriod is how often, at most, a client standing on spikes reports
+// their damage.
+const spikeReportPeriod = time.Second
+
+// hitReportTolerance absorbs the distance a target moves while a report is in
+// flight.
+const hitReportTolerance = tileSize
+
+// Demon attack reach and lifetime, mirroring the client. Firespots fly at
+// 200 px/s until they hit a wall; lightning strikes five times, 350 ms apart,
+// after an 800 ms warning, hitting anyone within 25px of the bolt.
+const firespotReach = 40 * tileSize
+const firespotLifetime = firespotReach * time.Second / 200
+const firespotHitsPerTarget = 2
+const lightningHitRadius = 25
+const lightningDuration = 800*time.Millisecond + 5*350*time.Millisecond
+
+// attackOrigin identifies who started an attack: a player (clientID) or a
+// monster (monsterID, trapOriginID for traps).
+type attackOrigin struct {
+	clientID  uint64
+	monsterID int
+}
+
+// recentAttack is an attack that clients may report hits for until it expires.
+type recentAttack struct {
+	origin        attackOrigin
+	kind          string
+	x             int
+	y             int
+	reach         int // max distance from (x, y) to a target it can hit
+	expiresAt     time.Time
+	hitsPerTarget int
+	hits          map[uint64]int
+}
+
+// recordAttackUnsafe remembers an attack that clients will report hits for.
+// Caller must hold g.mutex.
+func (g *Game) recordAttackUnsafe(origin attackOrigin, kind string, x, y, reach int, duration time.Duration, hitsPerTarget int) {
+	now := g.now()
+
+	kept := g.recentAttacks[:0]
+	for _, a := range g.recentAttacks {
+		if now.Before(a.expiresAt.Add(hitReportSlack)) {
+			kept = append(kept, a)
+		}
+	}
+
+	g.recentAttacks = append(kept, &recentAttack{
+		origin:        origin,
+		kind:          kind,
+		x:             x,
+		y:             y,
+		reach:         reach,
+		expiresAt:     now.Add(duration),
+		hitsPerTarget: hitsPerTarget,
+		hits:          make(map[uint64]int),
+	})
+}
+
+// recordTrapAttackUnsafe remembers a spike trap that just became active, until
+// it has retracted: the client shows the spikes, and reports their damage, on
+// the first cooldown frames too. Caller must hold g.mutex.
+func (g *Game) recordTrapAttackUnsafe(trap *Trap) {
+	if trap.Type != TrapTypeSpikes || !trap.IsActive() {
+		return
+	}
+	cooldown := trap.Activator.Period * trap.Params.CooldownPercent / 100
+	duration := time.Duration((trap.StateTimer + cooldown) * float64(time.Second))
+	hits := 1 + int(duration/spikeReportPeriod)
+	g.recordAttackUnsafe(attackOrigin{monsterID: trapOriginID}, damageKindSpike,
+		trap.Params.X+trapSize/2, trap.Params.Y+trapSize/2, trapSize, duration, hits)
+}
+
+// validateHitUnsafe reports whether a client-reported hit is plausible, and
+// consumes the matching attack's hit if so. An implausible report counts as a
+// strike against the sender; strikes is the sender's count after it, see
+// commandGuard.strike. Caller must hold g.mutex.
+func (g *Game) validateHitUnsafe(senderID uint64, c HitPlayerCommand) (valid bool, strikes int) {
+	sender, ok := g.players[senderID]
+	if !ok {
+		return false, 0
+	}
+	if !g.isPlausibleHitUnsafe(senderID, c) {
+		return false, sender.commands.strike(g.now())
+	}
+
+	return true, 0
+}
+
+func (g *Game) isPlausibleHitUnsafe(senderID uint64, c HitPlayerCommand) bool {
+	var origin attackOrigin
+	if c.MonsterID != 0 {
+		// Monsters and traps only hurt the player whose client saw the hit.
+		if c.TargetClientID != senderID {
+			return false
+		}
+		origin.monsterID = c.MonsterID
+	} else {
+		if c.OriginClientID != senderID {
+			return false
+		}
+		origin.clientID = senderID
+	}
+
+	target, ok := g.players[c.TargetClientID]
+	if !ok || target.hp <= 0 {
+		return false
+	}
+
+	now := g.now()
+	for _, a := range g.recentAttacks {
+		if a.origin != origin || a.kind != c.Kind || now.After(a.expiresAt.Add(hitReportSlack)) {
+			continue
+		}
+		if a.hits[c.TargetClientID] >= a.hitsPerTarget {
+			continue
+		}
+		if math.Hypot(float64(target.x-a.x), float64(target.y-a.y)) > float64(a.reach+hitReportTolerance) {
+			continue
+		}
+		if !g.isVisible(a.x, a.y, target.x, target.y) {
+			continue
+		}
+		a.hits[c.TargetClientID]++
+
+		return true
+	}
+
+	return false
+}
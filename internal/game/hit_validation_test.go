@@ -0,0 +1,138 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newHitValidationGame() (*Game, *Player) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(100, 100)
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 200, 200
+	addTestPlayer(g, 2, ClassMage)
+
+	return g, p
+}
+
+// validHit validates a hit reported by player 1.
+func validHit(g *Game, c HitPlayerCommand) bool {
+	valid, _ := g.validateHitUnsafe(1, c)
+	return valid
+}
+
+func TestValidateHitAcceptsRecentFirespot(t *testing.T) {
+	g, p := newHitValidationGame()
+	g.recordAttackUnsafe(attackOrigin{monsterID: 5}, damageKindFirespot, 100, 200, firespotReach, firespotLifetime, firespotHitsPerTarget)
+
+	c := HitPlayerCommand{MonsterID: 5, TargetClientID: 1, Kind: damageKindFirespot}
+	for i := 0; i < firespotHitsPerTarget; i++ {
+		if !validHit(g, c) {
+			t.Fatalf("hit %d rejected", i+1)
+		}
+	}
+	if validHit(g, c) {
+		t.Error("more hits accepted than the attack allows")
+	}
+	if p.commands.strikes != 1 {
+		t.Errorf("strikes = %d, want 1", p.commands.strikes)
+	}
+}
+
+func TestValidateHitRejectsForgedOriginAndTarget(t *testing.T) {
+	g, p := newHitValidationGame()
+	g.recordAttackUnsafe(attackOrigin{monsterID: 5}, damageKindLightning, 100, 200, 200, lightningDuration, 1)
+
+	cases := []HitPlayerCommand{
+		// Monster hits may only be reported by their victim.
+		{MonsterID: 5, TargetClientID: 2, Kind: damageKindLightning},
+		// Player-originated hits must come from that player.
+		{OriginClientID: 2, TargetClientID: 1, Kind: damageKindLightning},
+		// No such attack was started by this monster.
+		{MonsterID: 6, TargetClientID: 1, Kind: damageKindLightning},
+		// The monster did not start an attack of this kind.
+		{MonsterID: 5, TargetClientID: 1, Kind: damageKindFirespot},
+	}
+	for _, c := range cases {
+		if validHit(g, c) {
+			t.Errorf("forged hit %+v accepted", c)
+		}
+	}
+	if p.commands.strikes != len(cases) {
+		t.Errorf("strikes = %d, want %d", p.commands.strikes, len(cases))
+	}
+}
+
+func TestValidateHitRejectsOutOfReach(t *testing.T) {
+	g, p := newHitValidationGame()
+	g.recordAttackUnsafe(attackOrigin{monsterID: 5}, damageKindLightning, 100, 200, 50, lightningDuration, 1)
+
+	if validHit(g, HitPlayerCommand{MonsterID: 5, TargetClientID: 1, Kind: damageKindLightning}) {
+		t.Error("hit beyond the attack's reach accepted")
+	}
+
+	p.x = 150
+	if !validHit(g, HitPlayerCommand{MonsterID: 5, TargetClientID: 1, Kind: damageKindLightning}) {
+		t.Error("hit within reach rejected")
+	}
+}
+
+func TestValidateHitRequiresLineOfSight(t *testing.T) {
+	g, _ := newHitValidationGame()
+	g.gameMap.visibilityColliders = []Rectangle{{X: 140, Y: 160, Width: 16, Height: 80}}
+	g.recordAttackUnsafe(attackOrigin{monsterID: 5}, damageKindFirespot, 100, 200, firespotReach, firespotLifetime, firespotHitsPerTarget)
+
+	if validHit(g, HitPlayerCommand{MonsterID: 5, TargetClientID: 1, Kind: damageKindFirespot}) {
+		t.Error("hit through a wall accepted")
+	}
+}
+
+func TestValidateHitRejectsExpiredAttack(t *testing.T) {
+	g, _ := newHitValidationGame()
+	g.recordAttackUnsafe(attackOrigin{monsterID: 5}, damageKindFirespot, 100, 200, firespotReach, firespotLifetime, firespotHitsPerTarget)
+	stepFor(g, firespotLifetime+hitReportSlack+simulationStep)
+
+	if validHit(g, HitPlayerCommand{MonsterID: 5, TargetClientID: 1, Kind: damageKindFirespot}) {
+		t.Error("hit from an expired attack accepted")
+	}
+}
+
+func TestValidateHitAcceptsSpikesWhileRetracting(t *testing.T) {
+	g, p := newHitValidationGame()
+	trap := NewTrap("spikes", TrapTypeSpikes, TrapParams{
+		ActivePercent:   10,
+		CooldownPercent: 20,
+		X:               p.x - trapSize/2,
+		Y:               p.y - trapSize/2,
+	}, TrapActivator{Type: ActivatorTimer, Period: 4})
+	trap.Activate()
+	g.recordTrapAttackUnsafe(trap)
+
+	// Well into the cooldown, past the active time and the report slack, the
+	// client still shows frame 3 and reports the hit the player took on it.
+	stepFor(g, 950*time.Millisecond)
+	trap.Tick(0.4)
+	if trap.Tick(0.55); trap.GetCurrentFrame() != 3 {
+		t.Fatalf("trap frame %d, want 3", trap.GetCurrentFrame())
+	}
+	if !validHit(g, HitPlayerCommand{MonsterID: trapOriginID, TargetClientID: 1, Kind: damageKindSpike}) {
+		t.Error("spike hit reported on a cooldown frame rejected")
+	}
+	if p.commands.strikes != 0 {
+		t.Errorf("strikes = %d, want 0", p.commands.strikes)
+	}
+}
+
+func TestForgedHitsDisconnectForGood(t *testing.T) {
+	g, _ := newHitValidationGame()
+	client := g.players[1].client.(*fakeClient)
+	forged, _ := json.Marshal(HitPlayerCommand{OriginClientID: 2, TargetClientID: 1, Kind: damageKindLightning})
+
+	for i := 0; i < commandStrikesToDisconnect; i++ {
+		g.DispatchGameCommand(client, "HitPlayerCommand", json.RawMessage(forged))
+	}
+	if !client.kicked {
+		t.Errorf("not kicked after %d forged hits", commandStrikesToDisconnect)
+	}
+}
@@ -52,7 +52,7 @@ func (g *Game) useScrollOfXP(p *Player, clientID uint64) {
 }
 
 func (g *Game) useScrollOfFootprints(p *Player, clientID uint64) {
-	p.footprintsActiveUntil = time.Now().Add(30 * time.Second)
+	p.footprintsActiveUntil = g.now().Add(30 * time.Second)
 	if len(g.positionSnapshots) > 0 {
 		histPoints := make([]FootprintPoint, 0, len(g.positionSnapshots)*len(g.players))
 		for _, snap := range g.positionSnapshots {
@@ -63,11 +63,9 @@ func (g *Game) useScrollOfFootprints(p *Player, clientID uint64) {
 		}
 	}
 	expireClientID := clientID
-	time.AfterFunc(30*time.Second, func() {
-		g.mutex.Lock()
-		defer g.mutex.Unlock()
+	g.scheduleUnsafe(30*time.Second, func() {
 		pl, ok := g.players[expireClientID]
-		if !ok || time.Now().Before(pl.footprintsActiveUntil) {
+		if !ok || g.now().Before(pl.footprintsActiveUntil) {
 			return
 		}
 		pl.client.SendEvent(FootprintsExpiredEvent{})
@@ -86,14 +84,12 @@ func (g *Game) useBootsOfHaste(p *Player, clientID uint64) {
 
 func (g *Game) useScrollOfProtection(p *Player, clientID uint64) {
 	const protectionDuration = 60 * time.Second
-	p.protectionActiveUntil = time.Now().Add(protectionDuration)
+	p.protectionActiveUntil = g.now().Add(protectionDuration)
 	p.client.SendEvent(ProtectionActiveEvent{Duration: int(protectionDuration.Milliseconds())})
 	expireClientID := clientID
-	time.AfterFunc(protectionDuration, func() {
-		g.mutex.Lock()
-		defer g.mutex.Unlock()
+	g.scheduleUnsafe(protectionDuration, func() {
 		pl, ok := g.players[expireClientID]
-		if !ok || time.Now().Before(pl.protectionActiveUntil) {
+		if !ok || g.now().Before(pl.protectionActiveUntil) {
 			return
 		}
 		pl.client.SendEvent(ProtectionExpiredEvent{})
@@ -101,7 +97,7 @@ func (g *Game) useScrollOfProtection(p *Player, clientID uint64) {
 }
 
 func (g *Game) useSpikes(p *Player, clientID uint64) {
-	trapID := fmt.Sprintf("item_spike_%d_%d", clientID, time.Now().UnixNano())
+	trapID := fmt.Sprintf("item_spike_%d_%d", clientID, len(g.traps))
 	tileX := (p.x / tileSize) * tileSize
 	tileY := (p.y / tileSize) * tileSize
 	trap := NewTrap(trapID, TrapTypeSpikes, TrapParams{
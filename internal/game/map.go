@@ -3,7 +3,10 @@ package game
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type MapObject struct {
@@ -78,6 +81,8 @@ type Map struct {
 	RenderOrder         string                 `json:"renderorder"`
 	Type                string                 `json:"type"`
 	Version             string                 `json:"version"`
+	NextLayerID         int                    `json:"nextlayerid,omitempty"`
+	NextObjectID        int                    `json:"nextobjectid,omitempty"`
 	TilesPropertiesHash map[int]map[string]any `json:"-"`
 	blockedGrid         []bool
 	gridWidth           int
@@ -92,6 +97,7 @@ type Map struct {
 	hasBossStage        bool      // true when the map defines a boss_stage object
 	roomCenters         [][2]int  // interior centre (tiles) of each placed room
 	demonRoomCount      int       // number of placed rooms containing the demon spawn
+	dir                 string    // directory tileset images are relative to
 }
 
 // PlayerSpawn returns the pixel position where players start. Generated maps set
@@ -135,6 +141,7 @@ func parseMap(filename string) (*Map, error) {
 	if err != nil {
 		return nil, err
 	}
+	m.dir = filepath.Dir(filename)
 
 	return &m, nil
 }
@@ -174,26 +181,53 @@ func LoadMap(filename string) (*Map, error) {
 	return m, nil
 }
 
+// maxGenerateAttempts is how many seeds LoadGeneratedMap tries before giving up
+// on a template whose maps keep having unreachable rooms or chests.
+const maxGenerateAttempts = 10
+
 // LoadGeneratedMap parses the given template map (which must contain the
 // predefined room/entrance objects) and assembles a fresh map of numRooms rooms
-// connected by corridors. seed makes generation reproducible; pass 0 to derive a
-// seed from the current time.
-func LoadGeneratedMap(filename string, numRooms int, seed int64) (*Map, error) {
-	template, err := parseMap(filename)
-	if err != nil {
-		return nil, err
-	}
+// connected by corridors, laid out as layout. seed makes generation
+// reproducible; pass 0 to derive a seed from the current time. A map with a room
+// or chest players cannot reach is thrown away for the one of the next seed, so
+// the same seed still always gives the same map.
+func LoadGeneratedMap(filename string, layout MapLayout, numRooms int, seed int64) (*Map, error) {
+	return LoadMixedMap([]string{filename}, layout, numRooms, seed)
+}
 
-	m, err := generateMap(template, numRooms, seed)
+// LoadMixedMap is LoadGeneratedMap with the rooms and corridors of the template
+// maps in filenames[1:] mixed into those of the template map in filenames[0],
+// which brings the start room, the demon and the boss arena (see
+// map_biomes.go).
+func LoadMixedMap(filenames []string, layout MapLayout, numRooms int, seed int64) (*Map, error) {
+	template, err := parseTemplates(filenames)
 	if err != nil {
 		return nil, err
 	}
-
-	if err := m.postProcess(); err != nil {
-		return nil, err
+	if seed == 0 {
+		seed = time.Now().UnixNano()
 	}
 
-	return m, nil
+	for attempt := 1; ; attempt++ {
+		m, err := generateMap(template, layout, numRooms, seed)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := m.postProcess(); err != nil {
+			return nil, err
+		}
+
+		err = m.checkReachable()
+		if err == nil {
+			return m, nil
+		}
+		if attempt == maxGenerateAttempts {
+			return nil, fmt.Errorf("no playable map with seeds %d to %d: %v", seed-int64(attempt)+1, seed, err)
+		}
+		log.Printf("Map of seed %d: %v; trying seed %d\n", seed, err, seed+1)
+		seed++
+	}
 }
 
 // loadSpawnObjects reads the named markers from the "objects" layer that drive
@@ -511,9 +545,53 @@ func (m *Map) isTileBlockedForMonster(tileX, tileY int) bool {
 	return m.blockedGrid[idx]
 }
 
+// isSegmentBlocked reports whether walking in a straight line from pixel
+// (x1,y1) to (x2,y2) would cross a blocked tile. The blocked grid marks the same
+// light-absorbing wall tiles the collision-rects layer is built from, plus
+// collidable abyss tiles. The start point itself is not checked, so a creature
+// already standing on a blocked tile can still walk out of it. Maps without a
+// blocked grid never block.
+func (m *Map) isSegmentBlocked(x1, y1, x2, y2 int) bool {
+	if m == nil || len(m.blockedGrid) == 0 || m.TileWidth == 0 || m.TileHeight == 0 {
+		return false
+	}
+
+	const step = 4 // px between samples; well below a tile so corners can't be skipped
+	dx, dy := x2-x1, y2-y1
+	n := max(abs(dx), abs(dy)) / step
+	if n == 0 {
+		n = 1
+	}
+	for i := 1; i <= n; i++ {
+		px := x1 + dx*i/n
+		py := y1 + dy*i/n
+		if m.isTileBlockedForMonster(floorDiv(px, m.TileWidth), floorDiv(py, m.TileHeight)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// floorDiv divides rounding toward negative infinity, so pixels left of or above
+// the map map to negative (out of bounds) tiles instead of tile 0.
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
 // findPath runs A* from start tile to goal tile and returns a slice of pixel-center
-// waypoints (not including the start position). Returns nil if no path exists.
+// waypoints (not including the start position). Returns nil if no path exists,
+// or if finding one takes exploring too many tiles for a single AI tick.
 func (m *Map) findPath(startTX, startTY, goalTX, goalTY int) []Point {
+	return m.findPathExploring(startTX, startTY, goalTX, goalTY, 4096)
+}
+
+// findPathExploring is findPath giving up after exploring maxTiles tiles.
+func (m *Map) findPathExploring(startTX, startTY, goalTX, goalTY, maxTiles int) []Point {
 	if startTX == goalTX && startTY == goalTY {
 		return nil
 	}
@@ -570,7 +648,7 @@ func (m *Map) findPath(startTX, startTY, goalTX, goalTY int) []Point {
 			return path
 		}
 
-		if len(closed) > 4096 {
+		if len(closed) > maxTiles {
 			break
 		}
 
@@ -609,5 +687,23 @@ func (m *Map) buildVisibilityColliders() {
 }
 
 func (m *Map) getVisibilityColliders() []Rectangle {
+	if m == nil {
+		return nil
+	}
 	return m.visibilityColliders
 }
+
+// isPointInWall reports whether (x, y) lies inside one of the collision-rects
+// walls. Projectiles stop on these, while abyss tiles let them fly over.
+func (m *Map) isPointInWall(x, y int) bool {
+	if m == nil {
+		return false
+	}
+	for _, col := range m.visibilityColliders {
+		if pointInRect(x, y, col.X, col.Y, col.Width, col.Height) {
+			return true
+		}
+	}
+
+	return false
+}
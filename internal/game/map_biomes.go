@@ -0,0 +1,299 @@
+package game
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// A generated map can mix the rooms and corridors of several template maps, or
+// biomes: the first template is the dungeon's own and brings the start room,
+// the demon and the boss arena; every other one lends its rooms and its own
+// skin of the corridor pieces. The templates are merged into one composite
+// template before generation:
+//
+//   - each biome is stacked below the templates before it, so its tiles keep
+//     their own place and the generator copies them like any other;
+//   - tilesets are merged, a tileset used by several templates only once, and
+//     the gids of every biome are remapped to the merged tilesets;
+//   - the names of a biome's rooms, entrances and passages get the biome name
+//     and a slash as a prefix ("lava/room_1"), so its room_start is an ordinary
+//     room; its demon, player spawns and boss arena are left out.
+//
+// The corridor pieces of every biome must have the geometry of the first
+// template's: the layout is planned with the first template's pieces and each
+// piece is then stamped in the skin of the nearest room's biome, so a corridor
+// changes theme halfway between two rooms of different biomes.
+
+// biomeSeparator separates a biome name from the name of one of its objects.
+const biomeSeparator = "/"
+
+// gidFlags are the flip and rotation bits Tiled stores in the top of a gid.
+const gidFlags = 0xF0000000
+
+// mapBiome is the skin of the corridors next to the rooms of one biome: its
+// passage pieces, the floor carved into its doors and the wall sealing them.
+type mapBiome struct {
+	name     string // "" for the first template
+	pass     passageSet
+	floorGid int
+	wallGid  int
+}
+
+// biomeTemplate is a template map lending its rooms and corridors to another.
+type biomeTemplate struct {
+	name string
+	m    *Map
+}
+
+// parseTemplates parses the template maps in filenames and merges every one
+// after the first into it as a biome named after its file.
+func parseTemplates(filenames []string) (*Map, error) {
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no template map")
+	}
+	template, err := parseMap(filenames[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 1 {
+		return template, nil
+	}
+
+	biomes := make([]biomeTemplate, 0, len(filenames)-1)
+	seen := make(map[string]bool)
+	for _, filename := range filenames[1:] {
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		if seen[name] {
+			return nil, fmt.Errorf("biome %q given twice", name)
+		}
+		seen[name] = true
+		m, err := parseMap(filename)
+		if err != nil {
+			return nil, err
+		}
+		biomes = append(biomes, biomeTemplate{name: name, m: m})
+	}
+
+	return mergeTemplates(template, biomes)
+}
+
+// CheckMapMix returns an error if the template maps in filenames cannot be
+// mixed into one generated map, the first one with the rooms and corridors of
+// the others.
+func CheckMapMix(filenames []string) error {
+	template, err := parseTemplates(filenames)
+	if err != nil {
+		return err
+	}
+	_, err = extractBiomes(template)
+
+	return err
+}
+
+// mergeTemplates returns a template map holding primary with every biome
+// stacked below it.
+func mergeTemplates(primary *Map, biomes []biomeTemplate) (*Map, error) {
+	out := *primary
+	out.Tilesets = append([]MapTileset(nil), primary.Tilesets...)
+	nextGid := 1
+	for _, ts := range out.Tilesets {
+		nextGid = max(nextGid, ts.Firstgid+ts.Tilecount)
+	}
+
+	// Each biome's rows start where the previous template ends.
+	offsets := make([]int, len(biomes))
+	remaps := make([]func(int) int, len(biomes))
+	for i, b := range biomes {
+		if b.m.TileWidth != primary.TileWidth || b.m.TileHeight != primary.TileHeight {
+			return nil, fmt.Errorf("biome %q has %dx%d tiles, not %dx%d", b.name, b.m.TileWidth, b.m.TileHeight, primary.TileWidth, primary.TileHeight)
+		}
+		for _, l := range b.m.Layers {
+			if l.Type == "tilelayer" && primary.tileLayerData(l.Name) == nil {
+				return nil, fmt.Errorf("biome %q has a tile layer %q the template has not", b.name, l.Name)
+			}
+		}
+		offsets[i] = out.Height
+		out.Width = max(out.Width, b.m.Width)
+		out.Height += b.m.Height
+		remaps[i] = out.mergeTilesets(b.m, &nextGid)
+	}
+
+	nextID := 1
+	for _, l := range primary.Layers {
+		for _, o := range l.Objects {
+			nextID = max(nextID, o.Id+1)
+		}
+	}
+
+	out.Layers = make([]MapLayer, 0, len(primary.Layers))
+	for _, src := range primary.Layers {
+		layer := src
+		if src.Type == "tilelayer" {
+			layer.Width, layer.Height = out.Width, out.Height
+			layer.Data = make([]int, out.Width*out.Height)
+			copyRows(layer.Data, out.Width, 0, src.Data, primary.Width, func(g int) int { return g })
+			for i, b := range biomes {
+				if data := b.m.tileLayerData(src.Name); data != nil {
+					copyRows(layer.Data, out.Width, offsets[i], data, b.m.Width, remaps[i])
+				}
+			}
+		} else {
+			layer.Objects = append([]MapObject(nil), src.Objects...)
+			for i, b := range biomes {
+				if bl := b.m.objectLayer(src.Name); bl != nil {
+					dy := float64(offsets[i] * primary.TileHeight)
+					layer.Objects = appendBiomeObjects(layer.Objects, b.name, src.Name, bl.Objects, dy, &nextID)
+				}
+			}
+		}
+		out.Layers = append(out.Layers, layer)
+	}
+
+	return &out, nil
+}
+
+// mergeTilesets adds the tilesets of biome the map has not yet, numbering
+// their gids from *nextGid, and returns the function remapping the biome's
+// gids to the map's. A tileset is the same when its image, name and tile count
+// are.
+func (m *Map) mergeTilesets(biome *Map, nextGid *int) func(int) int {
+	type span struct{ first, count, to int }
+	spans := make([]span, 0, len(biome.Tilesets))
+	for _, ts := range biome.Tilesets {
+		// Images are relative to the map: keep them so from the template's
+		// directory.
+		if rel, err := filepath.Rel(m.dir, biome.imagePath(ts)); err == nil && !filepath.IsAbs(ts.Image) {
+			ts.Image = filepath.ToSlash(rel)
+		}
+		to := 0
+		for _, have := range m.Tilesets {
+			if have.Image == ts.Image && have.Name == ts.Name && have.Tilecount == ts.Tilecount {
+				to = have.Firstgid
+				break
+			}
+		}
+		if to == 0 {
+			to = *nextGid
+			*nextGid += ts.Tilecount
+			merged := ts
+			merged.Firstgid = to
+			m.Tilesets = append(m.Tilesets, merged)
+		}
+		spans = append(spans, span{first: ts.Firstgid, count: ts.Tilecount, to: to})
+	}
+
+	return func(g int) int {
+		flags := g & gidFlags
+		id := g &^ gidFlags
+		for _, s := range spans {
+			if id >= s.first && id < s.first+s.count {
+				return (s.to + id - s.first) | flags
+			}
+		}
+		return 0
+	}
+}
+
+// copyRows copies the rows of src, w tiles wide, into dst, dstW tiles wide,
+// from row y on, remapping every non-void gid.
+func copyRows(dst []int, dstW, y int, src []int, w int, remap func(int) int) {
+	for i, g := range src {
+		if g != 0 {
+			dst[i%w+(y+i/w)*dstW] = remap(g)
+		}
+	}
+}
+
+// appendBiomeObjects appends the objects of a biome's layer, moved dy px down,
+// with fresh ids and the biome prefix on the names the generator reads.
+func appendBiomeObjects(dst []MapObject, biome, layerName string, objects []MapObject, dy float64, nextID *int) []MapObject {
+	for _, o := range objects {
+		switch {
+		case o.Type == "room" || o.Type == "entrance" || o.Type == "passage":
+			o.Name = biome + biomeSeparator + o.Name
+		case o.Type == "boss_stage" || o.Type == "spawn":
+			continue
+		case layerName == "spawns" && o.Name == "demon":
+			continue
+		}
+		o.Y += dy
+		o.Id = *nextID
+		*nextID++
+		dst = append(dst, o)
+	}
+
+	return dst
+}
+
+// biomeOfName returns the biome of an object named name, "" for the first
+// template's.
+func biomeOfName(name string) string {
+	if i := strings.Index(name, biomeSeparator); i >= 0 {
+		return name[:i]
+	}
+
+	return ""
+}
+
+// extractBiomes returns the corridor skin of the template's own passages,
+// then of every biome merged into it, in the order they were merged.
+func extractBiomes(template *Map) ([]mapBiome, error) {
+	names := []string{""}
+	if layer := template.objectLayer("objects"); layer != nil {
+		seen := map[string]bool{"": true}
+		for _, o := range layer.Objects {
+			if b := biomeOfName(o.Name); o.Type == "passage" && !seen[b] {
+				seen[b] = true
+				names = append(names, b)
+			}
+		}
+	}
+
+	biomes := make([]mapBiome, 0, len(names))
+	for _, name := range names {
+		pass, err := extractBiomePassages(template, name)
+		if err != nil {
+			return nil, err
+		}
+		b := mapBiome{name: name, pass: pass}
+		if len(biomes) > 0 {
+			if err := biomes[0].pass.sameGeometry(pass); err != nil {
+				return nil, fmt.Errorf("biome %q: %v", name, err)
+			}
+		}
+		b.floorGid, b.wallGid, err = pickCorridorGids(template, pass)
+		if err != nil {
+			return nil, err
+		}
+		biomes = append(biomes, b)
+	}
+
+	return biomes, nil
+}
+
+// sameGeometry returns an error if the pieces of o cannot stand in for p's.
+func (p passageSet) sameGeometry(o passageSet) error {
+	if p.hFloorTop != o.hFloorTop || p.hChannel != o.hChannel || p.vFloorLeft != o.vFloorLeft || p.vChannel != o.vChannel {
+		return fmt.Errorf("corridor channels differ from the template's")
+	}
+	for _, name := range passageNames {
+		a, b := p.piece(name), o.piece(name)
+		if a.tw != b.tw || a.th != b.th || a.vChanOff != b.vChanOff || a.hChanOff != b.hChanOff {
+			return fmt.Errorf("passage %q is %dx%d, not %dx%d like the template's, or its channels differ", name, b.tw, b.th, a.tw, a.th)
+		}
+	}
+
+	return nil
+}
+
+// biomeOf returns the biome of the room t, the first one if t's is unknown.
+func biomeOf(biomes []mapBiome, t roomTemplate) *mapBiome {
+	for i := range biomes {
+		if biomes[i].name == t.biome {
+			return &biomes[i]
+		}
+	}
+
+	return &biomes[0]
+}
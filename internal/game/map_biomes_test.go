@@ -0,0 +1,144 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+// newLavaBiome returns the test template as a biome whose catacombs tileset is
+// a lava one of its own, numbered from gid 1001 instead of 641.
+func newLavaBiome(t *testing.T) biomeTemplate {
+	m, err := parseMap(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Tilesets[1].Name, m.Tilesets[1].Image, m.Tilesets[1].Firstgid = "lava", "lava.png", 1001
+	for li := range m.Layers {
+		for i, g := range m.Layers[li].Data {
+			if id := g &^ gidFlags; id >= 641 {
+				m.Layers[li].Data[i] = g + 1001 - 641
+			}
+		}
+	}
+
+	return biomeTemplate{name: "lava", m: m}
+}
+
+func TestMergeTemplatesRemapsGids(t *testing.T) {
+	primary, err := parseMap(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lava := newLavaBiome(t)
+	flipped := lava.m.tileLayerData("walls")
+	flipped[0] = 1001 + 5 | 0x80000000 // flipped horizontally
+
+	merged, err := mergeTemplates(primary, []biomeTemplate{lava})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(merged.Tilesets) != 3 {
+		t.Fatalf("%d tilesets, want environment and catacombs once plus lava", len(merged.Tilesets))
+	}
+	lavaFirst := merged.Tilesets[2].Firstgid
+	if want := 641 + 2560; lavaFirst != want || merged.Tilesets[2].Name != "lava" {
+		t.Fatalf("tileset %q from gid %d, want lava from %d", merged.Tilesets[2].Name, lavaFirst, want)
+	}
+	if merged.Height != 2*primary.Height || merged.Width != primary.Width {
+		t.Errorf("merged map is %dx%d, want the biome stacked below the template", merged.Width, merged.Height)
+	}
+
+	walls := merged.tileLayerData("walls")
+	if got, want := walls[primary.Height*merged.Width], lavaFirst+5|0x80000000; got != want {
+		t.Errorf("flipped lava tile became gid %#x, want %#x", got, want)
+	}
+	floor, srcFloor := merged.tileLayerData("floor"), lava.m.tileLayerData("floor")
+	for i, g := range srcFloor {
+		if g != 0 && g < 641 && floor[i+primary.Height*merged.Width] != g {
+			t.Fatalf("environment gid %d of the biome became %d, want it kept", g, floor[i+primary.Height*merged.Width])
+		}
+	}
+
+	countDemons := func(m *Map) int {
+		n := 0
+		for _, o := range m.objectLayer("spawns").Objects {
+			if o.Name == "demon" {
+				n++
+			}
+		}
+		return n
+	}
+	rooms, starts := 0, 0
+	for _, o := range merged.objectLayer("objects").Objects {
+		switch {
+		case o.Type == "room" && strings.HasPrefix(o.Name, "lava/"):
+			rooms++
+		case o.Type == "spawn" && o.Name == "spawn_start":
+			starts++
+		}
+	}
+	if demons := countDemons(merged); rooms == 0 || demons != countDemons(primary) || starts != 1 {
+		t.Errorf("%d lava rooms, %d demons and %d player spawns, want lava rooms and the template's demons and spawn only", rooms, demons, starts)
+	}
+}
+
+func TestGenerateMixedMap(t *testing.T) {
+	primary, err := parseMap(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := mergeTemplates(primary, []biomeTemplate{newLavaBiome(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lavaFirst := template.Tilesets[2].Firstgid
+
+	for _, layout := range []MapLayout{MapLayoutLadder, MapLayoutTree} {
+		for seed := int64(1); seed <= 3; seed++ {
+			m, err := generateMap(template, layout, 12, seed)
+			if err != nil {
+				t.Fatalf("%s, seed %d: %v", layout, seed, err)
+			}
+			if err := m.postProcess(); err != nil {
+				t.Fatalf("%s, seed %d: %v", layout, seed, err)
+			}
+			if err := m.checkReachable(); err != nil {
+				t.Errorf("%s, seed %d: %v", layout, seed, err)
+			}
+
+			lava, catacombs := 0, 0
+			for _, g := range m.tileLayerData("walls") {
+				switch id := g &^ gidFlags; {
+				case id >= lavaFirst:
+					lava++
+				case id >= 641:
+					catacombs++
+				}
+			}
+			if lava == 0 || catacombs == 0 {
+				t.Errorf("%s, seed %d: %d lava and %d catacombs walls, want both themes", layout, seed, lava, catacombs)
+			}
+		}
+	}
+}
+
+func TestExtractBiomesRejectsOtherCorridors(t *testing.T) {
+	primary, err := parseMap(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lava := newLavaBiome(t)
+	for i, o := range lava.m.objectLayer("objects").Objects {
+		if o.Type == "passage" && o.Name == "vertical" {
+			lava.m.objectLayer("objects").Objects[i].Width += float64(lava.m.TileWidth)
+		}
+	}
+	template, err := mergeTemplates(primary, []biomeTemplate{lava})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractBiomes(template); err == nil || !strings.Contains(err.Error(), `"vertical"`) {
+		t.Errorf("got %v, want the wider vertical piece of the lava biome reported", err)
+	}
+}
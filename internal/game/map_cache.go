@@ -0,0 +1,95 @@
+package game
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MapCache loads the maps matches are played on. Every match on the same
+// dungeon shares one map, which is never modified once loaded, so maps are kept
+// by templates, layout, room count and seed. Maps generated from a random seed
+// are never played twice and are not kept.
+type MapCache struct {
+	mutex sync.Mutex
+	maps  map[mapCacheKey]*Map
+	keys  []mapCacheKey // oldest first
+	size  int
+}
+
+type mapCacheKey struct {
+	filenames string // the templates, one per line
+	layout    MapLayout
+	numRooms  int
+	seed      int64
+}
+
+// NewMapCache returns a cache that keeps up to size maps.
+func NewMapCache(size int) *MapCache {
+	return &MapCache{
+		maps: make(map[mapCacheKey]*Map),
+		size: size,
+	}
+}
+
+// Load returns the map of numRooms rooms generated from the template maps in
+// filenames as layout with seed, like LoadMixedMap, or the map in filenames[0]
+// as it is when numRooms is 0, like LoadMap.
+func (c *MapCache) Load(filenames []string, layout MapLayout, numRooms int, seed int64) (*Map, error) {
+	if numRooms == 0 {
+		filenames, layout, seed = filenames[:1], "", 0
+	} else if seed == 0 {
+		return LoadMixedMap(filenames, layout, numRooms, seed)
+	}
+
+	key := mapCacheKey{filenames: strings.Join(filenames, "\n"), layout: layout, numRooms: numRooms, seed: seed}
+	c.mutex.Lock()
+	m, ok := c.maps[key]
+	c.mutex.Unlock()
+	if ok {
+		return m, nil
+	}
+
+	// Generate outside the lock: it takes a while. Two matches asking for the
+	// same new map at once generate it twice, which is fine.
+	var err error
+	if numRooms == 0 {
+		m, err = LoadMap(filenames[0])
+	} else {
+		m, err = LoadMixedMap(filenames, layout, numRooms, seed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cached, ok := c.maps[key]; ok {
+		return cached, nil
+	}
+	if len(c.keys) >= c.size {
+		delete(c.maps, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.maps[key] = m
+	c.keys = append(c.keys, key)
+
+	return m, nil
+}
+
+// Forget drops every map loaded or mixed from filename, so that the next
+// matches load it again once it has changed.
+func (c *MapCache) Forget(filename string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := c.keys[:0]
+	for _, key := range c.keys {
+		if slices.Contains(strings.Split(key.filenames, "\n"), filename) {
+			delete(c.maps, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	c.keys = keys
+}
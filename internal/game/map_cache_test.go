@@ -0,0 +1,64 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTemplate = "../../public/assets/dungeon1.tmj"
+
+func TestMapCacheKeepsSeededMaps(t *testing.T) {
+	c := NewMapCache(2)
+
+	a, err := c.Load([]string{testTemplate}, MapLayoutLadder, 3, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := c.Load([]string{testTemplate}, MapLayoutLadder, 3, 7); b != a {
+		t.Error("the same template, room count and seed loaded a new map")
+	}
+	if b, _ := c.Load([]string{testTemplate}, MapLayoutLadder, 4, 7); b == a {
+		t.Error("another room count got the cached map")
+	}
+	if b, _ := c.Load([]string{testTemplate}, MapLayoutLadder, 3, 0); b == a || len(c.maps) != 2 {
+		t.Errorf("a random seed got the cached map or was kept (%d maps kept)", len(c.maps))
+	}
+
+	// The oldest map makes room for a new one.
+	if _, err := c.Load([]string{testTemplate}, MapLayoutLadder, 3, 8); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := c.Load([]string{testTemplate}, MapLayoutLadder, 3, 7); b == a {
+		t.Error("the oldest map was kept beyond the cache size")
+	}
+}
+
+func TestMapCacheForgetsMixedMaps(t *testing.T) {
+	c := NewMapCache(4)
+	lava := filepath.Join(t.TempDir(), "lava.tmj")
+	content, err := os.ReadFile(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lava, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mixed, err := c.Load([]string{testTemplate, lava}, MapLayoutLadder, 6, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := c.Load([]string{testTemplate}, MapLayoutLadder, 6, 7)
+	if plain == mixed {
+		t.Fatal("the template alone got the mixed map")
+	}
+
+	c.Forget(lava)
+	if m, _ := c.Load([]string{testTemplate}, MapLayoutLadder, 6, 7); m != plain {
+		t.Error("forgetting the biome dropped the map without it")
+	}
+	if m, _ := c.Load([]string{testTemplate, lava}, MapLayoutLadder, 6, 7); m == mixed {
+		t.Error("the map mixing the forgotten biome was kept")
+	}
+}
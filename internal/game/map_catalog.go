@@ -0,0 +1,218 @@
+package game
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MapCatalog offers the .tmj maps of a directory to play on, by file name
+// without the extension. Level designers save maps from Tiled into the
+// directory of a running server: the catalog rescans it, validates new and
+// changed files and drops removed ones, so they can try a map as soon as it is
+// saved. A map that fails validation stays listed with the reason but cannot be
+// played.
+
+// MapTemplate describes a map of a catalog.
+type MapTemplate struct {
+	Name string `json:"name"`
+	// Width and Height are the size of the map in tiles.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// RoomTemplates counts the predefined rooms maps are generated from. A map
+	// without any can only be played as it is.
+	RoomTemplates int  `json:"roomTemplates"`
+	HasBossStage  bool `json:"hasBossStage"`
+	// Error says why the map cannot be played, if it cannot.
+	Error string `json:"error,omitempty"`
+
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// MapCatalog is safe for concurrent use.
+type MapCatalog struct {
+	dir   string
+	cache *MapCache
+	mutex sync.RWMutex
+	maps  map[string]*MapTemplate
+}
+
+// NewMapCatalog scans dir for maps. Maps generated from a map that changes are
+// dropped from cache.
+func NewMapCatalog(dir string, cache *MapCache) (*MapCatalog, error) {
+	c := &MapCatalog{
+		dir:   dir,
+		cache: cache,
+		maps:  make(map[string]*MapTemplate),
+	}
+	if err := c.Scan(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Watch rescans the directory every period, for as long as the server runs.
+func (c *MapCatalog) Watch(period time.Duration) {
+	for range time.Tick(period) {
+		if err := c.Scan(); err != nil {
+			log.Printf("cannot scan maps: %v\n", err)
+		}
+	}
+}
+
+// Scan brings the catalog up to date with the directory.
+func (c *MapCatalog) Scan() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".tmj" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		name := strings.TrimSuffix(entry.Name(), ".tmj")
+		seen[name] = true
+
+		c.mutex.RLock()
+		known, ok := c.maps[name]
+		c.mutex.RUnlock()
+		if ok && known.modTime.Equal(info.ModTime()) && known.size == info.Size() {
+			continue
+		}
+
+		path := filepath.Join(c.dir, entry.Name())
+		t := validateMapTemplate(path)
+		t.Name = name
+		t.modTime, t.size = info.ModTime(), info.Size()
+		if t.Error != "" {
+			log.Printf("map %s cannot be played: %s\n", name, t.Error)
+		} else if ok {
+			log.Printf("map %s changed\n", name)
+		} else {
+			log.Printf("map %s added\n", name)
+		}
+
+		c.mutex.Lock()
+		c.maps[name] = t
+		c.mutex.Unlock()
+		c.cache.Forget(path)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for name, t := range c.maps {
+		if !seen[name] {
+			log.Printf("map %s removed\n", name)
+			delete(c.maps, name)
+			c.cache.Forget(t.path)
+		}
+	}
+
+	return nil
+}
+
+// validateMapTemplate loads the map in path the way matches do and reports what
+// is wrong with it, if anything.
+func validateMapTemplate(path string) *MapTemplate {
+	t := &MapTemplate{path: path}
+
+	m, err := parseMap(path)
+	if err != nil {
+		t.Error = fmt.Sprintf("cannot parse the map: %v", err)
+		return t
+	}
+	t.Width, t.Height = m.Width, m.Height
+
+	// Generation works on the map as parsed; postProcess prepares a map to be
+	// played, so it gets a copy of its own.
+	played, err := parseMap(path)
+	if err == nil {
+		err = played.postProcess()
+	}
+	if err != nil {
+		t.Error = fmt.Sprintf("cannot play the map as it is: %v", err)
+		return t
+	}
+	t.HasBossStage = played.hasBossStage
+
+	if !m.hasRoomTemplates() {
+		return t
+	}
+	rooms, boss, err := extractRoomTemplates(m)
+	if err != nil {
+		t.Error = fmt.Sprintf("room templates: %v", err)
+		return t
+	}
+	t.RoomTemplates = len(rooms)
+	t.HasBossStage = t.HasBossStage || boss != nil
+	pass, err := extractPassages(m)
+	if err == nil {
+		_, _, err = pickCorridorGids(m, pass)
+	}
+	if err != nil {
+		t.Error = fmt.Sprintf("passages: %v", err)
+	}
+
+	return t
+}
+
+// hasRoomTemplates reports whether the map defines any class="room" object.
+func (m *Map) hasRoomTemplates() bool {
+	if l := m.objectLayer("objects"); l != nil {
+		for _, o := range l.Objects {
+			if o.Type == "room" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Templates lists the maps of the catalog by name.
+func (c *MapCatalog) Templates() []MapTemplate {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	templates := make([]MapTemplate, 0, len(c.maps))
+	for _, t := range c.maps {
+		templates = append(templates, *t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates
+}
+
+// Lookup returns the map named name, if it can be played.
+func (c *MapCatalog) Lookup(name string) (MapTemplate, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	t, ok := c.maps[name]
+	if !ok || t.Error != "" {
+		return MapTemplate{}, false
+	}
+
+	return *t, true
+}
+
+// Path returns the file of the map.
+func (t MapTemplate) Path() string {
+	return t.path
+}
@@ -0,0 +1,76 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMapCatalogPicksUpChanges(t *testing.T) {
+	dir := t.TempDir()
+	template, err := os.ReadFile(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(dir, "good.tmj")
+	if err := os.WriteFile(good, template, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a map"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewMapCache(4)
+	c, err := NewMapCatalog(dir, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := c.Templates()
+	if len(templates) != 1 || templates[0].Name != "good" || templates[0].Error != "" {
+		t.Fatalf("templates = %+v, want only the good map", templates)
+	}
+	if templates[0].RoomTemplates == 0 || !templates[0].HasBossStage || templates[0].Width == 0 {
+		t.Errorf("good map = %+v, want its size, rooms and boss stage", templates[0])
+	}
+	cached, err := cache.Load([]string{good}, MapLayoutLadder, 3, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken map is listed with the reason but cannot be played.
+	if err := os.WriteFile(filepath.Join(dir, "broken.tmj"), []byte("{"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// The good map becomes one without rooms.
+	stripped := strings.ReplaceAll(string(template), `"type":"room"`, `"type":"zone"`)
+	if err := os.WriteFile(good, []byte(stripped+" "), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	templates = c.Templates()
+	if len(templates) != 2 || templates[0].Name != "broken" || templates[0].Error == "" {
+		t.Fatalf("templates = %+v, want the broken map with an error first", templates)
+	}
+	if _, ok := c.Lookup("broken"); ok {
+		t.Error("the broken map can be played")
+	}
+	if templates[1].RoomTemplates != 0 {
+		t.Errorf("changed map has %d room templates, want 0", templates[1].RoomTemplates)
+	}
+	if m, _ := cache.Load([]string{good}, "", 0, 0); m == cached {
+		t.Error("the cache kept a map of the old file")
+	}
+
+	if err := os.Remove(good); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup("good"); ok || len(c.Templates()) != 1 {
+		t.Errorf("templates = %+v, want the removed map gone", c.Templates())
+	}
+}
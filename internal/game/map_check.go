@@ -0,0 +1,263 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MapProblem is something wrong with a template map that makes the maps
+// generated from it fail or unplayable. Seed is the generation seed the problem
+// shows up with, or 0 for a problem of the template itself.
+type MapProblem struct {
+	Seed    int64
+	Message string
+}
+
+func (p MapProblem) String() string {
+	if p.Seed == 0 {
+		return p.Message
+	}
+
+	return fmt.Sprintf("seed %d: %s", p.Seed, p.Message)
+}
+
+// CheckMapTemplate reports every problem of the template map in filename, where
+// the generator stops at the first one. Then, unless the template itself cannot
+// generate maps, it generates maps of numRooms rooms laid out as layout with each
+// of seeds and reports those that fail or are not fully reachable. It returns an
+// error only if the file cannot be parsed at all.
+func CheckMapTemplate(filename string, layout MapLayout, numRooms int, seeds []int64) ([]MapProblem, error) {
+	template, err := parseMap(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkMapTemplate(template, layout, numRooms, seeds), nil
+}
+
+func checkMapTemplate(template *Map, layout MapLayout, numRooms int, seeds []int64) []MapProblem {
+	c := &mapChecker{template: template}
+	if c.checkTemplate() {
+		for _, seed := range seeds {
+			c.checkGenerated(layout, numRooms, seed)
+		}
+	}
+
+	return c.problems
+}
+
+type mapChecker struct {
+	template *Map
+	problems []MapProblem
+}
+
+func (c *mapChecker) report(seed int64, format string, args ...interface{}) {
+	c.problems = append(c.problems, MapProblem{Seed: seed, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkTemplate reports the problems of the template and whether maps can still
+// be generated from it.
+func (c *mapChecker) checkTemplate() bool {
+	m := c.template
+	canGenerate := true
+	if m.tileLayerData("floor") == nil || m.tileLayerData("walls") == nil {
+		c.report(0, "missing the floor or walls tile layer")
+		canGenerate = false
+	}
+	c.checkSpawns(0, m)
+	objects := m.objectLayer("objects")
+	if objects == nil {
+		c.report(0, "missing the objects layer")
+		return false
+	}
+	c.checkChests(0, m)
+
+	var hasStart, hasBoss bool
+	rooms := make(map[string]MapObject)
+	for _, o := range objects.Objects {
+		switch {
+		case o.Type == "room" && o.Name == "room_start":
+			hasStart = true
+		case o.Type == "boss_stage":
+			hasBoss = true
+		}
+		if o.Type != "room" {
+			continue
+		}
+		if _, ok := rooms[o.Name]; ok {
+			c.report(0, "two rooms are named %q: entrances cannot tell them apart", o.Name)
+			canGenerate = false
+		}
+		rooms[o.Name] = o
+	}
+	if len(rooms) == 0 {
+		c.report(0, "no class=room objects to generate maps from")
+		return false
+	}
+	if !hasStart {
+		c.report(0, "no room_start room: players would spawn in the first room")
+	}
+	if !hasBoss {
+		c.report(0, "no boss_stage object: the demon would be fought where it spawns")
+	}
+
+	// Every passage is reported missing, not only the first one.
+	pieces := make(map[string]bool)
+	for _, o := range objects.Objects {
+		if o.Type == "passage" {
+			pieces[o.Name] = true
+		}
+	}
+	for _, name := range passageNames {
+		if !pieces[name] {
+			c.report(0, "missing passage %q", name)
+			canGenerate = false
+		}
+	}
+	if !canGenerate {
+		return false
+	}
+
+	pass, err := extractPassages(m)
+	if err != nil {
+		c.report(0, "passages: %v", err)
+		return false
+	}
+	if _, _, err := pickCorridorGids(m, pass); err != nil {
+		c.report(0, "passages: %v", err)
+		return false
+	}
+	if !c.checkEntrances(objects, rooms, pass) {
+		return false
+	}
+
+	return true
+}
+
+// checkEntrances reports entrances that no room or corridor can use, and rooms
+// without any, in tiles as Tiled shows them.
+func (c *mapChecker) checkEntrances(objects *MapLayer, rooms map[string]MapObject, pass passageSet) bool {
+	ts := c.template.TileWidth
+	ok := true
+	doors := make(map[string]int)
+	for _, e := range objects.Objects {
+		if e.Type != "entrance" {
+			continue
+		}
+		ex, ey := int(e.X)/ts, int(e.Y)/ts
+		ew, eh := int(e.Width)/ts, int(e.Height)/ts
+		r, found := rooms[e.Name]
+		if !found {
+			c.report(0, "entrance %d at tile (%d, %d) is named %q, which is no room", e.Id, ex, ey, e.Name)
+			ok = false
+			continue
+		}
+		doors[e.Name]++
+
+		rx, ry := int(r.X)/ts, int(r.Y)/ts
+		rw, rh := int(r.Width)/ts, int(r.Height)/ts
+		alongY := ey >= ry && ey+eh <= ry+rh
+		alongX := ex >= rx && ex+ew <= rx+rw
+		var openLen, channel int
+		switch {
+		case ex <= rx && alongY, ex+ew >= rx+rw && alongY:
+			openLen, channel = eh, pass.hChannel
+		case ey <= ry && alongX, ey+eh >= ry+rh && alongX:
+			openLen, channel = ew, pass.vChannel
+		default:
+			c.report(0, "entrance %d of room %q at tile (%d, %d) is not on the room's edge", e.Id, e.Name, ex, ey)
+			ok = false
+			continue
+		}
+		if openLen > channel {
+			c.report(0, "entrance %d of room %q is %d tiles wide, wider than the %d-tile corridors", e.Id, e.Name, openLen, channel)
+		}
+	}
+
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if doors[name] == 0 {
+			c.report(0, "room %q has no entrance", name)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// checkSpawns reports spawns the game would not know what to spawn for.
+func (c *mapChecker) checkSpawns(seed int64, m *Map) {
+	spawns := m.objectLayer("spawns")
+	if spawns == nil {
+		c.report(seed, "missing the spawns layer: no monster would spawn")
+		return
+	}
+	for _, o := range spawns.Objects {
+		if monsterDefBySpawnName(o.Name) == nil {
+			c.report(seed, "spawn %d at (%d, %d) is for an unknown monster %q", o.Id, int(o.X), int(o.Y), o.Name)
+		}
+	}
+}
+
+// checkChests reports a map with fewer chests than the keys it must hold.
+func (c *mapChecker) checkChests(seed int64, m *Map) {
+	chests := 0
+	if objects := m.objectLayer("objects"); objects != nil {
+		for _, o := range objects.Objects {
+			if o.Type == "chest" {
+				chests++
+			}
+		}
+	}
+	if chests < 3 {
+		c.report(seed, "fewer chests (%d) than the 3 keys", chests)
+	}
+}
+
+// checkGenerated reports the problems of the map generated with seed.
+func (c *mapChecker) checkGenerated(layout MapLayout, numRooms int, seed int64) {
+	m, err := generateMap(c.template, layout, numRooms, seed)
+	if err == nil {
+		err = m.postProcess()
+	}
+	if err != nil {
+		c.report(seed, "cannot generate: %v", err)
+		return
+	}
+	c.checkChests(seed, m)
+	if err := m.checkReachable(); err != nil {
+		c.report(seed, "%v, the server would try the next seed", err)
+		return
+	}
+
+	sx, sy := m.PlayerSpawn()
+	startX, startY := sx/m.TileWidth, sy/m.TileHeight
+	if m.isTileBlockedForMonster(startX, startY) {
+		c.report(seed, "player spawn at tile (%d, %d) is in a wall", startX, startY)
+		return
+	}
+	// Every room reached is connected to the spawn, so a room is reachable if
+	// there is a path from the nearest room reached, which keeps searches short.
+	reached := [][2]int{{startX, startY}}
+	for _, center := range m.roomCenters {
+		from := reached[0]
+		for _, r := range reached {
+			if tileDistance(r, center) < tileDistance(from, center) {
+				from = r
+			}
+		}
+		if from != center && m.findPathExploring(from[0], from[1], center[0], center[1], len(m.blockedGrid)) == nil {
+			c.report(seed, "the room at tile (%d, %d) is unreachable from the player spawn", center[0], center[1])
+			continue
+		}
+		reached = append(reached, center)
+	}
+}
+
+func tileDistance(a, b [2]int) int {
+	return abs(a[0]-b[0]) + abs(a[1]-b[1])
+}
@@ -0,0 +1,87 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckMapTemplateAcceptsDungeon(t *testing.T) {
+	problems, err := CheckMapTemplate(testTemplate, MapLayoutLadder, 10, []int64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("problems = %v, want none", problems)
+	}
+
+	// A single room holds too few chests for the keys, whatever the seed.
+	problems, _ = CheckMapTemplate(testTemplate, MapLayoutLadder, 1, []int64{1, 2})
+	if len(problems) != 2 || problems[0].Seed != 1 || problems[1].Seed != 2 {
+		t.Errorf("problems = %v, want too few chests with seeds 1 and 2", problems)
+	}
+}
+
+func TestCheckMapTemplateReportsEveryProblem(t *testing.T) {
+	template, err := parseMap(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := template.objectLayer("objects")
+	kept := objects.Objects[:0]
+	for _, o := range objects.Objects {
+		switch {
+		case o.Type == "passage" && (o.Name == "crossroad" || o.Name == "turn_left_upper"):
+			continue
+		case o.Type == "boss_stage":
+			continue
+		case o.Type == "room" && o.Name == "room_start":
+			o.Name = "room_first"
+		}
+		kept = append(kept, o)
+	}
+	objects.Objects = kept
+	spawns := template.objectLayer("spawns")
+	spawns.Objects = append(spawns.Objects, MapObject{Id: 9999, Name: "dragon", X: 64, Y: 32})
+
+	got := make([]string, 0)
+	for _, p := range checkMapTemplate(template, MapLayoutLadder, 10, []int64{1}) {
+		if p.Seed != 0 {
+			t.Errorf("seed problem %v, want maps not generated from a broken template", p)
+		}
+		got = append(got, p.Message)
+	}
+	report := strings.Join(got, "\n")
+	for _, want := range []string{
+		`spawn 9999 at (64, 32) is for an unknown monster "dragon"`,
+		"no room_start room",
+		"no boss_stage object",
+		`missing passage "crossroad"`,
+		`missing passage "turn_left_upper"`,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestCheckMapTemplateMatchesEntrances(t *testing.T) {
+	template, err := parseMap(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := template.objectLayer("objects")
+	for i, o := range objects.Objects {
+		if o.Type == "entrance" && o.Name == "room_1" {
+			objects.Objects[i].Name = "room_one"
+		}
+	}
+
+	report := make([]string, 0)
+	for _, p := range checkMapTemplate(template, MapLayoutLadder, 10, []int64{1}) {
+		report = append(report, p.String())
+	}
+	if len(report) != 2 || !strings.Contains(report[0], `named "room_one", which is no room`) ||
+		report[1] != `room "room_1" has no entrance` {
+		t.Errorf("problems = %q, want the stray entrance and the room without one", report)
+	}
+}
@@ -0,0 +1,209 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+)
+
+// Generated maps can be saved as Tiled maps and rendered to an image, so level
+// designers can look at the dungeon of a seed in Tiled or at a glance.
+
+// Tiled stores how a tile is flipped in the top bits of its gid. The diagonal
+// flip swaps x and y and is applied before the other two.
+const (
+	gidFlipH = 0x80000000
+	gidFlipV = 0x40000000
+	gidFlipD = 0x20000000
+)
+
+// SaveTiled writes the map as a Tiled .tmj file. Tileset images are referred to
+// relative to filename, so Tiled finds them wherever the file is written.
+func (m *Map) SaveTiled(filename string) error {
+	out := *m
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	out.Tilesets = make([]MapTileset, len(m.Tilesets))
+	for i, ts := range m.Tilesets {
+		image, err := filepath.Abs(m.imagePath(ts))
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, image); err == nil {
+			image = rel
+		}
+		ts.Image = filepath.ToSlash(image)
+		out.Tilesets[i] = ts
+	}
+
+	// Tiled wants every layer and object to have an id of its own. The layers
+	// postProcess adds have none, and their objects reuse the map's ids.
+	out.Layers = make([]MapLayer, len(m.Layers))
+	copy(out.Layers, m.Layers)
+	nextLayerID, nextObjectID := 1, 1
+	for _, l := range out.Layers {
+		nextLayerID = max(nextLayerID, l.ID+1)
+		for _, o := range l.Objects {
+			nextObjectID = max(nextObjectID, o.Id+1)
+		}
+	}
+	seen := make(map[int]bool)
+	for i := range out.Layers {
+		l := &out.Layers[i]
+		if l.ID == 0 {
+			l.ID = nextLayerID
+			nextLayerID++
+		}
+		l.Objects = append([]MapObject(nil), l.Objects...)
+		for k := range l.Objects {
+			if o := &l.Objects[k]; o.Id == 0 || seen[o.Id] {
+				o.Id = nextObjectID
+				nextObjectID++
+			}
+			seen[l.Objects[k].Id] = true
+		}
+	}
+	out.NextLayerID, out.NextObjectID = nextLayerID, nextObjectID
+
+	content, err := json.Marshal(&out)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, content, 0o644)
+}
+
+// SavePreview renders the map with RenderPreview and writes it as a PNG image.
+func (m *Map) SavePreview(filename string) error {
+	img, err := m.RenderPreview()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// RenderPreview draws the visible tile layers of the map from its tileset
+// images, one pixel per map pixel, the way Tiled does: layers in order, tiles
+// taller than the map's aligned to the bottom of their cell.
+func (m *Map) RenderPreview() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, m.Width*m.TileWidth, m.Height*m.TileHeight))
+	sheets := make(map[string]image.Image)
+	for _, l := range m.Layers {
+		if l.Type != "tilelayer" || !l.Visible {
+			continue
+		}
+		var mask image.Image
+		if l.Opacity > 0 && l.Opacity < 1 {
+			mask = image.NewUniform(color.Alpha{A: uint8(l.Opacity * 255)})
+		}
+		for i, g := range l.Data {
+			ts := m.tilesetOf(g &^ gidFlags)
+			if g == 0 || ts == nil {
+				continue
+			}
+			sheet, ok := sheets[ts.Image]
+			if !ok {
+				var err error
+				if sheet, err = loadImage(m.imagePath(*ts)); err != nil {
+					return nil, fmt.Errorf("tileset %q: %v", ts.Name, err)
+				}
+				sheets[ts.Image] = sheet
+			}
+			tile := flippedTile(sheet, *ts, g)
+			x, y := i%m.Width*m.TileWidth, (i/m.Width+1)*m.TileHeight-ts.Tileheight
+			r := image.Rect(x, y, x+ts.Tilewidth, y+ts.Tileheight)
+			draw.DrawMask(img, r, tile, tile.Bounds().Min, mask, image.Point{}, draw.Over)
+		}
+	}
+
+	return img, nil
+}
+
+// tilesetOf returns the tileset of the tile gid, without its flip bits.
+func (m *Map) tilesetOf(gid int) *MapTileset {
+	var found *MapTileset
+	for i := range m.Tilesets {
+		ts := &m.Tilesets[i]
+		if ts.Firstgid <= gid && (found == nil || ts.Firstgid > found.Firstgid) {
+			found = ts
+		}
+	}
+	if found == nil || gid >= found.Firstgid+found.Tilecount {
+		return nil
+	}
+
+	return found
+}
+
+// imagePath returns the path of the image of ts, which Tiled stores relative
+// to the map.
+func (m *Map) imagePath(ts MapTileset) string {
+	if filepath.IsAbs(ts.Image) {
+		return ts.Image
+	}
+
+	return filepath.Join(m.dir, filepath.FromSlash(ts.Image))
+}
+
+// flippedTile returns the tile gid of the tileset image sheet, flipped as its
+// gid says.
+func flippedTile(sheet image.Image, ts MapTileset, gid int) image.Image {
+	id := gid&^gidFlags - ts.Firstgid
+	columns := max(ts.Columns, 1)
+	x := ts.Margin + id%columns*(ts.Tilewidth+ts.Spacing)
+	y := ts.Margin + id/columns*(ts.Tileheight+ts.Spacing)
+	src := image.Rect(x, y, x+ts.Tilewidth, y+ts.Tileheight).Add(sheet.Bounds().Min)
+	if s, ok := sheet.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok && gid&(gidFlipH|gidFlipV|gidFlipD) == 0 {
+		return s.SubImage(src)
+	}
+
+	w, h := ts.Tilewidth, ts.Tileheight
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for dy := 0; dy < h; dy++ {
+		for dx := 0; dx < w; dx++ {
+			// Undo the flips in reverse order: vertical, horizontal, diagonal.
+			sx, sy := dx, dy
+			if gid&gidFlipV != 0 {
+				sy = h - 1 - sy
+			}
+			if gid&gidFlipH != 0 {
+				sx = w - 1 - sx
+			}
+			if gid&gidFlipD != 0 {
+				sx, sy = sy, sx
+			}
+			out.Set(dx, dy, sheet.At(src.Min.X+sx, src.Min.Y+sy))
+		}
+	}
+
+	return out
+}
+
+// loadImage decodes the PNG image in filename.
+func loadImage(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return png.Decode(f)
+}
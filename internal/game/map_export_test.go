@@ -0,0 +1,99 @@
+package game
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveTiledWritesAMapTiledCanOpen(t *testing.T) {
+	m, err := LoadGeneratedMap(testTemplate, MapLayoutLadder, 4, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "maps", "seed7.tmj")
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveTiled(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := parseMap(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Width != m.Width || saved.Height != m.Height || len(saved.Layers) != len(m.Layers) {
+		t.Errorf("saved a %dx%d map of %d layers, want %dx%d of %d", saved.Width, saved.Height, len(saved.Layers), m.Width, m.Height, len(m.Layers))
+	}
+	for _, ts := range saved.Tilesets {
+		if _, err := os.Stat(saved.imagePath(ts)); err != nil {
+			t.Errorf("tileset %q: %v", ts.Name, err)
+		}
+	}
+
+	layerIDs, objectIDs := make(map[int]bool), make(map[int]bool)
+	for _, l := range saved.Layers {
+		if l.ID == 0 || l.ID >= saved.NextLayerID || layerIDs[l.ID] {
+			t.Errorf("layer %q has id %d, want a unique one below %d", l.Name, l.ID, saved.NextLayerID)
+		}
+		layerIDs[l.ID] = true
+		for _, o := range l.Objects {
+			if o.Id == 0 || o.Id >= saved.NextObjectID || objectIDs[o.Id] {
+				t.Fatalf("object %q of layer %q has id %d, want a unique one below %d", o.Name, l.Name, o.Id, saved.NextObjectID)
+			}
+			objectIDs[o.Id] = true
+		}
+	}
+}
+
+func TestRenderPreviewDrawsTheTileLayers(t *testing.T) {
+	m, err := LoadGeneratedMap(testTemplate, MapLayoutLadder, 1, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := m.RenderPreview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != m.Width*m.TileWidth || b.Dy() != m.Height*m.TileHeight {
+		t.Fatalf("preview is %v, want %dx%d", b, m.Width*m.TileWidth, m.Height*m.TileHeight)
+	}
+
+	// The centre of the start room has floor, the map's corner is void.
+	sx, sy := m.PlayerSpawn()
+	if _, _, _, a := img.At(sx, sy).RGBA(); a == 0 {
+		t.Error("the start room is not drawn")
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Error("the void corner of the map is drawn")
+	}
+}
+
+func TestFlippedTile(t *testing.T) {
+	// A 2x2 tile whose top-left pixel only is red.
+	sheet := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	red := color.RGBA{R: 255, A: 255}
+	sheet.Set(0, 0, red)
+	ts := MapTileset{Firstgid: 1, Columns: 1, Tilecount: 1, Tilewidth: 2, Tileheight: 2}
+
+	for _, tc := range []struct {
+		gid  int
+		x, y int
+	}{
+		{1, 0, 0},
+		{1 | gidFlipH, 1, 0},
+		{1 | gidFlipV, 0, 1},
+		{1 | gidFlipH | gidFlipV, 1, 1},
+		{1 | gidFlipD, 0, 0},
+		{1 | gidFlipD | gidFlipH, 1, 0},
+	} {
+		tile := flippedTile(sheet, ts, tc.gid)
+		at := tile.Bounds().Min.Add(image.Pt(tc.x, tc.y))
+		if got := color.RGBAModel.Convert(tile.At(at.X, at.Y)); got != red {
+			t.Errorf("gid %#x: pixel (%d, %d) is %v, want the red one", tc.gid, tc.x, tc.y, got)
+		}
+	}
+}
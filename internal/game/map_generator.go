@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -80,6 +81,7 @@ type roomTemplate struct {
 	isStart        bool
 	isDemon        bool
 	isBoss         bool
+	biome          string // "" for the template's own rooms, see map_biomes.go
 }
 
 // passagePiece is a corridor tile block plus the detected offsets (within the
@@ -118,7 +120,30 @@ type slot struct {
 	awayLaneCx int    // column of the return lane for a south door facing away (0 = none)
 }
 
-func generateMap(template *Map, numRooms int, seed int64) (*Map, error) {
+// MapLayout names a way of laying the rooms of a generated map out.
+type MapLayout string
+
+const (
+	// MapLayoutLadder lays rooms along rows of corridors joined into loops.
+	MapLayoutLadder MapLayout = "ladder"
+	// MapLayoutTree grows a tree of corridors out of the start room, with side
+	// rooms and dead ends off a critical path (see map_generator_tree.go).
+	MapLayoutTree MapLayout = "tree"
+)
+
+// ParseMapLayout returns the layout named name, the ladder when name is empty.
+func ParseMapLayout(name string) (MapLayout, error) {
+	switch layout := MapLayout(name); layout {
+	case "":
+		return MapLayoutLadder, nil
+	case MapLayoutLadder, MapLayoutTree:
+		return layout, nil
+	default:
+		return "", fmt.Errorf("unknown map layout %q", name)
+	}
+}
+
+func generateMap(template *Map, layout MapLayout, numRooms int, seed int64) (*Map, error) {
 	if numRooms < 1 {
 		return nil, fmt.Errorf("numRooms must be >= 1, got %d", numRooms)
 	}
@@ -131,14 +156,11 @@ func generateMap(template *Map, numRooms int, seed int64) (*Map, error) {
 	if err != nil {
 		return nil, err
 	}
-	pass, err := extractPassages(template)
-	if err != nil {
-		return nil, err
-	}
-	floorGid, wallGid, err := pickCorridorGids(template, pass)
+	biomes, err := extractBiomes(template)
 	if err != nil {
 		return nil, err
 	}
+	pass := biomes[0].pass
 
 	place := chooseRooms(templates, numRooms, rng)
 	n := len(place)
@@ -162,7 +184,10 @@ func generateMap(template *Map, numRooms int, seed int64) (*Map, error) {
 	}
 
 	if n == 1 {
-		return generateSingleRoom(template, &slots[0], wallGid, boss)
+		return generateSingleRoom(template, &slots[0], biomeOf(biomes, slots[0].t).wallGid, boss)
+	}
+	if layout == MapLayoutTree {
+		return generateTreeMap(template, place, boss, biomes, rng)
 	}
 
 	// Split rooms into rows so the map folds into a compact rectangle instead of
@@ -296,89 +321,11 @@ func generateMap(template *Map, numRooms int, seed int64) (*Map, error) {
 		return nil, fmt.Errorf("template map is missing a floor or walls layer")
 	}
 
-	// corridorMask marks every tile written by a corridor piece (not a room) so
-	// the pillar-pairing repair only touches corridors.
-	corridorMask := make([]bool, canvasW*canvasH)
-	mark := func(dstX, dstY, w, h int) {
-		for y := dstY; y < dstY+h; y++ {
-			if y < 0 || y >= canvasH {
-				continue
-			}
-			for x := dstX; x < dstX+w; x++ {
-				if x >= 0 && x < canvasW {
-					corridorMask[x+y*canvasW] = true
-				}
-			}
-		}
-	}
-
-	stamp := func(name string, dstX, dstY int) passagePiece {
-		p := pass.piece(name)
-		copyBlock(out, template, p.tx, p.ty, p.tw, p.th, dstX, dstY)
-		mark(dstX, dstY, p.tw, p.th)
-		return p
-	}
-	// stampJunction places a piece aligning its vertical channel to column cx and
-	// its horizontal channel to row ry.
-	stampJunction := func(name string, cx, ry int) passagePiece {
-		p := pass.piece(name)
-		copyBlock(out, template, p.tx, p.ty, p.tw, p.th, cx-p.vChanOff, ry-p.hChanOff)
-		mark(cx-p.vChanOff, ry-p.hChanOff, p.tw, p.th)
-		return p
-	}
-	tileV := func(cx, y0, y1 int) {
-		if y1 < y0 {
-			return
-		}
-		v := pass.piece("vertical")
-		dstX := cx - v.vChanOff
-		for y := y0; y <= y1; y += v.th {
-			copyBlock(out, template, v.tx, v.ty, v.tw, min(v.th, y1-y+1), dstX, y)
-			mark(dstX, y, v.tw, min(v.th, y1-y+1))
-		}
-	}
-	tileH := func(ry, x0, x1 int) {
-		if x1 < x0 {
-			return
-		}
-		h := pass.piece("horizontal")
-		dstY := ry - h.hChanOff
-		for x := x0; x <= x1; x += h.tw {
-			copyBlock(out, template, h.tx, h.ty, min(h.tw, x1-x+1), h.th, x, dstY)
-			mark(x, dstY, min(h.tw, x1-x+1), h.th)
-		}
-	}
-	// carveThroat opens a connected door inward through the room's wall ring by
-	// clearing only collidable wall tiles (the floor already exists underneath).
-	// Decorative tiles - door arches, jambs, pillars - are left in place.
+	cs := newCorridorStamper(out, template, biomes)
+	stamp, stampJunction, tileV, tileH := cs.stamp, cs.stampJunction, cs.tileV, cs.tileH
 	absorb := lightAbsorbingGids(template)
 	carveThroat := func(rx, ry int, t roomTemplate, e entrance) {
-		clear := func(x, y int) {
-			if x >= 0 && x < canvasW && y >= 0 && y < canvasH {
-				i := x + y*canvasW
-				if !absorb[walls[i]] {
-					return
-				}
-				walls[i] = 0
-				if floor[i] == 0 {
-					floor[i] = floorGid
-				}
-			}
-		}
-		for k := 0; k < e.openLen; k++ {
-			for d := 0; d <= genReach; d++ {
-				switch e.side {
-				case sideNorth:
-					clear(rx+e.off+k, ry+d)
-				case sideSouth:
-					clear(rx+e.off+k, ry+t.th-1-d)
-				case sideWest:
-					clear(rx+d, ry+e.off+k)
-				case sideEast:
-					clear(rx+t.tw-1-d, ry+e.off+k)
-				}
-			}
-		}
+		carveDoor(out, absorb, biomeOf(biomes, t).floorGid, rx, ry, t, e)
 	}
 	// renderAway connects a south door that faces away from the room's spine with a
 	// U-shaped detour: a riser drops off the spine in a return lane to the right,
@@ -465,6 +412,7 @@ func generateMap(template *Map, numRooms int, seed int64) (*Map, error) {
 	for i := range slots {
 		s := &slots[i]
 		stampRoom(out, template, s.t, s.roomX, s.roomY)
+		cs.addRoom(s.t, s.roomX, s.roomY)
 		objects = appendRoomObjects(objects, template, s.t, s.roomX, s.roomY, &nextID)
 		spawns = appendRoomSpawns(spawns, template, s.t, s.roomX, s.roomY, &nextID)
 		out.roomCenters = append(out.roomCenters, [2]int{s.roomX + s.t.tw/2, s.roomY + s.t.th/2})
@@ -521,7 +469,7 @@ func generateMap(template *Map, numRooms int, seed int64) (*Map, error) {
 		renderTrunk(trunkRightX, rightJunctionName)
 	}
 
-	repairCorridorPillars(out, template, pass, corridorMask)
+	repairCorridorPillars(out, template, biomes, cs.mask)
 
 	out.setObjectLayer("objects", objects)
 	out.setObjectLayer("spawns", spawns)
@@ -554,19 +502,7 @@ func generateSingleRoom(template *Map, s *slot, wallGid int, boss *roomTemplate)
 		out.demonRoomCount++
 	}
 	for _, e := range t.entrances {
-		for k := 0; k < e.openLen; k++ {
-			set := func(x, y int) { walls[x+y*out.Width] = wallGid }
-			switch e.side {
-			case sideNorth:
-				set(ox+e.off+k, oy)
-			case sideSouth:
-				set(ox+e.off+k, oy+t.th-1)
-			case sideWest:
-				set(ox, oy+e.off+k)
-			case sideEast:
-				set(ox+t.tw-1, oy+e.off+k)
-			}
-		}
+		sealDoor(out, wallGid, ox, oy, t, e)
 	}
 	if boss != nil {
 		objects, spawns = stampBossRoom(out, template, boss, bossOX, bossOY, objects, spawns, &nextID)
@@ -635,27 +571,30 @@ func lightAbsorbingGids(m *Map) map[int]bool {
 // wide, so wherever the tiled run's phase fails to line up with a junction a
 // single, unpaired pillar is left behind. This pass scans the tiles written by
 // corridors and turns any pillar that has lost its partner back into plain wall
-// by copying an adjacent panel tile over it.
-func repairCorridorPillars(out, template *Map, pass passageSet, mask []bool) {
+// by copying an adjacent panel tile over it. The pillars of every biome's
+// pieces pair with each other.
+func repairCorridorPillars(out, template *Map, biomes []mapBiome, mask []bool) {
 	walls := out.tileLayerData("walls")
 	src := template.tileLayerData("walls")
 	if walls == nil || src == nil {
 		return
 	}
-	h := pass.piece("horizontal")
 	// A left/right pillar tile is the left/right-most wall column of the
 	// horizontal piece, in the rows where it differs from the panel interior.
 	left := map[int]bool{}
 	right := map[int]bool{}
-	for y := 0; y < h.th; y++ {
-		c0 := src[h.tx+(h.ty+y)*template.Width]
-		mid := src[h.tx+2+(h.ty+y)*template.Width]
-		cR := src[h.tx+h.tw-1+(h.ty+y)*template.Width]
-		if c0 != 0 && c0 != mid {
-			left[c0] = true
-		}
-		if cR != 0 && cR != mid {
-			right[cR] = true
+	for _, b := range biomes {
+		h := b.pass.piece("horizontal")
+		for y := 0; y < h.th; y++ {
+			c0 := src[h.tx+(h.ty+y)*template.Width]
+			mid := src[h.tx+2+(h.ty+y)*template.Width]
+			cR := src[h.tx+h.tw-1+(h.ty+y)*template.Width]
+			if c0 != 0 && c0 != mid {
+				left[c0] = true
+			}
+			if cR != 0 && cR != mid {
+				right[cR] = true
+			}
 		}
 	}
 	W, H := out.Width, out.Height
@@ -747,7 +686,9 @@ func junctionName(dir byte, i, n int) string {
 
 // chooseRooms selects which templates to place: room_start first, the demon room
 // last, every other template at least once, then random fills (never duplicating
-// the two special rooms).
+// the two special rooms). The rooms of a template mixing several biomes are
+// placed one biome after the other, each with its share of the rooms, so the
+// dungeon changes theme along its length.
 func chooseRooms(templates []roomTemplate, n int, rng *rand.Rand) []roomTemplate {
 	var start, demon *roomTemplate
 	var normal []roomTemplate
@@ -772,11 +713,15 @@ func chooseRooms(templates []roomTemplate, n int, rng *rand.Rand) []roomTemplate
 	if demon != nil && n >= 2 {
 		reserve = 1
 	}
-	for i := 0; i < len(normal) && len(out) < n-reserve; i++ {
-		out = append(out, normal[i])
-	}
-	for len(out) < n-reserve && len(normal) > 0 {
-		out = append(out, normal[rng.Intn(len(normal))])
+	groups := groupByBiome(normal)
+	for k, group := range groups {
+		end := 1 + (n-reserve-1)*(k+1)/len(groups)
+		for i := 0; i < len(group) && len(out) < end; i++ {
+			out = append(out, group[i])
+		}
+		for len(out) < end {
+			out = append(out, group[rng.Intn(len(group))])
+		}
 	}
 	if reserve == 1 {
 		out = append(out, *demon)
@@ -784,6 +729,22 @@ func chooseRooms(templates []roomTemplate, n int, rng *rand.Rand) []roomTemplate
 	return out
 }
 
+// groupByBiome splits rooms by biome, in the order the biomes first appear.
+func groupByBiome(rooms []roomTemplate) [][]roomTemplate {
+	var groups [][]roomTemplate
+	index := make(map[string]int)
+	for _, t := range rooms {
+		k, ok := index[t.biome]
+		if !ok {
+			k = len(groups)
+			index[t.biome] = k
+			groups = append(groups, nil)
+		}
+		groups[k] = append(groups[k], t)
+	}
+	return groups
+}
+
 // extractRoomTemplates reads every class="room", its class="entrance" openings,
 // and whether it is the start room or contains the demon spawn. It also returns
 // the boss arena (a "boss_stage" object), if present, as a sealed room with no
@@ -829,6 +790,7 @@ func extractRoomTemplates(template *Map) ([]roomTemplate, *roomTemplate, error)
 			tw:      int(r.Width) / ts,
 			th:      int(r.Height) / ts,
 			isStart: r.Name == "room_start",
+			biome:   biomeOfName(r.Name),
 		}
 		for _, e := range entrancesByName[r.Name] {
 			ex, ey := int(e.X)/ts, int(e.Y)/ts
@@ -868,8 +830,19 @@ func extractRoomTemplates(template *Map) ([]roomTemplate, *roomTemplate, error)
 	return templates, boss, nil
 }
 
+// passageNames are the corridor pieces every template map must define.
+var passageNames = []string{"horizontal", "vertical", "crossroad",
+	"turn_right_upper", "turn_right_bottom", "turn_left_upper", "turn_left_bottom",
+	"t_cross_down", "t_cross_up", "t_cross_left", "t_cross_right"}
+
 // extractPassages reads every corridor piece and detects its channel geometry.
 func extractPassages(template *Map) (passageSet, error) {
+	return extractBiomePassages(template, "")
+}
+
+// extractBiomePassages reads the corridor pieces of one biome merged into the
+// template, "" for the template's own.
+func extractBiomePassages(template *Map, biome string) (passageSet, error) {
 	objectsLayer := template.objectLayer("objects")
 	if objectsLayer == nil {
 		return passageSet{}, fmt.Errorf("template map has no objects layer")
@@ -897,7 +870,7 @@ func extractPassages(template *Map) (passageSet, error) {
 	ts := template.TileWidth
 	ps := passageSet{pieces: make(map[string]passagePiece)}
 	for _, o := range objectsLayer.Objects {
-		if o.Type != "passage" {
+		if o.Type != "passage" || biomeOfName(o.Name) != biome {
 			continue
 		}
 		pc := passagePiece{tx: int(o.X) / ts, ty: int(o.Y) / ts, tw: int(o.Width) / ts, th: int(o.Height) / ts}
@@ -913,14 +886,13 @@ func extractPassages(template *Map) (passageSet, error) {
 		} else if off, n := firstRun(func(i int) bool { return open(pc.tx+pc.tw-1, pc.ty+i) }, pc.th); n > 0 {
 			pc.hChanOff = off
 		}
-		ps.pieces[o.Name] = pc
+		ps.pieces[strings.TrimPrefix(o.Name, biome+biomeSeparator)] = pc
 	}
 
-	need := []string{"horizontal", "vertical", "crossroad",
-		"turn_right_upper", "turn_right_bottom", "turn_left_upper", "turn_left_bottom",
-		"t_cross_down", "t_cross_up", "t_cross_left", "t_cross_right"}
-	for _, name := range need {
-		if _, ok := ps.pieces[name]; !ok {
+	for _, name := range passageNames {
+		if _, ok := ps.pieces[name]; !ok && biome != "" {
+			return passageSet{}, fmt.Errorf("biome %q is missing passage %q", biome, name)
+		} else if !ok {
 			return passageSet{}, fmt.Errorf("template map is missing passage %q", name)
 		}
 	}
@@ -984,6 +956,164 @@ func copyBlock(out, template *Map, srcX, srcY, w, h, dstX, dstY int) {
 	}
 }
 
+// corridorStamper stamps passage pieces onto a generated map, marking the tiles
+// they cover so the pillar-pairing repair only touches corridors. Pieces are
+// stamped in the skin of the biome of the nearest room added so far, so rooms
+// are added before the corridors joining them.
+type corridorStamper struct {
+	out, template *Map
+	pass          passageSet // the geometry of every biome's pieces
+	biomes        []mapBiome
+	rooms         []stampedRoom
+	mask          []bool
+}
+
+// stampedRoom is the centre, in tiles, of a placed room and its biome.
+type stampedRoom struct {
+	cx, cy int
+	biome  *mapBiome
+}
+
+func newCorridorStamper(out, template *Map, biomes []mapBiome) *corridorStamper {
+	return &corridorStamper{out: out, template: template, pass: biomes[0].pass, biomes: biomes, mask: make([]bool, out.Width*out.Height)}
+}
+
+// addRoom records the room t placed at (x, y), for the corridors next to it.
+func (cs *corridorStamper) addRoom(t roomTemplate, x, y int) {
+	cs.rooms = append(cs.rooms, stampedRoom{cx: x + t.tw/2, cy: y + t.th/2, biome: biomeOf(cs.biomes, t)})
+}
+
+// biomeAt returns the biome of the room nearest to the block of w x h tiles at
+// (x, y).
+func (cs *corridorStamper) biomeAt(x, y, w, h int) *mapBiome {
+	best, bestDist := &cs.biomes[0], -1
+	cx, cy := x+w/2, y+h/2
+	for _, r := range cs.rooms {
+		if d := (r.cx-cx)*(r.cx-cx) + (r.cy-cy)*(r.cy-cy); bestDist < 0 || d < bestDist {
+			best, bestDist = r.biome, d
+		}
+	}
+	return best
+}
+
+// wallGid returns the wall tile of the biome at (x, y).
+func (cs *corridorStamper) wallGid(x, y int) int {
+	return cs.biomeAt(x, y, 1, 1).wallGid
+}
+
+// copyPiece copies the block of a piece w x h tiles from its top-left corner, in
+// the skin of the biome at (dstX, dstY).
+func (cs *corridorStamper) copyPiece(name string, w, h, dstX, dstY int) {
+	p := cs.biomeAt(dstX, dstY, w, h).pass.piece(name)
+	copyBlock(cs.out, cs.template, p.tx, p.ty, w, h, dstX, dstY)
+	cs.mark(dstX, dstY, w, h)
+}
+
+func (cs *corridorStamper) mark(dstX, dstY, w, h int) {
+	for y := dstY; y < dstY+h; y++ {
+		if y < 0 || y >= cs.out.Height {
+			continue
+		}
+		for x := dstX; x < dstX+w; x++ {
+			if x >= 0 && x < cs.out.Width {
+				cs.mask[x+y*cs.out.Width] = true
+			}
+		}
+	}
+}
+
+// stamp places a whole piece with its top-left corner at (dstX, dstY).
+func (cs *corridorStamper) stamp(name string, dstX, dstY int) passagePiece {
+	p := cs.pass.piece(name)
+	cs.copyPiece(name, p.tw, p.th, dstX, dstY)
+	return p
+}
+
+// stampJunction places a piece aligning its vertical channel to column cx and
+// its horizontal channel to row ry.
+func (cs *corridorStamper) stampJunction(name string, cx, ry int) passagePiece {
+	p := cs.pass.piece(name)
+	return cs.stamp(name, cx-p.vChanOff, ry-p.hChanOff)
+}
+
+// tileV tiles vertical straights with their channel at column cx over rows y0
+// to y1, cutting the last one short.
+func (cs *corridorStamper) tileV(cx, y0, y1 int) {
+	if y1 < y0 {
+		return
+	}
+	v := cs.pass.piece("vertical")
+	dstX := cx - v.vChanOff
+	for y := y0; y <= y1; y += v.th {
+		cs.copyPiece("vertical", v.tw, min(v.th, y1-y+1), dstX, y)
+	}
+}
+
+// tileH tiles horizontal straights with their channel at row ry over columns
+// x0 to x1, cutting the last one short.
+func (cs *corridorStamper) tileH(ry, x0, x1 int) {
+	if x1 < x0 {
+		return
+	}
+	h := cs.pass.piece("horizontal")
+	dstY := ry - h.hChanOff
+	for x := x0; x <= x1; x += h.tw {
+		cs.copyPiece("horizontal", min(h.tw, x1-x+1), h.th, x, dstY)
+	}
+}
+
+// carveDoor opens a connected door of the room placed at (rx, ry) inward through
+// its wall ring by clearing only collidable wall tiles. Decorative tiles - door
+// arches, jambs, pillars - are left in place, but get floor if they have none:
+// an arch on the outer row of a room is drawn over the void.
+func carveDoor(out *Map, absorb map[int]bool, floorGid int, rx, ry int, t roomTemplate, e entrance) {
+	floor := out.tileLayerData("floor")
+	walls := out.tileLayerData("walls")
+	clear := func(x, y int) {
+		if x >= 0 && x < out.Width && y >= 0 && y < out.Height {
+			i := x + y*out.Width
+			if floor[i] == 0 {
+				floor[i] = floorGid
+			}
+			if absorb[walls[i]] {
+				walls[i] = 0
+			}
+		}
+	}
+	for k := 0; k < e.openLen; k++ {
+		for d := 0; d <= genReach; d++ {
+			switch e.side {
+			case sideNorth:
+				clear(rx+e.off+k, ry+d)
+			case sideSouth:
+				clear(rx+e.off+k, ry+t.th-1-d)
+			case sideWest:
+				clear(rx+d, ry+e.off+k)
+			case sideEast:
+				clear(rx+t.tw-1-d, ry+e.off+k)
+			}
+		}
+	}
+}
+
+// sealDoor closes a door no corridor reaches with the corridor wall tile.
+func sealDoor(out *Map, wallGid int, rx, ry int, t roomTemplate, e entrance) {
+	walls := out.tileLayerData("walls")
+	set := func(x, y int) { walls[x+y*out.Width] = wallGid }
+	for k := 0; k < e.openLen; k++ {
+		switch e.side {
+		case sideNorth:
+			set(rx+e.off+k, ry)
+		case sideSouth:
+			set(rx+e.off+k, ry+t.th-1)
+		case sideWest:
+			set(rx, ry+e.off+k)
+		case sideEast:
+			set(rx+t.tw-1, ry+e.off+k)
+		}
+	}
+}
+
 func stampRoom(out, template *Map, t roomTemplate, ox, oy int) {
 	copyBlock(out, template, t.tx, t.ty, t.tw, t.th, ox, oy)
 }
@@ -1106,6 +1236,7 @@ func newBlankMap(template *Map, w, h int) *Map {
 		RenderOrder: template.RenderOrder,
 		Type:        template.Type,
 		Version:     template.Version,
+		dir:         template.dir,
 	}
 	out.Layers = make([]MapLayer, 0, len(template.Layers))
 	for _, src := range template.Layers {
@@ -47,7 +47,7 @@ func TestGenerateMapConnectivity(t *testing.T) {
 
 	for _, n := range []int{1, 2, 4, 6, 9, 10, 13, 20} {
 		for seed := int64(1); seed <= 6; seed++ {
-			m, err := generateMap(template, n, seed)
+			m, err := generateMap(template, MapLayoutLadder, n, seed)
 			if err != nil {
 				t.Fatalf("generate (n=%d seed=%d): %v", n, seed, err)
 			}
@@ -67,3 +67,43 @@ func TestGenerateMapConnectivity(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateTreeMap(t *testing.T) {
+	template, err := parseMap("../../public/assets/dungeon1.tmj")
+	if err != nil {
+		t.Fatalf("parse template: %v", err)
+	}
+
+	for _, n := range []int{2, 3, 7, 15, 30} {
+		for seed := int64(1); seed <= 3; seed++ {
+			m, err := generateMap(template, MapLayoutTree, n, seed)
+			if err != nil {
+				t.Fatalf("generate (n=%d seed=%d): %v", n, seed, err)
+			}
+			if len(m.roomCenters) != n {
+				t.Errorf("n=%d seed=%d: %d rooms placed", n, seed, len(m.roomCenters))
+			}
+			sx, sy := m.PlayerSpawn()
+			reach := reachableFloor(m, sx/m.TileWidth, sy/m.TileHeight)
+			for _, c := range m.roomCenters {
+				if !reach[c[0]+c[1]*m.Width] {
+					t.Errorf("n=%d seed=%d: room interior (%d,%d) unreachable", n, seed, c[0], c[1])
+				}
+			}
+			if m.demonRoomCount > 1 {
+				t.Errorf("n=%d seed=%d: demon room placed %d times", n, seed, m.demonRoomCount)
+			}
+		}
+	}
+}
+
+func TestParseMapLayout(t *testing.T) {
+	for name, want := range map[string]MapLayout{"": MapLayoutLadder, "ladder": MapLayoutLadder, "tree": MapLayoutTree} {
+		if got, err := ParseMapLayout(name); err != nil || got != want {
+			t.Errorf("ParseMapLayout(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseMapLayout("maze"); err == nil {
+		t.Error("ParseMapLayout accepted an unknown layout")
+	}
+}
@@ -0,0 +1,597 @@
+package game
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// The tree layout grows the map out of room_start instead of laying it out in
+// rows. Corridors leave a door, run straight for a while and end in a junction
+// piece, a room or a dead end:
+//
+//   - The trunk is the critical path: corridors and junctions from room_start's
+//     door to the last room chosen (the demon room, when a room holds the demon
+//     spawn), with about one junction for every two other rooms, and a few more
+//     when the last room does not fit.
+//   - Every other way out of a trunk junction starts a branch. A branch reaches
+//     a side room - bending through a turn piece when the room's door faces
+//     sideways - or splits again at a junction of its own: by chance a few
+//     junctions deep, and at any depth when no room fits at its end.
+//   - A room with more than one door passes the corridor on through the others.
+//   - The ways out nothing uses once every room is placed become dead ends: a
+//     short corridor capped with the corridor wall tile, or just the cap where
+//     there is no room for one. Unused room doors are sealed like a lone room's.
+//
+// Nothing is joined back, so unlike the ladder there are no loops: the only way
+// between two rooms is through the junctions between them. Rooms and pieces are
+// planned in unbounded coordinates and checked against each other's bounds, then
+// stamped once everything fits. A plan that runs out of space or of ways out
+// before every room is placed is started over.
+
+const (
+	treeGap          = 2   // void between elements no corridor joins
+	treeMaxDepth     = 3   // junctions deep a branch may split by chance
+	treeSplitChance  = 0.3 // chance a branch splits before it tries to reach a room
+	treeMaxStraights = 12  // straight pieces in a corridor: long ones leave room for more
+	treeTrunkSpare   = 3   // junctions the trunk may run on for the last room
+	treeAttempts     = 400
+)
+
+// passageSides lists the sides each passage piece opens on.
+var passageSides = map[string][]roomSide{
+	"crossroad":         {sideNorth, sideSouth, sideEast, sideWest},
+	"t_cross_down":      {sideWest, sideEast, sideSouth},
+	"t_cross_up":        {sideWest, sideEast, sideNorth},
+	"t_cross_left":      {sideNorth, sideSouth, sideWest},
+	"t_cross_right":     {sideNorth, sideSouth, sideEast},
+	"turn_left_upper":   {sideSouth, sideEast},
+	"turn_left_bottom":  {sideNorth, sideEast},
+	"turn_right_upper":  {sideSouth, sideWest},
+	"turn_right_bottom": {sideNorth, sideWest},
+}
+
+var treeJunctions = []string{"crossroad", "t_cross_down", "t_cross_up", "t_cross_left", "t_cross_right"}
+
+var treeTurns = []string{"turn_left_upper", "turn_left_bottom", "turn_right_upper", "turn_right_bottom"}
+
+func oppositeSide(side roomSide) roomSide {
+	switch side {
+	case sideNorth:
+		return sideSouth
+	case sideSouth:
+		return sideNorth
+	case sideEast:
+		return sideWest
+	default:
+		return sideEast
+	}
+}
+
+func isVertical(side roomSide) bool {
+	return side == sideNorth || side == sideSouth
+}
+
+func opensOn(name string, side roomSide) bool {
+	for _, s := range passageSides[name] {
+		if s == side {
+			return true
+		}
+	}
+	return false
+}
+
+// treeRect is a rectangle of the plan, in tiles.
+type treeRect struct {
+	x, y, w, h int
+}
+
+// overlaps reports whether r and o are closer than gap tiles; rectangles that
+// only touch are gap 0 apart.
+func (r treeRect) overlaps(o treeRect, gap int) bool {
+	return r.x < o.x+o.w+gap && o.x < r.x+r.w+gap && r.y < o.y+o.h+gap && o.y < r.y+r.h+gap
+}
+
+// treePort is a way out of a planned room or piece a corridor can take.
+type treePort struct {
+	dir   roomSide // heading of a corridor leaving through the port
+	c     int      // left column (north/south) or top row (east/west) of the channel
+	edge  int      // first row (north/south) or column (east/west) past the element
+	owner int      // the element, in treePlan.rects
+	room  int      // the room whose door the port is, or -1
+	door  int      // the door, in the room's entrances
+	depth int      // junctions since the trunk
+}
+
+// advance returns the port n tiles further along its heading.
+func (p treePort) advance(n int) treePort {
+	if p.dir == sideNorth || p.dir == sideWest {
+		p.edge -= n
+	} else {
+		p.edge += n
+	}
+	return p
+}
+
+type treeRoom struct {
+	t    roomTemplate
+	x, y int
+	open []bool // doors a corridor reaches, by entrance
+}
+
+type treePiece struct {
+	name string
+	x, y int
+}
+
+// treeStraight is a run of straight pieces with their channel at column c
+// (vertical) or row c (horizontal), from row or column from to to.
+type treeStraight struct {
+	vertical bool
+	c        int
+	from, to int
+}
+
+type treePlan struct {
+	pass      passageSet
+	rng       *rand.Rand
+	rects     []treeRect
+	rooms     []treeRoom
+	pieces    []treePiece
+	straights []treeStraight
+	caps      []treeRect
+	ports     []treePort
+}
+
+// treeStep is an element about to be added to the plan.
+type treeStep struct {
+	rect     treeRect
+	piece    *treePiece
+	straight *treeStraight
+	room     *treeRoom
+}
+
+// straightStep is a corridor n tiles long leaving through port.
+func (p *treePlan) straightStep(port treePort, n int) treeStep {
+	from, to := port.edge, port.advance(n-1).edge
+	if from > to {
+		from, to = to, from
+	}
+	s := &treeStraight{vertical: isVertical(port.dir), c: port.c, from: from, to: to}
+	if s.vertical {
+		v := p.pass.piece("vertical")
+		return treeStep{rect: treeRect{port.c - v.vChanOff, from, v.tw, n}, straight: s}
+	}
+	h := p.pass.piece("horizontal")
+	return treeStep{rect: treeRect{from, port.c - h.hChanOff, n, h.th}, straight: s}
+}
+
+// pieceStep is the piece name entered through port.
+func (p *treePlan) pieceStep(port treePort, name string) treeStep {
+	pc := p.pass.piece(name)
+	var x, y int
+	switch port.dir {
+	case sideNorth:
+		x, y = port.c-pc.vChanOff, port.edge-pc.th+1
+	case sideSouth:
+		x, y = port.c-pc.vChanOff, port.edge
+	case sideEast:
+		x, y = port.edge, port.c-pc.hChanOff
+	case sideWest:
+		x, y = port.edge-pc.tw+1, port.c-pc.hChanOff
+	}
+	return treeStep{rect: treeRect{x, y, pc.tw, pc.th}, piece: &treePiece{name, x, y}}
+}
+
+// piecePort is the way out of a piece planned at step on side.
+func (p *treePlan) piecePort(step treeStep, side roomSide) treePort {
+	pc := p.pass.piece(step.piece.name)
+	r := step.rect
+	port := treePort{dir: side, room: -1}
+	switch side {
+	case sideNorth:
+		port.c, port.edge = r.x+pc.vChanOff, r.y-1
+	case sideSouth:
+		port.c, port.edge = r.x+pc.vChanOff, r.y+r.h
+	case sideEast:
+		port.c, port.edge = r.y+pc.hChanOff, r.x+r.w
+	case sideWest:
+		port.c, port.edge = r.y+pc.hChanOff, r.x-1
+	}
+	return port
+}
+
+// roomStep is the room t entered through its door e from port.
+func (p *treePlan) roomStep(port treePort, t roomTemplate, e entrance) treeStep {
+	var x, y int
+	switch port.dir {
+	case sideNorth:
+		x, y = port.c-e.off+(p.pass.vChannel-e.openLen)/2, port.edge-t.th+1
+	case sideSouth:
+		x, y = port.c-e.off+(p.pass.vChannel-e.openLen)/2, port.edge
+	case sideEast:
+		x, y = port.edge, port.c-e.off+(p.pass.hChannel-e.openLen)/2
+	case sideWest:
+		x, y = port.edge-t.tw+1, port.c-e.off+(p.pass.hChannel-e.openLen)/2
+	}
+	return treeStep{rect: treeRect{x, y, t.tw, t.th}, room: &treeRoom{t: t, x: x, y: y, open: make([]bool, len(t.entrances))}}
+}
+
+// doorPort is the way out of a room planned at (x, y) through its door e.
+func (p *treePlan) doorPort(x, y int, t roomTemplate, e entrance) treePort {
+	port := treePort{dir: e.side}
+	switch e.side {
+	case sideNorth:
+		port.c, port.edge = x+e.off-(p.pass.vChannel-e.openLen)/2, y-1
+	case sideSouth:
+		port.c, port.edge = x+e.off-(p.pass.vChannel-e.openLen)/2, y+t.th
+	case sideEast:
+		port.c, port.edge = y+e.off-(p.pass.hChannel-e.openLen)/2, x+t.tw
+	case sideWest:
+		port.c, port.edge = y+e.off-(p.pass.hChannel-e.openLen)/2, x-1
+	}
+	return port
+}
+
+// fits reports whether steps, leaving through port one after the other, keep
+// clear of the plan and of each other. Each step touches the one before it.
+func (p *treePlan) fits(port treePort, steps []treeStep) bool {
+	for i, s := range steps {
+		for j, r := range p.rects {
+			gap := treeGap
+			if i == 0 && j == port.owner {
+				gap = 0
+			}
+			if s.rect.overlaps(r, gap) {
+				return false
+			}
+		}
+		for j := 0; j < i; j++ {
+			gap := treeGap
+			if j == i-1 {
+				gap = 0
+			}
+			if s.rect.overlaps(steps[j].rect, gap) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// add plans steps, leaving through port, and returns the index of the last one.
+func (p *treePlan) add(port treePort, steps []treeStep) int {
+	if port.room >= 0 {
+		p.rooms[port.room].open[port.door] = true
+	}
+	for _, s := range steps {
+		p.rects = append(p.rects, s.rect)
+		switch {
+		case s.piece != nil:
+			p.pieces = append(p.pieces, *s.piece)
+		case s.straight != nil:
+			p.straights = append(p.straights, *s.straight)
+		case s.room != nil:
+			p.rooms = append(p.rooms, *s.room)
+		}
+	}
+	return len(p.rects) - 1
+}
+
+// addRoom plans the room of the last of steps, entered through its door door,
+// and offers its other doors as ports.
+func (p *treePlan) addRoom(port treePort, steps []treeStep, door, depth int) {
+	owner := p.add(port, steps)
+	i := len(p.rooms) - 1
+	room := &p.rooms[i]
+	if door >= 0 {
+		room.open[door] = true
+	}
+	for k, e := range room.t.entrances {
+		if k == door {
+			continue
+		}
+		dp := p.doorPort(room.x, room.y, room.t, e)
+		dp.owner, dp.room, dp.door, dp.depth = owner, i, k, depth
+		p.ports = append(p.ports, dp)
+	}
+}
+
+// lengths returns corridor lengths of 1 to n straight pieces, in random order.
+func (p *treePlan) lengths(port treePort, n int) []int {
+	unit := p.pass.piece("horizontal").tw
+	if isVertical(port.dir) {
+		unit = p.pass.piece("vertical").th
+	}
+	out := make([]int, n)
+	for i, k := range p.rng.Perm(n) {
+		out[i] = (k + 1) * unit
+	}
+	return out
+}
+
+// growJunction plans a corridor from port to a junction piece. On the trunk it
+// returns the way on, and every other way out joins the ports of branches.
+func (p *treePlan) growJunction(port treePort, trunk bool) (treePort, bool) {
+	return p.growPiece(port, treeJunctions, trunk)
+}
+
+// growPiece plans a corridor from port to one of the pieces names, like
+// growJunction.
+func (p *treePlan) growPiece(port treePort, names []string, trunk bool) (treePort, bool) {
+	enter := oppositeSide(port.dir)
+	for _, i := range p.rng.Perm(len(names)) {
+		name := names[i]
+		if !opensOn(name, enter) {
+			continue
+		}
+		for _, n := range p.lengths(port, treeMaxStraights) {
+			steps := []treeStep{p.straightStep(port, n), p.pieceStep(port.advance(n), name)}
+			if !p.fits(port, steps) {
+				continue
+			}
+			owner := p.add(port, steps)
+			var outs []treePort
+			for _, side := range passageSides[name] {
+				if side == enter {
+					continue
+				}
+				out := p.piecePort(steps[1], side)
+				out.owner, out.depth = owner, port.depth+1
+				outs = append(outs, out)
+			}
+			var on treePort
+			if trunk {
+				k := p.rng.Intn(len(outs))
+				on = outs[k]
+				outs = append(outs[:k], outs[k+1:]...)
+			}
+			p.ports = append(p.ports, outs...)
+			return on, true
+		}
+	}
+	return treePort{}, false
+}
+
+// reachRoom plans a corridor from port into t, straight through a door facing
+// it or bending through a turn piece to a door facing sideways.
+func (p *treePlan) reachRoom(port treePort, t roomTemplate) bool {
+	type option struct {
+		door int
+		turn string
+	}
+	var options []option
+	for k, e := range t.entrances {
+		heading := oppositeSide(e.side)
+		switch {
+		case heading == port.dir:
+			options = append(options, option{door: k})
+		case isVertical(heading) != isVertical(port.dir):
+			for _, name := range treeTurns {
+				if opensOn(name, oppositeSide(port.dir)) && opensOn(name, heading) {
+					options = append(options, option{door: k, turn: name})
+				}
+			}
+		}
+	}
+
+	for _, i := range p.rng.Perm(len(options)) {
+		o := options[i]
+		e := t.entrances[o.door]
+		heading := oppositeSide(e.side)
+		for _, n := range p.lengths(port, treeMaxStraights) {
+			steps := []treeStep{p.straightStep(port, n)}
+			at := port.advance(n)
+			if o.turn != "" {
+				turn := p.pieceStep(at, o.turn)
+				at = p.piecePort(turn, heading)
+				m := p.lengths(at, 2)[0]
+				steps = append(steps, turn, p.straightStep(at, m))
+				at = at.advance(m)
+			}
+			steps = append(steps, p.roomStep(at, t, e))
+			if p.fits(port, steps) {
+				p.addRoom(port, steps, o.door, port.depth)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deadEnd caps port with a short corridor, or right where it leaves when there
+// is no room for one. Room doors are sealed instead, when the map is stamped.
+func (p *treePlan) deadEnd(port treePort) {
+	if port.room >= 0 {
+		return
+	}
+	channel := p.pass.hChannel
+	if isVertical(port.dir) {
+		channel = p.pass.vChannel
+	}
+	end := port.advance(-1) // the element's own last row or column
+	for _, n := range p.lengths(port, 2) {
+		steps := []treeStep{p.straightStep(port, n)}
+		if p.fits(port, steps) {
+			p.add(port, steps)
+			end = port.advance(n - 1)
+			break
+		}
+	}
+	if isVertical(port.dir) {
+		p.caps = append(p.caps, treeRect{end.c, end.edge, channel, 1})
+	} else {
+		p.caps = append(p.caps, treeRect{end.edge, end.c, 1, channel})
+	}
+}
+
+// planTree plans the rooms of place, room_start first and the end of the trunk
+// last, or returns nil if they did not fit.
+func planTree(place []roomTemplate, pass passageSet, rng *rand.Rand) *treePlan {
+	p := &treePlan{pass: pass, rng: rng}
+	start := treeStep{rect: treeRect{0, 0, place[0].tw, place[0].th},
+		room: &treeRoom{t: place[0], open: make([]bool, len(place[0].entrances))}}
+	p.addRoom(treePort{owner: -1, room: -1}, []treeStep{start}, -1, 0)
+
+	last := place[len(place)-1]
+	side := append([]roomTemplate(nil), place[1:len(place)-1]...)
+	rng.Shuffle(len(side), func(i, j int) { side[i], side[j] = side[j], side[i] })
+
+	k := rng.Intn(len(p.ports))
+	trunk := p.ports[k]
+	p.ports = append(p.ports[:k], p.ports[k+1:]...)
+	// The trunk runs on past its junctions while the last room does not fit at
+	// its end.
+	junctions := (len(side) + 1) / 2
+	ended := false
+	for i := 0; i < junctions+treeTrunkSpare && !ended; i++ {
+		if i >= junctions {
+			if ended = p.reachRoom(trunk, last); ended {
+				break
+			}
+		}
+		on, ok := p.growJunction(trunk, true)
+		if !ok {
+			break
+		}
+		on.depth = 0
+		trunk = on
+	}
+	if !ended && !p.reachRoom(trunk, last) {
+		// The other ways out of the last junction lead on as well.
+		for k := len(p.ports) - 1; k >= 0 && !ended; k-- {
+			if p.ports[k].owner != p.ports[len(p.ports)-1].owner {
+				break
+			}
+			if ended = p.reachRoom(p.ports[k], last); ended {
+				p.ports = append(p.ports[:k], p.ports[k+1:]...)
+			}
+		}
+	}
+	if !ended {
+		return nil
+	}
+
+	takePort := func() treePort {
+		k := rng.Intn(len(p.ports))
+		port := p.ports[k]
+		p.ports = append(p.ports[:k], p.ports[k+1:]...)
+		return port
+	}
+	for len(side) > 0 {
+		if len(p.ports) == 0 {
+			return nil
+		}
+		port := takePort()
+		if port.depth < treeMaxDepth && rng.Float64() < treeSplitChance {
+			if _, ok := p.growJunction(port, false); ok {
+				continue
+			}
+		}
+		placed := false
+		for _, i := range rng.Perm(len(side)) {
+			if p.reachRoom(port, side[i]) {
+				side = append(side[:i], side[i+1:]...)
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+		if _, ok := p.growJunction(port, false); ok {
+			continue
+		}
+		p.deadEnd(port)
+	}
+	for len(p.ports) > 0 {
+		p.deadEnd(takePort())
+	}
+
+	return p
+}
+
+// generateTreeMap lays the rooms of place out as a tree and stamps them.
+func generateTreeMap(template *Map, place []roomTemplate, boss *roomTemplate, biomes []mapBiome, rng *rand.Rand) (*Map, error) {
+	pass := biomes[0].pass
+	var p *treePlan
+	for attempt := 0; attempt < treeAttempts && p == nil; attempt++ {
+		p = planTree(place, pass, rng)
+	}
+	if p == nil {
+		return nil, fmt.Errorf("cannot lay %d rooms out as a tree", len(place))
+	}
+
+	minX, minY, maxX, maxY := 0, 0, 0, 0
+	for _, r := range p.rects {
+		minX, minY = min(minX, r.x), min(minY, r.y)
+		maxX, maxY = max(maxX, r.x+r.w), max(maxY, r.y+r.h)
+	}
+	ox, oy := genMargin-minX, genMargin-minY
+	canvasW, canvasH := maxX-minX+2*genMargin, maxY-minY+2*genMargin
+	bossOX, bossOY := 0, 0
+	if boss != nil {
+		bossOX, bossOY, canvasW, canvasH = bossOrigin(boss, canvasW, canvasH)
+	}
+
+	out := newBlankMap(template, canvasW, canvasH)
+	walls := out.tileLayerData("walls")
+	if walls == nil || out.tileLayerData("floor") == nil {
+		return nil, fmt.Errorf("template map is missing a floor or walls layer")
+	}
+	absorb := lightAbsorbingGids(template)
+	cs := newCorridorStamper(out, template, biomes)
+
+	objects := make([]MapObject, 0)
+	spawns := make([]MapObject, 0)
+	nextID := 1
+	for _, r := range p.rooms {
+		x, y := r.x+ox, r.y+oy
+		stampRoom(out, template, r.t, x, y)
+		cs.addRoom(r.t, x, y)
+		objects = appendRoomObjects(objects, template, r.t, x, y, &nextID)
+		spawns = appendRoomSpawns(spawns, template, r.t, x, y, &nextID)
+		out.roomCenters = append(out.roomCenters, [2]int{x + r.t.tw/2, y + r.t.th/2})
+		if r.t.isStart {
+			out.spawnX = (x + r.t.tw/2) * out.TileWidth
+			out.spawnY = (y + r.t.th/2) * out.TileHeight
+		}
+		if r.t.isDemon {
+			out.demonRoomCount++
+		}
+		b := biomeOf(biomes, r.t)
+		for k, e := range r.t.entrances {
+			if r.open[k] {
+				carveDoor(out, absorb, b.floorGid, x, y, r.t, e)
+			} else {
+				sealDoor(out, b.wallGid, x, y, r.t, e)
+			}
+		}
+	}
+	if boss != nil {
+		objects, spawns = stampBossRoom(out, template, boss, bossOX, bossOY, objects, spawns, &nextID)
+	}
+
+	for _, s := range p.straights {
+		if s.vertical {
+			cs.tileV(s.c+ox, s.from+oy, s.to+oy)
+		} else {
+			cs.tileH(s.c+oy, s.from+ox, s.to+ox)
+		}
+	}
+	for _, pc := range p.pieces {
+		cs.stamp(pc.name, pc.x+ox, pc.y+oy)
+	}
+	for _, c := range p.caps {
+		wallGid := cs.wallGid(c.x+ox, c.y+oy)
+		for y := c.y; y < c.y+c.h; y++ {
+			for x := c.x; x < c.x+c.w; x++ {
+				walls[x+ox+(y+oy)*out.Width] = wallGid
+			}
+		}
+	}
+	repairCorridorPillars(out, template, biomes, cs.mask)
+
+	out.setObjectLayer("objects", objects)
+	out.setObjectLayer("spawns", spawns)
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chestReachTiles is how close, in tiles, a player must get to open a chest.
+const chestReachTiles = 3
+
+// reachableTiles flood-fills the walkable tiles reachable from tile (x, y). A
+// tile is walkable when it has floor and the blocked grid does not block it, so
+// the void around the dungeon never connects rooms. A map without a floor layer
+// is all floor.
+func (m *Map) reachableTiles(x, y int) []bool {
+	floor := m.tileLayerData("floor")
+	walkable := func(x, y int) bool {
+		if m.isTileBlockedForMonster(x, y) {
+			return false
+		}
+		i := x + y*m.gridWidth
+		return floor == nil || (i < len(floor) && floor[i] != 0)
+	}
+
+	reached := make([]bool, m.gridWidth*m.gridHeight)
+	if !walkable(x, y) {
+		return reached
+	}
+	reached[x+y*m.gridWidth] = true
+	stack := []Point{{X: x, Y: y}}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			nx, ny := cur.X+d[0], cur.Y+d[1]
+			if walkable(nx, ny) && !reached[nx+ny*m.gridWidth] {
+				reached[nx+ny*m.gridWidth] = true
+				stack = append(stack, Point{X: nx, Y: ny})
+			}
+		}
+	}
+
+	return reached
+}
+
+// checkReachable returns an error listing the rooms and chests players cannot
+// get to from their spawn. Any chest may be given a key at the start of a match,
+// so a single unreachable one can make the match unwinnable. The boss arena is
+// not a room: players are teleported there.
+func (m *Map) checkReachable() error {
+	sx, sy := m.PlayerSpawn()
+	reached := m.reachableTiles(sx/m.TileWidth, sy/m.TileHeight)
+	isReached := func(x, y int) bool {
+		return x >= 0 && x < m.gridWidth && y >= 0 && y < m.gridHeight && reached[x+y*m.gridWidth]
+	}
+
+	var unreachable []string
+	for _, c := range m.roomCenters {
+		if !isReached(c[0], c[1]) {
+			unreachable = append(unreachable, fmt.Sprintf("room at tile (%d, %d)", c[0], c[1]))
+		}
+	}
+	if objects := m.objectLayer("objects"); objects != nil {
+		for _, o := range objects.Objects {
+			if o.Type != "chest" {
+				continue
+			}
+			cx, cy := int(o.X)/m.TileWidth, int(o.Y)/m.TileHeight
+			near := false
+			for y := cy - chestReachTiles; y <= cy+chestReachTiles && !near; y++ {
+				for x := cx - chestReachTiles; x <= cx+chestReachTiles && !near; x++ {
+					near = isReached(x, y)
+				}
+			}
+			if !near {
+				unreachable = append(unreachable, fmt.Sprintf("chest %d at tile (%d, %d)", o.Id, cx, cy))
+			}
+		}
+	}
+	if len(unreachable) > 0 {
+		return fmt.Errorf("%s unreachable from the player spawn", strings.Join(unreachable, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckReachable(t *testing.T) {
+	// A wall down column 20 cuts the map in two, the spawn on the left.
+	var wall []Point
+	for y := 0; y < 40; y++ {
+		wall = append(wall, Point{X: 20, Y: y})
+	}
+	m := newTestMap(40, 40, wall...)
+	m.spawnX, m.spawnY = 5*tileSize, 5*tileSize
+	m.roomCenters = [][2]int{{5, 5}, {15, 30}}
+	m.Layers = []MapLayer{{Name: "objects", Type: "objectgroup", Objects: []MapObject{
+		{Id: 1, Type: "chest", X: 10 * tileSize, Y: 10 * tileSize},
+		// Behind the wall but within reach of the left side.
+		{Id: 2, Type: "chest", X: 22 * tileSize, Y: 10 * tileSize},
+	}}}
+
+	if err := m.checkReachable(); err != nil {
+		t.Errorf("checkReachable() = %v, want everything reachable", err)
+	}
+
+	m.roomCenters = append(m.roomCenters, [2]int{30, 30})
+	m.Layers[0].Objects = append(m.Layers[0].Objects, MapObject{Id: 3, Type: "chest", X: 30 * tileSize, Y: 10 * tileSize})
+	err := m.checkReachable()
+	if err == nil || !strings.Contains(err.Error(), "room at tile (30, 30), chest 3 at tile (30, 10) unreachable") {
+		t.Errorf("checkReachable() = %v, want the room and chest right of the wall", err)
+	}
+}
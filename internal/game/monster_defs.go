@@ -8,6 +8,7 @@ type MonsterDef struct {
 	SpawnName    string // name in the Tiled "spawns" layer; "" if never map-spawned
 	SpawnOnStart bool   // spawned by spawnInitialMonsters (demon and jelly children are not)
 	BaseHP       int
+	Threat       int                           // weight in a match's monster population; 0 if never added by difficulty scaling
 	Damage       int                           // mon.damage at spawn (0 if unused)
 	MoveSpeed    int                           // px per position-tick
 	Intellect    func(*Game, *Monster)         // AI tick
@@ -59,6 +60,7 @@ func init() {
 		SpawnName:    "archer",
 		SpawnOnStart: true,
 		BaseHP:       100,
+		Threat:       2,
 		Intellect:    (*Game).intellectArcher,
 	})
 	registerMonster(&MonsterDef{
@@ -66,6 +68,7 @@ func init() {
 		SpawnName:    "skeleton",
 		SpawnOnStart: true,
 		BaseHP:       200,
+		Threat:       2,
 		Intellect:    (*Game).intellectSkeleton,
 	})
 	registerMonster(&MonsterDef{
@@ -73,6 +76,7 @@ func init() {
 		SpawnName:    "golem",
 		SpawnOnStart: true,
 		BaseHP:       1000,
+		Threat:       4,
 		MoveSpeed:    1,
 		Intellect:    (*Game).intellectGolem,
 	})
@@ -81,6 +85,7 @@ func init() {
 		SpawnName:    "spider",
 		SpawnOnStart: true,
 		BaseHP:       150,
+		Threat:       1,
 		Intellect:    (*Game).intellectSpider,
 	})
 	registerMonster(&MonsterDef{
@@ -88,6 +93,7 @@ func init() {
 		SpawnName:    "jelly",
 		SpawnOnStart: true,
 		BaseHP:       500,
+		Threat:       3,
 		Damage:       20,
 		MoveSpeed:    1,
 		Intellect:    (*Game).intellectJelly,
@@ -98,6 +104,7 @@ func init() {
 		SpawnName:    "demon_mage",
 		SpawnOnStart: true,
 		BaseHP:       300,
+		Threat:       3,
 		Intellect:    (*Game).intellectDemonMage,
 	})
 	registerMonster(&MonsterDef{
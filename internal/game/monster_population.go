@@ -0,0 +1,257 @@
+package game
+
+import (
+	"dungeon/internal/lobby"
+	"sort"
+)
+
+// The spawns of a map are designed for a party of referencePartySize players.
+// With a difficulty set, spawnInitialMonsters scales that population to the
+// players of the match: it adds up the Threat of the map's spawns, scales the
+// total by the difficulty and the party size, then drops random spawns or adds
+// monsters until the population matches. Added monsters go to open floor far
+// from the other monsters, which fills rooms and corridors the map left empty.
+// Kinds tougher than the difficulty allows are swapped for allowed ones, and
+// every monster's BaseHP is scaled as well.
+
+// referencePartySize is the number of players map spawns are designed for.
+const referencePartySize = 4
+
+const (
+	// partyThreatPercent is the population added, in percent of the map's, for
+	// every player above referencePartySize, and removed for every player below.
+	partyThreatPercent = 15
+	minPartyThreat     = 55 // percent of the map's population, whatever the party
+	maxPartyThreat     = 300
+	// partyHPPercent is the monster HP added or removed likewise.
+	partyHPPercent = 5
+	minPartyHP     = 85
+	maxPartyHP     = 200
+)
+
+const (
+	// monsterSpawnClearance is how far, in tiles, added monsters stay from the
+	// player spawn.
+	monsterSpawnClearance = 12
+	// monsterSpotSamples is how many open tiles are drawn for an added monster;
+	// it goes to the one farthest from the other monsters.
+	monsterSpotSamples = 8
+)
+
+// MonsterDifficultyDef tunes the monster population of a match. Adding a
+// difficulty is an entry in monsterDifficultyDefs plus its
+// lobby.MonsterDifficulty* constant.
+type MonsterDifficultyDef struct {
+	// ThreatPercent scales the population of a map's spawns, for a party of
+	// referencePartySize players.
+	ThreatPercent int
+	// HPPercent scales every monster's BaseHP.
+	HPPercent int
+	// MaxThreat is the Threat of the toughest kind spawned. Tougher spawns of
+	// the map are replaced with other kinds.
+	MaxThreat int
+}
+
+var monsterDifficultyDefs = map[string]*MonsterDifficultyDef{
+	lobby.MonsterDifficultyEasy: {
+		ThreatPercent: 70,
+		HPPercent:     75,
+		MaxThreat:     2,
+	},
+	lobby.MonsterDifficultyNormal: {
+		ThreatPercent: 100,
+		HPPercent:     100,
+		MaxThreat:     4,
+	},
+	lobby.MonsterDifficultyHard: {
+		ThreatPercent: 140,
+		HPPercent:     130,
+		MaxThreat:     4,
+	},
+}
+
+// UseMonsterDifficulty scales the monsters of every floor to difficulty and the
+// number of players. Without it, matches have the monsters of the map's spawns.
+// It must be called before StartMainLoop.
+func (g *Game) UseMonsterDifficulty(difficulty string) {
+	g.monsterDifficulty = monsterDifficultyDefs[difficulty]
+}
+
+// monsterSpawn is a monster about to be spawned.
+type monsterSpawn struct {
+	def  *MonsterDef
+	x, y int
+}
+
+// mapMonsterSpawns returns the monsters of the map's spawns layer spawned when
+// a floor starts.
+func (g *Game) mapMonsterSpawns() []monsterSpawn {
+	var spawns []monsterSpawn
+	if layer := g.gameMap.getLayerByName("spawns"); layer != nil {
+		for _, obj := range layer.Objects {
+			if def := monsterDefBySpawnName(obj.Name); def != nil && def.SpawnOnStart {
+				spawns = append(spawns, monsterSpawn{def: def, x: int(obj.X), y: int(obj.Y)})
+			}
+		}
+	}
+
+	return spawns
+}
+
+// partySize counts the players monsters are scaled for.
+func (g *Game) partySize() int {
+	n := 0
+	for _, p := range g.players {
+		if !p.isSpectator {
+			n++
+		}
+	}
+
+	return max(n, 1)
+}
+
+// partyPercent returns 100 plus perPlayer for every player above
+// referencePartySize, minus it for every one below, within [lo, hi].
+func partyPercent(players, perPlayer, lo, hi int) int {
+	return min(max(100+perPlayer*(players-referencePartySize), lo), hi)
+}
+
+// monsterHP is the HP of a new monster of def.
+func (g *Game) monsterHP(def *MonsterDef) int {
+	d := g.monsterDifficulty
+	if d == nil {
+		return def.BaseHP
+	}
+	hp := def.BaseHP * d.HPPercent / 100 * partyPercent(g.partySize(), partyHPPercent, minPartyHP, maxPartyHP) / 100
+
+	return max(hp, 1)
+}
+
+// scaleMonsterSpawns returns spawns, the map's, scaled to the difficulty and the
+// party.
+func (g *Game) scaleMonsterSpawns(spawns []monsterSpawn) []monsterSpawn {
+	d := g.monsterDifficulty
+	kinds := scalableMonsterKinds(d.MaxThreat)
+	if len(kinds) == 0 {
+		return spawns
+	}
+
+	threat := 0
+	for i := range spawns {
+		threat += spawns[i].def.Threat
+		if spawns[i].def.Threat > d.MaxThreat {
+			spawns[i].def = g.pickMonsterKind(kinds)
+		}
+	}
+	target := threat * d.ThreatPercent / 100 * partyPercent(g.partySize(), partyThreatPercent, minPartyThreat, maxPartyThreat) / 100
+
+	// Sum again: swapped kinds may be weaker.
+	threat = 0
+	for _, s := range spawns {
+		threat += s.def.Threat
+	}
+	if threat > target {
+		g.rng.Shuffle(len(spawns), func(i, j int) { spawns[i], spawns[j] = spawns[j], spawns[i] })
+		for len(spawns) > 0 && threat > target {
+			threat -= spawns[len(spawns)-1].def.Threat
+			spawns = spawns[:len(spawns)-1]
+		}
+		return spawns
+	}
+
+	spots := g.openMonsterSpots()
+	for threat < target && len(spots) > 0 {
+		x, y := g.farthestMonsterSpot(spots, spawns)
+		def := g.pickMonsterKind(kinds)
+		spawns = append(spawns, monsterSpawn{def: def, x: x, y: y})
+		threat += def.Threat
+	}
+
+	return spawns
+}
+
+// scalableMonsterKinds returns the kinds added to a population, by Kind so a
+// seed always picks the same ones.
+func scalableMonsterKinds(maxThreat int) []*MonsterDef {
+	var kinds []*MonsterDef
+	for _, d := range monsterDefs {
+		if d.SpawnOnStart && d.Threat > 0 && d.Threat <= maxThreat {
+			kinds = append(kinds, d)
+		}
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Kind < kinds[j].Kind })
+
+	return kinds
+}
+
+// pickMonsterKind picks one of kinds, tougher ones more often.
+func (g *Game) pickMonsterKind(kinds []*MonsterDef) *MonsterDef {
+	total := 0
+	for _, d := range kinds {
+		total += d.Threat
+	}
+	n := g.rng.Intn(total)
+	for _, d := range kinds {
+		if n < d.Threat {
+			return d
+		}
+		n -= d.Threat
+	}
+
+	return kinds[len(kinds)-1]
+}
+
+// openMonsterSpots returns the centres, in px, of the tiles a monster can be
+// added on: open floor players can walk to, with open floor all around, away
+// from the player spawn. The boss arena is not connected to the spawn, so no
+// monster is added there.
+func (g *Game) openMonsterSpots() [][2]int {
+	m := g.gameMap
+	sx, sy := m.PlayerSpawn()
+	stx, sty := sx/m.TileWidth, sy/m.TileHeight
+	reached := m.reachableTiles(stx, sty)
+
+	var spots [][2]int
+	for y := 1; y < m.gridHeight-1; y++ {
+		for x := 1; x < m.gridWidth-1; x++ {
+			if abs(x-stx) < monsterSpawnClearance && abs(y-sty) < monsterSpawnClearance {
+				continue
+			}
+			open := true
+			for dy := -1; dy <= 1 && open; dy++ {
+				for dx := -1; dx <= 1 && open; dx++ {
+					open = reached[x+dx+(y+dy)*m.gridWidth]
+				}
+			}
+			if !open {
+				continue
+			}
+			spots = append(spots, [2]int{x*m.TileWidth + m.TileWidth/2, y*m.TileHeight + m.TileHeight/2})
+		}
+	}
+
+	return spots
+}
+
+// farthestMonsterSpot returns, of a few spots drawn at random, the one farthest
+// from every monster of spawns.
+func (g *Game) farthestMonsterSpot(spots [][2]int, spawns []monsterSpawn) (int, int) {
+	best, bestDist := spots[0], -1
+	for i := 0; i < monsterSpotSamples; i++ {
+		spot := spots[g.rng.Intn(len(spots))]
+		dist := -1
+		for _, s := range spawns {
+			if d := getDistance(spot[0], spot[1], s.x, s.y); dist < 0 || d < dist {
+				dist = d
+			}
+		}
+		if dist < 0 {
+			return spot[0], spot[1]
+		}
+		if dist > bestDist {
+			best, bestDist = spot, dist
+		}
+	}
+
+	return best[0], best[1]
+}
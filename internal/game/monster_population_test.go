@@ -0,0 +1,106 @@
+package game
+
+import (
+	"dungeon/internal/lobby"
+	"testing"
+)
+
+// newPopulationTestGame returns a game on an open 80x80 map whose spawns are
+// four skeletons and a golem, with the players spawning at the top-left
+// corner.
+func newPopulationTestGame(players int, difficulty string) *Game {
+	g, _ := newTestGame()
+	m := newTestMap(80, 80)
+	m.spawnX, m.spawnY = 40, 40
+	m.Layers = []MapLayer{{Name: "spawns", Objects: []MapObject{
+		{Id: 1, Name: "skeleton", X: 400, Y: 400},
+		{Id: 2, Name: "skeleton", X: 800, Y: 400},
+		{Id: 3, Name: "skeleton", X: 400, Y: 800},
+		{Id: 4, Name: "skeleton", X: 800, Y: 800},
+		{Id: 5, Name: "golem", X: 600, Y: 600},
+	}}}
+	g.gameMap = m
+	for id := 1; id <= players; id++ {
+		addTestPlayer(g, uint64(id), ClassKnight)
+	}
+	if difficulty != "" {
+		g.UseMonsterDifficulty(difficulty)
+	}
+
+	return g
+}
+
+func monstersThreat(g *Game) int {
+	threat := 0
+	for _, m := range g.monsters {
+		threat += monsterDefs[m.kind].Threat
+	}
+
+	return threat
+}
+
+func TestMonsterPopulationScalesWithPartyAndDifficulty(t *testing.T) {
+	mapThreat := 4*monsterDefs[monsterKindSkeleton].Threat + monsterDefs[monsterKindGolem].Threat
+
+	g := newPopulationTestGame(4, "")
+	g.spawnInitialMonsters()
+	if len(g.monsters) != 5 || monstersThreat(g) != mapThreat {
+		t.Fatalf("no difficulty: %d monsters of threat %d, want the map's 5", len(g.monsters), monstersThreat(g))
+	}
+
+	g = newPopulationTestGame(4, lobby.MonsterDifficultyNormal)
+	g.spawnInitialMonsters()
+	if got := monstersThreat(g); got != mapThreat {
+		t.Errorf("normal, 4 players: threat %d, want the map's %d", got, mapThreat)
+	}
+
+	small := newPopulationTestGame(1, lobby.MonsterDifficultyNormal)
+	small.spawnInitialMonsters()
+	big := newPopulationTestGame(12, lobby.MonsterDifficultyNormal)
+	big.spawnInitialMonsters()
+	if !(monstersThreat(small) < mapThreat && monstersThreat(big) > mapThreat) {
+		t.Errorf("threat with 1, 4, 12 players = %d, %d, %d, want it to grow with the party", monstersThreat(small), mapThreat, monstersThreat(big))
+	}
+	if big.monsters[0].maxHP <= monsterDefs[big.monsters[0].kind].BaseHP {
+		t.Errorf("12 players: %s has %d HP, want more than its base %d", big.monsters[0].kind, big.monsters[0].maxHP, monsterDefs[big.monsters[0].kind].BaseHP)
+	}
+
+	hard := newPopulationTestGame(4, lobby.MonsterDifficultyHard)
+	hard.spawnInitialMonsters()
+	if monstersThreat(hard) <= mapThreat {
+		t.Errorf("hard, 4 players: threat %d, want more than the map's %d", monstersThreat(hard), mapThreat)
+	}
+
+	easy := newPopulationTestGame(4, lobby.MonsterDifficultyEasy)
+	easy.spawnInitialMonsters()
+	for _, m := range easy.monsters {
+		if def := monsterDefs[m.kind]; def.Threat > monsterDifficultyDefs[lobby.MonsterDifficultyEasy].MaxThreat {
+			t.Errorf("easy: spawned a %s", m.kind)
+		}
+		if m.hp >= monsterDefs[m.kind].BaseHP {
+			t.Errorf("easy: %s has %d HP, want less than its base %d", m.kind, m.hp, monsterDefs[m.kind].BaseHP)
+		}
+	}
+}
+
+func TestMonsterPopulationAddsMonstersOnOpenFloor(t *testing.T) {
+	g := newPopulationTestGame(12, lobby.MonsterDifficultyHard)
+	// A wall across the map: the bottom half cannot be walked to.
+	for x := 0; x < 80; x++ {
+		g.gameMap.blockedGrid[x+50*80] = true
+	}
+	g.spawnInitialMonsters()
+	if len(g.monsters) <= 5 {
+		t.Fatalf("%d monsters, want monsters added for 12 players on hard", len(g.monsters))
+	}
+
+	for _, m := range g.monsters[5:] {
+		tx, ty := m.x/tileSize, m.y/tileSize
+		if ty >= 50 {
+			t.Errorf("%s added at tile (%d, %d), behind the wall", m.kind, tx, ty)
+		}
+		if tx < monsterSpawnClearance && ty < monsterSpawnClearance {
+			t.Errorf("%s added at tile (%d, %d), next to the player spawn", m.kind, tx, ty)
+		}
+	}
+}
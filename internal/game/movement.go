@@ -0,0 +1,83 @@
+package game
+
+import "time"
+
+// Movement is client-predicted but server-authoritative: clients report where
+// they are, and the server only accepts a position that the player could have
+// legally reached since the last accepted one. A rejected move leaves the
+// server position untouched and snaps the client back with a
+// PlayerPositionCorrectionEvent.
+
+// moveWindowMax caps the time credited to a single move. Without it a player
+// could stand still for a while and then cover the whole map in one command.
+const moveWindowMax = 500 * time.Millisecond
+
+// moveTolerance absorbs rounding, jitter and the client's fixed-step physics.
+const moveTolerance = tileSize
+
+// Dodge parameters mirror the client: a 300 px/s dash lasting 400 ms that can be
+// used once every 2 s.
+const dodgeSpeed = 300
+const dodgeDuration = 400 * time.Millisecond
+const dodgeCooldown = 2 * time.Second
+
+// dodgeCooldownSlack forgives network jitter between two dodges sent exactly one
+// cooldown apart.
+const dodgeCooldownSlack = 200 * time.Millisecond
+
+// maxMoveDistanceUnsafe returns how many pixels the player may have travelled
+// since their last accepted move, given class speed, haste and an active dodge.
+// Caller must hold g.mutex.
+func (g *Game) maxMoveDistanceUnsafe(p *Player, now time.Time) int {
+	elapsed := moveWindowMax
+	if !p.lastMoveAt.IsZero() {
+		elapsed = min(now.Sub(p.lastMoveAt), moveWindowMax)
+	}
+
+	speed := classMoveSpeed(p.class)
+	if p.speedBoostPercent > 0 {
+		speed = speed * (100 + p.speedBoostPercent) / 100
+	}
+	if !p.dodgeStartedAt.IsZero() && now.Sub(p.dodgeStartedAt) < dodgeDuration+moveWindowMax {
+		speed = max(speed, dodgeSpeed)
+	}
+
+	return int(float64(speed)*elapsed.Seconds()) + moveTolerance
+}
+
+// validateMoveUnsafe reports whether the player may move from their current
+// server position to (x, y): the step must fit within the speed budget and must
+// not pass through walls or abyss. Caller must hold g.mutex.
+func (g *Game) validateMoveUnsafe(p *Player, x, y int, now time.Time) bool {
+	// The client drives each axis at full speed independently, so the budget
+	// applies per axis (a diagonal step may be longer than the budget overall).
+	budget := g.maxMoveDistanceUnsafe(p, now)
+	if abs(x-p.x) > budget || abs(y-p.y) > budget {
+		return false
+	}
+
+	return !g.gameMap.isSegmentBlocked(p.x, p.y, x, y)
+}
+
+// applyMoveUnsafe validates and applies a client-reported position. On
+// rejection the server position is kept and the client is told to snap back.
+// It returns whether the move was accepted. Caller must hold g.mutex.
+func (g *Game) applyMoveUnsafe(p *Player, x, y int, direction string, isMoving bool) bool {
+	now := g.now()
+	if !g.validateMoveUnsafe(p, x, y, now) {
+		p.client.SendEvent(PlayerPositionCorrectionEvent{
+			X:         p.x,
+			Y:         p.y,
+			Direction: p.direction,
+		})
+		return false
+	}
+
+	p.x = x
+	p.y = y
+	p.direction = direction
+	p.isMoving = isMoving
+	p.lastMoveAt = now
+
+	return true
+}
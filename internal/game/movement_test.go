@@ -0,0 +1,128 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMovePlayerToAcceptsNormalStep(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(20, 20)
+	p, client := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 100, 100
+	p.lastMoveAt = g.now().Add(-50 * time.Millisecond)
+
+	g.movePlayerTo(1, 108, 100, "right", true)
+
+	if p.x != 108 || p.y != 100 {
+		t.Errorf("position = (%d,%d), want (108,100)", p.x, p.y)
+	}
+	if len(client.sentEvents) != 0 {
+		t.Errorf("expected no correction, got %v", client.sentEvents)
+	}
+}
+
+func TestMovePlayerToRejectsTeleport(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(100, 100)
+	p, client := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 100, 100
+	p.lastMoveAt = g.now()
+
+	g.movePlayerTo(1, 1000, 100, "right", true)
+
+	if p.x != 100 || p.y != 100 {
+		t.Errorf("position = (%d,%d), want unchanged (100,100)", p.x, p.y)
+	}
+	corr, ok := client.sentEvents[len(client.sentEvents)-1].(PlayerPositionCorrectionEvent)
+	if !ok {
+		t.Fatalf("expected PlayerPositionCorrectionEvent, got %v", client.sentEvents)
+	}
+	if corr.X != 100 || corr.Y != 100 {
+		t.Errorf("correction = (%d,%d), want (100,100)", corr.X, corr.Y)
+	}
+}
+
+func TestMovePlayerToRejectsWalkingThroughWall(t *testing.T) {
+	g, _ := newTestGame()
+	// A wall column at tile x=7 between (100,100) and (120,100).
+	g.gameMap = newTestMap(20, 20, Point{7, 6})
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 100, 100
+	p.lastMoveAt = g.now().Add(-200 * time.Millisecond)
+
+	g.movePlayerTo(1, 124, 100, "right", true)
+
+	if p.x != 100 {
+		t.Errorf("x = %d, want 100 (blocked by wall)", p.x)
+	}
+}
+
+func TestMovePlayerToCreditsIdleTimeUpToWindow(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(100, 100)
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 100, 100
+	p.lastMoveAt = g.now().Add(-time.Minute)
+
+	// A minute idle still only buys one window: 180 px/s * 0.5s + tolerance.
+	g.movePlayerTo(1, 100+90+moveTolerance+10, 100, "right", true)
+	if p.x != 100 {
+		t.Errorf("x = %d, want 100 (beyond one move window)", p.x)
+	}
+	g.movePlayerTo(1, 100+90, 100, "right", true)
+	if p.x != 190 {
+		t.Errorf("x = %d, want 190 (within one move window)", p.x)
+	}
+}
+
+func TestHasteRaisesMoveBudget(t *testing.T) {
+	g, _ := newTestGame()
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	now := g.now()
+	p.lastMoveAt = now.Add(-time.Second)
+
+	base := g.maxMoveDistanceUnsafe(p, now)
+	p.speedBoostPercent = 30
+	boosted := g.maxMoveDistanceUnsafe(p, now)
+
+	if boosted <= base {
+		t.Errorf("boosted budget %d should exceed base %d", boosted, base)
+	}
+}
+
+func TestDodgeGrantsDashSpeedOncePerCooldown(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(20, 20)
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 100, 100
+	p.lastMoveAt = g.now()
+
+	g.dodge(1, 100, 100, "right", true)
+	first := p.dodgeStartedAt
+	if first.IsZero() {
+		t.Fatal("dodge should record its start time")
+	}
+
+	g.dodge(1, 100, 100, "right", true)
+	if !p.dodgeStartedAt.Equal(first) {
+		t.Error("a second dodge inside the cooldown must not restart the dash")
+	}
+}
+
+func TestIsSegmentBlocked(t *testing.T) {
+	m := newTestMap(10, 10, Point{5, 5})
+
+	if m.isSegmentBlocked(8, 88, 79, 88) {
+		t.Error("row 5 segment left of the wall should be open up to x=79")
+	}
+	if !m.isSegmentBlocked(70, 88, 100, 88) {
+		t.Error("segment crossing tile (5,5) should be blocked")
+	}
+	if !m.isSegmentBlocked(8, 8, -20, 8) {
+		t.Error("leaving the map should be blocked")
+	}
+	if (*Map)(nil).isSegmentBlocked(0, 0, 100, 100) {
+		t.Error("a nil map never blocks")
+	}
+}
@@ -0,0 +1,227 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+// Projectiles (player fireballs and arrows, archer and trap arrows, demon bolts)
+// are simulated on the server; clients only draw them. Every position tick each
+// projectile is advanced and resolved against walls, players and monsters, and
+// the server applies the damage itself. When a projectile stops, a
+// ProjectileDestroyedEvent tells clients where, so their copy ends in the same
+// place.
+
+// Projectile velocities in px per second. They mirror what clients draw.
+const fireballVelocity = 500
+const playerArrowVelocity = 700
+const monsterArrowVelocity = 400
+const demonBoltVelocity = 700
+
+// maxProjectileTravel bounds projectiles without a range of their own (arrows,
+// bolts), so one that leaves the walled area does not live forever.
+const maxProjectileTravel = 60 * tileSize
+
+// projectileStep is the sampling distance along a projectile's path within a
+// tick. It is small enough that a fast projectile cannot skip over a wall or a
+// creature between two ticks.
+const projectileStep = 4
+
+// Hit radii around a creature's position.
+const playerHitRadius = 12
+const monsterHitRadius = 14
+
+// explosionRadius is the reach of a fireball explosion, roughly the size of the
+// explosion sprite drawn by clients.
+const explosionRadius = 32
+
+// projectileDamageKinds are the damage kinds resolved by the projectile
+// simulation. Client reports of hits of these kinds are ignored.
+var projectileDamageKinds = map[string]bool{
+	damageKindFireball:  true,
+	damageKindExplosion: true,
+	damageKindArrow:     true,
+	damageKindBullet:    true,
+}
+
+type Projectile struct {
+	id            int
+	kind          string // damage kind applied on a direct hit
+	ownerClientID uint64 // shooting player, 0 for monsters and traps
+	x             float64
+	y             float64
+	vx            float64 // px per second
+	vy            float64 // px per second
+	remaining     float64 // px left to travel before the projectile stops
+	explodes      bool
+	// inWall is set while a projectile launched from inside a wall (arrow traps
+	// are set into walls) has not left it yet; walls don't stop it until then.
+	inWall bool
+}
+
+// isFromPlayer reports whether a player fired the projectile. Only player
+// projectiles hurt monsters.
+func (pr *Projectile) isFromPlayer() bool {
+	return pr.ownerClientID != 0
+}
+
+// spawnProjectileUnsafe launches a projectile from (x1, y1) toward (x2, y2). A
+// distance of 0 means it flies until it hits something (up to
+// maxProjectileTravel). It returns nil if the two points are the same, as there
+// is no direction to fly in. Caller must hold g.mutex.
+func (g *Game) spawnProjectileUnsafe(kind string, ownerClientID uint64, x1, y1, x2, y2 int, velocity int, distance int) *Projectile {
+	dx, dy := float64(x2-x1), float64(y2-y1)
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	if distance <= 0 {
+		distance = maxProjectileTravel
+	}
+
+	g.lastProjectileID++
+	pr := &Projectile{
+		id:            g.lastProjectileID,
+		kind:          kind,
+		ownerClientID: ownerClientID,
+		x:             float64(x1),
+		y:             float64(y1),
+		vx:            dx / length * float64(velocity),
+		vy:            dy / length * float64(velocity),
+		remaining:     float64(distance),
+		explodes:      kind == damageKindFireball,
+		inWall:        g.gameMap.isPointInWall(x1, y1),
+	}
+	g.projectiles = append(g.projectiles, pr)
+
+	return pr
+}
+
+// tickProjectilesUnsafe advances all projectiles by dt and removes those that
+// stopped. Caller must hold g.mutex.
+func (g *Game) tickProjectilesUnsafe(dt time.Duration) {
+	// Damage may trigger code that launches new projectiles, so detach the list
+	// while iterating and merge any newcomers back afterwards.
+	current := g.projectiles
+	g.projectiles = nil
+
+	kept := make([]*Projectile, 0, len(current))
+	for _, pr := range current {
+		if g.advanceProjectileUnsafe(pr, dt.Seconds()) {
+			kept = append(kept, pr)
+		}
+	}
+	g.projectiles = append(kept, g.projectiles...)
+}
+
+// advanceProjectileUnsafe moves a projectile along its path for the given
+// number of seconds, stopping it at the first wall or creature it meets. It
+// returns whether the projectile is still flying. Caller must hold g.mutex.
+func (g *Game) advanceProjectileUnsafe(pr *Projectile, seconds float64) bool {
+	speed := math.Hypot(pr.vx, pr.vy)
+	travel := math.Min(speed*seconds, pr.remaining)
+	dirX, dirY := pr.vx/speed, pr.vy/speed
+
+	steps := int(math.Ceil(travel / projectileStep))
+	for i := 1; i <= steps; i++ {
+		d := math.Min(float64(i*projectileStep), travel)
+		x := int(math.Round(pr.x + dirX*d))
+		y := int(math.Round(pr.y + dirY*d))
+		hitWall := g.gameMap.isPointInWall(x, y)
+		if !hitWall {
+			pr.inWall = false
+		}
+		if (hitWall && !pr.inWall) || g.hitProjectileTargetUnsafe(pr, x, y) {
+			g.stopProjectileUnsafe(pr, x, y)
+			return false
+		}
+	}
+
+	pr.x += dirX * travel
+	pr.y += dirY * travel
+	pr.remaining -= travel
+	if pr.remaining <= 0 {
+		g.stopProjectileUnsafe(pr, int(math.Round(pr.x)), int(math.Round(pr.y)))
+		return false
+	}
+
+	return true
+}
+
+// hitProjectileTargetUnsafe damages the first creature the projectile touches at
+// (x, y) and reports whether there was one. A projectile never hits its shooter,
+// and monster projectiles pass through monsters. Caller must hold g.mutex.
+func (g *Game) hitProjectileTargetUnsafe(pr *Projectile, x, y int) bool {
+	for _, p := range g.players {
+		if p.hp <= 0 || p.isSpectator || p.client.ID() == pr.ownerClientID {
+			continue
+		}
+		if math.Hypot(float64(p.x-x), float64(p.y-y)) > playerHitRadius {
+			continue
+		}
+		g.hitPlayerWithKindUnsafe(p.client.ID(), pr.kind)
+
+		return true
+	}
+
+	if !pr.isFromPlayer() {
+		return false
+	}
+
+	for _, m := range g.monsters {
+		if m.hp <= 0 {
+			continue
+		}
+		if math.Hypot(float64(m.x-x), float64(m.y-y)) > monsterHitRadius {
+			continue
+		}
+		g.hitMonsterUnsafe(pr.ownerClientID, m.id, damageForKind(pr.kind))
+
+		return true
+	}
+
+	return false
+}
+
+// stopProjectileUnsafe ends a projectile at (x, y), telling clients where it
+// stopped and detonating it if it explodes. Caller must hold g.mutex.
+func (g *Game) stopProjectileUnsafe(pr *Projectile, x, y int) {
+	g.broadcastEventFunc(ProjectileDestroyedEvent{
+		ProjectileID: pr.id,
+		X:            x,
+		Y:            y,
+	})
+
+	if pr.explodes {
+		g.explodeUnsafe(pr.ownerClientID, x, y)
+	}
+}
+
+// explodeUnsafe deals explosion damage to every living player within
+// explosionRadius of (x, y), the shooter included, and to monsters if a player
+// caused the explosion. Caller must hold g.mutex.
+func (g *Game) explodeUnsafe(ownerClientID uint64, x, y int) {
+	for _, p := range g.players {
+		if p.hp <= 0 || p.isSpectator {
+			continue
+		}
+		if math.Hypot(float64(p.x-x), float64(p.y-y)) > explosionRadius {
+			continue
+		}
+		g.hitPlayerWithKindUnsafe(p.client.ID(), damageKindExplosion)
+	}
+
+	if ownerClientID == 0 {
+		return
+	}
+
+	for _, m := range g.monsters {
+		if m.hp <= 0 {
+			continue
+		}
+		if math.Hypot(float64(m.x-x), float64(m.y-y)) > explosionRadius {
+			continue
+		}
+		g.hitMonsterUnsafe(ownerClientID, m.id, damageForKind(damageKindExplosion))
+	}
+}
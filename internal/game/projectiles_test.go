@@ -0,0 +1,162 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// runProjectiles ticks the simulation until no projectile is left in flight.
+func runProjectiles(t *testing.T, g *Game) {
+	t.Helper()
+	for i := 0; i < 600 && len(g.projectiles) > 0; i++ {
+		g.tickProjectilesUnsafe(positionsUpdateTickPeriod)
+	}
+	if len(g.projectiles) > 0 {
+		t.Fatalf("projectiles still flying after 10s: %d", len(g.projectiles))
+	}
+}
+
+func lastProjectileDestroyed(t *testing.T, events []interface{}) ProjectileDestroyedEvent {
+	t.Helper()
+	for i := len(events) - 1; i >= 0; i-- {
+		if ev, ok := events[i].(ProjectileDestroyedEvent); ok {
+			return ev
+		}
+	}
+	t.Fatalf("no ProjectileDestroyedEvent in %v", events)
+	return ProjectileDestroyedEvent{}
+}
+
+func TestArrowHitsMonsterAndStops(t *testing.T) {
+	g, broadcast := newTestGame()
+	p, _ := addTestPlayer(g, 1, ClassRogue)
+	p.x, p.y = 100, 100
+	mon := &Monster{id: 7, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 200, y: 100}
+	g.monsters = append(g.monsters, mon)
+
+	pr := g.spawnProjectileUnsafe(damageKindArrow, 1, 100, 100, 300, 100, playerArrowVelocity, 0)
+	runProjectiles(t, g)
+
+	if want := 100 - damageForKind(damageKindArrow); mon.hp != want {
+		t.Errorf("monster hp = %d, want %d", mon.hp, want)
+	}
+	ev := lastProjectileDestroyed(t, *broadcast)
+	if ev.ProjectileID != pr.id {
+		t.Errorf("destroyed projectile = %d, want %d", ev.ProjectileID, pr.id)
+	}
+	if ev.X < 200-monsterHitRadius || ev.X > 200 {
+		t.Errorf("arrow stopped at x = %d, want near the monster", ev.X)
+	}
+}
+
+func TestProjectileStopsAtWall(t *testing.T) {
+	g, broadcast := newTestGame()
+	g.gameMap = newTestMap(40, 20)
+	g.gameMap.visibilityColliders = []Rectangle{{X: 150, Y: 80, Width: 16, Height: 48}}
+	mon := &Monster{id: 7, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 200, y: 100}
+	g.monsters = append(g.monsters, mon)
+
+	g.spawnProjectileUnsafe(damageKindArrow, 1, 100, 100, 300, 100, playerArrowVelocity, 0)
+	runProjectiles(t, g)
+
+	if mon.hp != 100 {
+		t.Errorf("monster behind the wall was hit: hp = %d", mon.hp)
+	}
+	if ev := lastProjectileDestroyed(t, *broadcast); ev.X < 150 || ev.X > 154 {
+		t.Errorf("arrow stopped at x = %d, want at the wall (150)", ev.X)
+	}
+}
+
+func TestProjectileLeavesWallItStartsIn(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(40, 20)
+	g.gameMap.visibilityColliders = []Rectangle{{X: 96, Y: 80, Width: 16, Height: 48}}
+	p, _ := addTestPlayer(g, 1, ClassMage)
+	p.x, p.y = 200, 100
+
+	// An arrow trap set into the wall shoots at the player.
+	g.spawnProjectileUnsafe(damageKindArrow, 0, 104, 100, 120, 100, monsterArrowVelocity, 0)
+	runProjectiles(t, g)
+
+	if want := classMaxHP(ClassMage) - damageForKind(damageKindArrow); p.hp != want {
+		t.Errorf("player hp = %d, want %d", p.hp, want)
+	}
+}
+
+func TestFireballExplodesAtEndOfRange(t *testing.T) {
+	g, _ := newTestGame()
+	caster, _ := addTestPlayer(g, 1, ClassMage)
+	caster.x, caster.y = 100, 100
+	// Beside the fireball's path, but within the explosion at its end.
+	mon := &Monster{id: 7, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 200, y: 120}
+	g.monsters = append(g.monsters, mon)
+
+	g.spawnProjectileUnsafe(damageKindFireball, 1, 100, 100, 101, 100, fireballVelocity, 100)
+	runProjectiles(t, g)
+
+	if want := 100 - damageForKind(damageKindExplosion); mon.hp != want {
+		t.Errorf("monster hp = %d, want %d", mon.hp, want)
+	}
+	if caster.hp != classMaxHP(ClassMage) {
+		t.Errorf("caster out of the blast was hurt: hp = %d", caster.hp)
+	}
+}
+
+func TestMonsterProjectilePassesThroughMonsters(t *testing.T) {
+	g, _ := newTestGame()
+	p, _ := addTestPlayer(g, 1, ClassKnight)
+	p.x, p.y = 300, 100
+	shield := &Monster{id: 7, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 200, y: 100}
+	g.monsters = append(g.monsters, shield)
+
+	g.spawnProjectileUnsafe(damageKindBullet, 0, 100, 100, 300, 100, demonBoltVelocity, 0)
+	runProjectiles(t, g)
+
+	if shield.hp != 100 {
+		t.Errorf("monster hit by a monster projectile: hp = %d", shield.hp)
+	}
+	if want := classMaxHP(ClassKnight) - damageForKind(damageKindBullet); p.hp != want {
+		t.Errorf("player hp = %d, want %d", p.hp, want)
+	}
+}
+
+func TestProjectileDoesNotHitShooter(t *testing.T) {
+	g, _ := newTestGame()
+	p, _ := addTestPlayer(g, 1, ClassRogue)
+	p.x, p.y = 100, 100
+
+	g.spawnProjectileUnsafe(damageKindArrow, 1, 100, 100, 300, 100, playerArrowVelocity, 0)
+	runProjectiles(t, g)
+
+	if p.hp != classMaxHP(ClassRogue) {
+		t.Errorf("shooter hit by own arrow: hp = %d", p.hp)
+	}
+}
+
+func TestHitPlayerCommandIgnoresProjectileKinds(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(20, 20)
+	p, client := addTestPlayer(g, 1, ClassMage)
+	p.x, p.y = 32, 32
+	maxHP := p.hp
+
+	trap := NewTrap("t", TrapTypeSpikes, TrapParams{ActivePercent: 50, X: 0, Y: 0}, TrapActivator{Type: ActivatorTimer, Period: 2})
+	trap.Activate()
+	g.recordTrapAttackUnsafe(trap)
+
+	send := func(kind string) {
+		data, _ := json.Marshal(HitPlayerCommand{MonsterID: trapOriginID, TargetClientID: 1, Kind: kind})
+		g.DispatchGameCommand(client, "HitPlayerCommand", json.RawMessage(data))
+	}
+
+	send(damageKindArrow)
+	send(damageKindExplosion)
+	if p.hp != maxHP {
+		t.Errorf("client-reported projectile hit applied: hp = %d", p.hp)
+	}
+
+	send(damageKindSpike)
+	if p.hp != maxHP-damageForKind(damageKindSpike) {
+		t.Errorf("spike hit not applied: hp = %d", p.hp)
+	}
+}
@@ -0,0 +1,99 @@
+package game
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// A match can be recorded for later review. The recorder gets every command the
+// game accepts and every event it broadcasts, plus, once each floor of the
+// dungeon is populated, a spectator's JoinToStartedGameEvent, so that playing the
+// broadcasts back after it shows the match to a web client as if it were live.
+
+// ReplayRecorder records a match. It is called from several goroutines, with
+// and without g.mutex held.
+type ReplayRecorder interface {
+	ID() string
+	RecordCommand(clientID uint64, name string, data json.RawMessage)
+	RecordEvent(event interface{})
+	Close() error
+}
+
+// replayViewerNickname names the spectator a replay is watched as.
+const replayViewerNickname = "Replay"
+
+// RecordReplay records the match with rec until it ends. It must be called
+// before StartMainLoop.
+func (g *Game) RecordReplay(rec ReplayRecorder) {
+	g.replay = rec
+	broadcast := g.broadcastEventFunc
+	g.broadcastEventFunc = func(event interface{}) {
+		rec.RecordEvent(event)
+		broadcast(event)
+	}
+}
+
+// recordReplayStart records the initial game data of a spectator, which a replay
+// starts with.
+func (g *Game) recordReplayStart() {
+	g.mutex.Lock()
+	g.recordReplayViewUnsafe()
+	g.mutex.Unlock()
+}
+
+// recordReplayViewUnsafe records a spectator's JoinToStartedGameEvent for the
+// current floor. Caller must hold g.mutex.
+func (g *Game) recordReplayViewUnsafe() {
+	if g.replay == nil {
+		return
+	}
+
+	x, y := g.playerSpawn()
+	viewer := &Player{
+		client:      &replayViewer{},
+		class:       ClassKnight,
+		level:       1,
+		x:           x,
+		y:           y,
+		direction:   "right",
+		isSpectator: true,
+	}
+	// The game data refers to live game state, so encode it under the lock.
+	g.replay.RecordEvent(JoinToStartedGameEvent{GameData: g.getPlayerInitialGameData(viewer)})
+}
+
+// closeReplay finishes the recording, if there is one.
+func (g *Game) closeReplay() {
+	if g.replay == nil {
+		return
+	}
+	if err := g.replay.Close(); err != nil {
+		log.Printf("cannot close replay %s: %v\n", g.replay.ID(), err)
+		return
+	}
+	log.Printf("replay %s saved\n", g.replay.ID())
+}
+
+// replayViewer is the client a replay is watched as. It never takes part in the
+// match.
+type replayViewer struct{}
+
+func (v *replayViewer) SendEvent(event interface{}) {}
+
+func (v *replayViewer) ID() uint64 {
+	return 0
+}
+
+func (v *replayViewer) SetNickname(string) {}
+
+func (v *replayViewer) Nickname() string {
+	return replayViewerNickname
+}
+
+func (v *replayViewer) CloseConnection() {}
+
+func (v *replayViewer) GetAdditionalProperties() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (v *replayViewer) SetAdditionalProperties(properties map[string]interface{}) {}
@@ -0,0 +1,82 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type fakeReplayRecorder struct {
+	commands []string
+	events   []interface{}
+	closed   bool
+}
+
+func (r *fakeReplayRecorder) ID() string {
+	return "test-replay"
+}
+
+func (r *fakeReplayRecorder) RecordCommand(clientID uint64, name string, data json.RawMessage) {
+	r.commands = append(r.commands, name)
+}
+
+func (r *fakeReplayRecorder) RecordEvent(event interface{}) {
+	r.events = append(r.events, event)
+}
+
+func (r *fakeReplayRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestReplayRecordsCommandsAndBroadcasts(t *testing.T) {
+	g, broadcast := newTestGame()
+	g.gameMap = newTestMap(20, 20)
+	_, client := addTestPlayer(g, 1, ClassMage)
+	rec := &fakeReplayRecorder{}
+	g.RecordReplay(rec)
+
+	g.recordReplayStart()
+	start, ok := rec.events[0].(JoinToStartedGameEvent)
+	if !ok || start.GameData["isSpectator"] != true {
+		t.Fatalf("replay does not start with a spectator's game data: %#v", rec.events)
+	}
+
+	data, _ := json.Marshal(CastFireballCommand{Direction: "right"})
+	g.DispatchGameCommand(client, "CastFireballCommand", json.RawMessage(data))
+	if len(rec.commands) != 1 || rec.commands[0] != "CastFireballCommand" {
+		t.Errorf("recorded commands = %v", rec.commands)
+	}
+
+	g.endGame(1, winningSideLight)
+	if !rec.closed {
+		t.Error("replay not closed when the game ended")
+	}
+	if len(rec.events) != len(*broadcast)+1 {
+		t.Errorf("recorded %d events, want the start and all %d broadcasts", len(rec.events), len(*broadcast))
+	}
+	end, ok := rec.events[len(rec.events)-1].(EndGameEvent)
+	if !ok || end.ReplayID != "test-replay" {
+		t.Errorf("last recorded event = %#v, want EndGameEvent with the replay id", rec.events[len(rec.events)-1])
+	}
+}
+
+func TestStoppedGameFinishesReplay(t *testing.T) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(20, 20)
+	rec := &fakeReplayRecorder{}
+	g.RecordReplay(rec)
+
+	g.Stop()
+
+	if !rec.closed {
+		t.Error("replay not closed when the game was deleted")
+	}
+	if !g.isGameEnded() {
+		t.Error("a deleted game keeps running")
+	}
+	for _, event := range rec.events {
+		if _, ok := event.(EndGameEvent); ok {
+			t.Error("a deleted game was ended with a winner")
+		}
+	}
+}
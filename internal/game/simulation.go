@@ -0,0 +1,102 @@
+package game
+
+import (
+	"dungeon/internal/lobby"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// A simulated match is played by bots only and driven step by step instead of
+// by the real-time loop, so it runs as fast as the CPU allows. Bots receive
+// events and think in lockstep with the simulation instead of on their own
+// goroutines.
+
+// botThinkEverySteps is how often bots think in a simulated match.
+const botThinkEverySteps = int(botThinkPeriod / simulationStep)
+
+// MatchResult summarizes a simulated match.
+type MatchResult struct {
+	Seed int64
+	// WinningSide is winningSideLight or winningSideCultists, or empty if the
+	// match hit the time limit.
+	WinningSide string
+	Duration    time.Duration
+	// BossRevealedAfter is how long it took to reveal the boss, or 0 if the boss
+	// was never revealed.
+	BossRevealedAfter time.Duration
+	Cultists          int
+	PlayerDeaths      int
+	// MonsterDeaths counts killed monsters by kind.
+	MonsterDeaths map[string]int
+}
+
+// SimulateMatch plays a match on gameMap with numBots bots until one side wins
+// or maxDuration of game time passes. Monsters are scaled to difficulty, unless
+// it is empty.
+func SimulateMatch(gameMap *Map, numBots int, difficulty string, seed int64, maxDuration time.Duration) MatchResult {
+	clients := make([]lobby.ClientPlayer, 0, numBots)
+	bots := make([]*Bot, 0, numBots)
+	for i := 1; i <= numBots; i++ {
+		bc := &BotClient{id: uint64(i), incomingEvents: make(chan interface{}, 256)}
+		bc.SetNickname(fmt.Sprintf("Bot %d", i))
+		clients = append(clients, bc)
+		bots = append(bots, newBot(bc, nil, botDifficultyDefs[lobby.BotDifficultyNormal]))
+	}
+
+	var g *Game
+	var start time.Time
+	var result MatchResult
+	broadcast := func(event interface{}) {
+		switch e := event.(type) {
+		case BossRevealedEvent:
+			result.BossRevealedAfter = g.simNow.Sub(start)
+		case EndGameEvent:
+			result.WinningSide = e.WinningSide
+		case PlayerDeathEvent:
+			result.PlayerDeaths++
+		}
+		for _, c := range clients {
+			c.SendEvent(event)
+		}
+	}
+
+	g = NewGame(clients, nil, broadcast, gameMap, false, seed)
+	if difficulty != "" {
+		g.UseMonsterDifficulty(difficulty)
+	}
+	result.Seed = g.seed
+	for _, b := range bots {
+		bc := b.botClient
+		b.sendCommand = func(commandName string, commandData interface{}) {
+			if data, ok := encodeBotCommand(commandName, commandData); ok {
+				g.DispatchGameCommand(bc, commandName, data)
+			}
+		}
+	}
+
+	g.spawnInitialMonsters()
+	g.spawnInitialObjects()
+	g.sendPlayerInitialGameData()
+
+	start = g.simNow
+	for !g.isGameEnded() && g.simNow.Sub(start) < maxDuration {
+		g.step()
+		for _, b := range bots {
+			b.handlePendingEvents()
+			if g.stepCount%botThinkEverySteps == 0 {
+				b.think(g.simNow)
+			}
+		}
+	}
+	result.Duration = g.simNow.Sub(start)
+
+	result.MonsterDeaths = maps.Clone(g.monsterDeaths)
+	for _, p := range g.players {
+		if p.isCultist {
+			result.Cultists++
+		}
+	}
+
+	return result
+}
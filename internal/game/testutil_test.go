@@ -1,5 +1,10 @@
 package game
 
+import (
+	"math/rand"
+	"time"
+)
+
 // fakeClient is a test double for lobby.ClientPlayer. It records the events sent
 // to it so tests can assert on server -> client messages.
 type fakeClient struct {
@@ -7,6 +12,8 @@ type fakeClient struct {
 	nickname   string
 	props      map[string]interface{}
 	sentEvents []interface{}
+	closed     bool
+	kicked     bool
 }
 
 func newFakeClient(id uint64) *fakeClient {
@@ -21,30 +28,59 @@ func (c *fakeClient) SendEvent(event interface{}) { c.sentEvents = append(c.sent
 func (c *fakeClient) ID() uint64                  { return c.id }
 func (c *fakeClient) SetNickname(n string)        { c.nickname = n }
 func (c *fakeClient) Nickname() string            { return c.nickname }
-func (c *fakeClient) CloseConnection()            {}
+func (c *fakeClient) CloseConnection()            { c.closed = true }
+func (c *fakeClient) Kick()                       { c.kicked, c.closed = true, true }
 func (c *fakeClient) GetAdditionalProperties() map[string]interface{} {
 	return c.props
 }
 func (c *fakeClient) SetAdditionalProperties(p map[string]interface{}) { c.props = p }
 
+// manualClock is a Clock that only moves when a test advances it.
+type manualClock struct {
+	t time.Time
+}
+
+func (c *manualClock) Now() time.Time { return c.t }
+
+// testEpoch is where the simulation timeline of test games starts.
+var testEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
 // newTestGame builds a Game with an in-memory broadcast recorder and no map.
 // Tests that exercise the *Unsafe damage/XP helpers don't need a real map, room
-// or main loop. The returned slice pointer collects all broadcast events.
+// or main loop. The returned slice pointer collects all broadcast events. The
+// game's clock is manual and starts at testEpoch; use stepFor to advance it.
 func newTestGame() (*Game, *[]interface{}) {
 	broadcast := &[]interface{}{}
 	g := &Game{
+		seed:               1,
+		rng:                rand.New(rand.NewSource(1)),
 		status:             StatusStarted,
 		players:            make(map[uint64]*Player),
 		monsters:           []*Monster{},
 		objects:            make(map[uint64]*Object),
+		monsterDeaths:      make(map[string]int),
 		traps:              make(map[string]*Trap),
 		keysCollected:      map[string]bool{},
+		floor:              1,
+		floors:             1,
 		broadcastEventFunc: func(event interface{}) { *broadcast = append(*broadcast, event) },
 	}
+	g.UseClock(&manualClock{t: testEpoch})
 
 	return g, broadcast
 }
 
+// stepFor advances the game's manual clock by d, rounded up to whole steps,
+// past the simulation time, and runs the steps it reached one at a time.
+func stepFor(g *Game, d time.Duration) {
+	steps := (d + simulationStep - 1) / simulationStep
+	clock := g.clock.(*manualClock)
+	clock.t = g.simNow.Add(steps * simulationStep)
+	for g.stepDue() {
+		g.step()
+	}
+}
+
 // addTestPlayer registers a player of the given class with full HP and returns it
 // along with its fake client.
 func addTestPlayer(g *Game, id uint64, class string) (*Player, *fakeClient) {
@@ -63,3 +99,23 @@ func addTestPlayer(g *Game, id uint64, class string) (*Player, *fakeClient) {
 
 	return p, client
 }
+
+// newTestMap builds a w x h tile map (16px tiles) with no layers whose blocked
+// grid marks the given tiles as walls. It is enough for collision and
+// pathfinding checks without loading a .tmj file.
+func newTestMap(w, h int, blocked ...Point) *Map {
+	m := &Map{
+		Width:       w,
+		Height:      h,
+		TileWidth:   tileSize,
+		TileHeight:  tileSize,
+		gridWidth:   w,
+		gridHeight:  h,
+		blockedGrid: make([]bool, w*h),
+	}
+	for _, b := range blocked {
+		m.blockedGrid[b.X+b.Y*w] = true
+	}
+
+	return m
+}
@@ -0,0 +1,139 @@
+package game
+
+import (
+	"time"
+
+	"dungeon/internal/lobby"
+)
+
+// Positions and stats are not broadcast: every player is sent only the
+// creatures they can see, so a modified client cannot show the whole map.
+// A player sees what is within viewRange and in line of sight, and cloaked
+// players are hidden from the other team. Spectators see everything.
+//
+// The stats update keeps listing every player, for the player list, but the
+// ones the recipient cannot see are marked hidden and carry no position. Monsters
+// out of sight are left out of it, and the client hides them until they show up
+// again.
+
+// viewRange is how far a player sees, in pixels: the half diagonal of the
+// largest client view.
+const viewRange = 500
+
+// creatureView is what a player is sent of one step's position and stats
+// updates. Either event is nil when there is nothing to send.
+type creatureView struct {
+	client    lobby.ClientPlayer
+	positions *CreaturesPosUpdateEvent
+	stats     *CreaturesStatsUpdateEvent
+}
+
+// creatureViewsUnsafe filters the step's positions and stats, either of which
+// may be nil, down to what each player can see. Caller must hold g.mutex.
+func (g *Game) creatureViewsUnsafe(positions *CreaturesPosUpdateEvent, stats *CreaturesStatsUpdateEvent) []creatureView {
+	if positions == nil && stats == nil {
+		return nil
+	}
+
+	now := g.now()
+	views := make([]creatureView, 0, len(g.players))
+	for _, viewer := range g.players {
+		view := creatureView{client: viewer.client}
+		if positions != nil {
+			view.positions = g.filterPositionsUnsafe(viewer, positions, now)
+		}
+		if stats != nil {
+			view.stats = g.filterStatsUnsafe(viewer, stats, now)
+		}
+		if view.positions != nil || view.stats != nil {
+			views = append(views, view)
+		}
+	}
+
+	return views
+}
+
+// filterPositionsUnsafe returns the part of positions viewer can see, or nil if
+// it is none of it. Caller must hold g.mutex.
+func (g *Game) filterPositionsUnsafe(viewer *Player, positions *CreaturesPosUpdateEvent, now time.Time) *CreaturesPosUpdateEvent {
+	if viewer.isSpectator {
+		return positions
+	}
+
+	out := CreaturesPosUpdateEvent{
+		Players:  make([]PlayerPosition, 0, len(positions.Players)),
+		Monsters: make([]MonsterPosition, 0, len(positions.Monsters)),
+	}
+	for _, p := range positions.Players {
+		if target, ok := g.players[p.ClientID]; ok && g.canSeePlayerUnsafe(viewer, target, now) {
+			out.Players = append(out.Players, p)
+		}
+	}
+	for _, m := range positions.Monsters {
+		if g.canSeeUnsafe(viewer, m.X, m.Y) {
+			out.Monsters = append(out.Monsters, m)
+		}
+	}
+	if len(out.Players) == 0 && len(out.Monsters) == 0 {
+		return nil
+	}
+
+	return &out
+}
+
+// filterStatsUnsafe returns stats as viewer may see them. Caller must hold
+// g.mutex.
+func (g *Game) filterStatsUnsafe(viewer *Player, stats *CreaturesStatsUpdateEvent, now time.Time) *CreaturesStatsUpdateEvent {
+	if viewer.isSpectator {
+		return stats
+	}
+
+	out := CreaturesStatsUpdateEvent{
+		Players:  make([]PlayerStats, 0, len(stats.Players)),
+		Monsters: make([]MonsterStats, 0, len(stats.Monsters)),
+	}
+	for _, p := range stats.Players {
+		if target, ok := g.players[p.ClientID]; !ok || !g.canSeePlayerUnsafe(viewer, target, now) {
+			p.PlayerPosition = PlayerPosition{ClientID: p.ClientID}
+			p.IsInvisible = false
+			p.Hidden = true
+		}
+		out.Players = append(out.Players, p)
+	}
+	for _, m := range stats.Monsters {
+		if g.canSeeUnsafe(viewer, m.X, m.Y) {
+			out.Monsters = append(out.Monsters, m)
+		}
+	}
+
+	return &out
+}
+
+// canSeePlayerUnsafe reports whether viewer may be sent where target is. A
+// cloaked player is seen only by themselves and, if they are a cultist, by the
+// other cultists: a good player seeing only the cloaked allies would learn who
+// the cultists are. Cultists track every other player on their radar. Caller
+// must hold g.mutex.
+func (g *Game) canSeePlayerUnsafe(viewer, target *Player, now time.Time) bool {
+	if viewer == target || viewer.isSpectator {
+		return true
+	}
+	if target.isInvisible(now) {
+		return viewer.isCultist && target.isCultist
+	}
+	if viewer.isCultist {
+		return true
+	}
+
+	return g.canSeeUnsafe(viewer, target.x, target.y)
+}
+
+// canSeeUnsafe reports whether (x, y) is within the view range and line of
+// sight of viewer. Caller must hold g.mutex.
+func (g *Game) canSeeUnsafe(viewer *Player, x, y int) bool {
+	if viewer.isSpectator {
+		return true
+	}
+
+	return getDistance(viewer.x, viewer.y, x, y) <= viewRange && g.isVisible(viewer.x, viewer.y, x, y)
+}
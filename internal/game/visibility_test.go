@@ -0,0 +1,142 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+// newVisibilityGame returns a game whose map has a wall between x 150 and 166,
+// from y 0 to 200, with the viewer at (100, 100) on its left.
+func newVisibilityGame() (*Game, *Player, *fakeClient) {
+	g, _ := newTestGame()
+	g.gameMap = newTestMap(80, 80)
+	g.gameMap.visibilityColliders = []Rectangle{{X: 150, Y: 0, Width: 16, Height: 200}}
+	viewer, client := addTestPlayer(g, 1, ClassKnight)
+	viewer.x, viewer.y = 100, 100
+
+	return g, viewer, client
+}
+
+// lastStats returns the last stats update sent to client.
+func lastStats(t *testing.T, client *fakeClient) CreaturesStatsUpdateEvent {
+	t.Helper()
+	for i := len(client.sentEvents) - 1; i >= 0; i-- {
+		if stats, ok := client.sentEvents[i].(CreaturesStatsUpdateEvent); ok {
+			return stats
+		}
+	}
+	t.Fatal("no stats update sent")
+
+	return CreaturesStatsUpdateEvent{}
+}
+
+func hiddenPlayers(stats CreaturesStatsUpdateEvent) map[uint64]bool {
+	hidden := make(map[uint64]bool)
+	for _, p := range stats.Players {
+		hidden[p.ClientID] = p.Hidden
+	}
+
+	return hidden
+}
+
+func TestStatsOnlyShowWhatThePlayerSees(t *testing.T) {
+	g, _, client := newVisibilityGame()
+	near, _ := addTestPlayer(g, 2, ClassMage)
+	near.x, near.y = 130, 100
+	walled, _ := addTestPlayer(g, 3, ClassMage)
+	walled.x, walled.y = 200, 100
+	far, _ := addTestPlayer(g, 4, ClassMage)
+	far.x, far.y = 100, 100+viewRange+50
+	g.monsters = append(g.monsters,
+		&Monster{id: 1, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 120, y: 140},
+		&Monster{id: 2, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 220, y: 140},
+		&Monster{id: 3, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 60, y: 100 + viewRange + 50},
+	)
+
+	stepFor(g, time.Second)
+
+	stats := lastStats(t, client)
+	hidden := hiddenPlayers(stats)
+	if len(hidden) != 4 || hidden[1] || hidden[2] || !hidden[3] || !hidden[4] {
+		t.Errorf("hidden players %v, want all 4 listed and 3 and 4 hidden", hidden)
+	}
+	for _, p := range stats.Players {
+		if p.Hidden && (p.X != 0 || p.Y != 0) {
+			t.Errorf("hidden player %d sent at (%d, %d)", p.ClientID, p.X, p.Y)
+		}
+	}
+	if len(stats.Monsters) != 1 || stats.Monsters[0].ID != 1 {
+		t.Errorf("sent monsters %+v, want the one in sight only", stats.Monsters)
+	}
+}
+
+func TestPositionsOnlyShowWhatThePlayerSees(t *testing.T) {
+	g, _, client := newVisibilityGame()
+	walled, _ := addTestPlayer(g, 2, ClassMage)
+	walled.x, walled.y = 200, 100
+	walled.isMoving = true
+
+	g.step()
+
+	for _, ev := range client.sentEvents {
+		if _, ok := ev.(CreaturesPosUpdateEvent); ok {
+			t.Fatalf("positions %+v sent of a player behind a wall", ev)
+		}
+	}
+}
+
+func TestCloakedPlayersAreHiddenFromTheOtherTeam(t *testing.T) {
+	g, viewer, client := newVisibilityGame()
+	cloaked, cloakedClient := addTestPlayer(g, 2, ClassMage)
+	cloaked.x, cloaked.y = 120, 100
+	cloaked.invisibleUntil = testEpoch.Add(time.Hour)
+
+	stepFor(g, time.Second)
+	if !hiddenPlayers(lastStats(t, client))[2] {
+		t.Error("a cloaked player is shown to a player next to them")
+	}
+	if hiddenPlayers(lastStats(t, cloakedClient))[2] {
+		t.Error("a cloaked player is hidden from themselves")
+	}
+
+	viewer.isCultist = true
+	stepFor(g, time.Second)
+	if !hiddenPlayers(lastStats(t, client))[2] {
+		t.Error("a cloaked good player is shown to a cultist")
+	}
+
+	cloaked.isCultist = true
+	stepFor(g, time.Second)
+	if hiddenPlayers(lastStats(t, client))[2] {
+		t.Error("a cloaked cultist is hidden from another cultist")
+	}
+}
+
+func TestCultistsTrackPlayersOutOfSight(t *testing.T) {
+	g, viewer, client := newVisibilityGame()
+	viewer.isCultist = true
+	walled, _ := addTestPlayer(g, 2, ClassMage)
+	walled.x, walled.y = 200, 100+viewRange
+
+	stepFor(g, time.Second)
+
+	if hiddenPlayers(lastStats(t, client))[2] {
+		t.Error("a player out of sight is missing from the cultist radar")
+	}
+}
+
+func TestSpectatorsSeeEverything(t *testing.T) {
+	g, viewer, client := newVisibilityGame()
+	viewer.isSpectator = true
+	cloaked, _ := addTestPlayer(g, 2, ClassMage)
+	cloaked.x, cloaked.y = 200, 100
+	cloaked.invisibleUntil = testEpoch.Add(time.Hour)
+	g.monsters = append(g.monsters, &Monster{id: 1, kind: monsterKindSkeleton, hp: 100, maxHP: 100, x: 220, y: 140})
+
+	stepFor(g, time.Second)
+
+	stats := lastStats(t, client)
+	if len(stats.Players) != 1 || stats.Players[0].Hidden || stats.Players[0].X != 200 || len(stats.Monsters) != 1 {
+		t.Errorf("spectator sent %+v, want the cloaked player and the monster behind the wall", stats)
+	}
+}
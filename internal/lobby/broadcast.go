@@ -1,22 +1,36 @@
 package lobby
 
-// eventEncoder serializes an event to its wire bytes. It is set once by the
-// transport layer at startup (see transport.init) so the lobby can pre-serialize
-// a broadcast a single time instead of once per recipient — without importing
-// transport, which would create an import cycle.
-var eventEncoder func(event interface{}) ([]byte, error)
+// eventEncoders serialize an event to its wire bytes, one per encoding. They
+// are set once by the transport layer at startup (see transport.init) so the
+// lobby can pre-serialize a broadcast a single time per encoding instead of
+// once per recipient — without importing transport, which would create an
+// import cycle. They run in the order they were set, so an encoding can be
+// derived from the bytes of one set before it.
+var eventEncoders []eventEncoder
 
-// SetEventEncoder registers the wire serializer used to pre-encode broadcasts.
-func SetEventEncoder(enc func(event interface{}) ([]byte, error)) {
-	eventEncoder = enc
+type eventEncoder struct {
+	encoding string
+	encode   EventEncoder
+}
+
+// EventEncoder serializes event. encoded holds the bytes of event in the
+// encodings set before this one, by encoding.
+type EventEncoder func(event interface{}, encoded map[string][]byte) ([]byte, error)
+
+// SetEventEncoder registers the wire serializer of encoding used to pre-encode
+// broadcasts.
+func SetEventEncoder(encoding string, enc EventEncoder) {
+	eventEncoders = append(eventEncoders, eventEncoder{encoding, enc})
 }
 
 // PreEncodedEvent carries an event together with its already-serialized wire
 // bytes. broadcastEvent wraps an event in this once and passes it to every
-// recipient: transport clients write Data directly (skipping a redundant
-// per-client marshal), while non-transport clients such as bots unwrap Event to
-// get the original object.
+// recipient: transport clients write the Data of their encoding directly
+// (skipping a redundant per-client marshal), while non-transport clients such as
+// bots unwrap Event to get the original object.
 type PreEncodedEvent struct {
 	Event interface{}
-	Data  []byte
+	// Data holds the wire bytes of Event by encoding. An encoding that failed to
+	// serialize it is missing.
+	Data map[string][]byte
 }
@@ -1,5 +1,7 @@
 package lobby
 
+import "sync/atomic"
+
 // ClientSender represents interface which sends events to connected players.
 type ClientSender interface {
 	SendEvent(event interface{})
@@ -26,6 +28,9 @@ type Client struct {
 	nickname             string
 	room                 *Room
 	additionalProperties map[string]interface{}
+
+	// kicked is set when the game disconnects the client for abuse, see Kick.
+	kicked atomic.Bool
 }
 
 func (c *Client) SendEvent(event interface{}) {
@@ -49,6 +54,13 @@ func (c *Client) CloseConnection() {
 	c.transportClient.Close()
 }
 
+// Kick closes the connection of a client that abused the game. Unlike after a
+// lost connection, its session is ended instead of kept to be resumed.
+func (c *Client) Kick() {
+	c.kicked.Store(true)
+	c.transportClient.Close()
+}
+
 func (c *Client) SetAdditionalProperties(properties map[string]interface{}) {
 	c.additionalProperties = properties
 }
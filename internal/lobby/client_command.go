@@ -13,6 +13,8 @@ const (
 	ClientCommandLobbySubTypeJoinRoom = "joinRoom"
 	// ClientCommandLobbySubTypeMakeMatch find an opponent and play the game
 	ClientCommandLobbySubTypeMakeMatch = "makeMatch"
+	// ClientCommandLobbySubTypeResume resume the session of a dropped connection
+	ClientCommandLobbySubTypeResume = "resume"
 
 	// ClientCommandTypeGame namespace for commands about a game
 	ClientCommandTypeGame = "game"
@@ -37,6 +39,8 @@ const (
 	ClientCommandRoomSubTypeStartGame = "startGame"
 	// ClientCommandRoomSubTypeDeleteGame command to delete the game in the room
 	ClientCommandRoomSubTypeDeleteGame = "deleteGame"
+	// ClientCommandRoomSubTypeSetMap command to choose the map of the room's matches
+	ClientCommandRoomSubTypeSetMap = "setMap"
 	// ClientCommandRoomSubTypeAddBot command to add a bot to the game
 	ClientCommandRoomSubTypeAddBot = "addBot"
 	// ClientCommandRoomSubTypeRemoveBots command to remove all bots from the game
@@ -9,6 +9,10 @@ const (
 	errorCantChangeStatusGameHasBeenStarted = "cant_change_status_game_has_been_started"
 	errorYouShouldBeOwner                   = "you_should_be_owner"
 	errorGameAlreadyDeleted                 = "game_already_deleted"
+	errorInvalidBotSettings                 = "invalid_bot_settings"
+	errorInvalidMapSettings                 = "invalid_map_settings"
+	errorCannotCreateGame                   = "cannot_create_game"
+	errorSessionExpired                     = "session_expired"
 )
 
 // ClientCommandError contains info about error on client's command.
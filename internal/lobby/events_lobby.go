@@ -21,6 +21,11 @@ type ClientJoinedEvent struct {
 	YourNickname string          `json:"yourNickname"`
 	Clients      []*ClientInList `json:"clients"`
 	Rooms        []*RoomInList   `json:"roomsCreatedByClients"`
+	// SessionToken resumes the client's session from a new connection if this
+	// one drops.
+	SessionToken string `json:"sessionToken"`
+	// Resumed is set when the client resumed its session rather than joined.
+	Resumed bool `json:"resumed,omitempty"`
 }
 
 // ClientLeftEvent contains id of client who left lobby.
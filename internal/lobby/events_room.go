@@ -7,8 +7,27 @@ type RoomMemberInfo struct {
 	WantsToPlay bool   `json:"wantsToPlay"`
 	IsPlayer    bool   `json:"isPlayer"`
 	IsBot       bool   `json:"isBot"`
+	// BotDifficulty and BotClass are the settings a bot was added with. The
+	// class is empty when the game picks it.
+	BotDifficulty string `json:"botDifficulty,omitempty"`
+	BotClass      string `json:"botClass,omitempty"`
 }
 
+// RoomMapInfo contains the map settings of a room. The seed is a decimal
+// string, which JavaScript clients cannot round, or MapSeedRandom.
+type RoomMapInfo struct {
+	Template       string   `json:"template"`
+	Rooms          int      `json:"rooms"`
+	Layout         string   `json:"layout"`
+	Biomes         []string `json:"biomes"`
+	BiomesPerFloor bool     `json:"biomesPerFloor"`
+	Seed           string   `json:"seed"`
+	Difficulty     string   `json:"difficulty"`
+}
+
+// MapSeedRandom is the seed of a room whose every match has a new dungeon.
+const MapSeedRandom = "random"
+
 // RoomInfo contains info about room where client is.
 type RoomInfo struct {
 	Id         uint64            `json:"id"`
@@ -17,6 +36,7 @@ type RoomInfo struct {
 	GameStatus string            `json:"gameStatus"`
 	Members    []*RoomMemberInfo `json:"members"`
 	MaxPlayers int               `json:"maxPlayers"`
+	Map        RoomMapInfo       `json:"map"`
 }
 
 // RoomJoinedEvent contains info about room where client is
@@ -46,6 +66,29 @@ type RoomMemberChangedPlayerStatusEvent struct {
 	Room *RoomMemberInfo `json:"member"`
 }
 
+// RoomAddBotCommandData represents data from room owner to add a bot. Both
+// fields are optional: the difficulty defaults to normal and the game picks a
+// class if none is given.
+type RoomAddBotCommandData struct {
+	Difficulty string `json:"difficulty"`
+	Class      string `json:"class"`
+}
+
+// RoomSetMapCommandData represents data from room owner to choose the map of the
+// room's matches. Layout is "ladder" or "tree"; empty means the ladder. Biomes
+// are other templates mixed in, or with BiomesPerFloor taking turns floor by
+// floor. Seed is a decimal string or MapSeedRandom; empty means random.
+// Difficulty is "easy", "normal" or "hard"; empty means normal.
+type RoomSetMapCommandData struct {
+	Template       string   `json:"template"`
+	Rooms          int      `json:"rooms"`
+	Layout         string   `json:"layout"`
+	Biomes         []string `json:"biomes"`
+	BiomesPerFloor bool     `json:"biomesPerFloor"`
+	Seed           string   `json:"seed"`
+	Difficulty     string   `json:"difficulty"`
+}
+
 // RoomSetPlayerStatusCommandData represents data from room owner to set or unset player status of a member
 type RoomSetPlayerStatusCommandData struct {
 	MemberId uint64 `json:"memberId"`
@@ -58,3 +101,4 @@ const RoomUpdatedCauseClientRemoved = "clientRemoved"
 const RoomUpdatedCauseGameStarted = "gameStarted"
 const RoomUpdatedCauseGameDeleted = "gameDeleted"
 const RoomUpdatedCauseGameEnded = "gameEnded"
+const RoomUpdatedCauseMapChanged = "mapChanged"
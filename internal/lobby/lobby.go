@@ -15,13 +15,99 @@ type GameEventsDispatcher interface {
 	DispatchGameCommand(client ClientPlayer, eventName string, eventData interface{})
 	OnClientRemoved(client ClientPlayer)
 	OnClientJoined(client ClientPlayer)
+	// OnClientDisconnected is called when a player lost its connection. The
+	// player is kept until OnClientReconnected or OnClientRemoved.
+	OnClientDisconnected(client ClientPlayer)
+	// OnClientReconnected is called when a disconnected player resumed its
+	// session, to send it the full state of the game.
+	OnClientReconnected(client ClientPlayer)
 	StartMainLoop()
+	// Stop ends the game, if it still runs, when it is deleted.
+	Stop()
 	Status() string
 	GetCommonInitialGameData() map[string]interface{}
 }
 
-type NewGameFunc func(playersClients []ClientPlayer, room *Room, broadcastEventFunc func(event interface{})) GameEventsDispatcher
-type NewBotFunc func(botId uint64, room *Room, sendGameEvent func(client ClientPlayer, eventName string, eventData json.RawMessage)) ClientPlayer
+// NewGameFunc creates a match played on the map chosen by mapSettings, or
+// returns nil if the game cannot create one.
+type NewGameFunc func(playersClients []ClientPlayer, room *Room, mapSettings MapSettings, broadcastEventFunc func(event interface{})) GameEventsDispatcher
+
+// NewBotFunc creates a bot, or returns nil if the game cannot create one with
+// the given settings.
+type NewBotFunc func(botId uint64, room *Room, settings BotSettings, sendGameEvent func(client ClientPlayer, eventName string, eventData json.RawMessage)) ClientPlayer
+
+// Bot difficulties a room owner can choose from.
+const (
+	BotDifficultyEasy   = "easy"
+	BotDifficultyNormal = "normal"
+	BotDifficultyHard   = "hard"
+)
+
+// BotSettings are what a bot is added to a room with.
+type BotSettings struct {
+	Difficulty string
+	// Class is the class the bot plays, or empty to let the game pick one.
+	Class string
+}
+
+func isValidBotDifficulty(difficulty string) bool {
+	switch difficulty {
+	case BotDifficultyEasy, BotDifficultyNormal, BotDifficultyHard:
+		return true
+	}
+
+	return false
+}
+
+// Monster difficulties a room owner can choose from.
+const (
+	MonsterDifficultyEasy   = "easy"
+	MonsterDifficultyNormal = "normal"
+	MonsterDifficultyHard   = "hard"
+)
+
+// IsValidMonsterDifficulty reports whether difficulty is one of the
+// MonsterDifficulty* constants.
+func IsValidMonsterDifficulty(difficulty string) bool {
+	switch difficulty {
+	case MonsterDifficultyEasy, MonsterDifficultyNormal, MonsterDifficultyHard:
+		return true
+	}
+
+	return false
+}
+
+// MapSettings choose the dungeon of a room's matches.
+type MapSettings struct {
+	// Template names the map template.
+	Template string
+	// Rooms is how many rooms are assembled from the template, or 0 to play the
+	// template as it is.
+	Rooms int
+	// Layout names how the rooms are laid out, empty for the default ladder.
+	Layout string
+	// Biomes names other map templates whose rooms and corridors are mixed in
+	// with the template's, so the dungeon changes theme along its length.
+	Biomes []string
+	// BiomesPerFloor generates each floor from one of the template and the
+	// biomes in turn instead of mixing them.
+	BiomesPerFloor bool
+	// Seed is the seed of the map generation, or 0 for a new dungeon every match.
+	Seed int64
+	// Difficulty sets how many and how tough the monsters are, for the number
+	// of players.
+	Difficulty string
+}
+
+// CheckMapSettingsFunc returns an error if matches cannot be played with
+// settings. It runs on the lobby goroutine, so it must be cheap.
+type CheckMapSettingsFunc func(settings MapSettings) error
+
+// PrepareMapFunc does the slow checks of settings that passed the
+// CheckMapSettingsFunc, like generating the dungeon of a seed ahead of the
+// match, and returns an error if matches cannot be played with them. It runs on
+// its own goroutine.
+type PrepareMapFunc func(settings MapSettings) error
 
 type MatchMakerSettings map[string]interface{}
 
@@ -35,13 +121,16 @@ type MatchMaker interface {
 	OnRoomRemoved(room *Room)
 }
 
+// resyncQueueSize is how many resync requests may wait for the lobby.
+const resyncQueueSize = 64
+
 // Lobby is the first place for connected clients. It passes commands to games.
 type Lobby struct {
 	// Registered clients.
 	clients map[uint64]ClientPlayer
 
 	// Outbound events to the clients.
-	broadcast chan interface{}
+	broadcast chan lobbyBroadcast
 
 	// Register requests from the clients.
 	register chan ClientSender
@@ -49,6 +138,9 @@ type Lobby struct {
 	// Unregister requests from clients.
 	unregister chan ClientSender
 
+	// Resync requests from clients that fell behind, see ResyncTransportClient.
+	resync chan ClientSender
+
 	// Commands from clients
 	clientCommands chan *ClientCommand
 
@@ -63,17 +155,36 @@ type Lobby struct {
 	matchMaker       MatchMaker
 	minPlayersInRoom int
 	maxPlayersInRoom int
+
+	// Rooms start with defaultMapSettings; checkMapSettings and prepareMap
+	// validate what room owners choose instead. See UseMaps.
+	defaultMapSettings MapSettings
+	checkMapSettings   CheckMapSettingsFunc
+	prepareMap         PrepareMapFunc
+
+	// Results of prepareMap, see Room.onSetMapCommand.
+	mapPrepared chan mapPreparation
+
+	// Sessions of the clients by token and by client ID, see session.go.
+	sessions         map[string]*session
+	sessionsByClient map[uint64]*session
+	sessionExpired   chan sessionExpiry
 }
 
 func NewLobby(newGameFunc NewGameFunc, newBotFunc NewBotFunc, matchMaker MatchMaker, minPlayersInRoom int, maxPlayersInRoom int) *Lobby {
 	return &Lobby{
-		broadcast:             make(chan interface{}),
+		broadcast:             make(chan lobbyBroadcast),
 		register:              make(chan ClientSender),
 		unregister:            make(chan ClientSender),
+		resync:                make(chan ClientSender, resyncQueueSize),
 		clients:               make(map[uint64]ClientPlayer),
 		clientCommands:        make(chan *ClientCommand),
 		roomsCreatedByClients: make(map[ClientPlayer]*Room),
 		clientsJoinedRooms:    make(map[ClientPlayer]*Room),
+		sessions:              make(map[string]*session),
+		sessionsByClient:      make(map[uint64]*session),
+		sessionExpired:        make(chan sessionExpiry),
+		mapPrepared:           make(chan mapPreparation),
 		newGameFunc:           newGameFunc,
 		newBotFunc:            newBotFunc,
 		matchMaker:            matchMaker,
@@ -82,18 +193,28 @@ func NewLobby(newGameFunc NewGameFunc, newBotFunc NewBotFunc, matchMaker MatchMa
 	}
 }
 
+// UseMaps lets room owners choose the map of their room's matches. Rooms start
+// with defaults, check validates the owners' choices and prepare, if not nil,
+// finishes validating them in the background. It must be called before Run;
+// without it every match is played on the game's default map.
+func (l *Lobby) UseMaps(defaults MapSettings, check CheckMapSettingsFunc, prepare PrepareMapFunc) {
+	l.defaultMapSettings = defaults
+	l.checkMapSettings = check
+	l.prepareMap = prepare
+}
+
 func (l *Lobby) Run() {
 	log.Println("Go lobby")
 
 	go func() {
 		for {
 			select {
-			case event, ok := <-l.broadcast:
+			case broadcast, ok := <-l.broadcast:
 				if !ok {
 					continue
 				}
-				for _, client := range l.clients {
-					client.SendEvent(event)
+				for _, client := range broadcast.clients {
+					client.SendEvent(broadcast.event)
 				}
 			}
 		}
@@ -111,30 +232,54 @@ func (l *Lobby) Run() {
 			)
 		case tc := <-l.register:
 			log.Println("read from register channel")
-			atomic.AddUint64(&lastClientId, 1)
-			lastClientIdSafe := atomic.LoadUint64(&lastClientId)
-			tc.SetID(lastClientIdSafe)
-
-			client := &Client{
-				lobby:           l,
-				transportClient: tc,
-			}
-			l.clients[client.ID()] = client
+			l.registerClient(tc)
 			log.Println("processed register channel")
 		case tc := <-l.unregister:
 			log.Println("read from unregister channel")
-			if client, ok := l.clients[tc.ID()]; ok {
-				client.CloseConnection()
-				delete(l.clients, client.ID())
-				l.onClientLeft(client)
-			}
+			l.unregisterClient(tc)
 			log.Println("processed unregister channel")
+		case tc := <-l.resync:
+			l.resyncClient(tc)
+		case expiry := <-l.sessionExpired:
+			l.expireSession(expiry)
+		case prepared := <-l.mapPrepared:
+			prepared.room.onMapPrepared(prepared)
 		case clientCommand := <-l.clientCommands:
 			l.onClientCommand(clientCommand)
 		}
 	}
 }
 
+func (l *Lobby) registerClient(tc ClientSender) {
+	atomic.AddUint64(&lastClientId, 1)
+	lastClientIdSafe := atomic.LoadUint64(&lastClientId)
+	tc.SetID(lastClientIdSafe)
+
+	client := &Client{
+		lobby:           l,
+		transportClient: tc,
+	}
+	l.clients[client.ID()] = client
+}
+
+func (l *Lobby) unregisterClient(tc ClientSender) {
+	client, ok := l.clients[tc.ID()]
+	if !ok {
+		return
+	}
+	// The connection of a session resumed elsewhere is already replaced.
+	if c, ok := client.(*Client); ok && c.transportClient != tc {
+		return
+	}
+	client.CloseConnection()
+	if l.keepSession(client) {
+		return
+	}
+	delete(l.clients, client.ID())
+	l.endSession(client)
+	l.onClientLeft(client)
+}
+
 func (l *Lobby) RegisterTransportClient(tc ClientSender) {
 	log.Println("Register transport client")
 	l.register <- tc
@@ -147,6 +292,40 @@ func (l *Lobby) UnregisterTransportClient(tc ClientSender) {
 	log.Println("Unregistered transport client")
 }
 
+// ResyncTransportClient asks for a fresh snapshot of its match to be sent to a
+// client whose connection fell behind and lost events. It is called from the
+// client's write loop, which must not wait for the lobby, so it reports false
+// instead when the lobby has resyncQueueSize requests waiting already.
+func (l *Lobby) ResyncTransportClient(tc ClientSender) bool {
+	select {
+	case l.resync <- tc:
+		return true
+	default:
+		return false
+	}
+}
+
+// resyncClient sends the client of tc, which lost events, the full state of its
+// match, as to a client that resumed its session. A client not playing a match
+// is disconnected to start over.
+func (l *Lobby) resyncClient(tc ClientSender) {
+	client, ok := l.clients[tc.ID()]
+	if !ok {
+		return
+	}
+	if c, ok := client.(*Client); ok && c.transportClient != tc {
+		return
+	}
+	room := l.clientsJoinedRooms[client]
+	if room == nil || room.game == nil {
+		log.Printf("client %d fell behind outside a match, disconnecting\n", client.ID())
+		tc.Close()
+		return
+	}
+	log.Printf("client %d fell behind, resyncing its match\n", client.ID())
+	room.game.OnClientReconnected(client)
+}
+
 func (l *Lobby) HandleClientCommand(tc ClientSender, clientCommand *ClientCommand) {
 	if client, ok := l.clients[tc.ID()]; ok {
 		clientCommand.client = client
@@ -154,8 +333,19 @@ func (l *Lobby) HandleClientCommand(tc ClientSender, clientCommand *ClientComman
 	}
 }
 
+// lobbyBroadcast is an event and the clients registered when it was broadcast.
+// The clients are listed by the lobby goroutine, the only one using l.clients.
+type lobbyBroadcast struct {
+	event   interface{}
+	clients []ClientPlayer
+}
+
 func (l *Lobby) broadcastEvent(event interface{}) {
-	l.broadcast <- event
+	clients := make([]ClientPlayer, 0, len(l.clients))
+	for _, client := range l.clients {
+		clients = append(clients, client)
+	}
+	l.broadcast <- lobbyBroadcast{event: event, clients: clients}
 }
 
 func (l *Lobby) joinLobbyCommand(c ClientPlayer, nickname string) {
@@ -168,6 +358,11 @@ func (l *Lobby) joinLobbyCommand(c ClientPlayer, nickname string) {
 	}
 	l.broadcastEvent(broadcastEvent)
 
+	l.sendClientJoined(c, false)
+}
+
+// sendClientJoined sends c the lobby it joined, or resumed its session in.
+func (l *Lobby) sendClientJoined(c ClientPlayer, resumed bool) {
 	clientsInList := make([]*ClientInList, 0)
 	for _, client := range l.clients {
 		clientInList := &ClientInList{
@@ -188,6 +383,8 @@ func (l *Lobby) joinLobbyCommand(c ClientPlayer, nickname string) {
 		YourNickname: c.Nickname(),
 		Clients:      clientsInList,
 		Rooms:        roomsInList,
+		SessionToken: l.sessionToken(c),
+		Resumed:      resumed,
 	}
 	c.SendEvent(event)
 }
@@ -314,6 +511,12 @@ func (l *Lobby) onClientCommand(cc *ClientCommand) {
 				return
 			}
 			l.makeMatch(cc.client, mmSettings)
+		} else if cc.SubType == ClientCommandLobbySubTypeResume {
+			var token string
+			if err := json.Unmarshal(cc.Data, &token); err != nil {
+				return
+			}
+			l.resumeSessionCommand(cc.client, token)
 		}
 		log.Println("lobby command processed", cc.SubType)
 	} else if cc.Type == ClientCommandTypeRoom {
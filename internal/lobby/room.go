@@ -3,6 +3,7 @@ package lobby
 import (
 	"encoding/json"
 	"log"
+	"strconv"
 	"sync"
 	"sync/atomic"
 )
@@ -13,6 +14,7 @@ type RoomMember struct {
 	wantsToPlay bool
 	isPlayer    bool
 	isBot       bool
+	botSettings BotSettings
 }
 
 // Room represents place where some of the members want to start a new game.
@@ -23,6 +25,23 @@ type Room struct {
 	game        GameEventsDispatcher
 	lobby       *Lobby
 	membersLock sync.RWMutex
+	mapSettings MapSettings
+
+	// mapChoices counts the maps the owner chose, so that only the latest
+	// choice takes effect. Maps are prepared before they are set, one at a time
+	// per room: mapPreparing is set meanwhile and mapQueued holds the latest
+	// choice waiting for its turn.
+	mapChoices   uint64
+	mapPreparing bool
+	mapQueued    *mapPreparation
+}
+
+// mapPreparation is a map chosen by a room owner that is being generated.
+type mapPreparation struct {
+	room     *Room
+	settings MapSettings
+	choice   uint64
+	err      error
 }
 
 func newRoom(roomId uint64, owner ClientPlayer, lobby *Lobby) *Room {
@@ -30,14 +49,20 @@ func newRoom(roomId uint64, owner ClientPlayer, lobby *Lobby) *Room {
 	ownerInRoom := newRoomMember(owner, false)
 	ownerInRoom.isPlayer = true
 	members[ownerInRoom] = true
-	room := &Room{roomId, ownerInRoom, members, nil, lobby, sync.RWMutex{}}
+	room := &Room{
+		id:          roomId,
+		owner:       ownerInRoom,
+		members:     members,
+		lobby:       lobby,
+		mapSettings: lobby.defaultMapSettings,
+	}
 	lobby.clientsJoinedRooms[owner] = room
 
 	return room
 }
 
 func newRoomMember(client ClientPlayer, isBot bool) *RoomMember {
-	return &RoomMember{client, true, false, isBot}
+	return &RoomMember{client: client, wantsToPlay: true, isBot: isBot}
 }
 
 // Name returns name of the room by its owner.
@@ -117,8 +142,9 @@ func (r *Room) addClient(client ClientPlayer) {
 	client.SendEvent(roomJoinedEvent)
 }
 
-func (r *Room) addBot(botClient ClientPlayer) {
+func (r *Room) addBot(botClient ClientPlayer, settings BotSettings) {
 	member := newRoomMember(botClient, true)
+	member.botSettings = settings
 	r.members[member] = true
 	member.isPlayer = true
 
@@ -133,12 +159,16 @@ func (r *Room) broadcastEvent(event interface{}, exceptClient ClientPlayer) {
 	r.membersLock.RLock()
 	defer r.membersLock.RUnlock()
 
-	// Serialize the payload at most once and reuse it for every recipient,
-	// instead of re-marshaling the same event per client in SendEvent.
-	if eventEncoder != nil {
-		if data, err := eventEncoder(event); err == nil {
-			event = &PreEncodedEvent{Event: event, Data: data}
+	// Serialize the payload at most once per encoding and reuse it for every
+	// recipient, instead of re-marshaling the same event per client in SendEvent.
+	if len(eventEncoders) > 0 {
+		pre := &PreEncodedEvent{Event: event, Data: make(map[string][]byte, len(eventEncoders))}
+		for _, enc := range eventEncoders {
+			if data, err := enc.encode(event, pre.Data); err == nil {
+				pre.Data[enc.encoding] = data
+			}
 		}
+		event = pre
 	}
 
 	for m := range r.members {
@@ -272,9 +302,15 @@ func (r *Room) OnStartGameCommand(c ClientPlayer) {
 		return
 	}
 
-	r.game = r.lobby.newGameFunc([]ClientPlayer{c}, r, func(event interface{}) {
+	game := r.lobby.newGameFunc([]ClientPlayer{c}, r, r.mapSettings, func(event interface{}) {
 		r.broadcastEvent(event, nil)
 	})
+	if game == nil {
+		errEvent := &ClientCommandError{errorCannotCreateGame}
+		c.SendEvent(errEvent)
+		return
+	}
+	r.game = game
 	go r.game.StartMainLoop()
 
 	roomUpdatedEvent := &RoomUpdatedEvent{r.toRoomInfo(), RoomUpdatedCauseGameStarted}
@@ -286,6 +322,102 @@ func (r *Room) OnStartGameCommand(c ClientPlayer) {
 	r.lobby.sendRoomUpdate(r)
 }
 
+func (r *Room) onSetMapCommand(c ClientPlayer, settings MapSettings) {
+	if r.owner.client.ID() != c.ID() {
+		errEvent := &ClientCommandError{errorYouShouldBeOwner}
+		c.SendEvent(errEvent)
+		return
+	}
+	if r.game != nil {
+		errEvent := &ClientCommandError{errorGameHasBeenAlreadyStarted}
+		c.SendEvent(errEvent)
+		return
+	}
+	if settings.Difficulty == "" {
+		settings.Difficulty = MonsterDifficultyNormal
+	}
+	if !IsValidMonsterDifficulty(settings.Difficulty) || r.lobby.checkMapSettings == nil {
+		errEvent := &ClientCommandError{errorInvalidMapSettings}
+		c.SendEvent(errEvent)
+		return
+	}
+	if err := r.lobby.checkMapSettings(settings); err != nil {
+		log.Printf("room %d: invalid map settings %+v: %v\n", r.ID(), settings, err)
+		errEvent := &ClientCommandError{errorInvalidMapSettings}
+		c.SendEvent(errEvent)
+		return
+	}
+
+	r.mapChoices++
+	r.mapQueued = nil
+	if r.lobby.prepareMap == nil {
+		r.setMapSettings(settings)
+		return
+	}
+
+	// Preparing the map (generating it, for a seed) takes long: it is done off
+	// the lobby goroutine, and the map is set once it turns out to be playable.
+	preparation := &mapPreparation{room: r, settings: settings, choice: r.mapChoices}
+	if r.mapPreparing {
+		r.mapQueued = preparation
+		return
+	}
+	r.startMapPreparation(preparation)
+}
+
+func (r *Room) startMapPreparation(p *mapPreparation) {
+	r.mapPreparing = true
+	prepare, prepared := r.lobby.prepareMap, r.lobby.mapPrepared
+	go func() {
+		p.err = prepare(p.settings)
+		prepared <- *p
+	}()
+}
+
+// onMapPrepared sets the prepared map, if it is still the owner's latest
+// choice, and starts generating the next one waiting.
+func (r *Room) onMapPrepared(p mapPreparation) {
+	r.mapPreparing = false
+	if p.choice == r.mapChoices && r.game == nil {
+		if p.err != nil {
+			log.Printf("room %d: invalid map settings %+v: %v\n", r.ID(), p.settings, p.err)
+			errEvent := &ClientCommandError{errorInvalidMapSettings}
+			r.owner.client.SendEvent(errEvent)
+		} else {
+			r.setMapSettings(p.settings)
+		}
+	}
+
+	if queued := r.mapQueued; queued != nil {
+		r.mapQueued = nil
+		r.startMapPreparation(queued)
+	}
+}
+
+func (r *Room) setMapSettings(settings MapSettings) {
+	r.mapSettings = settings
+
+	roomUpdatedEvent := &RoomUpdatedEvent{r.toRoomInfo(), RoomUpdatedCauseMapChanged}
+	r.broadcastEvent(roomUpdatedEvent, nil)
+}
+
+// parseMapSeed parses the seed of a RoomSetMapCommandData.
+func parseMapSeed(seed string) (int64, error) {
+	if seed == "" || seed == MapSeedRandom {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(seed, 10, 64)
+}
+
+func formatMapSeed(seed int64) string {
+	if seed == 0 {
+		return MapSeedRandom
+	}
+
+	return strconv.FormatInt(seed, 10)
+}
+
 func (r *Room) onDeleteGameCommand(c ClientPlayer) {
 	if r.owner.client.ID() != c.ID() {
 		errEvent := &ClientCommandError{errorYouShouldBeOwner}
@@ -298,6 +430,7 @@ func (r *Room) onDeleteGameCommand(c ClientPlayer) {
 		return
 	}
 
+	r.game.Stop()
 	r.game = nil
 
 	roomUpdatedEvent := &RoomUpdatedEvent{r.toRoomInfo(), RoomUpdatedCauseGameDeleted}
@@ -306,7 +439,7 @@ func (r *Room) onDeleteGameCommand(c ClientPlayer) {
 	r.lobby.sendRoomUpdate(r)
 }
 
-func (r *Room) onAddBotCommand(c ClientPlayer) {
+func (r *Room) onAddBotCommand(c ClientPlayer, settings BotSettings) {
 	if r.owner.client.ID() != c.ID() {
 		errEvent := &ClientCommandError{errorYouShouldBeOwner}
 		c.SendEvent(errEvent)
@@ -322,21 +455,31 @@ func (r *Room) onAddBotCommand(c ClientPlayer) {
 		c.SendEvent(errEvent)
 		return
 	}
-
-	r.CreateBot()
+	if settings.Difficulty == "" {
+		settings.Difficulty = BotDifficultyNormal
+	}
+	if !isValidBotDifficulty(settings.Difficulty) || r.CreateBot(settings) == nil {
+		errEvent := &ClientCommandError{errorInvalidBotSettings}
+		c.SendEvent(errEvent)
+	}
 }
 
-func (r *Room) CreateBot() ClientPlayer {
+// CreateBot adds a bot to the room. It returns nil if the game cannot create a
+// bot with settings.
+func (r *Room) CreateBot(settings BotSettings) ClientPlayer {
 	atomic.AddUint64(&lastClientId, 1)
 	lastBotIdSafe := atomic.LoadUint64(&lastClientId)
-	clientPlayer := r.lobby.newBotFunc(lastBotIdSafe, r, func(client ClientPlayer, eventName string, eventData json.RawMessage) {
+	clientPlayer := r.lobby.newBotFunc(lastBotIdSafe, r, settings, func(client ClientPlayer, eventName string, eventData json.RawMessage) {
 		if r.game == nil {
 			return
 		}
 		r.game.DispatchGameCommand(client, eventName, eventData)
 	})
+	if clientPlayer == nil {
+		return nil
+	}
 	client := clientPlayer.(ClientPlayer)
-	r.addBot(client)
+	r.addBot(client, settings)
 
 	return client
 }
@@ -376,8 +519,34 @@ func (r *Room) onClientCommand(cc *ClientCommand) {
 		r.OnStartGameCommand(cc.client)
 	case ClientCommandRoomSubTypeDeleteGame:
 		r.onDeleteGameCommand(cc.client)
+	case ClientCommandRoomSubTypeSetMap:
+		var mapData RoomSetMapCommandData
+		if err := json.Unmarshal(cc.Data, &mapData); err != nil {
+			return
+		}
+		seed, err := parseMapSeed(mapData.Seed)
+		if err != nil {
+			cc.client.SendEvent(&ClientCommandError{errorInvalidMapSettings})
+			return
+		}
+		r.onSetMapCommand(cc.client, MapSettings{
+			Template:       mapData.Template,
+			Rooms:          mapData.Rooms,
+			Layout:         mapData.Layout,
+			Biomes:         mapData.Biomes,
+			BiomesPerFloor: mapData.BiomesPerFloor,
+			Seed:           seed,
+			Difficulty:     mapData.Difficulty,
+		})
 	case ClientCommandRoomSubTypeAddBot:
-		r.onAddBotCommand(cc.client)
+		// The data is optional, for clients that add default bots.
+		var addBotData RoomAddBotCommandData
+		if len(cc.Data) > 0 && string(cc.Data) != "null" {
+			if err := json.Unmarshal(cc.Data, &addBotData); err != nil {
+				return
+			}
+		}
+		r.onAddBotCommand(cc.client, BotSettings{Difficulty: addBotData.Difficulty, Class: addBotData.Class})
 	case ClientCommandRoomSubTypeRemoveBots:
 		r.onRemoveBotsCommand(cc.client)
 	case ClientCommandRoomSetAdditionalProperties:
@@ -413,11 +582,13 @@ func (r *Room) OnGameEnded() {
 
 func (rm *RoomMember) memberToRoomMemberInfo() *RoomMemberInfo {
 	return &RoomMemberInfo{
-		Id:          rm.client.ID(),
-		Nickname:    rm.client.Nickname(),
-		WantsToPlay: rm.wantsToPlay,
-		IsPlayer:    rm.isPlayer,
-		IsBot:       rm.isBot,
+		Id:            rm.client.ID(),
+		Nickname:      rm.client.Nickname(),
+		WantsToPlay:   rm.wantsToPlay,
+		IsPlayer:      rm.isPlayer,
+		IsBot:         rm.isBot,
+		BotDifficulty: rm.botSettings.Difficulty,
+		BotClass:      rm.botSettings.Class,
 	}
 }
 
@@ -456,6 +627,15 @@ func (r *Room) toRoomInfo() *RoomInfo {
 		GameStatus: gameStatus,
 		Members:    membersInfo,
 		MaxPlayers: r.lobby.maxPlayersInRoom,
+		Map: RoomMapInfo{
+			Template:       r.mapSettings.Template,
+			Rooms:          r.mapSettings.Rooms,
+			Layout:         r.mapSettings.Layout,
+			Biomes:         r.mapSettings.Biomes,
+			BiomesPerFloor: r.mapSettings.BiomesPerFloor,
+			Seed:           formatMapSeed(r.mapSettings.Seed),
+			Difficulty:     r.mapSettings.Difficulty,
+		},
 	}
 
 	return roomInfo
@@ -1,6 +1,11 @@
 package lobby
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
 
 // makeRoom creates a room owned by a fresh client in a test lobby.
 func makeRoom(l *Lobby, ownerID uint64) (*Room, *fakeClient) {
@@ -193,6 +198,23 @@ func TestOnStartGameCommandStartsGame(t *testing.T) {
 	}
 }
 
+func TestOnStartGameCommandCannotCreateGame(t *testing.T) {
+	l, _, _ := newTestLobby(1, 4)
+	l.newGameFunc = func(_ []ClientPlayer, _ *Room, _ MapSettings, _ func(interface{})) GameEventsDispatcher {
+		return nil
+	}
+	room, owner := makeRoom(l, 1)
+
+	room.OnStartGameCommand(owner)
+
+	if room.game != nil {
+		t.Error("game should not be set when it cannot be created")
+	}
+	if e, ok := findEvent[*ClientCommandError](owner.sentEvents); !ok || e.Message != errorCannotCreateGame {
+		t.Errorf("events = %v, want %s", owner.sentEvents, errorCannotCreateGame)
+	}
+}
+
 func TestOnStartGameCommandAlreadyStartedJoinsClient(t *testing.T) {
 	l, _, game := newTestLobby(1, 4)
 	room, owner := makeRoom(l, 1)
@@ -207,6 +229,19 @@ func TestOnStartGameCommandAlreadyStartedJoinsClient(t *testing.T) {
 	}
 }
 
+func TestOnDeleteGameCommandStopsGame(t *testing.T) {
+	l, _, game := newTestLobby(1, 4)
+	room, owner := makeRoom(l, 1)
+	room.OnStartGameCommand(owner)
+	<-game.loopStarted
+
+	room.onDeleteGameCommand(owner)
+
+	if room.game != nil || !game.stopped {
+		t.Error("expected the deleted game to be stopped and dropped")
+	}
+}
+
 func TestOnClientCommandSetAdditionalProperties(t *testing.T) {
 	l, _, _ := newTestLobby(1, 2)
 	room, owner := makeRoom(l, 1)
@@ -221,3 +256,159 @@ func TestOnClientCommandSetAdditionalProperties(t *testing.T) {
 		t.Errorf("additional properties not set: %v", owner.props)
 	}
 }
+
+func TestOnClientCommandAddBot(t *testing.T) {
+	l, _, _ := newTestLobby(1, 4)
+	var created []BotSettings
+	l.newBotFunc = func(botId uint64, _ *Room, settings BotSettings, _ func(ClientPlayer, string, json.RawMessage)) ClientPlayer {
+		if settings.Class == "dragon" {
+			return nil
+		}
+		created = append(created, settings)
+		return newFakeClient(botId, "bot")
+	}
+	room, owner := makeRoom(l, 1)
+
+	room.onClientCommand(&ClientCommand{SubType: ClientCommandRoomSubTypeAddBot, client: owner})
+	room.onClientCommand(&ClientCommand{
+		SubType: ClientCommandRoomSubTypeAddBot,
+		Data:    mustJSON(RoomAddBotCommandData{Difficulty: BotDifficultyHard, Class: "rogue"}),
+		client:  owner,
+	})
+
+	want := []BotSettings{{Difficulty: BotDifficultyNormal}, {Difficulty: BotDifficultyHard, Class: "rogue"}}
+	if !reflect.DeepEqual(created, want) {
+		t.Fatalf("created bots = %v, want %v", created, want)
+	}
+	var hard *RoomMemberInfo
+	for _, m := range room.toRoomInfo().Members {
+		if m.BotDifficulty == BotDifficultyHard {
+			hard = m
+		}
+	}
+	if hard == nil || !hard.IsBot || hard.BotClass != "rogue" {
+		t.Errorf("hard bot member info = %+v, want a rogue bot", hard)
+	}
+
+	for _, data := range []RoomAddBotCommandData{{Difficulty: "impossible"}, {Class: "dragon"}} {
+		owner.sentEvents = nil
+		room.onClientCommand(&ClientCommand{SubType: ClientCommandRoomSubTypeAddBot, Data: mustJSON(data), client: owner})
+		if e, ok := findEvent[*ClientCommandError](owner.sentEvents); !ok || e.Message != errorInvalidBotSettings {
+			t.Errorf("add bot %+v: events = %v, want %s", data, owner.sentEvents, errorInvalidBotSettings)
+		}
+	}
+	if len(room.members) != 3 {
+		t.Errorf("member count = %d, want the owner and two bots", len(room.members))
+	}
+}
+
+func TestOnClientCommandSetMap(t *testing.T) {
+	l, _, game := newTestLobby(1, 4)
+	var started MapSettings
+	l.newGameFunc = func(_ []ClientPlayer, _ *Room, settings MapSettings, _ func(interface{})) GameEventsDispatcher {
+		started = settings
+		return game
+	}
+	l.UseMaps(MapSettings{Template: "dungeon1", Rooms: 10}, func(settings MapSettings) error {
+		if settings.Template != "dungeon1" && settings.Template != "crypt" {
+			return errors.New("unknown template")
+		}
+		if settings.Layout != "" && settings.Layout != "tree" {
+			return errors.New("unknown layout")
+		}
+		for _, biome := range settings.Biomes {
+			if biome != "dungeon1" {
+				return errors.New("unknown biome")
+			}
+		}
+		return nil
+	}, nil)
+	room, owner := makeRoom(l, 1)
+	if info := room.toRoomInfo().Map; !reflect.DeepEqual(info, RoomMapInfo{Template: "dungeon1", Rooms: 10, Seed: MapSeedRandom}) {
+		t.Errorf("initial map = %+v, want the lobby's defaults", info)
+	}
+
+	room.onClientCommand(&ClientCommand{
+		SubType: ClientCommandRoomSubTypeSetMap,
+		Data:    mustJSON(RoomSetMapCommandData{Template: "crypt", Rooms: 6, Layout: "tree", Biomes: []string{"dungeon1"}, BiomesPerFloor: true, Seed: "9007199254740993", Difficulty: "hard"}),
+		client:  owner,
+	})
+
+	updated, ok := findEvent[*RoomUpdatedEvent](owner.sentEvents)
+	if !ok || updated.Cause != RoomUpdatedCauseMapChanged || updated.Room.Map.Seed != "9007199254740993" || updated.Room.Map.Layout != "tree" || updated.Room.Map.Difficulty != "hard" || !reflect.DeepEqual(updated.Room.Map.Biomes, []string{"dungeon1"}) {
+		t.Fatalf("events = %v, want the room updated with the new map", owner.sentEvents)
+	}
+
+	for _, data := range []RoomSetMapCommandData{{Template: "lava", Rooms: 6}, {Template: "crypt", Seed: "lucky"}, {Template: "crypt", Layout: "maze"}, {Template: "crypt", Difficulty: "nightmare"}, {Template: "crypt", Biomes: []string{"lava"}}} {
+		owner.sentEvents = nil
+		room.onClientCommand(&ClientCommand{SubType: ClientCommandRoomSubTypeSetMap, Data: mustJSON(data), client: owner})
+		if e, ok := findEvent[*ClientCommandError](owner.sentEvents); !ok || e.Message != errorInvalidMapSettings {
+			t.Errorf("set map %+v: events = %v, want %s", data, owner.sentEvents, errorInvalidMapSettings)
+		}
+	}
+
+	member := newFakeClient(2, "member")
+	room.addClient(member)
+	room.onClientCommand(&ClientCommand{
+		SubType: ClientCommandRoomSubTypeSetMap,
+		Data:    mustJSON(RoomSetMapCommandData{Template: "dungeon1", Seed: MapSeedRandom}),
+		client:  member,
+	})
+	if e, ok := findEvent[*ClientCommandError](member.sentEvents); !ok || e.Message != errorYouShouldBeOwner {
+		t.Errorf("events = %v, want %s", member.sentEvents, errorYouShouldBeOwner)
+	}
+
+	room.OnStartGameCommand(owner)
+	<-game.loopStarted
+	if want := (MapSettings{Template: "crypt", Rooms: 6, Layout: "tree", Biomes: []string{"dungeon1"}, BiomesPerFloor: true, Seed: 9007199254740993, Difficulty: "hard"}); !reflect.DeepEqual(started, want) {
+		t.Errorf("game started with %+v, want %+v", started, want)
+	}
+}
+
+func TestSetMapIsPreparedOffTheLobbyGoroutine(t *testing.T) {
+	l, _, _ := newTestLobby(1, 4)
+	prepared := make(chan int64)
+	l.UseMaps(MapSettings{Template: "dungeon1", Rooms: 10}, func(MapSettings) error { return nil }, func(settings MapSettings) error {
+		prepared <- settings.Seed
+		if settings.Seed == 13 {
+			return errors.New("no dungeon")
+		}
+		return nil
+	})
+	room, owner := makeRoom(l, 1)
+	setMap := func(seed string) {
+		room.onClientCommand(&ClientCommand{
+			SubType: ClientCommandRoomSubTypeSetMap,
+			Data:    mustJSON(RoomSetMapCommandData{Template: "dungeon1", Rooms: 10, Seed: seed}),
+			client:  owner,
+		})
+	}
+
+	// The command returns while the map is prepared; choices made meanwhile
+	// wait, and only the latest of them is prepared next.
+	setMap("1")
+	setMap("2")
+	setMap("3")
+	if seed := <-prepared; seed != 1 {
+		t.Fatalf("prepared seed %d first, want 1", seed)
+	}
+	room.onMapPrepared(<-l.mapPrepared)
+	if _, ok := findEvent[*RoomUpdatedEvent](owner.sentEvents); ok || room.mapSettings.Seed != 0 {
+		t.Errorf("map set to seed %d, want the outdated choice ignored", room.mapSettings.Seed)
+	}
+	if seed := <-prepared; seed != 3 {
+		t.Fatalf("prepared seed %d next, want the latest choice 3", seed)
+	}
+	room.onMapPrepared(<-l.mapPrepared)
+	if updated, ok := findEvent[*RoomUpdatedEvent](owner.sentEvents); !ok || updated.Room.Map.Seed != "3" {
+		t.Errorf("events = %v, want the room updated with seed 3", owner.sentEvents)
+	}
+
+	owner.sentEvents = nil
+	setMap("13")
+	<-prepared
+	room.onMapPrepared(<-l.mapPrepared)
+	if e, ok := findEvent[*ClientCommandError](owner.sentEvents); !ok || e.Message != errorInvalidMapSettings || room.mapSettings.Seed != 3 {
+		t.Errorf("events = %v, map seed %d; want %s and the map kept", owner.sentEvents, room.mapSettings.Seed, errorInvalidMapSettings)
+	}
+}
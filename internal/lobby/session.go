@@ -0,0 +1,135 @@
+package lobby
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// A client that joins the lobby is given a session token. If its connection
+// drops during a match, the client is kept for sessionGracePeriod, its player
+// marked disconnected, and a new connection presenting the token takes its
+// place: same client ID, same room, same player.
+
+// sessionGracePeriod is how long a client that lost its connection during a
+// match is kept for it to resume its session.
+const sessionGracePeriod = time.Minute
+
+type session struct {
+	token  string
+	client ClientPlayer
+	// disconnected is set while the client has no connection. disconnects
+	// counts the times it lost one, so a stale grace timer can tell it is
+	// not the last one.
+	disconnected bool
+	disconnects  int
+	timer        *time.Timer
+}
+
+// sessionExpiry tells the lobby that the grace period after the disconnects-th
+// disconnection of the session's client is over.
+type sessionExpiry struct {
+	session     *session
+	disconnects int
+}
+
+// sessionToken returns the session token of c, issuing one on the first call.
+func (l *Lobby) sessionToken(c ClientPlayer) string {
+	if s, ok := l.sessionsByClient[c.ID()]; ok {
+		return s.token
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	s := &session{token: hex.EncodeToString(b), client: c}
+	l.sessions[s.token] = s
+	l.sessionsByClient[c.ID()] = s
+
+	return s.token
+}
+
+// endSession forgets the session of c, if any.
+func (l *Lobby) endSession(c ClientPlayer) {
+	if s, ok := l.sessionsByClient[c.ID()]; ok {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		delete(l.sessions, s.token)
+		delete(l.sessionsByClient, c.ID())
+	}
+}
+
+// keepSession keeps c, which lost its connection, for its session to be resumed
+// if it is playing a match and was not kicked. It reports whether c was kept.
+func (l *Lobby) keepSession(c ClientPlayer) bool {
+	s, ok := l.sessionsByClient[c.ID()]
+	room := l.clientsJoinedRooms[c]
+	if !ok || room == nil || room.game == nil {
+		return false
+	}
+	if client, ok := c.(*Client); ok && client.kicked.Load() {
+		log.Printf("client %d kicked, ending its session\n", c.ID())
+		return false
+	}
+
+	log.Printf("client %d disconnected, keeping its session for %s\n", c.ID(), sessionGracePeriod)
+	s.disconnected = true
+	s.disconnects++
+	expiry := sessionExpiry{session: s, disconnects: s.disconnects}
+	s.timer = time.AfterFunc(sessionGracePeriod, func() {
+		l.sessionExpired <- expiry
+	})
+	room.game.OnClientDisconnected(c)
+
+	return true
+}
+
+// expireSession removes the client of a session that was not resumed in time.
+func (l *Lobby) expireSession(e sessionExpiry) {
+	s := e.session
+	if !s.disconnected || s.disconnects != e.disconnects || l.sessions[s.token] != s {
+		return
+	}
+
+	log.Printf("session of client %d expired\n", s.client.ID())
+	delete(l.clients, s.client.ID())
+	l.endSession(s.client)
+	l.onClientLeft(s.client)
+}
+
+// resumeSessionCommand rebinds the connection of c, which has just connected,
+// to the client of the session token, and sends it everything it needs to pick
+// up where it was. A session still connected elsewhere is taken over.
+func (l *Lobby) resumeSessionCommand(c ClientPlayer, token string) {
+	s, ok := l.sessions[token]
+	var client, fresh *Client
+	if ok {
+		client, _ = s.client.(*Client)
+		fresh, _ = c.(*Client)
+	}
+	if client == nil || fresh == nil || fresh == client || l.clientsJoinedRooms[c] != nil {
+		c.SendEvent(&ClientCommandError{errorSessionExpired})
+		return
+	}
+
+	log.Printf("client %d resumes the session of client %d\n", fresh.ID(), client.ID())
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if !s.disconnected {
+		client.transportClient.Close()
+	}
+	s.disconnected = false
+	delete(l.clients, fresh.ID())
+	fresh.transportClient.SetID(client.ID())
+	client.transportClient = fresh.transportClient
+
+	l.sendClientJoined(client, true)
+	if room := l.clientsJoinedRooms[client]; room != nil {
+		client.SendEvent(&RoomJoinedEvent{room.toRoomInfo()})
+		if room.game != nil {
+			room.game.OnClientReconnected(client)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package lobby
+
+import "testing"
+
+// newPlayingClient registers a transport client, joins it to the lobby and to
+// a room playing a match, and returns its client and the room's game.
+func newPlayingClient(t *testing.T, l *Lobby, game *fakeGame) (*Client, *fakeSender, string) {
+	t.Helper()
+	tc := &fakeSender{}
+	l.registerClient(tc)
+	c := l.clients[tc.ID()].(*Client)
+	l.joinLobbyCommand(c, "Alice")
+	joined, ok := findEvent[*ClientJoinedEvent](tc.sent)
+	if !ok || joined.SessionToken == "" {
+		t.Fatalf("ClientJoinedEvent %+v, want one with a session token", joined)
+	}
+	room := l.CreateNewRoomCommand(c)
+	room.game = game
+
+	return c, tc, joined.SessionToken
+}
+
+func TestDisconnectedPlayerResumesItsSession(t *testing.T) {
+	l, _, game := newTestLobby(1, 2)
+	c, tc, token := newPlayingClient(t, l, game)
+	id := c.ID()
+
+	l.unregisterClient(tc)
+	if l.clients[id] != c || l.clientsJoinedRooms[c] == nil || len(game.clientsRemvd) != 0 {
+		t.Fatal("a player who lost its connection during a match was removed")
+	}
+	if len(game.disconnected) != 1 || game.disconnected[0] != c {
+		t.Errorf("game told of disconnected %v, want the client", game.disconnected)
+	}
+
+	tc2 := &fakeSender{}
+	l.registerClient(tc2)
+	l.resumeSessionCommand(l.clients[tc2.ID()], token)
+
+	if tc2.ID() != id || l.clients[id] != c || len(l.clients) != 1 {
+		t.Errorf("new connection got id %d and %d clients, want the id %d of the resumed client only", tc2.ID(), len(l.clients), id)
+	}
+	if len(game.reconnected) != 1 || game.reconnected[0] != c {
+		t.Errorf("game told of reconnected %v, want the client", game.reconnected)
+	}
+	joined, ok := findEvent[*ClientJoinedEvent](tc2.sent)
+	if !ok || !joined.Resumed || joined.YourId != id || joined.SessionToken != token {
+		t.Errorf("ClientJoinedEvent %+v, want the resumed session", joined)
+	}
+	if _, ok := findEvent[*RoomJoinedEvent](tc2.sent); !ok {
+		t.Error("the resumed client is not sent its room")
+	}
+
+	// The session outlives the grace timer of the first disconnection.
+	l.expireSession(sessionExpiry{session: l.sessions[token], disconnects: 1})
+	if l.clients[id] != c || len(game.clientsRemvd) != 0 {
+		t.Error("a resumed session expired")
+	}
+}
+
+func TestSessionExpiresAfterTheGracePeriod(t *testing.T) {
+	l, mm, game := newTestLobby(1, 2)
+	c, tc, token := newPlayingClient(t, l, game)
+
+	l.unregisterClient(tc)
+	l.expireSession(sessionExpiry{session: l.sessions[token], disconnects: 1})
+
+	if _, ok := l.clients[c.ID()]; ok || len(game.clientsRemvd) != 1 || len(mm.cancelled) != 1 {
+		t.Error("a client whose session expired is still in the lobby or its match")
+	}
+	tc2 := &fakeSender{}
+	l.registerClient(tc2)
+	l.resumeSessionCommand(l.clients[tc2.ID()], token)
+	if e, ok := findEvent[*ClientCommandError](tc2.sent); !ok || e.Message != errorSessionExpired {
+		t.Errorf("resuming an expired session got %+v, want %s", e, errorSessionExpired)
+	}
+}
+
+func TestDisconnectOutsideAMatchLeavesAtOnce(t *testing.T) {
+	l, _, _ := newTestLobby(1, 2)
+	tc := &fakeSender{}
+	l.registerClient(tc)
+	c := l.clients[tc.ID()]
+	l.joinLobbyCommand(c, "Alice")
+	l.CreateNewRoomCommand(c)
+
+	l.unregisterClient(tc)
+
+	if _, ok := l.clients[c.ID()]; ok || len(l.sessions) != 0 {
+		t.Error("a client not in a match was kept after losing its connection")
+	}
+}
+
+func TestResumeTakesOverAConnectedSession(t *testing.T) {
+	l, _, game := newTestLobby(1, 2)
+	c, tc, token := newPlayingClient(t, l, game)
+
+	tc2 := &fakeSender{}
+	l.registerClient(tc2)
+	l.resumeSessionCommand(l.clients[tc2.ID()], token)
+	if !tc.closed || c.transportClient != tc2 {
+		t.Fatal("the old connection was not replaced")
+	}
+
+	// The old connection's late unregistration leaves the session alone.
+	l.unregisterClient(tc)
+	if l.clients[c.ID()] != c || len(game.disconnected) != 0 {
+		t.Error("the replaced connection disconnected the resumed client")
+	}
+}
+
+func TestResyncSendsAFallenBehindClientItsMatch(t *testing.T) {
+	l, _, game := newTestLobby(1, 2)
+	c, tc, _ := newPlayingClient(t, l, game)
+
+	l.resyncClient(tc)
+	if len(game.reconnected) != 1 || game.reconnected[0] != c || tc.closed {
+		t.Errorf("game told of reconnected %v, want the resynced client", game.reconnected)
+	}
+
+	idle := &fakeSender{}
+	l.registerClient(idle)
+	l.resyncClient(idle)
+	if !idle.closed {
+		t.Error("a client behind outside a match was not disconnected")
+	}
+}
+
+func TestKickedPlayerCannotResumeItsSession(t *testing.T) {
+	l, _, game := newTestLobby(1, 2)
+	c, tc, token := newPlayingClient(t, l, game)
+
+	c.Kick()
+	l.unregisterClient(tc)
+
+	if _, ok := l.clients[c.ID()]; ok || len(game.clientsRemvd) != 1 || l.sessions[token] != nil {
+		t.Error("the session of a kicked client was kept")
+	}
+}
@@ -57,6 +57,9 @@ type fakeGame struct {
 	loopStarted   chan struct{}
 	clientsJoined []ClientPlayer
 	clientsRemvd  []ClientPlayer
+	disconnected  []ClientPlayer
+	reconnected   []ClientPlayer
+	stopped       bool
 }
 
 func newFakeGame() *fakeGame {
@@ -66,7 +69,10 @@ func newFakeGame() *fakeGame {
 func (g *fakeGame) DispatchGameCommand(_ ClientPlayer, _ string, _ interface{}) {}
 func (g *fakeGame) OnClientRemoved(c ClientPlayer)                              { g.clientsRemvd = append(g.clientsRemvd, c) }
 func (g *fakeGame) OnClientJoined(c ClientPlayer)                               { g.clientsJoined = append(g.clientsJoined, c) }
+func (g *fakeGame) OnClientDisconnected(c ClientPlayer)                         { g.disconnected = append(g.disconnected, c) }
+func (g *fakeGame) OnClientReconnected(c ClientPlayer)                          { g.reconnected = append(g.reconnected, c) }
 func (g *fakeGame) StartMainLoop()                                              { g.loopStarted <- struct{}{} }
+func (g *fakeGame) Stop()                                                       { g.stopped = true }
 func (g *fakeGame) Status() string                                              { return g.status }
 func (g *fakeGame) GetCommonInitialGameData() map[string]interface{}            { return map[string]interface{}{} }
 
@@ -77,17 +83,22 @@ func newTestLobby(minPlayers, maxPlayers int) (*Lobby, *fakeMatchMaker, *fakeGam
 	mm := &fakeMatchMaker{}
 	game := newFakeGame()
 	l := &Lobby{
-		broadcast:             make(chan interface{}, 256),
+		broadcast:             make(chan lobbyBroadcast, 256),
 		register:              make(chan ClientSender, 1),
 		unregister:            make(chan ClientSender, 1),
+		resync:                make(chan ClientSender, 1),
 		clients:               make(map[uint64]ClientPlayer),
 		clientCommands:        make(chan *ClientCommand, 1),
 		roomsCreatedByClients: make(map[ClientPlayer]*Room),
 		clientsJoinedRooms:    make(map[ClientPlayer]*Room),
+		sessions:              make(map[string]*session),
+		sessionsByClient:      make(map[uint64]*session),
+		sessionExpired:        make(chan sessionExpiry, 1),
+		mapPrepared:           make(chan mapPreparation, 1),
 		matchMaker:            mm,
 		minPlayersInRoom:      minPlayers,
 		maxPlayersInRoom:      maxPlayers,
-		newGameFunc: func(_ []ClientPlayer, _ *Room, _ func(interface{})) GameEventsDispatcher {
+		newGameFunc: func(_ []ClientPlayer, _ *Room, _ MapSettings, _ func(interface{})) GameEventsDispatcher {
 			return game
 		},
 	}
@@ -99,8 +110,8 @@ func drainBroadcast(l *Lobby) []interface{} {
 	events := make([]interface{}, 0)
 	for {
 		select {
-		case e := <-l.broadcast:
-			events = append(events, e)
+		case broadcast := <-l.broadcast:
+			events = append(events, broadcast.event)
 		default:
 			return events
 		}
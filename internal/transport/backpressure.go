@@ -0,0 +1,282 @@
+package transport
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"dungeon/internal/game"
+	"dungeon/internal/lobby"
+)
+
+// A client that reads slower than events are sent to it falls behind. Events
+// that are full snapshots sent over and over, like positions, are coalesced
+// instead: only the latest one queued is sent. Every other event must be
+// delivered; when the client has sendQueueSize of them queued, they are all
+// dropped and the lobby sends it a fresh snapshot of its match. A client that
+// falls behind more than maxResyncs times within resyncWindow cannot keep up
+// and is disconnected. The WebSocket and the SSE transports share this queue,
+// sendQueue.
+const (
+	sendQueueSize = 256
+	maxResyncs    = 3
+	resyncWindow  = time.Minute
+)
+
+// coalesceKey returns the key of the events event supersedes, or "" if event
+// must be delivered.
+func coalesceKey(event interface{}) string {
+	if pre, ok := event.(*lobby.PreEncodedEvent); ok {
+		event = pre.Event
+	}
+	switch event.(type) {
+	case game.CreaturesPosUpdateEvent, game.CreaturesStatsUpdateEvent:
+		return getNameOfStruct(event)
+	}
+
+	return ""
+}
+
+type coalescedMessage struct {
+	key     string
+	message []byte
+}
+
+// sendQueue is the outbound queue of a client, the same for every transport.
+type sendQueue struct {
+	// Channel of outbound messages, which must all be delivered.
+	send         chan []byte
+	sendIsClosed bool
+	mu           sync.Mutex
+
+	// coalesced are the latest queued messages of events that supersede each
+	// other, and behind is set when the client fell behind; wake tells the
+	// write loop about either.
+	coalesced []coalescedMessage
+	behind    bool
+	wake      chan struct{}
+	resyncs   []time.Time
+	stats     QueueStats
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		send: make(chan []byte, sendQueueSize),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// QueueStats are the metrics of the outbound queue of a client.
+type QueueStats struct {
+	ClientID uint64 `json:"clientId"`
+	// Depth is the number of messages queued, MaxDepth the most ever queued.
+	Depth    int `json:"depth"`
+	MaxDepth int `json:"maxDepth"`
+	// Coalesced counts the messages superseded before they were sent, Dropped
+	// the ones dropped when the client fell behind.
+	Coalesced int `json:"coalesced"`
+	Dropped   int `json:"dropped"`
+	Resyncs   int `json:"resyncs"`
+}
+
+// queue queues the message of event for the client with id, unless the queue
+// is closed.
+func (q *sendQueue) queue(id uint64, event interface{}, message []byte) {
+	key := coalesceKey(event)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.sendIsClosed || q.send == nil {
+		return
+	}
+	if key != "" {
+		q.queueCoalescedUnsafe(key, message)
+	} else {
+		select {
+		case q.send <- message:
+		default:
+			q.fallBehindUnsafe(id)
+		}
+	}
+	q.noteDepthUnsafe()
+}
+
+// queueCoalescedUnsafe queues message in place of any queued one with the same
+// key. Caller must hold q.mu.
+func (q *sendQueue) queueCoalescedUnsafe(key string, message []byte) {
+	i := slices.IndexFunc(q.coalesced, func(m coalescedMessage) bool { return m.key == key })
+	if i >= 0 {
+		q.coalesced[i].message = message
+		q.stats.Coalesced++
+	} else {
+		q.coalesced = append(q.coalesced, coalescedMessage{key, message})
+	}
+	q.wakeUnsafe()
+}
+
+// fallBehindUnsafe drops everything queued for the client with id, which has
+// too many messages queued, and has the write loop ask for a resync. Caller
+// must hold q.mu.
+func (q *sendQueue) fallBehindUnsafe(id uint64) {
+	if !q.behind {
+		log.Printf("client %d fell behind with %d messages queued\n", id, len(q.send))
+	}
+	q.behind = true
+	q.stats.Dropped += len(q.coalesced) + 1
+	q.coalesced = q.coalesced[:0]
+drain:
+	for {
+		select {
+		case <-q.send:
+			q.stats.Dropped++
+		default:
+			break drain
+		}
+	}
+	q.wakeUnsafe()
+}
+
+// wakeUnsafe tells the write loop there is work besides the send channel.
+// Caller must hold q.mu.
+func (q *sendQueue) wakeUnsafe() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// noteDepthUnsafe records the depth of the queue. Caller must hold q.mu.
+func (q *sendQueue) noteDepthUnsafe() {
+	q.stats.MaxDepth = max(q.stats.MaxDepth, len(q.send)+len(q.coalesced))
+}
+
+// takeWork returns whether the client fell behind since the last call, and the
+// coalesced messages queued.
+func (q *sendQueue) takeWork() (bool, [][]byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	behind := q.behind
+	q.behind = false
+	messages := make([][]byte, 0, len(q.coalesced))
+	for _, m := range q.coalesced {
+		messages = append(messages, m.message)
+	}
+	q.coalesced = q.coalesced[:0]
+
+	return behind, messages
+}
+
+// resync asks l to resync tc, which fell behind, or reports false if the client
+// falls behind too often to keep up at all. It does not wait for a busy lobby:
+// the client then stays behind and asks again on the next wake.
+func (q *sendQueue) resync(l *lobby.Lobby, tc lobby.ClientSender) bool {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resyncs = slices.DeleteFunc(q.resyncs, func(at time.Time) bool { return now.Sub(at) >= resyncWindow })
+	if len(q.resyncs) >= maxResyncs {
+		log.Printf("client %d fell behind %d times in %s, disconnecting\n", tc.ID(), len(q.resyncs)+1, resyncWindow)
+		return false
+	}
+	if l != nil && !l.ResyncTransportClient(tc) {
+		q.behind = true
+		return true
+	}
+	q.resyncs = append(q.resyncs, now)
+	q.stats.Resyncs++
+
+	return true
+}
+
+// flush asks l for a resync of tc if it fell behind, then writes the messages
+// queued with write. The coalesced snapshots go first: they were taken before
+// the send channel is drained, and written after it they could take the client
+// back from the fresh state of a resync. A snapshot that gets ahead of the
+// events before it is soon superseded by the next one. write gets ok false once
+// the send channel is closed, and reports whether to go on. flush reports
+// whether the write loop should go on.
+func (q *sendQueue) flush(l *lobby.Lobby, tc lobby.ClientSender, write func(message []byte, ok bool) bool) bool {
+	behind, coalesced := q.takeWork()
+	if behind && !q.resync(l, tc) {
+		return false
+	}
+	for _, message := range coalesced {
+		if !write(message, true) {
+			return false
+		}
+	}
+	for {
+		select {
+		case message, ok := <-q.send:
+			if !write(message, ok) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// close closes the send channel, and reports false if it was already closed.
+func (q *sendQueue) close() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.sendIsClosed {
+		return false
+	}
+	q.sendIsClosed = true
+	close(q.send)
+
+	return true
+}
+
+// queueStats returns the metrics of the queue of the client with id.
+func (q *sendQueue) queueStats(id uint64) QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.ClientID = id
+	stats.Depth = len(q.send) + len(q.coalesced)
+
+	return stats
+}
+
+// statsSource is a connected client with an outbound queue.
+type statsSource interface {
+	Stats() QueueStats
+}
+
+// connected are the clients connected with any transport, for their metrics.
+var connected = struct {
+	sync.Mutex
+	clients map[statsSource]struct{}
+}{clients: make(map[statsSource]struct{})}
+
+func addConnected(c statsSource) {
+	connected.Lock()
+	connected.clients[c] = struct{}{}
+	connected.Unlock()
+}
+
+func removeConnected(c statsSource) {
+	connected.Lock()
+	delete(connected.clients, c)
+	connected.Unlock()
+}
+
+// ServeQueueStats writes the queue metrics of every connected client as JSON.
+func ServeQueueStats(w http.ResponseWriter, r *http.Request) {
+	connected.Lock()
+	stats := make([]QueueStats, 0, len(connected.clients))
+	for c := range connected.clients {
+		stats = append(stats, c.Stats())
+	}
+	connected.Unlock()
+	slices.SortFunc(stats, func(a, b QueueStats) int { return cmp.Compare(a.ClientID, b.ClientID) })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
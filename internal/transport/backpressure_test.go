@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"testing"
+
+	"dungeon/internal/game"
+	"dungeon/internal/lobby"
+)
+
+func TestSnapshotEventsAreCoalesced(t *testing.T) {
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 4), wake: make(chan struct{}, 1)}}
+
+	for x := 1; x <= 3; x++ {
+		positions := game.CreaturesPosUpdateEvent{Players: []game.PlayerPosition{{ClientID: 1, X: x}}}
+		c.SendEvent(&lobby.PreEncodedEvent{Event: positions})
+	}
+	c.SendEvent(game.CreaturesStatsUpdateEvent{})
+	c.SendEvent(&sampleEvent{Foo: "bar"})
+
+	if stats := c.Stats(); stats.Depth != 3 || stats.MaxDepth != 3 || stats.Coalesced != 2 {
+		t.Errorf("stats %+v, want 3 messages queued after coalescing 2", stats)
+	}
+	if len(c.send) != 1 {
+		t.Errorf("%d messages queued for delivery, want the event only", len(c.send))
+	}
+	behind, coalesced := c.takeWork()
+	if behind || len(coalesced) != 2 {
+		t.Fatalf("took %d coalesced messages, want the positions and the stats", len(coalesced))
+	}
+	if want, _ := eventToJSON(game.CreaturesPosUpdateEvent{Players: []game.PlayerPosition{{ClientID: 1, X: 3}}}); string(coalesced[0]) != string(want) {
+		t.Errorf("positions sent %s, want the latest %s", coalesced[0], want)
+	}
+}
+
+func TestClientFallingBehindTooOftenIsDisconnected(t *testing.T) {
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1)}}
+
+	for i := 0; i < maxResyncs; i++ {
+		if !c.resync(nil, c) {
+			t.Fatalf("disconnected at resync %d, want %d resyncs", i+1, maxResyncs)
+		}
+	}
+	if c.resync(nil, c) {
+		t.Error("a client falling behind over and over was resynced again")
+	}
+}
+
+func TestBusyLobbyLeavesClientBehindWithoutBlocking(t *testing.T) {
+	l := lobby.NewLobby(nil, nil, game.NewMatchMaker(), 1, 2)
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1), wake: make(chan struct{}, 1)}}
+	// The lobby does not run, so its resync requests pile up.
+	for l.ResyncTransportClient(c) {
+	}
+
+	c.behind = true
+	if !c.sendQueue.flush(l, c, func([]byte, bool) bool { return true }) {
+		t.Fatal("client disconnected while the lobby was busy")
+	}
+	if behind, _ := c.takeWork(); !behind || c.Stats().Resyncs != 0 {
+		t.Error("client not left behind to ask again for a resync")
+	}
+}
+
+func TestFlushWritesSnapshotsBeforeBacklog(t *testing.T) {
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 4), wake: make(chan struct{}, 1)}}
+	c.SendEvent(game.CreaturesPosUpdateEvent{})
+	c.SendEvent(&sampleEvent{Foo: "bar"})
+
+	var written []string
+	c.sendQueue.flush(nil, c, func(message []byte, ok bool) bool {
+		written = append(written, string(message))
+		return true
+	})
+	want, _ := eventToJSON(game.CreaturesPosUpdateEvent{})
+	if len(written) != 2 || written[0] != string(want) {
+		t.Errorf("wrote %q, want the positions first", written)
+	}
+}
@@ -0,0 +1,240 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"dungeon/internal/game"
+)
+
+// Clients choose how events are sent to them with the WebSocket subprotocol they
+// ask for. By default, or with encodingJSON, every event is a JSONEvent in a
+// text message. With encodingBinary every event is a binary message whose first
+// byte says what follows:
+//
+//	binaryJSON       the JSONEvent, as in a text message
+//	binaryPositions  a CreaturesPosUpdateEvent, delta-encoded (see below)
+//
+// and the client acknowledges every positions message it decodes with a binary
+// message of its own: binaryAck followed by the message's sequence number as a
+// uvarint. Commands stay JSON text messages in both encodings.
+//
+// A positions message is, in uvarints unless said otherwise: its sequence
+// number, the sequence number of the acknowledged message it is a delta against
+// (0 for none), then the number of players followed by each player's client id,
+// flags byte and fields, then the same for the monsters, by id. A creature's x
+// and y are zigzag varints relative to where it was in the base message, or to
+// 0 if it was not in it, and its direction is a byte: an index into directions
+// or directionLiteral followed by the length and bytes of the string.
+const (
+	encodingJSON   = "dungeon.json"
+	encodingBinary = "dungeon.bin.v1"
+)
+
+// Kinds of binary messages.
+const (
+	binaryJSON      byte = 1
+	binaryPositions byte = 2
+	binaryAck       byte = 3
+)
+
+// Flags of a creature in a positions message.
+const (
+	flagX         byte = 1 << iota // x differs from the base
+	flagY                          // y differs from the base
+	flagDirection                  // direction differs from the base
+	flagMoving
+	flagAction // dodging for a player, attacking for a monster
+)
+
+// directions are the directions a positions message has a byte for.
+var directions = []string{"", "up", "down", "left", "right"}
+
+const directionLiteral byte = 0xFF
+
+// maxDirectionLength bounds the direction strings sent literally.
+const maxDirectionLength = 32
+
+// maxUnackedPositions is how many positions messages a client can leave
+// unacknowledged before the oldest are forgotten. A message acknowledged after
+// that cannot be a base any more.
+const maxUnackedPositions = 64
+
+// eventToBinary encodes an event as a binaryJSON message. Only position updates
+// have an encoding of their own, which depends on what the client acknowledged.
+func eventToBinary(e interface{}) ([]byte, error) {
+	data, err := eventToJSON(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonToBinary(data), nil
+}
+
+// preEncodeBinary is eventToBinary for broadcasts: it reuses the JSON the event
+// was already encoded to instead of marshalling it again.
+func preEncodeBinary(e interface{}, encoded map[string][]byte) ([]byte, error) {
+	if data, ok := encoded[encodingJSON]; ok {
+		return jsonToBinary(data), nil
+	}
+
+	return eventToBinary(e)
+}
+
+// jsonToBinary wraps the JSONEvent data in a binaryJSON message.
+func jsonToBinary(data []byte) []byte {
+	message := make([]byte, 0, len(data)+1)
+	message = append(message, binaryJSON)
+
+	return append(message, data...)
+}
+
+// positionsSnapshot is what a client was sent in a positions message.
+type positionsSnapshot struct {
+	players  map[uint64]game.PlayerPosition
+	monsters map[int]game.MonsterPosition
+}
+
+// positionsEncoder delta-encodes the position updates of one client against the
+// last one it acknowledged. It is safe for concurrent use.
+type positionsEncoder struct {
+	mu    sync.Mutex
+	seq   uint64
+	acked uint64
+	// sent holds the acknowledged message and the ones sent after it.
+	sent map[uint64]positionsSnapshot
+}
+
+func newPositionsEncoder() *positionsEncoder {
+	return &positionsEncoder{sent: make(map[uint64]positionsSnapshot)}
+}
+
+// ack records that the client decoded the positions message seq, which later
+// messages can be deltas against.
+func (e *positionsEncoder) ack(seq uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if seq <= e.acked {
+		return
+	}
+	if _, ok := e.sent[seq]; !ok {
+		return
+	}
+	for s := range e.sent {
+		if s < seq {
+			delete(e.sent, s)
+		}
+	}
+	e.acked = seq
+}
+
+// encode encodes event as the next positions message.
+func (e *positionsEncoder) encode(event game.CreaturesPosUpdateEvent) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.seq++
+	base, ok := e.sent[e.acked]
+	baseSeq := e.acked
+	if !ok {
+		baseSeq = 0
+	}
+	snapshot := positionsSnapshot{
+		players:  make(map[uint64]game.PlayerPosition, len(event.Players)),
+		monsters: make(map[int]game.MonsterPosition, len(event.Monsters)),
+	}
+
+	buf := []byte{binaryPositions}
+	buf = binary.AppendUvarint(buf, e.seq)
+	buf = binary.AppendUvarint(buf, baseSeq)
+	buf = binary.AppendUvarint(buf, uint64(len(event.Players)))
+	for _, p := range event.Players {
+		old, known := base.players[p.ClientID]
+		buf = binary.AppendUvarint(buf, p.ClientID)
+		buf = appendCreature(buf, old.X, old.Y, old.Direction, known, p.X, p.Y, p.Direction, p.IsMoving, p.IsDodging)
+		snapshot.players[p.ClientID] = p
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(event.Monsters)))
+	for _, m := range event.Monsters {
+		old, known := base.monsters[m.ID]
+		buf = binary.AppendUvarint(buf, uint64(m.ID))
+		buf = appendCreature(buf, old.X, old.Y, old.Direction, known, m.X, m.Y, m.Direction, m.IsMoving, m.IsAttacking)
+		snapshot.monsters[m.ID] = m
+	}
+
+	e.sent[e.seq] = snapshot
+	if old := e.seq - maxUnackedPositions; old != e.acked {
+		delete(e.sent, old)
+	}
+
+	return buf
+}
+
+// appendCreature appends the flags and fields of a creature at x, y facing
+// direction, which was at baseX, baseY facing baseDirection in the base message
+// if known.
+func appendCreature(buf []byte, baseX, baseY int, baseDirection string, known bool, x, y int, direction string, moving, action bool) []byte {
+	if !known {
+		baseX, baseY = 0, 0
+	}
+	var flags byte
+	if x != baseX {
+		flags |= flagX
+	}
+	if y != baseY {
+		flags |= flagY
+	}
+	if !known || direction != baseDirection {
+		flags |= flagDirection
+	}
+	if moving {
+		flags |= flagMoving
+	}
+	if action {
+		flags |= flagAction
+	}
+
+	buf = append(buf, flags)
+	if flags&flagX != 0 {
+		buf = binary.AppendVarint(buf, int64(x-baseX))
+	}
+	if flags&flagY != 0 {
+		buf = binary.AppendVarint(buf, int64(y-baseY))
+	}
+	if flags&flagDirection != 0 {
+		buf = appendDirection(buf, direction)
+	}
+
+	return buf
+}
+
+func appendDirection(buf []byte, direction string) []byte {
+	for i, d := range directions {
+		if d == direction {
+			return append(buf, byte(i))
+		}
+	}
+	if len(direction) > maxDirectionLength {
+		direction = direction[:maxDirectionLength]
+	}
+	buf = append(buf, directionLiteral)
+	buf = binary.AppendUvarint(buf, uint64(len(direction)))
+
+	return append(buf, direction...)
+}
+
+var errInvalidAck = errors.New("invalid positions acknowledgement")
+
+// parseAck returns the sequence number of a binaryAck message.
+func parseAck(message []byte) (uint64, error) {
+	if len(message) < 2 || message[0] != binaryAck {
+		return 0, errInvalidAck
+	}
+	seq, n := binary.Uvarint(message[1:])
+	if n <= 0 || n != len(message)-1 {
+		return 0, errInvalidAck
+	}
+
+	return seq, nil
+}
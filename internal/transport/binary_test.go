@@ -0,0 +1,264 @@
+package transport
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dungeon/internal/game"
+	"dungeon/internal/lobby"
+
+	"github.com/gorilla/websocket"
+)
+
+// positionsDecoder decodes positions messages the way the web client does.
+type positionsDecoder struct {
+	received map[uint64]game.CreaturesPosUpdateEvent
+}
+
+func (d *positionsDecoder) decode(t *testing.T, message []byte) (uint64, game.CreaturesPosUpdateEvent) {
+	t.Helper()
+	if message[0] != binaryPositions {
+		t.Fatalf("message kind %d, want positions", message[0])
+	}
+	r := message[1:]
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(r)
+		if n <= 0 {
+			t.Fatal("truncated positions message")
+		}
+		r = r[n:]
+		return v
+	}
+	varint := func() int {
+		v, n := binary.Varint(r)
+		if n <= 0 {
+			t.Fatal("truncated positions message")
+		}
+		r = r[n:]
+		return int(v)
+	}
+	// creature reads the fields of a creature last seen at x, y facing
+	// direction.
+	creature := func(x, y int, direction string) (int, int, string, bool, bool) {
+		flags := r[0]
+		r = r[1:]
+		if flags&flagX != 0 {
+			x += varint()
+		}
+		if flags&flagY != 0 {
+			y += varint()
+		}
+		if flags&flagDirection != 0 {
+			code := r[0]
+			r = r[1:]
+			if code == directionLiteral {
+				n := uvarint()
+				direction, r = string(r[:n]), r[n:]
+			} else {
+				direction = directions[code]
+			}
+		}
+		return x, y, direction, flags&flagMoving != 0, flags&flagAction != 0
+	}
+
+	seq, baseSeq := uvarint(), uvarint()
+	base, ok := d.received[baseSeq]
+	if baseSeq != 0 && !ok {
+		t.Fatalf("message %d is a delta against %d, which was not received", seq, baseSeq)
+	}
+	var event game.CreaturesPosUpdateEvent
+	for n := uvarint(); n > 0; n-- {
+		p := game.PlayerPosition{ClientID: uvarint()}
+		for _, old := range base.Players {
+			if old.ClientID == p.ClientID {
+				p = old
+			}
+		}
+		p.X, p.Y, p.Direction, p.IsMoving, p.IsDodging = creature(p.X, p.Y, p.Direction)
+		event.Players = append(event.Players, p)
+	}
+	for n := uvarint(); n > 0; n-- {
+		m := game.MonsterPosition{ID: int(uvarint())}
+		for _, old := range base.Monsters {
+			if old.ID == m.ID {
+				m = old
+			}
+		}
+		m.X, m.Y, m.Direction, m.IsMoving, m.IsAttacking = creature(m.X, m.Y, m.Direction)
+		event.Monsters = append(event.Monsters, m)
+	}
+	if len(r) != 0 {
+		t.Fatalf("%d bytes left after the positions message", len(r))
+	}
+	d.received[seq] = event
+
+	return seq, event
+}
+
+func TestPositionsAreDeltasAgainstTheAcknowledgedMessage(t *testing.T) {
+	enc := newPositionsEncoder()
+	dec := &positionsDecoder{received: make(map[uint64]game.CreaturesPosUpdateEvent)}
+
+	events := []game.CreaturesPosUpdateEvent{
+		{
+			Players:  []game.PlayerPosition{{ClientID: 7, X: 400, Y: 300, Direction: "left", IsMoving: true}},
+			Monsters: []game.MonsterPosition{{ID: 3, X: 120, Y: 80, Direction: "up", IsAttacking: true}},
+		},
+		{
+			Players:  []game.PlayerPosition{{ClientID: 7, X: 396, Y: 300, Direction: "left", IsMoving: true}},
+			Monsters: []game.MonsterPosition{{ID: 3, X: 120, Y: 80, Direction: "up"}},
+		},
+		{
+			Players:  []game.PlayerPosition{{ClientID: 7, X: 392, Y: 304, Direction: "up-left", IsDodging: true}},
+			Monsters: []game.MonsterPosition{{ID: 4, X: -8, Y: 16}},
+		},
+	}
+	var full, delta int
+	for i, event := range events {
+		message := enc.encode(event)
+		seq, got := dec.decode(t, message)
+		if !reflect.DeepEqual(got, event) {
+			t.Errorf("message %d decoded as %+v, want %+v", i, got, event)
+		}
+		switch i {
+		case 0:
+			full = len(message)
+		case 1:
+			delta = len(message)
+		}
+		enc.ack(seq)
+	}
+	if json, _ := eventToJSON(events[0]); full >= len(json)/4 {
+		t.Errorf("positions message of %d bytes, want much less than the %d of JSON", full, len(json))
+	}
+	if delta >= full {
+		t.Errorf("delta of %d bytes, want less than the %d of the first message", delta, full)
+	}
+}
+
+func TestPositionsSurviveDroppedMessages(t *testing.T) {
+	enc := newPositionsEncoder()
+	dec := &positionsDecoder{received: make(map[uint64]game.CreaturesPosUpdateEvent)}
+	at := func(x int) game.CreaturesPosUpdateEvent {
+		return game.CreaturesPosUpdateEvent{Players: []game.PlayerPosition{{ClientID: 1, X: x, Y: 10, Direction: "right"}}}
+	}
+
+	seq, _ := dec.decode(t, enc.encode(at(10)))
+	enc.ack(seq)
+	enc.encode(at(20)) // dropped: never received nor acknowledged
+	enc.encode(at(30)) // received, acknowledged too late
+	if _, got := dec.decode(t, enc.encode(at(40))); got.Players[0].X != 40 {
+		t.Errorf("player at x %d, want 40", got.Players[0].X)
+	}
+}
+
+func TestPositionsEncoderForgetsUnacknowledgedMessages(t *testing.T) {
+	enc := newPositionsEncoder()
+	enc.encode(game.CreaturesPosUpdateEvent{})
+	enc.ack(1)
+	for i := 0; i < 3*maxUnackedPositions; i++ {
+		enc.encode(game.CreaturesPosUpdateEvent{})
+	}
+	if _, ok := enc.sent[1]; !ok || len(enc.sent) > maxUnackedPositions+1 {
+		t.Errorf("%d messages kept, want the acknowledged one and at most %d more", len(enc.sent), maxUnackedPositions)
+	}
+}
+
+func TestParseAck(t *testing.T) {
+	if seq, err := parseAck(binary.AppendUvarint([]byte{binaryAck}, 300)); err != nil || seq != 300 {
+		t.Errorf("parseAck = %d, %v, want 300", seq, err)
+	}
+	for _, message := range [][]byte{{binaryAck}, {binaryJSON, 1}, {binaryAck, 0x80}, {binaryAck, 1, 2}} {
+		if _, err := parseAck(message); err == nil {
+			t.Errorf("parseAck(%v) accepted", message)
+		}
+	}
+}
+
+func TestSendEventUsesTheClientEncoding(t *testing.T) {
+	event := &sampleEvent{Foo: "bar"}
+	pre := &lobby.PreEncodedEvent{Event: event, Data: map[string][]byte{encodingJSON: []byte("json"), encodingBinary: []byte("binary")}}
+	for _, tc := range []struct {
+		encoding string
+		event    interface{}
+		want     string
+	}{
+		{encodingJSON, pre, "json"},
+		{encodingBinary, pre, "binary"},
+		{encodingBinary, event, "\x01{\"name\":\"sampleEvent\",\"data\":{\"foo\":\"bar\"}}"},
+	} {
+		c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1)}, encoding: tc.encoding, positions: newPositionsEncoder()}
+		c.SendEvent(tc.event)
+		if got := string(<-c.send); got != tc.want {
+			t.Errorf("%s client sent %q, want %q", tc.encoding, got, tc.want)
+		}
+	}
+
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1)}, encoding: encodingBinary, positions: newPositionsEncoder()}
+	c.SendEvent(&lobby.PreEncodedEvent{Event: game.CreaturesPosUpdateEvent{}, Data: pre.Data})
+	if _, got := c.takeWork(); len(got) != 1 || got[0][0] != binaryPositions {
+		t.Errorf("positions sent as %v, want a delta-encoded message", got)
+	}
+}
+
+func TestBroadcastBinaryReusesTheJSON(t *testing.T) {
+	// A marker no marshalling would produce shows the JSON bytes were reused.
+	encoded := map[string][]byte{encodingJSON: []byte("json")}
+	got, err := preEncodeBinary(sampleEvent{Foo: "bar"}, encoded)
+	if err != nil || string(got) != "\x01json" {
+		t.Errorf("binary broadcast %q, %v, want the JSON bytes behind binaryJSON", got, err)
+	}
+	if string(encoded[encodingJSON]) != "json" {
+		t.Error("the JSON bytes were changed")
+	}
+
+	want, _ := eventToBinary(sampleEvent{Foo: "bar"})
+	if got, _ := preEncodeBinary(sampleEvent{Foo: "bar"}, nil); string(got) != string(want) {
+		t.Errorf("binary broadcast without JSON %q, want %q", got, want)
+	}
+}
+
+func TestUpgradeNegotiatesTheEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, encoding, err := upgrade(w, r)
+		if err != nil {
+			return
+		}
+		c := &WebSocketClient{conn: conn, sendQueue: &sendQueue{send: make(chan []byte, 1)}, encoding: encoding, positions: newPositionsEncoder()}
+		c.SendEvent(&sampleEvent{Foo: "bar"})
+		c.writeLoop()
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, tc := range []struct {
+		protocols    []string
+		wantProtocol string
+		wantType     int
+	}{
+		{nil, "", websocket.TextMessage},
+		{[]string{encodingBinary, encodingJSON}, encodingBinary, websocket.BinaryMessage},
+		{[]string{"chat", encodingJSON}, encodingJSON, websocket.TextMessage},
+	} {
+		dialer := websocket.Dialer{Subprotocols: tc.protocols}
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.protocols, err)
+		}
+		if conn.Subprotocol() != tc.wantProtocol {
+			t.Errorf("%v: negotiated %q, want %q", tc.protocols, conn.Subprotocol(), tc.wantProtocol)
+		}
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != tc.wantType || !strings.Contains(string(message), "sampleEvent") {
+			t.Errorf("%v: got message %q of type %d, want the event in a message of type %d", tc.protocols, message, messageType, tc.wantType)
+		}
+		conn.Close()
+	}
+}
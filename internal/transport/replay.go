@@ -0,0 +1,306 @@
+package transport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A replay file is newline-delimited JSON, optionally gzipped: one ReplayEntry
+// per line, in the order the game produced them. Events are stored in the same
+// JSONEvent envelope clients receive, so playing a replay back is a matter of
+// sending each event line at its original time.
+//
+// A replay shows the whole map, so it is recorded under a partial name and
+// only served once the match ended and the file was closed: a player must not
+// watch the live replay of their own match.
+
+const replayExt = ".ndjson"
+const replayGzipExt = ".ndjson.gz"
+
+// replayPartialExt is appended to the file name while the match is recorded.
+const replayPartialExt = ".part"
+
+// replayIDBytes is how many random bytes a replay id is made of.
+const replayIDBytes = 16
+
+// replayIDPattern keeps replay ids safe to use as file names.
+var replayIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ReplayEntry is one line of a replay file. Exactly one of Event and Command is
+// set.
+type ReplayEntry struct {
+	// T is the time since the recording started, in milliseconds.
+	T       int64           `json:"t"`
+	Event   json.RawMessage `json:"event,omitempty"`
+	Command *ReplayCommand  `json:"command,omitempty"`
+}
+
+// ReplayCommand is a game command accepted from a client.
+type ReplayCommand struct {
+	ClientID uint64          `json:"clientId"`
+	Name     string          `json:"name"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// NewReplayID returns a random replay id, so that replays cannot be found by
+// guessing their ids.
+func NewReplayID() (string, error) {
+	b := make([]byte, replayIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// ReplayRecorder writes a match's replay file. It is safe for concurrent use.
+type ReplayRecorder struct {
+	id    string
+	start time.Time
+	path  string // final file name, the file is renamed to it on Close
+
+	mu     sync.Mutex
+	file   *os.File
+	gz     *gzip.Writer // nil when the file is not compressed
+	w      *bufio.Writer
+	closed bool
+}
+
+// NewReplayRecorder creates the replay file for id in dir, gzipped if compress
+// is set.
+func NewReplayRecorder(dir string, id string, compress bool) (*ReplayRecorder, error) {
+	if !replayIDPattern.MatchString(id) {
+		return nil, errors.New("invalid replay id: " + id)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ext := replayExt
+	if compress {
+		ext = replayGzipExt
+	}
+	path := filepath.Join(dir, id+ext)
+	file, err := os.Create(path + replayPartialExt)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ReplayRecorder{id: id, start: time.Now(), path: path, file: file}
+	if compress {
+		r.gz = gzip.NewWriter(file)
+		r.w = bufio.NewWriter(r.gz)
+	} else {
+		r.w = bufio.NewWriter(file)
+	}
+
+	return r, nil
+}
+
+func (r *ReplayRecorder) ID() string {
+	return r.id
+}
+
+// RecordEvent records a broadcast event.
+func (r *ReplayRecorder) RecordEvent(event interface{}) {
+	data, err := eventToJSON(event)
+	if err != nil {
+		log.Printf("replay %s: cannot encode %s: %v", r.id, getNameOfStruct(event), err)
+		return
+	}
+	r.write(ReplayEntry{Event: data})
+}
+
+// RecordCommand records a game command accepted from a client.
+func (r *ReplayRecorder) RecordCommand(clientID uint64, name string, data json.RawMessage) {
+	r.write(ReplayEntry{Command: &ReplayCommand{ClientID: clientID, Name: name, Data: data}})
+}
+
+func (r *ReplayRecorder) write(entry ReplayEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	entry.T = time.Since(r.start).Milliseconds()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("replay %s: cannot encode entry: %v", r.id, err)
+		return
+	}
+	_, _ = r.w.Write(line)
+	_ = r.w.WriteByte('\n')
+}
+
+// Close flushes the replay file, closes it and makes it available to viewers.
+// Later records are dropped.
+func (r *ReplayRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	err := r.w.Flush()
+	if r.gz != nil {
+		err = errors.Join(err, r.gz.Close())
+	}
+
+	err = errors.Join(err, r.file.Close())
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(r.file.Name(), r.path)
+}
+
+// findReplayFile returns the path of the finished replay file for id in dir.
+func findReplayFile(dir string, id string) (string, bool) {
+	if !replayIDPattern.MatchString(id) {
+		return "", false
+	}
+	for _, ext := range []string{replayGzipExt, replayExt} {
+		path := filepath.Join(dir, id+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// openReplayFile opens a replay file for reading, decompressing it if needed.
+func openReplayFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, replayGzipExt) {
+		return file, nil
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, file}, nil
+}
+
+// playReplay sends every event of a replay to send, calling wait with the
+// recorded delay before each one. It stops when wait returns false, when send
+// fails or at the end of the replay. Commands are skipped.
+func playReplay(rd io.Reader, send func(event []byte) error, wait func(d time.Duration) bool) error {
+	br := bufio.NewReader(rd)
+	var lastT int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry ReplayEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return err
+			}
+			if entry.Event != nil {
+				if delay := entry.T - lastT; delay > 0 {
+					if !wait(time.Duration(delay) * time.Millisecond) {
+						return nil
+					}
+					lastT = entry.T
+				}
+				if err := send(entry.Event); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ServeReplayRequest serves the replay named by the request's {id} path value
+// from dir. A websocket request gets the replay's events in real time, the way
+// a spectator saw the match; any other request downloads the replay file.
+func ServeReplayRequest(dir string, w http.ResponseWriter, r *http.Request) {
+	path, ok := findReplayFile(dir, r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Not found", 404)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	rd, err := openReplayFile(path)
+	if err != nil {
+		log.Println("open replay error:", err)
+		http.Error(w, "Internal server error", 500)
+		return
+	}
+	defer rd.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	// Viewers' commands are ignored; reading only notices when they leave.
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteMessage(websocket.TextMessage, event)
+	}
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-left:
+			return false
+		}
+	}
+	if err := playReplay(rd, send, wait); err != nil {
+		log.Println("replay playback error:", err)
+		return
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end of replay"))
+}
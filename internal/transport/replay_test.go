@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayRecordAndPlay(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		rec, err := NewReplayRecorder(dir, "match-1", compress)
+		if err != nil {
+			t.Fatalf("NewReplayRecorder: %v", err)
+		}
+		rec.RecordEvent(&sampleEvent{Foo: "first"})
+		rec.RecordCommand(7, "PlayerMoveCommand", json.RawMessage(`{"x":1}`))
+		time.Sleep(5 * time.Millisecond)
+		rec.RecordEvent(sampleEvent{Foo: "second"})
+		if err := rec.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		rec.RecordEvent(sampleEvent{Foo: "after close"})
+
+		path, ok := findReplayFile(dir, "match-1")
+		if !ok {
+			t.Fatalf("replay file not found (compress=%v)", compress)
+		}
+		rd, err := openReplayFile(path)
+		if err != nil {
+			t.Fatalf("openReplayFile: %v", err)
+		}
+
+		var sent []string
+		var waited time.Duration
+		err = playReplay(rd,
+			func(event []byte) error {
+				var e JSONEvent
+				if err := json.Unmarshal(event, &e); err != nil {
+					t.Fatalf("event is not a JSONEvent: %s", event)
+				}
+				sent = append(sent, e.Name+":"+e.Data.(map[string]interface{})["foo"].(string))
+				return nil
+			},
+			func(d time.Duration) bool {
+				waited += d
+				return true
+			})
+		_ = rd.Close()
+		if err != nil {
+			t.Fatalf("playReplay: %v", err)
+		}
+
+		if len(sent) != 2 || sent[0] != "sampleEvent:first" || sent[1] != "sampleEvent:second" {
+			t.Errorf("played events = %v (compress=%v)", sent, compress)
+		}
+		if waited < 5*time.Millisecond {
+			t.Errorf("waited %v between events, want at least 5ms", waited)
+		}
+	}
+}
+
+func TestReplayPlaybackStopsWhenViewerLeaves(t *testing.T) {
+	dir := t.TempDir()
+	rec, _ := NewReplayRecorder(dir, "match-2", false)
+	rec.RecordEvent(sampleEvent{Foo: "a"})
+	time.Sleep(2 * time.Millisecond)
+	rec.RecordEvent(sampleEvent{Foo: "b"})
+	_ = rec.Close()
+
+	rd, _ := openReplayFile(filepath.Join(dir, "match-2"+replayExt))
+	defer rd.Close()
+	sent := 0
+	_ = playReplay(rd, func([]byte) error { sent++; return nil }, func(time.Duration) bool { return false })
+	// The first event is due immediately, the second only after a wait.
+	if sent != 1 {
+		t.Errorf("sent %d events, want 1 before the viewer left", sent)
+	}
+}
+
+func TestReplayIDsCannotEscapeDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filepath.Dir(dir), "secret"+replayExt), []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"../secret", "", "a/b", ".."} {
+		if _, ok := findReplayFile(dir, id); ok {
+			t.Errorf("replay id %q was accepted", id)
+		}
+		if _, err := NewReplayRecorder(dir, id, false); err == nil {
+			t.Errorf("recorder created for id %q", id)
+		}
+	}
+}
+
+func TestReplayIsServedOnlyAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	id, err := NewReplayID()
+	if err != nil {
+		t.Fatalf("NewReplayID: %v", err)
+	}
+	rec, err := NewReplayRecorder(dir, id, false)
+	if err != nil {
+		t.Fatalf("NewReplayRecorder: %v", err)
+	}
+	rec.RecordEvent(sampleEvent{Foo: "a"})
+
+	if _, ok := findReplayFile(dir, id); ok {
+		t.Fatal("replay of a running match was found")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, ok := findReplayFile(dir, id); !ok {
+		t.Fatal("replay not found after Close")
+	}
+}
+
+func TestReplayIDsAreRandom(t *testing.T) {
+	a, _ := NewReplayID()
+	b, _ := NewReplayID()
+	if a == b || len(a) != 2*replayIDBytes || !replayIDPattern.MatchString(a) {
+		t.Errorf("replay ids = %q, %q", a, b)
+	}
+}
@@ -0,0 +1,218 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"dungeon/internal/lobby"
+)
+
+// For players whose proxies break WebSockets, events are streamed with
+// Server-Sent Events instead: a GET opens the stream, whose first event, named
+// "session", gives the session ID of the connection. The client POSTs its
+// commands, one JSON ClientCommand per line, to the stream's URL followed by the
+// session ID. Events are sent in the JSON encoding, one per SSE message.
+//
+// The session ID only ties the POSTs to the stream of the same connection; a
+// client that reconnects resumes its lobby session like a WebSocket one does.
+// Events are queued like for a WebSocket client, see backpressure.go.
+
+const (
+	// Send a comment with this period to keep proxies from closing an idle
+	// stream.
+	sseKeepAlivePeriod = 15 * time.Second
+
+	// Maximum size of the body of a POST, which may carry several commands.
+	maxSSEPostSize = 64 * maxMessageSize
+)
+
+// SSEClient represents a connected user using Server-Sent Events and POSTs.
+type SSEClient struct {
+	lobby *lobby.Lobby
+
+	sessionID string
+
+	// Outbound messages, see backpressure.go.
+	*sendQueue
+
+	id uint64
+}
+
+// sseClients are the connected SSE clients by session ID.
+var sseClients = struct {
+	sync.Mutex
+	bySession map[string]*SSEClient
+}{bySession: make(map[string]*SSEClient)}
+
+func newSSEClient(l *lobby.Lobby) *SSEClient {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return &SSEClient{
+		lobby:     l,
+		sessionID: hex.EncodeToString(b),
+		sendQueue: newSendQueue(),
+	}
+}
+
+func (c *SSEClient) SendEvent(event interface{}) {
+	var message []byte
+	if pre, ok := event.(*lobby.PreEncodedEvent); ok {
+		message = pre.Data[encodingJSON]
+		event = pre.Event
+	}
+	if message == nil {
+		var err error
+		if message, err = eventToJSON(event); err != nil {
+			log.Printf("cannot encode %s: %v", getNameOfStruct(event), err)
+			return
+		}
+	}
+
+	c.queue(c.id, event, message)
+}
+
+func (c *SSEClient) ID() uint64 {
+	return c.id
+}
+
+func (c *SSEClient) SetID(id uint64) {
+	c.id = id
+}
+
+// Stats returns the metrics of the client's outbound queue.
+func (c *SSEClient) Stats() QueueStats {
+	return c.queueStats(c.id)
+}
+
+// Close ends the event stream.
+func (c *SSEClient) Close() {
+	if c.sendQueue.close() {
+		removeConnected(c)
+	}
+}
+
+// writeLoop streams the queued messages to w until the client is closed or the
+// request is done.
+func (c *SSEClient) writeLoop(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+
+	// write writes a message received from the send channel, and reports
+	// whether the write loop should go on.
+	write := func(message []byte, ok bool) bool {
+		if !ok {
+			return false
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(writeWait))
+		_, err := fmt.Fprintf(w, "data: %s\n\n", message)
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Println("event stream write error:", err)
+			return false
+		}
+
+		return true
+	}
+
+	for {
+		var err error
+		select {
+		case message, ok := <-c.send:
+			if !write(message, ok) {
+				return
+			}
+		case <-c.wake:
+			if !c.flush(c.lobby, c, write) {
+				return
+			}
+		case <-ticker.C:
+			_ = rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			log.Println("event stream write error:", err)
+			return
+		}
+	}
+}
+
+// ServeSSERequest opens an event stream for a new client, registered with l
+// until the stream ends.
+func ServeSSERequest(l *lobby.Lobby, w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	c := newSSEClient(l)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep nginx-like proxies from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	if _, err := fmt.Fprintf(w, "event: session\ndata: %s\n\n", c.sessionID); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		log.Println("cannot stream events:", err)
+		return
+	}
+
+	sseClients.Lock()
+	sseClients.bySession[c.sessionID] = c
+	sseClients.Unlock()
+	l.RegisterTransportClient(c)
+	addConnected(c)
+	defer func() {
+		c.Close()
+		sseClients.Lock()
+		delete(sseClients.bySession, c.sessionID)
+		sseClients.Unlock()
+		l.UnregisterTransportClient(c)
+	}()
+
+	c.writeLoop(w, r)
+}
+
+// ServeSSECommands passes the commands POSTed by the client of the event stream
+// with the session ID in the request path to its lobby.
+func ServeSSECommands(w http.ResponseWriter, r *http.Request) {
+	sseClients.Lock()
+	c, ok := sseClients.bySession[r.PathValue("id")]
+	sseClients.Unlock()
+	if !ok {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+
+	scanner := bufio.NewScanner(http.MaxBytesReader(w, r.Body, maxSSEPostSize))
+	scanner.Buffer(make([]byte, 0, maxMessageSize), maxMessageSize)
+	for scanner.Scan() {
+		message := scanner.Bytes()
+		if len(bytes.TrimSpace(message)) == 0 {
+			continue
+		}
+		var clientCommand lobby.ClientCommand
+		if err := json.Unmarshal(message, &clientCommand); err != nil {
+			log.Printf("json unmarshal error: %s", err)
+			continue
+		}
+		c.lobby.HandleClientCommand(c, &clientCommand)
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
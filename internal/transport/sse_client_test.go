@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dungeon/internal/game"
+	"dungeon/internal/lobby"
+)
+
+// readSSE returns the name and data of the next message of the event stream.
+func readSSE(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestSSEStreamsEventsAndAcceptsPostedCommands(t *testing.T) {
+	l := lobby.NewLobby(nil, nil, game.NewMatchMaker(), 1, 2)
+	go l.Run()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		ServeSSERequest(l, w, r)
+	})
+	mux.HandleFunc("POST /sse/{id}", ServeSSECommands)
+	server := httptest.NewServer(mux)
+
+	resp, err := http.Get(server.URL + "/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// Closing the stream unregisters the client; the lobby handles requests
+		// in order, so it is done with the client once it took the next one.
+		resp.Body.Close()
+		server.Close()
+		l.UnregisterTransportClient(newSSEClient(l))
+	}()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q, want text/event-stream", ct)
+	}
+	stream := bufio.NewReader(resp.Body)
+	name, sessionID := readSSE(t, stream)
+	if name != "session" || sessionID == "" {
+		t.Fatalf("first message %q %q, want the session ID", name, sessionID)
+	}
+
+	commands := `{"type":"lobby","subType":"join","data":"Alice"}` + "\n" + `not json` + "\n"
+	post, err := http.Post(server.URL+"/sse/"+sessionID, "application/x-ndjson", strings.NewReader(commands))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusNoContent {
+		t.Errorf("POST status %d, want %d", post.StatusCode, http.StatusNoContent)
+	}
+	for {
+		_, data := readSSE(t, stream)
+		if strings.HasPrefix(data, `{"name":"ClientJoinedEvent"`) {
+			if !strings.Contains(data, `"nickname":"Alice"`) {
+				t.Errorf("ClientJoinedEvent %s, want Alice joined", data)
+			}
+			break
+		}
+	}
+
+	post, err = http.Post(server.URL+"/sse/unknown", "application/x-ndjson", strings.NewReader(commands))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusNotFound {
+		t.Errorf("POST to an unknown session status %d, want %d", post.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestSSEClientSendsPreEncodedJSON(t *testing.T) {
+	c := newSSEClient(nil)
+	c.SendEvent(&lobby.PreEncodedEvent{Event: &sampleEvent{Foo: "bar"}, Data: map[string][]byte{encodingJSON: []byte("json")}})
+	c.SendEvent(sampleEvent{Foo: "bar"})
+
+	if got := string(<-c.send); got != "json" {
+		t.Errorf("sent %q, want the pre-encoded JSON", got)
+	}
+	if got := string(<-c.send); got != `{"name":"sampleEvent","data":{"foo":"bar"}}` {
+		t.Errorf("sent %q, want the event encoded", got)
+	}
+}
+
+func TestSSEClientFallingBehindIsResyncedNotClosed(t *testing.T) {
+	c := newSSEClient(nil)
+	for i := 0; i < sendQueueSize; i++ {
+		c.SendEvent(game.CreaturesPosUpdateEvent{})
+	}
+	if stats := c.Stats(); stats.Depth != 1 || stats.Coalesced != sendQueueSize-1 {
+		t.Errorf("stats %+v, want the positions coalesced into one message", stats)
+	}
+
+	for i := 0; i <= sendQueueSize; i++ {
+		c.SendEvent(sampleEvent{Foo: "bar"})
+	}
+	if c.sendIsClosed {
+		t.Fatal("the stream of a client that fell behind was closed")
+	}
+	if !c.flush(nil, c, func([]byte, bool) bool { return true }) {
+		t.Error("a client that fell behind once was not resynced")
+	}
+}
@@ -1,12 +1,12 @@
 package transport
 
 import (
+	"dungeon/internal/game"
 	"dungeon/internal/lobby"
 	"encoding/json"
 	"log"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -40,12 +40,16 @@ type WebSocketClient struct {
 
 	conn *websocket.Conn
 
-	// Channel of outbound messages.
-	send         chan []byte
-	sendIsClosed bool
-	mu           sync.Mutex
+	// Outbound messages, see backpressure.go.
+	*sendQueue
 
 	id uint64
+
+	// encoding is how events are sent, negotiated when connecting: encodingJSON
+	// or encodingBinary.
+	encoding string
+	// positions delta-encodes position updates in encodingBinary.
+	positions *positionsEncoder
 }
 
 func (c *WebSocketClient) readLoop() {
@@ -64,11 +68,15 @@ func (c *WebSocketClient) readLoop() {
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			log.Println("read error:", err)
 			break
 		}
+		if messageType == websocket.BinaryMessage {
+			c.handleBinaryMessage(message)
+			continue
+		}
 		if !strings.Contains(string(message), `"type":"game"`) {
 			log.Printf("Incoming message: %s", message)
 		}
@@ -93,25 +101,11 @@ func (c *WebSocketClient) writeLoop() {
 	for {
 		select {
 		case message, ok := <-c.send:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				log.Println("write deadline exceeded")
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-
-				return
-			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Printf("error getting next writer: %s", err)
-
+			if !c.writeQueued(message, ok) {
 				return
 			}
-			_, _ = w.Write(message)
-
-			if err2 := w.Close(); err2 != nil {
-				log.Println("writer close error:", err2)
-
+		case <-c.wake:
+			if !c.flush() {
 				return
 			}
 		case <-ticker.C:
@@ -124,28 +118,114 @@ func (c *WebSocketClient) writeLoop() {
 	}
 }
 
-// init registers the wire serializer with the lobby so broadcasts are encoded
-// once per event rather than once per recipient.
+// writeQueued writes a message received from the send channel, or the close
+// message if it is closed. It reports whether the write loop should go on.
+func (c *WebSocketClient) writeQueued(message []byte, ok bool) bool {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if !ok {
+		log.Println("write deadline exceeded")
+		_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+
+		return false
+	}
+
+	w, err := c.conn.NextWriter(c.messageType())
+	if err != nil {
+		log.Printf("error getting next writer: %s", err)
+
+		return false
+	}
+	_, _ = w.Write(message)
+
+	if err2 := w.Close(); err2 != nil {
+		log.Println("writer close error:", err2)
+
+		return false
+	}
+
+	return true
+}
+
+// flush asks for a resync if the client fell behind, then writes the messages
+// queued. It reports whether the write loop should go on.
+func (c *WebSocketClient) flush() bool {
+	return c.sendQueue.flush(c.lobby, c, c.writeQueued)
+}
+
+// init registers the wire serializers with the lobby so broadcasts are encoded
+// once per event and encoding rather than once per recipient.
 func init() {
-	lobby.SetEventEncoder(eventToJSON)
+	lobby.SetEventEncoder(encodingJSON, func(e interface{}, _ map[string][]byte) ([]byte, error) {
+		return eventToJSON(e)
+	})
+	// After the JSON encoder, whose bytes it reuses.
+	lobby.SetEventEncoder(encodingBinary, preEncodeBinary)
 }
 
 func (c *WebSocketClient) SendEvent(event interface{}) {
-	var jsonDataMessage []byte
-	if pre, ok := event.(*lobby.PreEncodedEvent); ok {
-		jsonDataMessage = pre.Data
-	} else {
-		jsonDataMessage, _ = eventToJSON(event)
+	message := c.encode(event)
+	if message == nil {
+		return
+	}
+	c.queue(c.id, event, message)
+}
+
+// encode returns the wire bytes of event in the client's encoding, or nil if it
+// cannot be encoded.
+func (c *WebSocketClient) encode(event interface{}) []byte {
+	encoding := c.encoding
+	if encoding == "" {
+		encoding = encodingJSON
+	}
+	pre, isPre := event.(*lobby.PreEncodedEvent)
+	if isPre {
+		event = pre.Event
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.sendIsClosed || c.send == nil {
+	if encoding == encodingBinary {
+		if positions, ok := event.(game.CreaturesPosUpdateEvent); ok {
+			return c.positions.encode(positions)
+		}
+	}
+	if isPre {
+		if data, ok := pre.Data[encoding]; ok {
+			return data
+		}
+	}
+
+	enc := eventToJSON
+	if encoding == encodingBinary {
+		enc = eventToBinary
+	}
+	data, err := enc(event)
+	if err != nil {
+		log.Printf("cannot encode %s: %v", getNameOfStruct(event), err)
+		return nil
+	}
+
+	return data
+}
+
+// messageType is the type of the WebSocket messages events are sent in.
+func (c *WebSocketClient) messageType() int {
+	if c.encoding == encodingBinary {
+		return websocket.BinaryMessage
+	}
+
+	return websocket.TextMessage
+}
+
+// handleBinaryMessage handles a binary message from the client, which can only
+// acknowledge a positions message.
+func (c *WebSocketClient) handleBinaryMessage(message []byte) {
+	if c.positions == nil {
 		return
 	}
-	select {
-	case c.send <- jsonDataMessage:
-	default:
+	seq, err := parseAck(message)
+	if err != nil {
+		log.Printf("binary message error: %s", err)
+		return
 	}
+	c.positions.ack(seq)
 }
 
 func (c *WebSocketClient) SendMessage(message []byte) {
@@ -160,15 +240,16 @@ func (c *WebSocketClient) SetID(id uint64) {
 	c.id = id
 }
 
+// Stats returns the metrics of the client's outbound queue.
+func (c *WebSocketClient) Stats() QueueStats {
+	return c.queueStats(c.id)
+}
+
 func (c *WebSocketClient) Close() {
-	c.mu.Lock()
-	if c.sendIsClosed {
-		c.mu.Unlock()
+	if !c.sendQueue.close() {
 		return
 	}
-	c.sendIsClosed = true
-	close(c.send)
-	c.mu.Unlock()
+	removeConnected(c)
 
 	if err := c.conn.Close(); err != nil {
 		log.Println("Error closing websocket connection:", err)
@@ -176,20 +257,52 @@ func (c *WebSocketClient) Close() {
 }
 
 func ServeWebSocketRequest(lobby *lobby.Lobby, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, encoding, err := upgrade(w, r)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
 	client := &WebSocketClient{
-		lobby: lobby,
-		conn:  conn,
-		send:  make(chan []byte, 256),
-		mu:    sync.Mutex{},
+		lobby:     lobby,
+		conn:      conn,
+		sendQueue: newSendQueue(),
+		encoding:  encoding,
+	}
+	if encoding == encodingBinary {
+		client.positions = newPositionsEncoder()
 	}
 	client.lobby.RegisterTransportClient(client)
+	addConnected(client)
 
 	go client.writeLoop()
 	go client.readLoop()
 }
+
+// upgrade upgrades the request to a WebSocket connection sending events in the
+// encoding the client asks for, which it returns.
+func upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, string, error) {
+	encoding := negotiateEncoding(r)
+	var header http.Header
+	if encoding != "" {
+		header = http.Header{"Sec-Websocket-Protocol": {encoding}}
+	} else {
+		encoding = encodingJSON
+	}
+	conn, err := upgrader.Upgrade(w, r, header)
+
+	return conn, encoding, err
+}
+
+// negotiateEncoding picks the first of the subprotocols the client asks for that
+// is an encoding, in the client's order of preference, or returns "" if none
+// is.
+func negotiateEncoding(r *http.Request) string {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == encodingJSON || protocol == encodingBinary {
+			return protocol
+		}
+	}
+
+	return ""
+}
@@ -2,7 +2,6 @@ package transport
 
 import (
 	"encoding/json"
-	"sync"
 	"testing"
 )
 
@@ -15,7 +14,7 @@ func TestWebSocketClientIDRoundTrip(t *testing.T) {
 }
 
 func TestSendEventQueuesSerializedMessage(t *testing.T) {
-	c := &WebSocketClient{send: make(chan []byte, 1), mu: sync.Mutex{}}
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1)}}
 
 	c.SendEvent(&sampleEvent{Foo: "bar"})
 
@@ -36,7 +35,7 @@ func TestSendEventQueuesSerializedMessage(t *testing.T) {
 }
 
 func TestSendEventDropsWhenClosed(t *testing.T) {
-	c := &WebSocketClient{send: make(chan []byte, 1), sendIsClosed: true, mu: sync.Mutex{}}
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1), sendIsClosed: true}}
 
 	c.SendEvent(&sampleEvent{Foo: "bar"})
 
@@ -45,18 +44,24 @@ func TestSendEventDropsWhenClosed(t *testing.T) {
 	}
 }
 
-func TestSendEventNonBlockingWhenFull(t *testing.T) {
-	c := &WebSocketClient{send: make(chan []byte, 1), mu: sync.Mutex{}}
+func TestSendEventFallsBehindWhenFull(t *testing.T) {
+	c := &WebSocketClient{sendQueue: &sendQueue{send: make(chan []byte, 1), wake: make(chan struct{}, 1)}}
 	c.send <- []byte("occupied") // fill the buffer
 
 	done := make(chan struct{})
 	go func() {
-		c.SendEvent(&sampleEvent{Foo: "dropped"}) // must hit the default case, not block
+		c.SendEvent(&sampleEvent{Foo: "overflow"}) // must not block on a full channel
 		close(done)
 	}()
 
 	<-done // would deadlock if SendEvent blocked on a full channel
-	if len(c.send) != 1 {
-		t.Errorf("send buffer size = %d, want 1 (extra event dropped)", len(c.send))
+	if len(c.send) != 0 || len(c.wake) != 1 {
+		t.Errorf("send buffer size = %d, want the queue dropped and the write loop woken", len(c.send))
+	}
+	if behind, _ := c.takeWork(); !behind {
+		t.Error("client that overflowed its queue is not behind")
+	}
+	if stats := c.Stats(); stats.Dropped != 2 {
+		t.Errorf("%d messages dropped, want 2", stats.Dropped)
 	}
 }
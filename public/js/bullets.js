@@ -62,85 +62,18 @@ class Fireball extends Bullet
         this.setVisible(false);
         this.disableBody();
 
-        // Colliders registered below are removed when the explosion ends so they
-        // don't accumulate in the physics world for the rest of the match.
-        const explosionColliders = [];
-
+        // Only the visuals: explosion damage is dealt by the server's projectile
+        // simulation.
         const explosion = this.scene.add.sprite(this.x, this.y, 'explosion')
             .setDepth(DEPTH_PROJECTILES)
             .setScale(1.5)
             .setMask(this.scene.mask);
         explosion.anims.play('explosion', true);
         explosion.on('animationcomplete', () => {
-            for (const c of explosionColliders) {
-                this.scene.physics.world.removeCollider(c);
-            }
             explosion.destroy();
             this.setActive(false);
             this.hasActiveExplosion = false;
         });
-
-        if (this.clientId !== this.scene.myClientId) {
-            return;
-        }
-
-        this.scene.physics.add.existing(explosion);
-
-        // attack my player
-        let canDamagePlayer = true;
-        explosionColliders.push(this.scene.physics.add.overlap(explosion, this.scene.player, (s, p) => {
-            if (!canDamagePlayer) {
-                return;
-            }
-            canDamagePlayer = false;
-            this.scene.sendGameCommand('HitPlayerCommand', {
-                originClientId: this.scene.myClientId,
-                monsterId: -1,
-                targetClientId: this.scene.myClientId,
-                kind: DAMAGE_KIND_EXPLOSION
-            });
-            setTimeout(() => canDamagePlayer = false, 1000);
-        }, null, this));
-
-        // attack other players
-        const playersArray = [];
-        for (const id in this.scene.players) {
-            playersArray.push(this.scene.players[id]);
-        }
-        let cannotDamage = {};
-        explosionColliders.push(this.scene.physics.add.overlap(explosion, playersArray, (s, p) => {
-            if (cannotDamage[p.id]) {
-                return;
-            }
-            cannotDamage[p.id] = true;
-            this.scene.sendGameCommand('HitPlayerCommand', {
-                originClientId: this.scene.myClientId,
-                monsterId: -1,
-                targetClientId: p.id,
-                kind: DAMAGE_KIND_EXPLOSION
-            });
-            setTimeout(() => cannotDamage[p.id] = false, 300);
-        }, null, this));
-
-        // attack monsters
-        const monstersArray = [];
-        for (const id in this.scene.monsters) {
-            monstersArray.push(this.scene.monsters[id]);
-        }
-
-        let cannotDamageMonsters = {};
-        explosionColliders.push(this.scene.physics.add.overlap(explosion, monstersArray, (s, m) => {
-            if (cannotDamageMonsters[m.id]) {
-                return;
-            }
-            cannotDamageMonsters[m.id] = true;
-            this.scene.sendGameCommand('HitMonsterCommand', {
-                originClientId: this.clientId,
-                monsterId: m.id,
-                kind: 'explosion'
-            });
-            setTimeout(() => cannotDamage[m.id] = false, 1000);
-        }, null, this));
     }
 }
 
@@ -337,6 +270,9 @@ class AllProjectilesGroup
     firebolts;
     firespots;
     arrows;
+    // Server-simulated projectiles by their server id, so they can be stopped
+    // where the server says they stopped.
+    byId = {};
 
     constructor (scene, layerWalls, onBulletHitPlayer, onBulletHitMonster)
     {
@@ -367,6 +303,24 @@ class AllProjectilesGroup
         this.arrows.addObject(object);
     }
 
+    track(projectileId, bullet) {
+        if (projectileId && bullet) {
+            bullet.projectileId = projectileId;
+            this.byId[projectileId] = bullet;
+        }
+    }
+
+    destroyProjectile(projectileId, x, y) {
+        const bullet = this.byId[projectileId];
+        delete this.byId[projectileId];
+        // Pooled sprites are reused, so make sure it is still the same projectile.
+        if (!bullet || bullet.projectileId !== projectileId || !bullet.active) {
+            return;
+        }
+        bullet.setPosition(x, y);
+        bullet.onTravelEnd();
+    }
+
     getAllIlluminatedSprites() {
         const children = this.fireballs.getChildren();
         return children.filter(b => b.active);
@@ -2,11 +2,13 @@ const DEBUG_TRAPS = false; // Set to true to show trap debug rectangles
 
 const GameEventHandler = {
     FireballEvent(data) {
-        this.projectiles.castPlayerFireball(data.clientId, data.x, data.y + 10, data.direction, 500, data.distance)
+        const bullet = this.projectiles.castPlayerFireball(data.clientId, data.x, data.y + 10, data.direction, 500, data.distance);
+        this.projectiles.track(data.projectileId, bullet);
     },
 
     ShootArrowEvent(data) {
-        this.projectiles.shootPlayerArrow(data.clientId, data.x1, data.y1, data.x2, data.y2, data.velocity)
+        const bullet = this.projectiles.shootPlayerArrow(data.clientId, data.x1, data.y1, data.x2, data.y2, data.velocity);
+        this.projectiles.track(data.projectileId, bullet);
     },
 
     SwordAttackPrepareEvent(data) {
@@ -87,11 +89,20 @@ const GameEventHandler = {
                 continue;
             }
 
+            // Players out of sight are listed without a position.
+            if (p.hidden) {
+                if (this.players[id]) {
+                    this.players[id].setHidden(true);
+                }
+                continue;
+            }
+
             if (!this.players[id]) {
                 this.players[id] = new Player(p.class, this, p)
                 this.projectiles.addPlayer(this.players[id]);
             }
 
+            this.players[id].setHidden(false);
             this.players[id].updateStatAndPosition(p);
             this.players[id].setDisplayAlpha(p.isInvisible ? 0 : 1);
 
@@ -101,6 +112,8 @@ const GameEventHandler = {
             }
         }
 
+        // Only the monsters in sight are sent; hide the others until they are.
+        const seen = {};
         for (const m of data.monsters) {
             const id = m.id;
             if (!this.monsters[id]) {
@@ -108,7 +121,14 @@ const GameEventHandler = {
                 this.projectiles.addMonster(this.monsters[id]);
             }
 
+            this.monsters[id].setHidden(false);
             this.monsters[id].updateStatAndPosition(m);
+            seen[id] = true;
+        }
+        for (const id in this.monsters) {
+            if (!seen[id]) {
+                this.monsters[id].setHidden(true);
+            }
         }
 
         // Keep the player list in sync with the latest stats snapshot
@@ -185,6 +205,20 @@ const GameEventHandler = {
         }
     },
 
+    // The server rejected a reported move (too fast or through a wall): snap
+    // back to the authoritative position.
+    PlayerPositionCorrectionEvent(data) {
+        if (this.player && !this.isDead) {
+            this.player.teleport(data.x, data.y);
+        }
+    },
+
+    // The server keeps dropping our commands (sent too fast or malformed) and
+    // will disconnect us if it goes on.
+    CommandRejectedEvent(data) {
+        console.warn('server rejects ' + data.command + ': ' + data.reason);
+    },
+
     RespawnDeniedEvent(data) {
         if (this.respawnButton) {
             this.respawnButton.destroy();
@@ -236,6 +270,12 @@ const GameEventHandler = {
     },
 
     EndGameEvent(data) {
+        // The match seed lets a reported match be reproduced on the server.
+        console.log('match seed', data.seed);
+        if (data.replayId) {
+            console.log('match replay', '/?replay=' + data.replayId);
+        }
+
         let text, color;
         if (data.winningSide === 'cultists') {
             color = '#cc33ff';
@@ -288,6 +328,15 @@ const GameEventHandler = {
             .setDepth(DEPTH_UI);
     },
 
+    StairsOpenedEvent(data) {
+        const stairs = GameObject.SpawnNewObject(this, {id: data.objectId, kind: 'stairs', x: data.x, y: data.y});
+        this.gameObjects[data.objectId] = stairs;
+        this.showAnnouncement(
+            "The three keys open the way down!\n\n" +
+            "The stairs wait where you entered this floor.",
+            '#f3c800', 6000);
+    },
+
     CultistsRosterEvent(data) {
         this.cultistIds = data.clientIds || [];
         // Mark any already-spawned fellow cultists immediately.
@@ -299,11 +348,17 @@ const GameEventHandler = {
     },
 
     ArrowEvent(data) {
-        this.projectiles.shootMonsterArrow(data.monsterId, data.x1, data.y1, data.x2, data.y2, 400);
+        const bullet = this.projectiles.shootMonsterArrow(data.monsterId, data.x1, data.y1, data.x2, data.y2, 400);
+        this.projectiles.track(data.projectileId, bullet);
     },
 
     DemonFireballEvent(data) {
-        this.projectiles.castMonsterFirebolt(data.monsterId, data.x1, data.y1, data.x2, data.y2, 700)
+        const bullet = this.projectiles.castMonsterFirebolt(data.monsterId, data.x1, data.y1, data.x2, data.y2, 700);
+        this.projectiles.track(data.projectileId, bullet);
+    },
+
+    ProjectileDestroyedEvent(data) {
+        this.projectiles.destroyProjectile(data.projectileId, data.x, data.y);
     },
 
     FireCircleEvent(data) {
@@ -7,6 +7,7 @@ class Monster extends Phaser.Physics.Arcade.Sprite
     isAttacking = false;
     scene;
     isCorpse = false;
+    isHidden = false;
 
     constructor (kind, scene, statData, spriteKey, frame, scale)
     {
@@ -108,6 +109,18 @@ class Monster extends Phaser.Physics.Arcade.Sprite
         });
     }
 
+    // setHidden hides a monster out of the player's sight, which the server
+    // stops sending until it is seen again.
+    setHidden(hidden)
+    {
+        this.isHidden = hidden;
+        this.setVisible(!hidden);
+        if (this.body && !this.isCorpse) this.body.enable = !hidden;
+        if (this.hpText) this.hpText.setVisible(!hidden);
+        if (this._shieldGraphics) this._shieldGraphics.setVisible(!hidden);
+        if (this._speedBoostGraphics) this._speedBoostGraphics.setVisible(!hidden);
+    }
+
     updateStatAndPosition(statData)
     {
         if (this.hp !== statData.hp) {
@@ -78,6 +78,12 @@ class NavArrows
                 continue;
             }
 
+            // Cloaked players are not sent, so there is nothing to point at.
+            if (target.isHidden) {
+                if (this.arrows[id]) this.arrows[id].setVisible(false);
+                continue;
+            }
+
             // On-screen targets need no arrow — the cultist can already see them.
             if (view.contains(target.x, target.y)) {
                 if (this.arrows[id]) this.arrows[id].setVisible(false);
@@ -35,6 +35,7 @@ class GameObject extends Phaser.Physics.Arcade.Sprite
         switch (statData.kind) {
             case 'chest': return new Chest(scene, statData);
             case 'trigger': return new DebugRectangle(scene, statData);
+            case 'stairs': return new Stairs(scene, statData);
             default:
                 console.error('Unknown object kind:', statData.kind);
                 return null;
@@ -65,6 +66,23 @@ class Chest extends GameObject
     }
 }
 
+// Stairs down to the next floor. Players walk onto them, so unlike chests they
+// have no body.
+class Stairs extends Phaser.GameObjects.Image
+{
+    id;
+    kind = 'stairs';
+
+    constructor (scene, statData)
+    {
+        super(scene, statData.x, statData.y, 'stairs');
+        this.id = statData.id;
+        this.setDepth(DEPTH_OBJECTS);
+        this.setMask(scene.mask);
+        scene.add.existing(this);
+    }
+}
+
 class DebugRectangle
 {
     constructor (scene, statData)
@@ -8,6 +8,7 @@ class Player extends Phaser.Physics.Arcade.Sprite
     isAttacking = false;
     scene;
     isCorpse = false;
+    isHidden = false;
     initialTint = 0xffffff;
     _protectionGraphics = null;
     _speedBoostGraphics = null;
@@ -466,6 +467,20 @@ class Player extends Phaser.Physics.Arcade.Sprite
         if (this._speedBoostGraphics) this._speedBoostGraphics.setAlpha(alpha);
     }
 
+    // setHidden hides a player the server no longer sends the position of: out
+    // of sight, or cloaked from the other team.
+    setHidden(hidden)
+    {
+        this.isHidden = hidden;
+        this.setVisible(!hidden);
+        if (this.body && !this.isCorpse) this.body.enable = !hidden;
+        if (this.hpText) this.hpText.setVisible(!hidden);
+        if (this.avatarImage) this.avatarImage.setVisible(!hidden);
+        if (this._protectionGraphics) this._protectionGraphics.setVisible(!hidden);
+        if (this._speedBoostGraphics) this._speedBoostGraphics.setVisible(!hidden);
+        if (this._cultistMark) this._cultistMark.setVisible(!hidden);
+    }
+
     takeDamage(damage)
     {
         if (this.isCorpse) {
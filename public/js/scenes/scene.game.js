@@ -43,6 +43,11 @@ const DAMAGE_KIND_ARROW = 'arrow';
 const DAMAGE_KIND_EXPLOSION  = 'explosion';
 const DAMAGE_KIND_SPIKE = 'spike';
 const DAMAGE_KIND_LIGHTNING = 'lightning';
+const DAMAGE_KIND_BULLET = 'bullet';
+
+// Hits of these kinds are resolved by the server's projectile simulation, so the
+// client only draws them.
+const SERVER_SIMULATED_DAMAGE_KINDS = [DAMAGE_KIND_FIREBALL, DAMAGE_KIND_ARROW, DAMAGE_KIND_EXPLOSION, DAMAGE_KIND_BULLET];
 
 // Phaser shortcuts
 const { Circle, Line, Point, Rectangle } = Phaser.Geom;
@@ -159,6 +164,28 @@ class Game extends Phaser.Scene {
         Object.assign(this, GameEventHandler);
     }
 
+    // The scene starts over on every floor of the dungeon, and Phaser reuses the
+    // instance: forget what the floor above left behind.
+    init() {
+        this.players = {};
+        this.monsters = {};
+        this.gameObjects = {};
+        this.traps = {};
+        this.raycastByAreas = [];
+        this.prevAreaId = null;
+        this.bulletGlowTrail = [];
+        this.footprintGraphics = [];
+        this.mask = undefined;
+        this.isAttacking = false;
+        this.isMoving = false;
+        this.wasMoving = false;
+        this.isDodging = false;
+        this.isDead = false;
+        this.deadText = null;
+        this.respawnButton = null;
+        this.playerListVisible = false;
+    }
+
     create (data) {
         const gameData = data.gameData;
         this.myClientId = gameData.playerData.clientId;
@@ -229,8 +256,10 @@ class Game extends Phaser.Scene {
             const o = gameData.gameObjects[i];
             const id = o.id;
             this.gameObjects[id] = GameObject.SpawnNewObject(this, o);
-            this.projectiles.addObject(this.gameObjects[id]);
-            this.physics.add.collider(this.player, this.gameObjects[id]);
+            if (this.gameObjects[id] instanceof GameObject) {
+                this.projectiles.addObject(this.gameObjects[id]);
+                this.physics.add.collider(this.player, this.gameObjects[id]);
+            }
         }
 
         this.key1Collected = gameData.keysCollected["1"];
@@ -265,7 +294,7 @@ class Game extends Phaser.Scene {
         createRadialMaskTexture(this, LIGHT_MASK_BULLET,  BULLET_DIAMETER, BULLET_FEATHER);
 
         // Resize handler: rebuild RT & reposition UI
-        this.scale.on('resize', () => {
+        const onResize = () => {
             this._initDarknessRT();
             this.addKeysIcons();
             this.updateXpBar();
@@ -274,7 +303,9 @@ class Game extends Phaser.Scene {
             this.addPlayerListButton();
             this.renderPlayerList();
             this.updateSoulPowerUI();
-        });
+        };
+        this.scale.on('resize', onResize);
+        this.events.once('shutdown', () => this.scale.off('resize', onResize));
 
         // Debug polyline graphics for raycast mask
         this.graphics = this.make.graphics({ lineStyle: { color: DEBUG_STROKE_COLOR, width: 0.5 } });
@@ -315,6 +346,7 @@ class Game extends Phaser.Scene {
 
         // Curse / cultist + soul power
         this.isCultist = !!gameData.isCultist;
+        this.cultistIds = gameData.cultistIds || [];
         this.navArrows = new NavArrows(this);
         this.soulPower = gameData.soulPower || 0;
         this.soulPowerVisible = !!gameData.soulPowerVisible;
@@ -328,13 +360,25 @@ class Game extends Phaser.Scene {
         }
 
         // The quest: only shown to those who join while the demon is still sealed.
+        // Deeper floors just say how deep the players are.
         if (!this.isSpectator && !gameData.bossRevealed) {
-            this.showAnnouncement(
-                "The soul of the demon defiles these halls,\n" +
-                "corrupting all it touches.\n\n" +
-                "Gather the three keys to break its seal,\n" +
-                "then destroy it for good and cleanse the dungeon.",
-                '#f3c800', 8000);
+            if (gameData.floor > 1) {
+                this.showAnnouncement(
+                    "Floor " + gameData.floor + " of " + gameData.floors + "\n\n" +
+                    (gameData.floor < gameData.floors
+                        ? "Gather the three keys of this floor to open the way down."
+                        : "The demon's seal lies on this floor.\nGather the three keys to break it."),
+                    '#f3c800', 6000);
+            } else {
+                this.showAnnouncement(
+                    "The soul of the demon defiles these halls,\n" +
+                    "corrupting all it touches.\n\n" +
+                    (gameData.floors > 1
+                        ? "Gather the keys of each floor to go deeper,\nbreak its seal on the last one,\n"
+                        : "Gather the three keys to break its seal,\n") +
+                    "then destroy it for good and cleanse the dungeon.",
+                    '#f3c800', 8000);
+            }
         }
     }
 
@@ -519,6 +563,7 @@ class Game extends Phaser.Scene {
             return;
         }
 
+        this.players[p.clientId].setHidden(false);
         this.players[p.clientId].updatePosition(p);
     }
 
@@ -528,6 +573,7 @@ class Game extends Phaser.Scene {
             return;
         }
 
+        this.monsters[m.id].setHidden(false);
         this.monsters[m.id].updatePosition(m);
     }
 
@@ -574,8 +620,14 @@ class Game extends Phaser.Scene {
     }
 
     fireballAttack() {
+        if (this.isAttacking) {
+            return;
+        }
+        const attackTs = this.time.now;
+        this.lastAttackTime = attackTs;
         this.isAttacking = true;
-        this.time.delayedCall(500, () => {this.isAttacking = false;}, [], this);
+
+        this.time.delayedCall(500, () => {if (attackTs === this.lastAttackTime) { this.isAttacking = false; } }, [], this);
 
         this.sendGameCommand('CastFireballCommand', {
             x: Math.round(this.player.x),
@@ -621,6 +673,9 @@ class Game extends Phaser.Scene {
     onBulletHitPlayer(bullet, player, kind)
     {
         if (DEBUG) console.log('hit player', bullet.clientId, player.id, kind);
+        if (SERVER_SIMULATED_DAMAGE_KINDS.includes(kind)) {
+            return;
+        }
         if (bullet.monsterId && player.id === this.myClientId) {
             // hit caused by monster's bullet on ourselves
             this.sendGameCommand('HitPlayerCommand', {
@@ -643,20 +698,8 @@ class Game extends Phaser.Scene {
 
     onBulletHitMonster(bullet, monster, kind)
     {
-        if (DEBUG) console.log('hit monster', bullet.clientId, this.myClientId, bullet.monsterId, monster.id, kind);
-        if (bullet.monsterId) {
-            return; // ignore hitting monsters by monster bullets
-        }
-
-        if (bullet.clientId !== this.myClientId) {
-            return; // only report hits caused by our own bullets
-        }
-        this.sendGameCommand('HitMonsterCommand', {
-            originClientId: bullet.clientId,
-            monsterId: monster.id,
-            kind: kind,
-        });
-        if (DEBUG) console.log('monster hit command sent');
+        // Only fireballs and arrows hit monsters, and the server resolves both.
+        if (DEBUG) console.log('hit monster', bullet.clientId, bullet.monsterId, monster.id, kind);
     }
 
     // --- Darkness RenderTexture setup ---
@@ -1191,7 +1234,7 @@ class Game extends Phaser.Scene {
             sw.strokeRect(pad, y + 2, swatch, swatch);
             container.add(sw);
 
-            const name = (p.nickname || '???') + (isMe ? ' (you)' : '');
+            const name = (p.nickname || '???') + (isMe ? ' (you)' : '') + (p.disconnected ? ' (offline)' : '');
             container.add(this.add.text(pad + swatch + 6, y, name, {
                 font: `${fontSize}px Arial`,
                 fill: isMe ? '#ffe066' : '#ffffff'
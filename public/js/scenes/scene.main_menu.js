@@ -567,21 +567,70 @@ class MainMenu extends Phaser.Scene
     {
         const self = this;
         this.connectingText.x = 0;
+        // ?replay=<id> watches a recorded match instead of joining the lobby: the
+        // server streams the match's events as if it were live.
+        const replayId = new URLSearchParams(window.location.search).get('replay');
         const wsConnect = (nickname) => {
-            self.wsConnection = new WebSocket(WEBSOCKET_URL);
+            const url = replayId
+                ? WEBSOCKET_URL.replace(/\/ws$/, '/replays/' + encodeURIComponent(replayId))
+                : WEBSOCKET_URL;
+            // Live matches are sent in the binary encoding if the server speaks
+            // it; replays are always JSON. Where WebSockets never connected,
+            // events are streamed over SSE instead.
+            const useSSE = !replayId && window.sessionStorage.getItem('transport') === 'sse';
+            let opened = false;
+            if (useSSE) {
+                self.wsConnection = new SSEConnection(SSE_URL);
+            } else {
+                self.wsConnection = replayId
+                    ? new WebSocket(url)
+                    : new WebSocket(url, [BinaryWire.SUBPROTOCOL, 'dungeon.json']);
+            }
+            self.wsConnection.binaryType = 'arraybuffer';
+            const wire = new BinaryWire();
             self.wsConnection.onopen = function () {
-                console.log('WebSocket connected');
-                self.wsConnection.send(JSON.stringify({type: 'lobby', subType: 'join', data: nickname}));
-                self.wsConnection.send(JSON.stringify({type: 'lobby', subType: 'makeMatch', data: {roomName: self.roomName}}));
-                console.log("Sent commands to join lobby and make/join match");
+                console.log(useSSE ? 'Event stream connected' : 'WebSocket connected');
+                opened = true;
+                if (replayId) {
+                    console.log('Watching replay ' + replayId);
+                    return;
+                }
+                // A dropped connection resumes its session, and its match, if the
+                // server still keeps it.
+                const sessionToken = window.sessionStorage.getItem('sessionToken');
+                if (sessionToken) {
+                    self.wsConnection.send(JSON.stringify({type: 'lobby', subType: 'resume', data: sessionToken}));
+                    console.log("Sent command to resume the session");
+                    return;
+                }
+                self.joinLobby(nickname);
             };
             self.wsConnection.onclose = () => {
                 console.log('WebSocket disconnected');
+                if (replayId) {
+                    return; // the replay is over
+                }
+                if (!opened && !useSSE) {
+                    console.log('WebSocket never connected, falling back to SSE');
+                    window.sessionStorage.setItem('transport', 'sse');
+                }
                 window.setTimeout(function () {
                     location.reload();
                 }, 3000);
             };
             self.wsConnection.onmessage = function (evt) {
+                if (evt.data instanceof ArrayBuffer) {
+                    let json;
+                    try {
+                        json = wire.decode(evt.data, (ack) => self.wsConnection.send(ack));
+                    } catch (ex) {
+                        console.warn("Binary message error", ex);
+                    }
+                    if (json) {
+                        self.onIncomingMessage(json, evt);
+                    }
+                    return;
+                }
                 const messages = evt.data.split('\n');
                 for (let i = 0; i < messages.length; i++) {
                     let json;
@@ -601,6 +650,13 @@ class MainMenu extends Phaser.Scene
         console.log('Connecting to WebSocket server...');
     };
 
+    joinLobby(nickname)
+    {
+        this.wsConnection.send(JSON.stringify({type: 'lobby', subType: 'join', data: nickname}));
+        this.wsConnection.send(JSON.stringify({type: 'lobby', subType: 'makeMatch', data: {roomName: this.roomName}}));
+        console.log("Sent commands to join lobby and make/join match");
+    }
+
     onIncomingMessage(json, evt)
     {
         const spammingEvents = [
@@ -615,12 +671,22 @@ class MainMenu extends Phaser.Scene
         if (json.name === 'ClientJoinedEvent') {
             this.myClientId = json.data.yourId;
             console.log('My client id = ' + this.myClientId);
+            window.sessionStorage.setItem('sessionToken', json.data.sessionToken);
             this.connectingText.x = 10000;
             this.loadingSpinner.setVisible(false);
+            if (json.data.resumed) {
+                return; // the match state follows
+            }
             this.displayCharacterCreation();
 
             return;
         }
+        if (json.name === 'ClientCommandError' && json.data.message === 'session_expired') {
+            window.sessionStorage.removeItem('sessionToken');
+            this.joinLobby(this.nickname);
+
+            return;
+        }
         if (json.name === 'RoomJoinedEvent') {
             this.roomPlayersListText = this.make.text({
                 x: 10,
@@ -49,6 +49,7 @@ var sceneConfigPreloader = {
         this.load.atlas('ui', 'assets/ui.png', 'assets/ui.json');
         this.load.image('bullet', 'assets/bullet7.png');
         this.load.image('spinner', 'assets/spinner.png');
+        this.load.image('stairs', 'assets/MiniRouge/4 - Tiles/Tiles/32x32/Hatchway2x.png');
         this.load.image('lightning', 'assets/lightning.png');
         this.load.image('lightning_v', 'assets/lightning_v.png');
         this.load.image('potion_hp', 'assets/MiniRouge/1 - Decor/Decor/32x32/Potion HP2x.png');
@@ -0,0 +1,73 @@
+// SSEConnection stands in for a WebSocket where proxies break WebSockets: it
+// receives events over Server-Sent Events and POSTs commands back (see
+// internal/transport/sse_client.go). It has the WebSocket members the main
+// menu uses, and always receives the JSON encoding.
+class SSEConnection
+{
+    onopen = function () {};
+    onclose = function () {};
+    onmessage = function () {};
+    binaryType = 'arraybuffer';         // ignored, events are always text
+
+    sessionId = null;
+    pending = [];                       // commands waiting for the POST in flight
+    posting = false;
+    closed = false;
+
+    constructor(url)
+    {
+        this.url = url;
+        this.source = new EventSource(url);
+        this.source.addEventListener('session', (evt) => {
+            this.sessionId = evt.data;
+            this.onopen();
+        });
+        this.source.onmessage = (evt) => this.onmessage({ data: evt.data });
+        // EventSource would reconnect with a new stream the server does not
+        // know; report the connection closed instead, like a WebSocket.
+        this.source.onerror = () => this.close();
+    }
+
+    send(message)
+    {
+        if (this.closed) {
+            return;
+        }
+        this.pending.push(message);
+        this._post();
+    }
+
+    close()
+    {
+        if (this.closed) {
+            return;
+        }
+        this.closed = true;
+        this.source.close();
+        this.onclose();
+    }
+
+    // _post sends the pending commands in one POST, one at a time so they
+    // reach the server in order.
+    _post()
+    {
+        if (this.posting || this.pending.length === 0 || !this.sessionId) {
+            return;
+        }
+        const body = this.pending.join('\n');
+        this.pending = [];
+        this.posting = true;
+        fetch(this.url + '/' + encodeURIComponent(this.sessionId), { method: 'POST', body: body })
+            .then((resp) => {
+                if (!resp.ok) {
+                    throw new Error('POST status ' + resp.status);
+                }
+                this.posting = false;
+                this._post();
+            })
+            .catch((err) => {
+                console.warn('Cannot send commands', err);
+                this.close();
+            });
+    }
+}
@@ -0,0 +1,135 @@
+// BinaryWire decodes the server's binary encoding (the dungeon.bin.v1 WebSocket
+// subprotocol, see internal/transport/binary.go). Every message starts with its
+// kind: a JSON event like the text encoding sends, or a delta-encoded
+// CreaturesPosUpdateEvent, which the client acknowledges so the server can send
+// the next ones as deltas against it.
+class BinaryWire
+{
+    static SUBPROTOCOL = 'dungeon.bin.v1';
+    static KIND_JSON = 1;
+    static KIND_POSITIONS = 2;
+    static KIND_ACK = 3;
+
+    static FLAG_X = 1;
+    static FLAG_Y = 2;
+    static FLAG_DIRECTION = 4;
+    static FLAG_MOVING = 8;
+    static FLAG_ACTION = 16;          // dodging for a player, attacking for a monster
+
+    static DIRECTIONS = ['', 'up', 'down', 'left', 'right'];
+    static DIRECTION_LITERAL = 0xff;
+
+    snapshots = {};                   // seq -> decoded positions event
+    textDecoder = new TextDecoder();
+
+    // decode returns the event in the ArrayBuffer data, as {name, data}, and
+    // calls ack with the acknowledgement to send back, if any.
+    decode(data, ack)
+    {
+        const bytes = new Uint8Array(data);
+        if (bytes[0] === BinaryWire.KIND_JSON) {
+            return JSON.parse(this.textDecoder.decode(bytes.subarray(1)));
+        }
+        if (bytes[0] !== BinaryWire.KIND_POSITIONS) {
+            throw new Error('unknown message kind ' + bytes[0]);
+        }
+
+        const r = { bytes: bytes, pos: 1 };
+        const seq = this._uvarint(r);
+        const baseSeq = this._uvarint(r);
+        const base = baseSeq ? this.snapshots[baseSeq] : { players: [], monsters: [] };
+        if (!base) {
+            throw new Error('positions ' + seq + ' are a delta against unknown ' + baseSeq);
+        }
+
+        const players = [];
+        for (let n = this._uvarint(r); n > 0; n--) {
+            const id = this._uvarint(r);
+            const old = base.players.find(p => p.clientId === id);
+            const c = this._creature(r, old);
+            players.push({ clientId: id, x: c.x, y: c.y, direction: c.direction, isMoving: c.isMoving, isDodging: c.action });
+        }
+        const monsters = [];
+        for (let n = this._uvarint(r); n > 0; n--) {
+            const id = this._uvarint(r);
+            const old = base.monsters.find(m => m.id === id);
+            const c = this._creature(r, old);
+            monsters.push({ id: id, x: c.x, y: c.y, direction: c.direction, isMoving: c.isMoving, isAttacking: c.action });
+        }
+
+        // The server only ever bases its deltas on the latest acknowledged
+        // positions, so the ones before this base are not needed any more.
+        const event = { players: players, monsters: monsters };
+        for (const s in this.snapshots) {
+            if (Number(s) < baseSeq) {
+                delete this.snapshots[s];
+            }
+        }
+        this.snapshots[seq] = event;
+        ack(this._ack(seq));
+
+        return { name: 'CreaturesPosUpdateEvent', data: event };
+    }
+
+    _creature(r, old)
+    {
+        const flags = r.bytes[r.pos++];
+        let x = old ? old.x : 0;
+        let y = old ? old.y : 0;
+        let direction = old ? old.direction : '';
+        if (flags & BinaryWire.FLAG_X) x += this._varint(r);
+        if (flags & BinaryWire.FLAG_Y) y += this._varint(r);
+        if (flags & BinaryWire.FLAG_DIRECTION) {
+            const code = r.bytes[r.pos++];
+            if (code === BinaryWire.DIRECTION_LITERAL) {
+                const len = this._uvarint(r);
+                direction = this.textDecoder.decode(r.bytes.subarray(r.pos, r.pos + len));
+                r.pos += len;
+            } else {
+                direction = BinaryWire.DIRECTIONS[code];
+            }
+        }
+
+        return {
+            x: x,
+            y: y,
+            direction: direction,
+            isMoving: (flags & BinaryWire.FLAG_MOVING) !== 0,
+            action: (flags & BinaryWire.FLAG_ACTION) !== 0,
+        };
+    }
+
+    _uvarint(r)
+    {
+        let value = 0, scale = 1;
+        for (;;) {
+            if (r.pos >= r.bytes.length) {
+                throw new Error('truncated message');
+            }
+            const b = r.bytes[r.pos++];
+            value += (b & 0x7f) * scale;
+            if (b < 0x80) {
+                return value;
+            }
+            scale *= 128;
+        }
+    }
+
+    _varint(r)
+    {
+        const u = this._uvarint(r);
+        return u % 2 === 0 ? u / 2 : -(u + 1) / 2;
+    }
+
+    _ack(seq)
+    {
+        const out = [BinaryWire.KIND_ACK];
+        while (seq >= 0x80) {
+            out.push((seq % 128) | 0x80);
+            seq = Math.floor(seq / 128);
+        }
+        out.push(seq);
+
+        return new Uint8Array(out);
+    }
+}